@@ -0,0 +1,55 @@
+package addressverifiers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
+	"github.com/pkg/errors"
+)
+
+const httpVerifierTimeout = 10 * time.Second
+
+// httpVerifier posts addresses to a URL that responds with a Result.
+type httpVerifier struct {
+	client *http.Client
+	url    string
+}
+
+func newHTTPVerifier(url string, guard *ssrf.Guard) (*httpVerifier, error) {
+	if url == "" {
+		return nil, errors.New("No URL configured for address verification")
+	}
+
+	client := guard.Client()
+	client.Timeout = httpVerifierTimeout
+	return &httpVerifier{
+		client: client,
+		url:    url,
+	}, nil
+}
+
+func (h *httpVerifier) VerifyAddress(address models.AddressRequest) (*Result, error) {
+	body, err := json.Marshal(&address)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to verify address")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Address verification returned %v", resp.StatusCode)
+	}
+
+	result := &Result{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse address verification response")
+	}
+	return result, nil
+}
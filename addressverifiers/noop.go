@@ -0,0 +1,13 @@
+package addressverifiers
+
+import "github.com/netlify/gocommerce/models"
+
+type noopVerifier struct{}
+
+func newNoopVerifier() (*noopVerifier, error) {
+	return &noopVerifier{}, nil
+}
+
+func (n *noopVerifier) VerifyAddress(address models.AddressRequest) (*Result, error) {
+	return &Result{Deliverable: true}, nil
+}
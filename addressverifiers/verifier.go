@@ -0,0 +1,50 @@
+package addressverifiers
+
+import (
+	"fmt"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
+)
+
+// Result is the outcome of verifying an address. The message explains why an
+// address isn't deliverable.
+type Result struct {
+	Deliverable bool   `json:"deliverable"`
+	Message     string `json:"message"`
+}
+
+// AddressVerifier is the interface wrapping a provider that checks if
+// addresses are deliverable.
+type AddressVerifier interface {
+	VerifyAddress(address models.AddressRequest) (*Result, error)
+}
+
+// Modes of address verification. Off skips verification, warn stores the
+// result on the order and block also rejects undeliverable addresses.
+const (
+	OffMode   = "off"
+	WarnMode  = "warn"
+	BlockMode = "block"
+)
+
+// NewVerifier creates an address verifier based on the provided
+// configuration. The guard restricts which addresses the verifier can
+// connect to.
+func NewVerifier(config *conf.Configuration, guard *ssrf.Guard) (AddressVerifier, error) {
+	switch config.AddressVerification.Mode {
+	case "", OffMode, WarnMode, BlockMode:
+	default:
+		return nil, fmt.Errorf("Unknown address verification mode '%v'", config.AddressVerification.Mode)
+	}
+
+	switch config.AddressVerification.Provider {
+	case "http":
+		return newHTTPVerifier(config.AddressVerification.URL, guard)
+	case "":
+		return newNoopVerifier()
+	default:
+		return nil, fmt.Errorf("Unknown address verification provider '%v'", config.AddressVerification.Provider)
+	}
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/netlify/gocommerce/calculator"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+// IntegerAmounts serializes amounts as integer minor units, e.g. 1250.
+const IntegerAmounts = "integer"
+
+// DecimalAmounts serializes amounts as decimal major units, e.g. 12.50.
+const DecimalAmounts = "decimal"
+
+// decimalAmount is an amount in cents that is serialized as a decimal number
+// of major units.
+type decimalAmount int64
+
+// String formats the amount with two decimal places.
+func (a decimalAmount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/100, a%100)
+}
+
+// MarshalJSON writes the amount with two decimal places.
+func (a decimalAmount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// wantsDecimalAmounts checks if amounts should be serialized in major units.
+// Clients can choose with `Accept: application/json; amounts=decimal` or
+// `amounts=integer`, otherwise the instance configuration decides.
+func wantsDecimalAmounts(r *http.Request) bool {
+	switch acceptedAmounts(r) {
+	case DecimalAmounts:
+		return true
+	case IntegerAmounts:
+		return false
+	}
+	config := gcontext.GetConfig(r.Context())
+	return config != nil && config.Display.Amounts == DecimalAmounts
+}
+
+// The decimal types shadow the amount fields of the models they embed, so
+// all other fields are serialized unchanged.
+
+type decimalOrder struct {
+	*models.Order
+	Taxes        decimalAmount      `json:"taxes"`
+	Shipping     decimalAmount      `json:"shipping"`
+	SubTotal     decimalAmount      `json:"subtotal"`
+	Discount     decimalAmount      `json:"discount"`
+	NetTotal     decimalAmount      `json:"net_total"`
+	Tip          decimalAmount      `json:"tip"`
+	Total        decimalAmount      `json:"total"`
+	TaxOverride  *decimalAmount     `json:"tax_override,omitempty"`
+	TaxBreakdown []decimalTaxItem   `json:"tax_breakdown"`
+	LineItems    []*decimalLineItem `json:"line_items"`
+
+	Transactions []*decimalTransaction `json:"transactions"`
+
+	Display *orderDisplay `json:"display,omitempty"`
+}
+
+type decimalTaxItem struct {
+	calculator.TaxItem
+	Amount decimalAmount `json:"amount"`
+}
+
+type decimalLineItem struct {
+	*models.LineItem
+	Price       decimalAmount       `json:"price"`
+	AddonPrice  decimalAmount       `json:"addon_price"`
+	Calculation *decimalCalculation `json:"calculation"`
+	PriceItems  []*decimalPriceItem `json:"price_items"`
+	AddonItems  []*decimalAddonItem `json:"addons"`
+}
+
+type decimalCalculation struct {
+	*models.CalculationDetail
+	Subtotal      decimalAmount         `json:"subtotal"`
+	Discount      decimalAmount         `json:"discount"`
+	DiscountItems []decimalDiscountItem `json:"discount_items"`
+	NetTotal      decimalAmount         `json:"net_total"`
+	Taxes         decimalAmount         `json:"taxes"`
+	Total         decimalAmount         `json:"total"`
+}
+
+type decimalDiscountItem struct {
+	models.DiscountItem
+	Fixed decimalAmount `json:"fixed"`
+}
+
+type decimalPriceItem struct {
+	*models.PriceItem
+	Amount decimalAmount `json:"amount"`
+}
+
+type decimalAddonItem struct {
+	*models.AddonItem
+	Price decimalAmount `json:"price"`
+}
+
+type decimalTransaction struct {
+	*models.Transaction
+	Amount    decimalAmount  `json:"amount"`
+	Fee       *decimalAmount `json:"fee" visibility:"admin"`
+	NetAmount *decimalAmount `json:"net_amount" visibility:"admin"`
+}
+
+func newDecimalTransaction(t *models.Transaction) *decimalTransaction {
+	trans := &decimalTransaction{Transaction: t, Amount: decimalAmount(t.Amount)}
+	if t.Fee != nil {
+		fee := decimalAmount(*t.Fee)
+		trans.Fee = &fee
+	}
+	if t.NetAmount != nil {
+		net := decimalAmount(*t.NetAmount)
+		trans.NetAmount = &net
+	}
+	return trans
+}
+
+func newDecimalOrder(order *models.Order) *decimalOrder {
+	o := &decimalOrder{
+		Order:        order,
+		Taxes:        decimalAmount(order.Taxes),
+		Shipping:     decimalAmount(order.Shipping),
+		SubTotal:     decimalAmount(order.SubTotal),
+		Discount:     decimalAmount(order.Discount),
+		NetTotal:     decimalAmount(order.NetTotal),
+		Tip:          decimalAmount(order.Tip),
+		Total:        decimalAmount(order.Total),
+		Transactions: newDecimalTransactions(order.Transactions),
+	}
+	if order.TaxOverride != nil {
+		taxes := decimalAmount(*order.TaxOverride)
+		o.TaxOverride = &taxes
+	}
+	for _, item := range order.TaxBreakdown {
+		o.TaxBreakdown = append(o.TaxBreakdown, decimalTaxItem{TaxItem: item, Amount: decimalAmount(item.Amount)})
+	}
+	if order.LineItems != nil {
+		o.LineItems = make([]*decimalLineItem, len(order.LineItems))
+		for i, item := range order.LineItems {
+			o.LineItems[i] = newDecimalLineItem(item)
+		}
+	}
+	return o
+}
+
+func newDecimalLineItem(item *models.LineItem) *decimalLineItem {
+	i := &decimalLineItem{
+		LineItem:   item,
+		Price:      decimalAmount(item.Price),
+		AddonPrice: decimalAmount(item.AddonPrice),
+	}
+	if item.CalculationDetail != nil {
+		detail := item.CalculationDetail
+		i.Calculation = &decimalCalculation{
+			CalculationDetail: detail,
+			Subtotal:          decimalAmount(detail.Subtotal),
+			Discount:          decimalAmount(detail.Discount),
+			NetTotal:          decimalAmount(detail.NetTotal),
+			Taxes:             decimalAmount(detail.Taxes),
+			Total:             decimalAmount(detail.Total),
+		}
+		for _, discount := range detail.DiscountItems {
+			i.Calculation.DiscountItems = append(i.Calculation.DiscountItems, decimalDiscountItem{
+				DiscountItem: discount,
+				Fixed:        decimalAmount(discount.Fixed),
+			})
+		}
+	}
+	if item.PriceItems != nil {
+		i.PriceItems = make([]*decimalPriceItem, len(item.PriceItems))
+		for j, price := range item.PriceItems {
+			i.PriceItems[j] = &decimalPriceItem{PriceItem: price, Amount: decimalAmount(price.Amount)}
+		}
+	}
+	if item.AddonItems != nil {
+		i.AddonItems = make([]*decimalAddonItem, len(item.AddonItems))
+		for j, addon := range item.AddonItems {
+			i.AddonItems[j] = &decimalAddonItem{AddonItem: addon, Price: decimalAmount(addon.Price)}
+		}
+	}
+	return i
+}
+
+func newDecimalTransactions(trans []*models.Transaction) []*decimalTransaction {
+	if trans == nil {
+		return nil
+	}
+	result := make([]*decimalTransaction, len(trans))
+	for i, t := range trans {
+		result[i] = newDecimalTransaction(t)
+	}
+	return result
+}
+
+// presentTransaction serializes the amount of a transaction as requested.
+// Admin only fields are left out for customers.
+func presentTransaction(r *http.Request, trans *models.Transaction) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return redactForCustomer(r, trans)
+	}
+	return redactForCustomer(r, newDecimalTransaction(trans))
+}
+
+// presentTransactions serializes the amounts of a list of transactions as
+// requested. Admin only fields are left out for customers.
+func presentTransactions(r *http.Request, trans []models.Transaction) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return redactForCustomer(r, trans)
+	}
+	decimal := make([]*decimalTransaction, len(trans))
+	for i := range trans {
+		decimal[i] = newDecimalTransaction(&trans[i])
+	}
+	return redactForCustomer(r, decimal)
+}
+
+type decimalOrderTotals struct {
+	*orderTotals
+	SubTotal     decimalAmount    `json:"subtotal"`
+	Discount     decimalAmount    `json:"discount"`
+	NetTotal     decimalAmount    `json:"net_total"`
+	Taxes        decimalAmount    `json:"taxes"`
+	TaxBreakdown []decimalTaxItem `json:"tax_breakdown"`
+	Tip          decimalAmount    `json:"tip"`
+	Total        decimalAmount    `json:"total"`
+}
+
+// presentOrderTotals serializes the totals of an order as requested.
+func presentOrderTotals(r *http.Request, totals *orderTotals) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return totals
+	}
+	decimal := &decimalOrderTotals{
+		orderTotals: totals,
+		SubTotal:    decimalAmount(totals.SubTotal),
+		Discount:    decimalAmount(totals.Discount),
+		NetTotal:    decimalAmount(totals.NetTotal),
+		Taxes:       decimalAmount(totals.Taxes),
+		Tip:         decimalAmount(totals.Tip),
+		Total:       decimalAmount(totals.Total),
+	}
+	for _, item := range totals.TaxBreakdown {
+		decimal.TaxBreakdown = append(decimal.TaxBreakdown, decimalTaxItem{TaxItem: item, Amount: decimalAmount(item.Amount)})
+	}
+	return decimal
+}
+
+type decimalSalesRow struct {
+	*salesRow
+	Total    decimalAmount `json:"total"`
+	SubTotal decimalAmount `json:"subtotal"`
+	Taxes    decimalAmount `json:"taxes"`
+	Tips     decimalAmount `json:"tips"`
+}
+
+type decimalProductsRow struct {
+	*productsRow
+	Total decimalAmount `json:"total"`
+}
+
+type decimalRefundsRow struct {
+	*refundsRow
+	Total decimalAmount `json:"total"`
+	Net   decimalAmount `json:"net"`
+	Fees  decimalAmount `json:"fees"`
+}
+
+type decimalProductRefundsRow struct {
+	*productRefundsRow
+	Total decimalAmount `json:"total"`
+}
+
+// presentSalesReport serializes the amounts of a sales report as requested.
+func presentSalesReport(r *http.Request, rows []*salesRow) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return rows
+	}
+	decimal := make([]*decimalSalesRow, len(rows))
+	for i, row := range rows {
+		decimal[i] = &decimalSalesRow{
+			salesRow: row,
+			Total:    decimalAmount(row.Total),
+			SubTotal: decimalAmount(row.SubTotal),
+			Taxes:    decimalAmount(row.Taxes),
+			Tips:     decimalAmount(row.Tips),
+		}
+	}
+	return decimal
+}
+
+// presentProductsReport serializes the amounts of a products report as
+// requested.
+func presentProductsReport(r *http.Request, rows []*productsRow) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return rows
+	}
+	decimal := make([]*decimalProductsRow, len(rows))
+	for i, row := range rows {
+		decimal[i] = &decimalProductsRow{productsRow: row, Total: decimalAmount(row.Total)}
+	}
+	return decimal
+}
+
+// presentRefundsReport serializes the amounts of a refunds report as
+// requested.
+func presentRefundsReport(r *http.Request, rows []*refundsRow) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return rows
+	}
+	decimal := make([]*decimalRefundsRow, len(rows))
+	for i, row := range rows {
+		decimal[i] = &decimalRefundsRow{
+			refundsRow: row,
+			Total:      decimalAmount(row.Total),
+			Net:        decimalAmount(row.Net),
+			Fees:       decimalAmount(row.Fees),
+		}
+	}
+	return decimal
+}
+
+// presentProductRefundsReport serializes the amounts of a product refunds
+// report as requested.
+func presentProductRefundsReport(r *http.Request, rows []*productRefundsRow) interface{} {
+	if !wantsDecimalAmounts(r) {
+		return rows
+	}
+	decimal := make([]*decimalProductRefundsRow, len(rows))
+	for i, row := range rows {
+		decimal[i] = &decimalProductRefundsRow{productRefundsRow: row, Total: decimalAmount(row.Total)}
+	}
+	return decimal
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/sebest/xff"
@@ -15,6 +16,8 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/netlify/netlify-commons/graceful"
 )
 
@@ -32,7 +35,11 @@ type API struct {
 	db         *gorm.DB
 	config     *conf.GlobalConfiguration
 	httpClient *http.Client
+	hookClient *http.Client
 	version    string
+
+	userListLimiter *rateLimiter
+	licenseClient   *http.Client
 }
 
 // ListenAndServe starts the REST API.
@@ -55,15 +62,22 @@ func NewAPI(globalConfig *conf.GlobalConfiguration, db *gorm.DB) *API {
 
 // NewAPIWithVersion instantiates a new REST API.
 func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfiguration, db *gorm.DB, version string) *API {
+	licenseClient := ssrf.NewGuard(globalConfig.Outbound.AllowedHosts).Client()
+	licenseClient.Timeout = licenseProviderTimeout
+
 	api := &API{
 		config:     globalConfig,
 		db:         db,
 		httpClient: &http.Client{},
+		hookClient: models.NewHookClient(time.Duration(globalConfig.Webhooks.Timeout)*time.Second, ssrf.NewGuard(globalConfig.Outbound.AllowedHosts), globalConfig.NodeID),
 		version:    version,
+
+		userListLimiter: newRateLimiter(userListRateLimit, userListRateWindow),
+		licenseClient:   licenseClient,
 	}
 
 	xffmw, _ := xff.Default()
-	logger := newStructuredLogger(logrus.StandardLogger())
+	logger := newStructuredLogger(logrus.StandardLogger(), globalConfig.NodeID)
 
 	r := newRouter()
 	r.UseBypass(xffmw.Handler)
@@ -85,8 +99,18 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 		r.Route("/downloads", func(r *router) {
 			r.With(authRequired).Get("/", api.DownloadList)
 			r.Get("/{download_id}", api.DownloadURL)
+			r.With(adminRequired).Put("/{download_id}/expiry", api.DownloadSetExpiry)
 		})
 
+		r.Route("/licenses/{license_key}", func(r *router) {
+			r.Post("/activate", api.LicenseActivate)
+			r.Post("/deactivate", api.LicenseDeactivate)
+		})
+
+		r.With(adminRequired).Get("/serials/{serial}", api.SerialNumberView)
+
+		r.With(limitBody).Post("/tax/estimate", api.TaxEstimate)
+
 		r.Route("/vatnumbers", func(r *router) {
 			r.Get("/{vat_number}", api.VatNumberLookup)
 		})
@@ -105,19 +129,42 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 			r.With(addGetBody).Post("/", api.PreauthorizePayment)
 		})
 
+		r.Route("/braintree", func(r *router) {
+			r.With(addGetBody).Post("/", api.PreauthorizePayment)
+		})
+
 		r.Route("/reports", func(r *router) {
 			r.Use(adminRequired)
 
 			r.Get("/sales", api.SalesReport)
 			r.Get("/products", api.ProductsReport)
+			r.Get("/refunds", api.RefundsReport)
+			r.Get("/refunds/products", api.ProductRefundsReport)
+		})
+
+		r.Route("/inventory", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Get("/", api.InventoryList)
+			r.Get("/backorders", api.BackorderList)
+			r.Put("/{sku}", api.InventoryUpdate)
+			r.Post("/{sku}/restock", api.InventoryRestock)
 		})
 
 		r.Route("/coupons", func(r *router) {
 			r.With(adminRequired).Get("/", api.CouponList)
+			r.With(adminRequired).Post("/bulk", api.CouponBulkCreate)
 			r.Get("/{coupon_code}", api.CouponView)
 		})
 
+		r.Route("/webhooks", func(r *router) {
+			r.Use(adminRequired)
+
+			r.Post("/{endpoint}/test", api.WebhookTest)
+		})
+
 		r.Get("/settings", api.ViewSettings)
+		r.Get("/config", api.ViewConfig)
 
 		r.With(authRequired).Post("/claim", api.ClaimOrders)
 	})
@@ -153,27 +200,48 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 
 func (a *API) orderRoutes(r *router) {
 	r.With(authRequired).Get("/", a.OrderList)
-	r.Post("/", a.OrderCreate)
+	r.With(limitBody).Post("/", a.OrderCreate)
+	r.With(authRequired).Post("/claim", a.OrderClaim)
 
 	r.Route("/{order_id}", func(r *router) {
 		r.Use(a.withOrderID)
 		r.Get("/", a.OrderView)
-		r.With(adminRequired).Put("/", a.OrderUpdate)
+		r.With(adminRequired).With(limitBody).Put("/", a.OrderUpdate)
+		r.With(adminRequired).Patch("/tax", a.OrderTaxOverride)
+		r.With(adminRequired).Post("/approve", a.OrderApprove)
+		r.With(adminRequired).Post("/reject", a.OrderReject)
+		r.With(adminRequired).Post("/unarchive", a.OrderUnarchive)
+		r.With(authRequired).Post("/reorder", a.OrderReorder)
+		r.Post("/recalculate", a.OrderRecalculate)
+		r.With(adminRequired).Get("/webhooks", a.OrderWebhookDeliveries)
+		r.With(adminRequired).Get("/timeline", a.OrderTimeline)
+		r.With(adminRequired).Put("/line_items/{line_item_id}/fulfillment", a.LineItemFulfillmentUpdate)
+		r.With(adminRequired).Post("/shipments", a.ShipmentCreate)
+		r.With(adminRequired).Post("/returns", a.ReturnCreate)
+		r.With(adminRequired).Post("/line_items/{line_item_id}/serials", a.SerialNumberAssign)
 
 		r.Route("/payments", func(r *router) {
 			r.With(authRequired).Get("/", a.PaymentListForOrder)
 			r.With(addGetBody).Post("/", a.PaymentCreate)
+			r.With(adminRequired).Post("/manual/confirm", a.ManualPaymentConfirm)
 		})
 
 		r.Get("/downloads", a.DownloadList)
+		r.Get("/licenses", a.OrderLicenses)
 		r.Get("/receipt", a.ReceiptView)
 		r.Post("/receipt", a.ResendOrderReceipt)
+
+		r.Route("/invoice", func(r *router) {
+			r.Get("/", a.OrderInvoice)
+			r.With(adminRequired).Post("/credit_notes", a.CreditNoteCreate)
+			r.Get("/credit_notes/{number}", a.CreditNoteView)
+		})
 	})
 }
 
 func (a *API) userRoutes(r *router) {
 	r.Use(authRequired)
-	r.With(adminRequired).Get("/", a.UserList)
+	r.With(adminRequired).With(rateLimit(a.userListLimiter)).Get("/", a.UserList)
 	r.With(adminRequired).Delete("/", a.UserBulkDelete)
 
 	r.Route("/{user_id}", func(r *router) {
@@ -185,6 +253,7 @@ func (a *API) userRoutes(r *router) {
 
 		r.Get("/payments", a.PaymentListForUser)
 		r.Get("/orders", a.OrderList)
+		r.Get("/downloads", a.DownloadList)
 
 		r.Route("/addresses", func(r *router) {
 			r.Get("/", a.AddressList)
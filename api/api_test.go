@@ -23,7 +23,7 @@ func TestTraceWrapper(t *testing.T) {
 	config.Payment.Stripe.Enabled = true
 	config.Payment.Stripe.SecretKey = "secret"
 
-	ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, config, "")
+	ctx, err := WithInstanceConfig(context.Background(), globalConfig, config, "")
 	require.NoError(t, err)
 	api := NewAPIWithVersion(ctx, globalConfig, nil, "")
 
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const archivalPeriod = time.Hour
+
+// archiveOrders archives the closed orders of an instance that haven't
+// changed for the configured number of days. Orders are closed once they're
+// fulfilled, returned, rejected, their payment failed or they've been refunded
+// in full.
+func archiveOrders(db *gorm.DB, instanceID string, config *conf.Configuration, log logrus.FieldLogger) error {
+	orderTable := db.NewScope(models.Order{}).QuotedTableName()
+	transactionTable := db.NewScope(models.Transaction{}).QuotedTableName()
+	refunded := "(SELECT COALESCE(SUM(amount), 0) FROM " + transactionTable +
+		" WHERE order_id = " + orderTable + ".id AND type = ? AND status = ?)"
+
+	ids := []string{}
+	rsp := db.Model(&models.Order{}).
+		Where("instance_id = ? AND archived = ? AND updated_at < ?", instanceID, false, time.Now().AddDate(0, 0, -config.Archive.After)).
+		Where("fulfillment_state IN (?) OR payment_state = ? OR (payment_state = ? AND total > 0 AND total <= "+refunded+")",
+			[]string{models.FulfilledState, models.ReturnedState, models.RejectedState}, models.FailedState,
+			models.PaidState, models.RefundTransactionType, models.PaidState).
+		Pluck("id", &ids)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for closed orders")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx := db.Begin()
+	if rsp := tx.Model(&models.Order{}).Where("id IN (?)", ids).UpdateColumn("archived", true); rsp.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(rsp.Error, "Error archiving orders")
+	}
+	for _, id := range ids {
+		models.LogEvent(tx, "", "", id, models.EventArchived, []string{"archived"})
+	}
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error archiving orders")
+	}
+	log.Infof("Archived %d orders", len(ids))
+	return nil
+}
+
+// RunOrderArchival creates a goroutine that archives closed orders every
+// hour. Without a config, as in multi instance mode, all instances that
+// enabled archival are handled.
+func RunOrderArchival(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry) {
+	go func() {
+		for {
+			forEachInstance(db, globalConfig, config, log, func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) {
+				if config.Archive.After <= 0 {
+					return
+				}
+				if err := archiveOrders(db, instanceID, config, log); err != nil {
+					log.WithError(err).Error("Error archiving orders")
+				}
+			})
+			time.Sleep(archivalPeriod)
+		}
+	}()
+}
+
+// OrderUnarchive restores an archived order to the order listings. Since
+// this changes the order, it's only archived again once it hasn't changed
+// for the configured number of days.
+func (a *API) OrderUnarchive(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	claims := gcontext.GetClaims(ctx)
+	log := getLogEntry(r)
+
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if !order.Archived {
+		return badRequestError("Only archived orders can be unarchived")
+	}
+
+	order.Archived = false
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving order").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventUnarchived, []string{"archived"})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error committing unarchived order").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Order %s was unarchived", order.ID)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderArchival(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Archive.After = 30
+
+	age := func(id string, days int) {
+		require.NoError(t, test.DB.Model(&models.Order{}).Where("id = ?", id).UpdateColumn("updated_at", time.Now().AddDate(0, 0, -days)).Error)
+	}
+	listOrders := func(query string) []string {
+		recorder := test.TestEndpoint(http.MethodGet, "/orders"+query, nil, test.Data.testUserToken)
+		orders := []models.Order{}
+		extractPage(t, http.StatusOK, recorder, &orders)
+		ids := []string{}
+		for _, order := range orders {
+			ids = append(ids, order.ID)
+		}
+		return ids
+	}
+
+	// the first order is fulfilled, the second one refunded in full
+	test.Data.firstOrder.FulfillmentState = models.FulfilledState
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	refund := models.NewTransaction(test.Data.secondOrder)
+	refund.ID = "second-refund"
+	refund.Type = models.RefundTransactionType
+	refund.Status = models.PaidState
+	refund.Amount = test.Data.secondOrder.Total
+	require.NoError(t, test.DB.Create(refund).Error)
+
+	// recently changed orders are kept
+	age("second-order", 5)
+	require.NoError(t, archiveOrders(test.DB, "", test.Config, testLogger))
+	assert.Len(t, listOrders(""), 2)
+
+	age("first-order", 40)
+	age("second-order", 40)
+	require.NoError(t, archiveOrders(test.DB, "", test.Config, testLogger))
+	assert.Empty(t, listOrders(""))
+	assert.ElementsMatch(t, []string{"first-order", "second-order"}, listOrders("?include_archived=true"))
+
+	event := &models.Event{}
+	require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventArchived).Error)
+
+	t.Run("Unarchive", func(t *testing.T) {
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/unarchive", nil, token)
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.False(t, order.Archived)
+		assert.Equal(t, []string{"first-order"}, listOrders(""))
+
+		// unarchived orders aren't archived again right away
+		require.NoError(t, archiveOrders(test.DB, "", test.Config, testLogger))
+		assert.Equal(t, []string{"first-order"}, listOrders(""))
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/unarchive", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "Only archived orders")
+	})
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/second-order/unarchive", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestOrderArchivalOpenOrders(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Archive.After = 30
+
+	// paid orders that haven't been fulfilled stay open, as do partial refunds
+	refund := models.NewTransaction(test.Data.secondOrder)
+	refund.ID = "second-refund"
+	refund.Type = models.RefundTransactionType
+	refund.Status = models.PaidState
+	refund.Amount = test.Data.secondOrder.Total - 1
+	require.NoError(t, test.DB.Create(refund).Error)
+	require.NoError(t, test.DB.Model(&models.Order{}).UpdateColumn("updated_at", time.Now().AddDate(0, 0, -40)).Error)
+
+	require.NoError(t, archiveOrders(test.DB, "", test.Config, testLogger))
+	count := 0
+	require.NoError(t, test.DB.Model(&models.Order{}).Where("archived = ?", true).Count(&count).Error)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const capturePeriod = 10 * time.Minute
+
+// captureMargin is how long before the provider releases an authorization
+// the payment is captured at the latest.
+const captureMargin = 12 * time.Hour
+
+// captureDeadline is the latest time a payment authorized at authorizedAt is
+// captured.
+func captureDeadline(capturer payments.Capturer, authorizedAt time.Time) time.Time {
+	return authorizedAt.Add(capturer.AuthorizationExpiry() - captureMargin)
+}
+
+// scheduleCapture returns when a payment authorized at authorizedAt is
+// captured: after the configured delay, but before its authorization expires.
+func scheduleCapture(config *conf.Configuration, capturer payments.Capturer, authorizedAt time.Time) time.Time {
+	captureAt := authorizedAt.Add(time.Duration(config.Payment.Capture.Delay) * time.Hour)
+	if deadline := captureDeadline(capturer, authorizedAt); captureAt.After(deadline) {
+		return deadline
+	}
+	return captureAt
+}
+
+// captureAuthorizedPayments captures the authorized payments of an instance
+// once they're due and voids those of rejected orders. Payments of orders
+// still on hold for review wait until their authorization is about to expire.
+// Payments the provider failed to capture or void are retried next time,
+// until a failed capture passes its deadline: then the payment has failed and
+// the shop is alerted.
+func captureAuthorizedPayments(db *gorm.DB, instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) error {
+	transactions := []*models.Transaction{}
+	rsp := db.Where("instance_id = ? AND type = ? AND status = ? AND capture_at IS NOT NULL", instanceID, models.ChargeTransactionType, models.PaidState).
+		Find(&transactions)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for authorized payments")
+	}
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	providers, err := createPaymentProviders(config)
+	if err != nil {
+		return errors.Wrap(err, "Error creating payment providers")
+	}
+
+	now := time.Now()
+	for _, tr := range transactions {
+		log := log.WithFields(logrus.Fields{"order_id": tr.OrderID, "transaction_id": tr.ID})
+		order := &models.Order{}
+		if rsp := db.First(order, "id = ?", tr.OrderID); rsp.Error != nil {
+			log.WithError(rsp.Error).Error("Error loading the order of an authorized payment")
+			continue
+		}
+		capturer, ok := providers[order.PaymentProcessor].(payments.Capturer)
+		if !ok {
+			log.Errorf("Payment provider '%s' can't capture payments", order.PaymentProcessor)
+			continue
+		}
+
+		switch {
+		case order.FulfillmentState == models.RejectedState:
+			if err := capturer.Void(context.Background(), tr.ProcessorID); err != nil {
+				log.WithError(err).Error("Error voiding authorized payment")
+				continue
+			}
+			if err := completeCapture(db, order, tr, models.VoidedState, models.EventPaymentVoided); err != nil {
+				return err
+			}
+			log.Infof("Voided the payment of rejected order %s", order.ID)
+		case now.Before(*tr.CaptureAt):
+		case order.FulfillmentState == models.OnHoldState && now.Before(captureDeadline(capturer, tr.CreatedAt)):
+		default:
+			if err := capturer.Capture(context.Background(), tr.ProcessorID, tr.Amount, tr.Currency); err != nil {
+				log.WithError(err).Error("Error capturing authorized payment")
+				if now.Before(captureDeadline(capturer, tr.CreatedAt)) {
+					continue
+				}
+				if err := failCapture(db, order, tr, err); err != nil {
+					return err
+				}
+				log.WithField("alert", "payment_capture_failed").Errorf("The payment of order %s couldn't be captured before its authorization expired", order.ID)
+				tr.Order = order
+				if err := m.CaptureFailedMail(tr); err != nil {
+					log.WithError(err).Error("Error sending capture failed mail")
+				}
+				continue
+			}
+			if err := completeCapture(db, order, tr, models.PaidState, models.EventPaymentCaptured); err != nil {
+				return err
+			}
+			log.Infof("Captured the payment of order %s", order.ID)
+		}
+	}
+	return nil
+}
+
+// completeCapture records the outcome of capturing or voiding an authorized
+// payment. Voided payments leave the order unpaid.
+func completeCapture(db *gorm.DB, order *models.Order, tr *models.Transaction, status string, eventType models.EventType) error {
+	tx := db.Begin()
+	rsp := tx.Model(tr).Updates(map[string]interface{}{"status": status, "capture_at": gorm.Expr("NULL")})
+	if rsp.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(rsp.Error, "Error saving captured payment")
+	}
+	changes := []string{"capture_at"}
+	if status == models.VoidedState {
+		rsp := tx.Model(order).UpdateColumn("payment_state", models.VoidedState)
+		if rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error saving voided payment")
+		}
+		changes = []string{"payment_state"}
+	}
+	models.LogEvent(tx, "", "", order.ID, eventType, changes)
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error saving captured payment")
+	}
+	return nil
+}
+
+// failCapture marks an authorized payment that couldn't be captured in time,
+// and its order, as failed.
+func failCapture(db *gorm.DB, order *models.Order, tr *models.Transaction, captureErr error) error {
+	tx := db.Begin()
+	rsp := tx.Model(tr).Updates(map[string]interface{}{
+		"status":              models.FailedState,
+		"failure_description": captureErr.Error(),
+		"capture_at":          gorm.Expr("NULL"),
+	})
+	if rsp.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(rsp.Error, "Error saving failed capture")
+	}
+	if rsp := tx.Model(order).UpdateColumn("payment_state", models.FailedState); rsp.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(rsp.Error, "Error saving failed capture")
+	}
+	models.LogEvent(tx, "", "", order.ID, models.EventPaymentCaptureFailed, []string{"payment_state"})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error saving failed capture")
+	}
+	return nil
+}
+
+// RunPaymentCaptures creates a goroutine that captures authorized payments
+// once their capture delay passed. Without a config, as in multi instance
+// mode, all instances are handled.
+func RunPaymentCaptures(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry) {
+	go func() {
+		for {
+			forEachInstance(db, globalConfig, config, log, func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) {
+				if err := captureAuthorizedPayments(db, instanceID, config, m, log); err != nil {
+					log.WithError(err).Error("Error capturing authorized payments")
+				}
+			})
+			time.Sleep(capturePeriod)
+		}
+	}()
+}
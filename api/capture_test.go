@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+// authorizeStripePayment pays the first order with Stripe while captures are
+// delayed, tracking the Stripe API calls in calls.
+func authorizeStripePayment(t *testing.T, test *RouteTest, calls *[]string) *models.Transaction {
+	test.Config.Payment.Capture.Delay = 24
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		*calls = append(*calls, path)
+		switch path {
+		case "/charges":
+			p := params.(*stripe.ChargeParams)
+			require.NotNil(t, p.Capture)
+			assert.False(t, *p.Capture, "the payment is only authorized")
+			v.(*stripe.Charge).ID = "ch_123"
+		case "/charges/ch_123/capture":
+			p := params.(*stripe.CaptureParams)
+			assert.EqualValues(t, test.Data.firstOrder.Total, *p.Amount)
+		case "/refunds":
+			assert.Equal(t, "ch_123", *params.(*stripe.RefundParams).Charge)
+		default:
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+	}))
+
+	test.Data.firstOrder.PaymentState = models.PendingState
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	body, err := json.Marshal(&stripePaymentParams{
+		Amount:      test.Data.firstOrder.Total,
+		Currency:    test.Data.firstOrder.Currency,
+		StripeToken: "123456",
+		Provider:    payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	tr := &models.Transaction{}
+	extractPayload(t, http.StatusOK, recorder, tr)
+	assert.Equal(t, models.PaidState, tr.Status)
+	require.NotNil(t, tr.CaptureAt)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *tr.CaptureAt, time.Minute)
+	return tr
+}
+
+type captureMailer struct {
+	mailer.Mailer
+	failed []*models.Transaction
+}
+
+func (m *captureMailer) CaptureFailedMail(tr *models.Transaction) error {
+	m.failed = append(m.failed, tr)
+	return nil
+}
+
+// failingCaptureBackend fails the captures of Stripe payments.
+type failingCaptureBackend struct {
+	stripe.Backend
+}
+
+func (b failingCaptureBackend) Call(method, path, key string, params stripe.ParamsContainer, v interface{}) error {
+	if strings.HasSuffix(path, "/capture") {
+		return errors.New("The authorization has expired")
+	}
+	return b.Backend.Call(method, path, key, params, v)
+}
+
+func TestPaymentCapture(t *testing.T) {
+	t.Run("Delayed", func(t *testing.T) {
+		test := NewRouteTest(t)
+		calls := []string{}
+		m := &captureMailer{}
+		tr := authorizeStripePayment(t, test, &calls)
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := bytes.NewBufferString(`{"amount": 1, "currency": "USD", "reason": "defective"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/payments/"+tr.ID+"/refund", body, token)
+		validateError(t, http.StatusBadRequest, recorder, "before it's captured")
+
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Equal(t, []string{"/charges"}, calls, "the payment isn't due yet")
+
+		require.NoError(t, test.DB.Model(tr).UpdateColumn("capture_at", time.Now().Add(-time.Minute)).Error)
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Equal(t, []string{"/charges", "/charges/ch_123/capture"}, calls)
+
+		saved, err := models.GetTransaction(test.DB, tr.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.PaidState, saved.Status)
+		assert.Nil(t, saved.CaptureAt)
+
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Len(t, calls, 2, "payments are only captured once")
+	})
+
+	t.Run("OnHold", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Review.HoldOverAmount = 1
+		calls := []string{}
+		m := &captureMailer{}
+		tr := authorizeStripePayment(t, test, &calls)
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		require.NoError(t, test.DB.Model(tr).UpdateColumn("capture_at", time.Now().Add(-time.Minute)).Error)
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Len(t, calls, 1, "orders on hold wait for their review")
+
+		require.NoError(t, test.DB.Model(tr).UpdateColumn("created_at", time.Now().AddDate(0, 0, -7)).Error)
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Equal(t, []string{"/charges", "/charges/ch_123/capture"}, calls, "the authorization is about to expire")
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Review.HoldOverAmount = 1
+		calls := []string{}
+		m := &captureMailer{}
+		tr := authorizeStripePayment(t, test, &calls)
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/reject", nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Equal(t, []string{"/charges", "/refunds"}, calls, "payments of rejected orders are voided right away")
+
+		saved, err := models.GetTransaction(test.DB, tr.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.VoidedState, saved.Status)
+		assert.Nil(t, saved.CaptureAt)
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.VoidedState, order.PaymentState)
+
+		event := &models.Event{}
+		require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventPaymentVoided).Error)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		test := NewRouteTest(t)
+		calls := []string{}
+		m := &captureMailer{}
+		tr := authorizeStripePayment(t, test, &calls)
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+		stripe.SetBackend(stripe.APIBackend, failingCaptureBackend{stripe.GetBackend(stripe.APIBackend)})
+
+		require.NoError(t, test.DB.Model(tr).UpdateColumn("capture_at", time.Now().Add(-time.Minute)).Error)
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		saved, err := models.GetTransaction(test.DB, tr.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.PaidState, saved.Status, "failed captures are retried")
+		assert.Empty(t, m.failed)
+
+		require.NoError(t, test.DB.Model(tr).UpdateColumn("created_at", time.Now().AddDate(0, 0, -7)).Error)
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		saved, err = models.GetTransaction(test.DB, tr.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FailedState, saved.Status)
+		assert.Contains(t, saved.FailureDescription, "authorization has expired")
+		assert.Nil(t, saved.CaptureAt)
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.FailedState, order.PaymentState)
+		event := &models.Event{}
+		require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventPaymentCaptureFailed).Error)
+		if assert.Len(t, m.failed, 1, "the shop is alerted") {
+			assert.Equal(t, "first-order", m.failed[0].Order.ID)
+		}
+
+		require.NoError(t, captureAuthorizedPayments(test.DB, "", test.Config, m, testLogger))
+		assert.Len(t, m.failed, 1, "failed payments aren't captured again")
+	})
+}
+
+func TestScheduleCapture(t *testing.T) {
+	test := NewRouteTest(t)
+	providers, err := createPaymentProviders(test.Config)
+	require.NoError(t, err)
+	capturer, ok := providers[payments.StripeProvider].(payments.Capturer)
+	require.True(t, ok)
+
+	now := time.Now()
+	test.Config.Payment.Capture.Delay = 48
+	assert.Equal(t, now.Add(48*time.Hour), scheduleCapture(test.Config, capturer, now))
+
+	test.Config.Payment.Capture.Delay = 30 * 24
+	assert.Equal(t, now.Add(7*24*time.Hour-captureMargin), scheduleCapture(test.Config, capturer, now), "payments are captured before their authorization expires")
+}
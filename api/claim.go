@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+const orderClaimAudience = "order_claim"
+
+type orderClaimClaims struct {
+	jwt.StandardClaims
+	OrderID string `json:"order_id"`
+	Email   string `json:"email"`
+}
+
+type orderClaimParams struct {
+	Token string `json:"token"`
+}
+
+// orderClaimKey derives the signing key for claim tokens from the JWT secret,
+// so a claim token can never be used to authenticate as a user.
+func orderClaimKey(config *conf.Configuration) []byte {
+	mac := hmac.New(sha256.New, []byte(config.JWT.Secret))
+	mac.Write([]byte(orderClaimAudience))
+	return mac.Sum(nil)
+}
+
+// newOrderClaimToken signs a token allowing the owner of the order's email
+// to attach a guest order to their account.
+func newOrderClaimToken(config *conf.Configuration, order *models.Order) (string, error) {
+	claims := &orderClaimClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  orderClaimAudience,
+			Subject:   order.ID,
+			ExpiresAt: time.Now().Add(time.Duration(config.Claims.TokenExpiration) * time.Second).Unix(),
+		},
+		OrderID: order.ID,
+		Email:   order.Email,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(orderClaimKey(config))
+}
+
+func parseOrderClaimToken(config *conf.Configuration, tokenString string) (*orderClaimClaims, *HTTPError) {
+	claims := &orderClaimClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+	_, err := p.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return orderClaimKey(config), nil
+	})
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, goneError("Claim token has expired")
+		}
+		return nil, badRequestError("Invalid claim token").WithInternalError(err)
+	}
+	if !claims.VerifyAudience(orderClaimAudience, true) || claims.OrderID == "" {
+		return nil, badRequestError("Invalid claim token")
+	}
+	return claims, nil
+}
+
+// sendOrderClaimMail emails a claim token for a guest order to the order's email.
+func sendOrderClaimMail(ctx context.Context, order *models.Order, log logrus.FieldLogger) {
+	config := gcontext.GetConfig(ctx)
+	mailer := gcontext.GetMailer(ctx)
+
+	token, err := newOrderClaimToken(config, order)
+	if err != nil {
+		log.WithError(err).Error("Failed to create order claim token")
+		return
+	}
+
+	go func() {
+		if err := mailer.OrderClaimMail(order, token); err != nil {
+			log.WithError(err).Error("Error sending order claim mail")
+		}
+	}()
+}
+
+// OrderClaim attaches a guest order to the account of the current user, given
+// a claim token that was sent to the order's email address.
+func (a *API) OrderClaim(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	instanceID := gcontext.GetInstanceID(ctx)
+	log := getLogEntry(r)
+
+	claims := gcontext.GetClaims(ctx)
+	if claims.Email == "" {
+		return badRequestError("Must provide an email in the token to claim an order")
+	}
+	if claims.Subject == "" {
+		return badRequestError("Must provide a ID in the token to claim an order")
+	}
+
+	params := &orderClaimParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read claim params: %v", err)
+	}
+	if params.Token == "" {
+		return badRequestError("Claiming an order requires a 'token'")
+	}
+
+	claimToken, httpErr := parseOrderClaimToken(config, params.Token)
+	if httpErr != nil {
+		return httpErr
+	}
+	if !strings.EqualFold(claimToken.Email, claims.Email) {
+		return httpError(http.StatusForbidden, "This order can only be claimed by %s", claimToken.Email)
+	}
+
+	order := &models.Order{}
+	if rsp := orderQuery(a.db).Where("id = ? AND instance_id = ?", claimToken.OrderID, instanceID).First(order); rsp.Error != nil {
+		if rsp.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if order.UserID != "" {
+		return httpError(http.StatusConflict, "Order has already been claimed")
+	}
+	if !strings.EqualFold(order.Email, claims.Email) {
+		return httpError(http.StatusForbidden, "This order can only be claimed by %s", order.Email)
+	}
+
+	tx := a.db.Begin()
+
+	user := models.User{
+		InstanceID: instanceID,
+		ID:         claims.Subject,
+		Email:      claims.Email,
+	}
+	if res := tx.FirstOrCreate(&user); res.Error != nil {
+		tx.Rollback()
+		return internalServerError("Failed to create user with ID %s", claims.Subject).WithInternalError(res.Error)
+	}
+
+	// only one user may claim the order
+	rsp := tx.Model(&models.Order{}).Where("id = ? AND user_id = ?", order.ID, "").UpdateColumn("user_id", user.ID)
+	if rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Failed to claim order").WithInternalError(rsp.Error)
+	}
+	if rsp.RowsAffected == 0 {
+		tx.Rollback()
+		return httpError(http.StatusConflict, "Order has already been claimed")
+	}
+	addressIDs := []string{order.ShippingAddressID, order.BillingAddressID}
+	if rsp := tx.Model(&models.Address{}).Where("id IN (?) AND user_id = ?", addressIDs, "").UpdateColumn("user_id", user.ID); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Failed to update order addresses").WithInternalError(rsp.Error)
+	}
+	order.UserID = user.ID
+	order.ShippingAddress.UserID = user.ID
+	order.BillingAddress.UserID = user.ID
+
+	models.LogEvent(tx, r.RemoteAddr, user.ID, order.ID, models.EventClaimed, nil)
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Failed to claim order").WithInternalError(rsp.Error)
+	}
+
+	log.WithField("user_id", user.ID).Infof("Order %s was claimed", order.ID)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderClaim(t *testing.T) {
+	setupGuestOrder := func(test *RouteTest) string {
+		test.Data.firstOrder.Email = "villian@wayneindustries.com"
+		test.Data.firstOrder.UserID = ""
+		test.Data.firstOrder.User = nil
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		addressIDs := []string{test.Data.firstOrder.ShippingAddressID, test.Data.firstOrder.BillingAddressID}
+		require.NoError(t, test.DB.Model(&models.Address{}).Where("id IN (?)", addressIDs).UpdateColumn("user_id", "").Error)
+
+		// the test config has no defaults, which would expire the token right away
+		if test.Config.Claims.TokenExpiration == 0 {
+			test.Config.Claims.TokenExpiration = 60 * 60
+		}
+		claimToken, err := newOrderClaimToken(test.Config, test.Data.firstOrder)
+		require.NoError(t, err)
+		return claimToken
+	}
+
+	t.Run("Simple", func(t *testing.T) {
+		test := NewRouteTest(t)
+		claimToken := setupGuestOrder(test)
+
+		token := testToken("villian", "villian@wayneindustries.com")
+		body := fmt.Sprintf(`{"token": "%s"}`, claimToken)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), token)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.Equal(t, "villian", order.UserID)
+
+		stored := &models.Order{}
+		require.NoError(t, test.DB.First(stored, "id = ?", test.Data.firstOrder.ID).Error)
+		assert.Equal(t, "villian", stored.UserID)
+
+		address := &models.Address{ID: stored.BillingAddressID}
+		require.NoError(t, test.DB.First(address).Error)
+		assert.Equal(t, "villian", address.UserID)
+
+		// the same token can't be used twice
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), token)
+		validateError(t, http.StatusConflict, recorder)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Claims.TokenExpiration = -60
+		claimToken := setupGuestOrder(test)
+
+		token := testToken("villian", "villian@wayneindustries.com")
+		body := fmt.Sprintf(`{"token": "%s"}`, claimToken)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), token)
+		validateError(t, http.StatusGone, recorder)
+	})
+
+	t.Run("OtherEmail", func(t *testing.T) {
+		test := NewRouteTest(t)
+		claimToken := setupGuestOrder(test)
+
+		token := testToken("joker", "joker@wayneindustries.com")
+		body := fmt.Sprintf(`{"token": "%s"}`, claimToken)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), token)
+		validateError(t, http.StatusForbidden, recorder)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		test := NewRouteTest(t)
+		setupGuestOrder(test)
+
+		// a regular user token must not be accepted as a claim token
+		userToken, err := testToken("villian", "villian@wayneindustries.com").SignedString([]byte(test.Config.JWT.Secret))
+		require.NoError(t, err)
+
+		token := testToken("villian", "villian@wayneindustries.com")
+		body := fmt.Sprintf(`{"token": "%s"}`, userToken)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		test := NewRouteTest(t)
+		claimToken := setupGuestOrder(test)
+
+		body := fmt.Sprintf(`{"token": "%s"}`, claimToken)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/claim", strings.NewReader(body), nil)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
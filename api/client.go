@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/netlify/gocommerce/models"
+)
+
+// clientIP returns the address of the client that made a request, without the
+// port. Behind a proxy the xff middleware already replaced the remote address
+// with the client's address from X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientUserAgent returns the user agent of a request, truncated to the
+// length stored with orders.
+func clientUserAgent(r *http.Request) string {
+	userAgent := strings.TrimSpace(r.UserAgent())
+	if len(userAgent) > models.MaxUserAgentLength {
+		userAgent = stripInvalidUTF8(userAgent[:models.MaxUserAgentLength])
+	}
+	return userAgent
+}
+
+// stripInvalidUTF8 removes invalid runes, e.g. one cut in half by truncating.
+func stripInvalidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	valid := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r != utf8.RuneError || size > 1 {
+			valid = append(valid, s[i:i+size]...)
+		}
+		i += size
+	}
+	return string(valid)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "192.0.2.1", clientIP(r))
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	assert.Equal(t, "2001:db8::1", clientIP(r))
+}
+
+func TestClientUserAgent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set("User-Agent", " Mozilla/5.0 (Macintosh) ")
+	assert.Equal(t, "Mozilla/5.0 (Macintosh)", clientUserAgent(r))
+
+	r.Header.Set("User-Agent", strings.Repeat("a", models.MaxUserAgentLength+10))
+	assert.Len(t, clientUserAgent(r), models.MaxUserAgentLength)
+
+	r.Header.Set("User-Agent", strings.Repeat("a", models.MaxUserAgentLength-1)+"ü")
+	assert.Equal(t, strings.Repeat("a", models.MaxUserAgentLength-1), clientUserAgent(r), "a rune cut in half is removed")
+}
+
+func TestOrderCreateClient(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	header := http.Header{
+		"User-Agent":      []string{"Mozilla/5.0 (Macintosh)"},
+		"X-Forwarded-For": []string{"203.0.113.7"},
+	}
+
+	recorder := test.TestEndpointWithHeaders(http.MethodPost, "/orders", strings.NewReader(consentPayload("/simple-product", "")), test.Data.testUserToken, header)
+	body := recorder.Body.String()
+	assert.NotContains(t, body, "203.0.113.7", "the client is only shown to admins")
+	assert.NotContains(t, body, "Macintosh")
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+
+	saved := &models.Order{}
+	require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, "203.0.113.7", saved.IP)
+	assert.Equal(t, "Mozilla/5.0 (Macintosh)", saved.UserAgent)
+
+	event := &models.Event{}
+	require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", order.ID, models.EventCreated).Error)
+	assert.Equal(t, "203.0.113.7", event.IP)
+	assert.Equal(t, "Mozilla/5.0 (Macintosh)", event.UserAgent)
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID, nil, token)
+	order = &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, order)
+	assert.Equal(t, "203.0.113.7", order.IP)
+	assert.Equal(t, "Mozilla/5.0 (Macintosh)", order.UserAgent)
+}
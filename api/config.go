@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+)
+
+// publicConfig is the part of the configuration storefronts need to render
+// prices and payment options. It must never contain secrets.
+type publicConfig struct {
+	Currencies         []string          `json:"currencies"`
+	PaymentProviders   []string          `json:"payment_providers"`
+	PaymentRouting     map[string]string `json:"payment_routing,omitempty"`
+	PricesIncludeTaxes bool              `json:"prices_include_taxes"`
+	MinimumCharge      map[string]uint64 `json:"minimum_charge"`
+}
+
+// currencyEnabled checks if orders can be placed in a currency.
+func currencyEnabled(config *conf.Configuration, currency string) bool {
+	if len(config.Payment.Currencies) == 0 {
+		return true
+	}
+	for _, c := range config.Payment.Currencies {
+		if strings.EqualFold(c, currency) {
+			return true
+		}
+	}
+	return false
+}
+
+// minimumCharge returns the smallest amount that can be charged in a
+// currency, 0 if there's no minimum.
+func minimumCharge(config *conf.Configuration, currency string) uint64 {
+	for c, min := range config.Payment.MinimumCharge {
+		if strings.EqualFold(c, currency) {
+			return min
+		}
+	}
+	return 0
+}
+
+// ViewConfig returns the currencies, payment providers and pricing options
+// of the shop, so storefronts don't have to hardcode them. An empty list of
+// currencies means all currencies are accepted.
+func (a *API) ViewConfig(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+
+	settings, err := a.loadSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("Error loading site settings: %v", err)
+	}
+
+	rsp := &publicConfig{
+		Currencies:         []string{},
+		PaymentProviders:   []string{},
+		PricesIncludeTaxes: settings.PricesIncludeTaxes,
+		MinimumCharge:      map[string]uint64{},
+	}
+	for _, c := range config.Payment.Currencies {
+		rsp.Currencies = append(rsp.Currencies, strings.ToUpper(c))
+	}
+	for name := range gcontext.GetPaymentProviders(ctx) {
+		rsp.PaymentProviders = append(rsp.PaymentProviders, name)
+	}
+	sort.Strings(rsp.PaymentProviders)
+	if paymentRoutingEnabled(config) {
+		rsp.PaymentRouting = map[string]string{}
+		for c, name := range config.Payment.Routing.Currencies {
+			rsp.PaymentRouting[strings.ToUpper(c)] = strings.ToLower(name)
+		}
+		if config.Payment.Routing.Default != "" {
+			rsp.PaymentRouting["default"] = strings.ToLower(config.Payment.Routing.Default)
+		}
+	}
+	for c, min := range config.Payment.MinimumCharge {
+		rsp.MinimumCharge[strings.ToUpper(c)] = min
+	}
+
+	return sendJSON(w, http.StatusOK, rsp)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+func TestViewConfig(t *testing.T) {
+	server := startTestSiteWithSettings(&calculator.Settings{PricesIncludeTaxes: true})
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Payment.Currencies = []string{"usd", "EUR"}
+	test.Config.Payment.MinimumCharge = map[string]uint64{"USD": 50}
+	test.Config.Payment.Routing.Currencies = map[string]string{"EUR": "stripe"}
+
+	recorder := test.TestEndpoint(http.MethodGet, "/config", nil, nil)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.NotContains(t, recorder.Body.String(), test.Config.Payment.Stripe.SecretKey)
+
+	config := &publicConfig{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), config))
+	assert.Equal(t, []string{"USD", "EUR"}, config.Currencies)
+	assert.Equal(t, []string{payments.StripeProvider}, config.PaymentProviders)
+	assert.Equal(t, map[string]string{"EUR": "stripe"}, config.PaymentRouting)
+	assert.True(t, config.PricesIncludeTaxes)
+	assert.Equal(t, map[string]uint64{"USD": 50}, config.MinimumCharge)
+}
+
+func TestOrderCreateCurrency(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Payment.Currencies = []string{"EUR"}
+	body := strings.Replace(defaultPayload, `"line_items"`, `"currency": "USD", "line_items"`, 1)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+	validateError(t, http.StatusBadRequest, recorder, "Orders in USD are not supported")
+}
+
+func TestPaymentCreateMinimumCharge(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Payment.MinimumCharge = map[string]uint64{"USD": 50}
+
+	callCount := 0
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		callCount++
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	test.Data.firstOrder.PaymentState = models.PendingState
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+	body, err := json.Marshal(&stripePaymentParams{
+		Amount:      test.Data.firstOrder.Total,
+		Currency:    test.Data.firstOrder.Currency,
+		StripeToken: "123456",
+		Provider:    payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	validateError(t, http.StatusBadRequest, recorder, "Payments in USD must be at least 50")
+	assert.Equal(t, 0, callCount)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const confirmationRetryPeriod = time.Minute
+
+// confirmationBackoff is the wait before retrying the first failed
+// confirmation email. It doubles with every further attempt.
+const confirmationBackoff = time.Minute
+
+// sendConfirmation sends the confirmation email of a paid order. Every
+// attempt is claimed in the database first, so an email is only sent by one
+// process at a time and never again once it went out. If sending fails, the
+// next attempt is scheduled with exponential backoff.
+func sendConfirmation(db *gorm.DB, m mailer.Mailer, tr *models.Transaction, maxAttempts int, log logrus.FieldLogger) error {
+	order := tr.Order
+	attempt := order.ConfirmationAttempts + 1
+	retryAt := time.Now().Add(confirmationBackoff << uint(attempt-1))
+	rsp := db.Model(&models.Order{}).
+		Where("id = ? AND confirmation_sent_at IS NULL AND confirmation_attempts = ?", order.ID, order.ConfirmationAttempts).
+		UpdateColumns(map[string]interface{}{"confirmation_attempts": attempt, "confirmation_retry_at": retryAt})
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error claiming confirmation email")
+	}
+	if rsp.RowsAffected == 0 {
+		log.Debugf("Confirmation email for order %s was already sent or is being retried", order.ID)
+		return nil
+	}
+	order.ConfirmationAttempts = attempt
+
+	if err := m.OrderConfirmationMail(tr); err != nil {
+		if attempt >= maxAttempts {
+			models.LogEvent(db, "", order.UserID, order.ID, models.EventConfirmationFailed, []string{strconv.Itoa(attempt)})
+		}
+		return errors.Wrapf(err, "Error sending confirmation email, attempt %d of %d", attempt, maxAttempts)
+	}
+
+	sentAt := time.Now()
+	rsp = db.Model(&models.Order{}).
+		Where("id = ?", order.ID).
+		UpdateColumns(map[string]interface{}{"confirmation_sent_at": sentAt, "confirmation_retry_at": gorm.Expr("NULL")})
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error recording confirmation email")
+	}
+	order.ConfirmationSentAt = &sentAt
+	models.LogEvent(db, "", order.UserID, order.ID, models.EventConfirmationSent, nil)
+	return nil
+}
+
+// retryConfirmations sends the confirmation emails of paid orders whose
+// earlier attempts failed and are due for a retry.
+func retryConfirmations(db *gorm.DB, instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) error {
+	orders := []*models.Order{}
+	rsp := orderQuery(db).
+		Where("instance_id = ? AND payment_state = ?", instanceID, models.PaidState).
+		Where("confirmation_sent_at IS NULL AND confirmation_retry_at < ? AND confirmation_attempts < ?", time.Now(), config.Mailer.ConfirmationAttempts).
+		Find(&orders)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for unconfirmed orders")
+	}
+
+	for _, order := range orders {
+		var tr *models.Transaction
+		for _, t := range order.Transactions {
+			if t.Type == models.ChargeTransactionType && t.Status == models.PaidState {
+				tr = t
+			}
+		}
+		if tr == nil {
+			continue
+		}
+		tr.Order = order
+		if err := sendConfirmation(db, m, tr, config.Mailer.ConfirmationAttempts, log); err != nil {
+			log.WithError(err).Errorf("Error retrying confirmation email for order %s", order.ID)
+		}
+	}
+	return nil
+}
+
+// RunConfirmationRetries creates a goroutine that retries failed order
+// confirmation emails every minute. Without a config, as in multi instance
+// mode, all instances are handled.
+func RunConfirmationRetries(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry) {
+	go func() {
+		for {
+			forEachInstance(db, globalConfig, config, log, func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) {
+				if err := retryConfirmations(db, instanceID, config, m, log); err != nil {
+					log.WithError(err).Error("Error retrying confirmation emails")
+				}
+			})
+			time.Sleep(confirmationRetryPeriod)
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type confirmationMailer struct {
+	mailer.Mailer
+	failures int
+	sent     []string
+}
+
+func (m *confirmationMailer) OrderConfirmationMail(tr *models.Transaction) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("SMTP server unavailable")
+	}
+	m.sent = append(m.sent, tr.Order.ID)
+	return nil
+}
+
+func TestConfirmationRetries(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Mailer.ConfirmationAttempts = 3
+	m := &confirmationMailer{failures: 1}
+
+	loadOrder := func() *models.Order {
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		return order
+	}
+	makeDue := func() {
+		require.NoError(t, test.DB.Model(&models.Order{}).Where("id = ?", "first-order").UpdateColumn("confirmation_retry_at", time.Now().Add(-time.Second)).Error)
+	}
+
+	tr := test.Data.firstTransaction
+	tr.Order = test.Data.firstOrder
+	assert.Error(t, sendConfirmation(test.DB, m, tr, 3, testLogger))
+	order := loadOrder()
+	assert.Nil(t, order.ConfirmationSentAt)
+	assert.Equal(t, 1, order.ConfirmationAttempts)
+	require.NotNil(t, order.ConfirmationRetryAt)
+	assert.True(t, order.ConfirmationRetryAt.After(time.Now()))
+
+	// not retried before the backoff passed
+	require.NoError(t, retryConfirmations(test.DB, "", test.Config, m, testLogger))
+	assert.Empty(t, m.sent)
+
+	makeDue()
+	require.NoError(t, retryConfirmations(test.DB, "", test.Config, m, testLogger))
+	assert.Equal(t, []string{"first-order"}, m.sent)
+	order = loadOrder()
+	assert.NotNil(t, order.ConfirmationSentAt)
+	assert.Equal(t, 2, order.ConfirmationAttempts)
+	assert.Nil(t, order.ConfirmationRetryAt)
+
+	event := &models.Event{}
+	assert.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventConfirmationSent).Error)
+
+	// a sent confirmation is never sent again
+	makeDue()
+	require.NoError(t, retryConfirmations(test.DB, "", test.Config, m, testLogger))
+	tr.Order = loadOrder()
+	require.NoError(t, sendConfirmation(test.DB, m, tr, 3, testLogger))
+	assert.Equal(t, []string{"first-order"}, m.sent)
+}
+
+func TestConfirmationRetriesExhausted(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Mailer.ConfirmationAttempts = 2
+	m := &confirmationMailer{failures: 5}
+
+	tr := test.Data.firstTransaction
+	tr.Order = test.Data.firstOrder
+	assert.Error(t, sendConfirmation(test.DB, m, tr, 2, testLogger))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, test.DB.Model(&models.Order{}).Where("id = ?", "first-order").UpdateColumn("confirmation_retry_at", time.Now().Add(-time.Second)).Error)
+		require.NoError(t, retryConfirmations(test.DB, "", test.Config, m, testLogger))
+	}
+
+	assert.Equal(t, 3, m.failures)
+	event := &models.Event{}
+	require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventConfirmationFailed).Error)
+	assert.Equal(t, "2", event.Changes)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+)
+
+// recordConsent stores the consent given with a new order together with the
+// time and client address of the order as proof.
+func recordConsent(order *models.Order, params *consentParams) {
+	if params == nil || (params.TermsVersion == "" && !params.DigitalDelivery) {
+		return
+	}
+	acceptedAt := time.Now().UTC()
+	order.Consent = models.Consent{
+		TermsVersion:    params.TermsVersion,
+		DigitalDelivery: params.DigitalDelivery,
+		AcceptedAt:      &acceptedAt,
+		IP:              order.IP,
+	}
+}
+
+// validateConsent makes sure a new order accepted the current terms and, if
+// it contains digital goods, consented to their immediate delivery whenever
+// the configuration requires it.
+func validateConsent(config *conf.Configuration, order *models.Order) *HTTPError {
+	consent := order.Consent
+	if len(consent.TermsVersion) > models.MaxTermsVersionLength {
+		return httpError(http.StatusUnprocessableEntity, "Terms version can't be longer than %d characters", models.MaxTermsVersionLength)
+	}
+
+	fieldErrors := []FieldError{}
+	if required := config.Consent.TermsVersion; required != "" && consent.TermsVersion != required {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   "consent.terms_version",
+			Message: "The terms of version " + required + " must be accepted",
+		})
+	}
+	if config.Consent.RequireDigitalDelivery && !consent.DigitalDelivery {
+		for _, item := range order.LineItems {
+			if item.FulfillmentType == models.DigitalFulfillment {
+				fieldErrors = append(fieldErrors, FieldError{
+					Field:   "consent.digital_delivery",
+					Message: "Consent to the immediate delivery of digital goods is required, waiving the right of withdrawal",
+				})
+				break
+			}
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return httpError(http.StatusUnprocessableEntity, "Required consent is missing").WithFieldErrors(fieldErrors)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func consentPayload(path, consent string) string {
+	return `{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [{"path": "` + path + `", "quantity": 1}]` + consent + `
+	}`
+}
+
+func TestOrderCreateConsent(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	routeTest := func(t *testing.T) *RouteTest {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Consent.TermsVersion = "2024-05"
+		test.Config.Consent.RequireDigitalDelivery = true
+		return test
+	}
+
+	t.Run("Recorded", func(t *testing.T) {
+		test := routeTest(t)
+		body := consentPayload("/download-product", `, "consent": {"terms_version": "2024-05", "digital_delivery": true}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "2024-05", order.Consent.TermsVersion)
+		assert.True(t, order.Consent.DigitalDelivery)
+		require.NotNil(t, order.Consent.AcceptedAt)
+		assert.WithinDuration(t, time.Now(), *order.Consent.AcceptedAt, time.Minute)
+		assert.Empty(t, order.Consent.IP, "the consent IP is only shown to admins")
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, "2024-05", saved.Consent.TermsVersion)
+		assert.True(t, saved.Consent.DigitalDelivery)
+		assert.NotEmpty(t, saved.Consent.IP)
+	})
+
+	t.Run("MissingTerms", func(t *testing.T) {
+		test := routeTest(t)
+		body := consentPayload("/simple-product", `, "consent": {"terms_version": "2023-01"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "consent.terms_version")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Required consent is missing")
+	})
+
+	t.Run("MissingDigitalDelivery", func(t *testing.T) {
+		test := routeTest(t)
+		body := consentPayload("/download-product", `, "consent": {"terms_version": "2024-05"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "consent.digital_delivery")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Required consent is missing")
+	})
+
+	t.Run("PhysicalGoods", func(t *testing.T) {
+		test := routeTest(t)
+		body := consentPayload("/simple-product", `, "consent": {"terms_version": "2024-05"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.False(t, order.Consent.DigitalDelivery)
+	})
+
+	t.Run("NotRequired", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := consentPayload("/download-product", "")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Nil(t, order.Consent.AcceptedAt)
+	})
+}
+
+func TestOrderInvoiceConsent(t *testing.T) {
+	test := invoiceRouteTest(t)
+	acceptedAt := time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC)
+	test.Data.firstOrder.Consent = models.Consent{
+		TermsVersion:    "2024-05",
+		DigitalDelivery: true,
+		AcceptedAt:      &acceptedAt,
+		IP:              "127.0.0.1",
+	}
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+	recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+	invoice := &models.Invoice{}
+	extractPayload(t, http.StatusOK, recorder, invoice)
+	assert.Equal(t, "2024-05", invoice.Consent.TermsVersion)
+	assert.True(t, invoice.Consent.DigitalDelivery)
+	require.NotNil(t, invoice.Consent.AcceptedAt)
+	assert.True(t, acceptedAt.Equal(*invoice.Consent.AcceptedAt))
+	assert.Equal(t, "127.0.0.1", invoice.Consent.IP)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice?format=pdf", nil, test.Data.testUserToken)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	pdf := recorder.Body.String()
+	assert.Contains(t, pdf, "2024-05 on 2024-05-02 10:30 UTC from 127.0.0.1")
+	assert.Contains(t, pdf, "right of withdrawal")
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+// salesCountryAllowed checks a country against the configured allow and
+// block lists. Countries are compared ignoring case and surrounding spaces.
+func salesCountryAllowed(config *conf.Configuration, country string) bool {
+	country = strings.TrimSpace(country)
+	for _, blocked := range config.Countries.Blocked {
+		if strings.EqualFold(strings.TrimSpace(blocked), country) {
+			return false
+		}
+	}
+	if len(config.Countries.Allowed) == 0 {
+		return true
+	}
+	for _, allowed := range config.Countries.Allowed {
+		if strings.EqualFold(strings.TrimSpace(allowed), country) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSalesCountries rejects orders shipped or billed to a country we can't
+// sell to. Orders created by admins aren't checked. Rejected orders are
+// logged for compliance review.
+func checkSalesCountries(r *http.Request, config *conf.Configuration, order *models.Order) *HTTPError {
+	if gcontext.IsAdmin(r.Context()) {
+		return nil
+	}
+
+	errs := []FieldError{}
+	if !salesCountryAllowed(config, order.ShippingAddress.Country) {
+		errs = append(errs, FieldError{Field: "shipping_address.country", Message: "We can't sell to " + order.ShippingAddress.Country})
+	}
+	if !salesCountryAllowed(config, order.BillingAddress.Country) {
+		errs = append(errs, FieldError{Field: "billing_address.country", Message: "We can't sell to " + order.BillingAddress.Country})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	getLogEntry(r).WithFields(logrus.Fields{
+		"order_email":      order.Email,
+		"order_user_id":    order.UserID,
+		"shipping_country": order.ShippingAddress.Country,
+		"billing_country":  order.BillingAddress.Country,
+		"remote_addr":      r.RemoteAddr,
+	}).Warn("Blocked an order to a country we don't sell to")
+	return httpError(http.StatusForbidden, "Orders to this country are not accepted").WithFieldErrors(errs)
+}
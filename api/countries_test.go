@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCreateSalesCountries(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	t.Run("Blocked", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Countries.Blocked = []string{"usa"}
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		require.Equal(t, http.StatusForbidden, recorder.Code, recorder.Body.String())
+
+		rsp := &HTTPError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(rsp))
+		assert.Equal(t, []FieldError{
+			{Field: "shipping_address.country", Message: "We can't sell to USA"},
+			{Field: "billing_address.country", Message: "We can't sell to USA"},
+		}, rsp.Errors)
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Order{}).Where("email = ?", "info@example.com").Count(&count).Error)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("NotAllowed", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Countries.Allowed = []string{"Germany"}
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		validateError(t, http.StatusForbidden, recorder, "not accepted")
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Countries.Allowed = []string{"Germany", "USA"}
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Countries.Blocked = []string{"USA"}
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), token)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"sort"
 
 	"context"
 
@@ -9,25 +11,67 @@ import (
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/coupons"
 	"github.com/netlify/gocommerce/models"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
 )
 
+const (
+	maxBulkCoupons        = 10000
+	bulkCouponChunkSize   = 500
+	bulkCouponAttempts    = 5
+	defaultCouponCodeSize = 10
+)
+
+type bulkCouponParams struct {
+	BatchID        string         `json:"batch_id"`
+	Count          int            `json:"count"`
+	Prefix         string         `json:"prefix"`
+	Length         int            `json:"length"`
+	MaxRedemptions uint64         `json:"max_redemptions"`
+	Coupon         *models.Coupon `json:"coupon"`
+}
+
+type bulkCouponResponse struct {
+	BatchID string   `json:"batch_id"`
+	Codes   []string `json:"codes"`
+}
+
+// lookupCoupon finds a coupon by the code a customer entered. Whitespace
+// around the code is ignored, and so is its case unless coupon codes are
+// configured to be case sensitive.
 func (a *API) lookupCoupon(ctx context.Context, w http.ResponseWriter, code string) (*models.Coupon, error) {
 	couponCache := gcontext.GetCoupons(ctx)
-	if couponCache == nil {
-		return nil, notFoundError("No coupons available")
-	}
-
-	coupon, err := couponCache.Lookup(code)
-	if err != nil {
-		switch v := err.(type) {
-		case coupons.CouponNotFound:
-			return nil, notFoundError(v.Error())
+	if couponCache != nil {
+		coupon, err := couponCache.Lookup(code)
+		if err == nil {
+			return coupon, nil
+		}
+		switch err.(type) {
+		case coupons.CouponNotFound, *coupons.CouponNotFound:
 		default:
 			return nil, internalServerError("Error fetching coupon").WithInternalError(err)
 		}
 	}
 
-	return coupon, nil
+	caseSensitive := gcontext.GetConfig(ctx).Coupons.CaseSensitive
+	code = coupons.NormalizeCode(code, caseSensitive)
+	codeColumn := "LOWER(code)"
+	if caseSensitive {
+		codeColumn = "code"
+	}
+	generated := &models.GeneratedCoupon{}
+	rsp := a.db.Where("instance_id = ? AND "+codeColumn+" = ?", gcontext.GetInstanceID(ctx), code).First(generated)
+	if rsp.RecordNotFound() {
+		if couponCache == nil {
+			return nil, notFoundError("No coupons available")
+		}
+		return nil, notFoundError("%v", coupons.CouponNotFound{})
+	}
+	if rsp.Error != nil {
+		return nil, internalServerError("Error fetching coupon").WithInternalError(rsp.Error)
+	}
+
+	return generated.Coupon(), nil
 }
 
 // CouponView returns information about a single coupon code.
@@ -44,14 +88,20 @@ func (a *API) CouponView(w http.ResponseWriter, r *http.Request) error {
 	return sendJSON(w, http.StatusOK, coupon)
 }
 
-// CouponList returns all the coupons for the site. Requires admin permissions
+// CouponList returns the coupons for the site ordered by their key in the
+// coupon file. Requires admin permissions
 func (a *API) CouponList(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	log := getLogEntry(r)
 
+	page, httpErr := parsePagination(r.URL.Query())
+	if httpErr != nil {
+		return httpErr
+	}
+
 	couponCache := gcontext.GetCoupons(ctx)
 	if couponCache == nil {
-		return sendJSON(w, http.StatusOK, []string{})
+		return sendJSON(w, http.StatusOK, &listPage{Items: []*models.Coupon{}})
 	}
 
 	coupons, err := couponCache.List()
@@ -60,5 +110,145 @@ func (a *API) CouponList(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Error fetching coupons: %v", err)
 	}
 
-	return sendJSON(w, http.StatusOK, coupons)
+	keys := make([]string, 0, len(coupons))
+	for key := range coupons {
+		if page.Cursor == nil || key > page.Cursor.ID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if page.hasMore(len(keys)) {
+		keys = keys[:page.Limit]
+		next = encodeCursor(&pageCursor{ID: keys[len(keys)-1]})
+	}
+
+	items := make([]*models.Coupon, len(keys))
+	for i, key := range keys {
+		items[i] = coupons[key]
+	}
+	return sendJSON(w, http.StatusOK, &listPage{Items: items, NextCursor: next})
+}
+
+// CouponBulkCreate generates unique coupon codes sharing the same discount.
+// Each code can only be redeemed max_redemptions times, once by default.
+// Sending the same batch_id again only generates the codes still missing from
+// that batch, so retrying a failed request is safe. Requires admin permissions
+func (a *API) CouponBulkCreate(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	log := getLogEntry(r)
+
+	params := &bulkCouponParams{Length: defaultCouponCodeSize, MaxRedemptions: 1}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read coupon params: %v", err)
+	}
+	if params.Count <= 0 || params.Count > maxBulkCoupons {
+		return badRequestError("The number of coupons must be between 1 and %d", maxBulkCoupons)
+	}
+	if params.Length < 6 || params.Length > 32 {
+		return badRequestError("The length of coupon codes must be between 6 and 32")
+	}
+	if params.MaxRedemptions == 0 {
+		return badRequestError("Coupons must be redeemable at least once")
+	}
+	if params.Coupon == nil {
+		return badRequestError("Generating coupons requires a 'coupon' template")
+	}
+	if params.BatchID == "" {
+		params.BatchID = uuid.NewRandom().String()
+	}
+
+	existing := 0
+	if rsp := a.db.Model(&models.GeneratedCoupon{}).Where("instance_id = ? AND batch_id = ?", instanceID, params.BatchID).Count(&existing); rsp.Error != nil {
+		return internalServerError("Error querying coupons").WithInternalError(rsp.Error)
+	}
+
+	for remaining := params.Count - existing; remaining > 0; remaining -= bulkCouponChunkSize {
+		size := remaining
+		if size > bulkCouponChunkSize {
+			size = bulkCouponChunkSize
+		}
+		if err := a.generateCoupons(instanceID, params, size); err != nil {
+			return internalServerError("Error generating coupons").WithInternalError(err)
+		}
+	}
+
+	result := &bulkCouponResponse{BatchID: params.BatchID}
+	if rsp := a.db.Model(&models.GeneratedCoupon{}).Where("instance_id = ? AND batch_id = ?", instanceID, params.BatchID).Order("id asc").Pluck("code", &result.Codes); rsp.Error != nil {
+		return internalServerError("Error querying coupons").WithInternalError(rsp.Error)
+	}
+
+	log.WithField("batch_id", params.BatchID).Infof("Generated %d coupons", len(result.Codes)-existing)
+	return sendJSON(w, http.StatusCreated, result)
+}
+
+// generateCoupons stores count new coupons in one transaction. Codes that are
+// already taken are replaced before inserting, and the whole chunk is retried
+// if a concurrent request took one of the codes in the meantime.
+func (a *API) generateCoupons(instanceID string, params *bulkCouponParams, count int) error {
+	var err error
+	for attempt := 0; attempt < bulkCouponAttempts; attempt++ {
+		var codes []string
+		codes, err = a.uniqueCouponCodes(instanceID, params, count)
+		if err != nil {
+			return err
+		}
+
+		tx := a.db.Begin()
+		for _, code := range codes {
+			coupon := &models.GeneratedCoupon{
+				InstanceID:     instanceID,
+				Code:           code,
+				BatchID:        params.BatchID,
+				Template:       params.Coupon,
+				MaxRedemptions: params.MaxRedemptions,
+			}
+			if err = tx.Create(coupon).Error; err != nil {
+				break
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			continue
+		}
+		return tx.Commit().Error
+	}
+	return errors.Wrapf(err, "Failed to store coupons after %d attempts", bulkCouponAttempts)
+}
+
+func (a *API) uniqueCouponCodes(instanceID string, params *bulkCouponParams, count int) ([]string, error) {
+	unique := map[string]bool{}
+	for len(unique) < count {
+		candidates := []string{}
+		for len(unique)+len(candidates) < count {
+			code, err := models.NewCouponCode(params.Prefix, params.Length)
+			if err != nil {
+				return nil, err
+			}
+			if !unique[code] {
+				candidates = append(candidates, code)
+			}
+		}
+
+		taken := []string{}
+		if rsp := a.db.Model(&models.GeneratedCoupon{}).Where("instance_id = ? AND code IN (?)", instanceID, candidates).Pluck("code", &taken); rsp.Error != nil {
+			return nil, rsp.Error
+		}
+		isTaken := map[string]bool{}
+		for _, code := range taken {
+			isTaken[code] = true
+		}
+		for _, code := range candidates {
+			if !isTaken[code] {
+				unique[code] = true
+			}
+		}
+	}
+
+	codes := make([]string, 0, count)
+	for code := range unique {
+		codes = append(codes, code)
+	}
+	return codes, nil
 }
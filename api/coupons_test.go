@@ -1,13 +1,21 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
 )
 
 func TestCouponView(t *testing.T) {
@@ -30,6 +38,195 @@ func TestCouponView(t *testing.T) {
 	})
 }
 
+func TestCouponList(t *testing.T) {
+	test := NewRouteTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"coupons": {"beta": {"percentage": 10}, "alpha": {"percentage": 5}, "gamma": {"percentage": 15}}}`)
+	}))
+	defer server.Close()
+	test.Config.Coupons.URL = server.URL
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+
+	recorder := test.TestEndpoint(http.MethodGet, "/coupons?limit=2", nil, token)
+	coupons := []models.Coupon{}
+	cursor := extractPage(t, http.StatusOK, recorder, &coupons)
+	require.Len(t, coupons, 2)
+	assert.Equal(t, uint64(5), coupons[0].Percentage)
+	assert.Equal(t, uint64(10), coupons[1].Percentage)
+	require.NotEmpty(t, cursor)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/coupons?limit=2&cursor="+cursor, nil, token)
+	coupons = []models.Coupon{}
+	cursor = extractPage(t, http.StatusOK, recorder, &coupons)
+	require.Len(t, coupons, 1)
+	assert.Equal(t, uint64(15), coupons[0].Percentage)
+	assert.Empty(t, cursor)
+}
+
+func TestCouponBulkCreate(t *testing.T) {
+	t.Run("Simple", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := `{"count": 1200, "prefix": "SPRING-", "coupon": {"percentage": 10}}`
+		recorder := test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(body), token)
+
+		result := &bulkCouponResponse{}
+		extractPayload(t, http.StatusCreated, recorder, result)
+		assert.NotEmpty(t, result.BatchID)
+		require.Len(t, result.Codes, 1200)
+
+		unique := map[string]bool{}
+		for _, code := range result.Codes {
+			assert.True(t, strings.HasPrefix(code, "SPRING-"))
+			assert.Len(t, code, len("SPRING-")+defaultCouponCodeSize)
+			unique[code] = true
+		}
+		assert.Len(t, unique, 1200)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/coupons/"+result.Codes[0], nil, nil)
+		coupon := &models.Coupon{}
+		extractPayload(t, http.StatusOK, recorder, coupon)
+		assert.Equal(t, result.Codes[0], coupon.Code)
+		assert.Equal(t, uint64(10), coupon.Percentage)
+	})
+
+	t.Run("RetryBatch", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := `{"batch_id": "spring", "count": 5, "coupon": {"percentage": 10}}`
+		recorder := test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(body), token)
+		first := &bulkCouponResponse{}
+		extractPayload(t, http.StatusCreated, recorder, first)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(body), token)
+		second := &bulkCouponResponse{}
+		extractPayload(t, http.StatusCreated, recorder, second)
+		assert.Equal(t, first.Codes, second.Codes)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(`{"count": 100000, "coupon": {"percentage": 10}}`), token)
+		validateError(t, http.StatusBadRequest, recorder)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(`{"count": 10}`), token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := `{"count": 10, "coupon": {"percentage": 10}}`
+		recorder := test.TestEndpoint(http.MethodPost, "/coupons/bulk", strings.NewReader(body), test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestGeneratedCouponRedemption(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Payment.Manual.Enabled = true
+	test.Config.Payment.Manual.Instructions = "Transfer the amount to DE00 1234"
+	coupon := &models.GeneratedCoupon{
+		Code:           "SINGLE-USE",
+		Template:       &models.Coupon{Percentage: 10},
+		MaxRedemptions: 1,
+	}
+	require.NoError(t, test.DB.Create(coupon).Error)
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		v.(*stripe.Charge).ID = "ch_123"
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	payload := strings.Replace(defaultPayload, `"email"`, `"coupon": "SINGLE-USE", "email"`, 1)
+	orders := []*models.Order{}
+	for i := 0; i < 2; i++ {
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "SINGLE-USE", order.CouponCode)
+		assert.NotZero(t, order.Discount)
+		orders = append(orders, order)
+	}
+	require.NoError(t, test.DB.First(coupon, coupon.ID).Error)
+	assert.Equal(t, uint64(0), coupon.Redemptions, "the coupon is redeemed when an order is paid")
+
+	paymentBody := func(order *models.Order) *bytes.Buffer {
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      order.Total,
+			Currency:    order.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		return bytes.NewBuffer(body)
+	}
+
+	// a failed payment doesn't use up the coupon
+	ctx, err := WithInstanceConfig(context.Background(), test.GlobalConfig, test.Config, "")
+	require.NoError(t, err)
+	ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: &memProvider{name: payments.StripeProvider}})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/orders/"+orders[0].ID+"/payments", paymentBody(orders[0]))
+	require.NoError(t, signHTTPRequest(r, test.Data.testUserToken, test.Config.JWT.Secret))
+	NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, defaultVersion).handler.ServeHTTP(w, r)
+	validateError(t, http.StatusInternalServerError, w, "error charging your card")
+	require.NoError(t, test.DB.First(coupon, coupon.ID).Error)
+	assert.Equal(t, uint64(0), coupon.Redemptions)
+
+	// awaiting a manual payment redeems the coupon
+	body, err := json.Marshal(&stripePaymentParams{
+		Amount:   orders[1].Total,
+		Currency: orders[1].Currency,
+		Provider: payments.ManualProvider,
+	})
+	require.NoError(t, err)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/"+orders[1].ID+"/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	extractPayload(t, http.StatusOK, recorder, &models.Transaction{})
+	require.NoError(t, test.DB.First(coupon, coupon.ID).Error)
+	assert.Equal(t, uint64(1), coupon.Redemptions)
+
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+orders[0].ID+"/payments", paymentBody(orders[0]), test.Data.testUserToken)
+	validateError(t, http.StatusBadRequest, recorder, "already been redeemed")
+
+	recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+	validateError(t, http.StatusBadRequest, recorder, "already been redeemed")
+}
+
+func TestGeneratedCouponNormalization(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		require.NoError(t, test.DB.Create(&models.GeneratedCoupon{Code: "SPRING-ABC", Template: &models.Coupon{Percentage: 10}, MaxRedemptions: 1}).Error)
+
+		payload := strings.Replace(defaultPayload, `"email"`, `"coupon": " spring-abc ", "email"`, 1)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "SPRING-ABC", order.CouponCode)
+	})
+
+	t.Run("CaseSensitive", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Coupons.CaseSensitive = true
+		require.NoError(t, test.DB.Create(&models.GeneratedCoupon{Code: "SPRING-ABC", Template: &models.Coupon{Percentage: 10}, MaxRedemptions: 1}).Error)
+
+		recorder := test.TestEndpoint(http.MethodGet, "/coupons/spring-abc", nil, nil)
+		validateError(t, http.StatusNotFound, recorder)
+		recorder = test.TestEndpoint(http.MethodGet, "/coupons/%20SPRING-ABC%20", nil, nil)
+		coupon := &models.Coupon{}
+		extractPayload(t, http.StatusOK, recorder, coupon)
+		assert.Equal(t, "SPRING-ABC", coupon.Code)
+	})
+}
+
 func startTestCouponURLs() *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
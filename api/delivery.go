@@ -0,0 +1,68 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+)
+
+// deliveryLeadTime returns the business days it takes to deliver the shipped
+// items of an order: the longest lead time of their product types, with the
+// flat lead time for types that don't have their own. Digital items aren't
+// delivered and don't count.
+func deliveryLeadTime(config *conf.Configuration, order *models.Order) int {
+	lead := 0
+	for _, item := range order.LineItems {
+		if item.FulfillmentType == models.DigitalFulfillment {
+			continue
+		}
+		days, ok := config.Delivery.LeadTimes[item.Type]
+		if !ok {
+			days = config.Delivery.LeadTime
+		}
+		if days > lead {
+			lead = days
+		}
+	}
+	return lead
+}
+
+// estimateDelivery counts the lead time of an order in business days from the
+// day it was paid, skipping weekend days and holidays. It returns nil if no
+// lead time applies to the order.
+func estimateDelivery(config *conf.Configuration, order *models.Order, paidAt time.Time) *time.Time {
+	days := deliveryLeadTime(config, order)
+	if days <= 0 {
+		return nil
+	}
+
+	weekend := map[time.Weekday]bool{}
+	for _, name := range config.Delivery.Weekend {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(strings.TrimSpace(name), day.String()) {
+				weekend[day] = true
+			}
+		}
+	}
+	if len(weekend) == 7 {
+		// a week without business days would never deliver
+		weekend = nil
+	}
+	holidays := map[string]bool{}
+	for _, holiday := range config.Delivery.Holidays {
+		holidays[strings.TrimSpace(holiday)] = true
+	}
+
+	paidAt = paidAt.UTC()
+	day := time.Date(paidAt.Year(), paidAt.Month(), paidAt.Day(), 0, 0, 0, 0, time.UTC)
+	for days > 0 {
+		day = day.AddDate(0, 0, 1)
+		if weekend[day.Weekday()] || holidays[day.Format("2006-01-02")] {
+			continue
+		}
+		days--
+	}
+	return &day
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+func TestEstimateDelivery(t *testing.T) {
+	_, config := testConfig()
+	config.Delivery.LeadTime = 3
+	config.Delivery.LeadTimes = map[string]int{"plane": 5, "book": 1}
+	config.Delivery.Weekend = []string{"Saturday", "sunday"}
+	config.Delivery.Holidays = []string{"2026-12-25"}
+	// a Wednesday
+	paidAt := time.Date(2026, 12, 23, 18, 30, 0, 0, time.UTC)
+
+	order := &models.Order{LineItems: []*models.LineItem{{Type: "book"}}}
+	estimate := estimateDelivery(config, order, paidAt)
+	require.NotNil(t, estimate)
+	assert.Equal(t, "2026-12-24", estimate.Format("2006-01-02"))
+
+	// skips the holiday and the weekend
+	order.LineItems = append(order.LineItems, &models.LineItem{Type: "shirt"})
+	estimate = estimateDelivery(config, order, paidAt)
+	require.NotNil(t, estimate)
+	assert.Equal(t, "2026-12-29", estimate.Format("2006-01-02"))
+
+	// the longest lead time wins
+	order.LineItems = append(order.LineItems, &models.LineItem{Type: "plane"})
+	estimate = estimateDelivery(config, order, paidAt)
+	require.NotNil(t, estimate)
+	assert.Equal(t, "2026-12-31", estimate.Format("2006-01-02"))
+
+	// digital items aren't delivered
+	order.LineItems = []*models.LineItem{{Type: "plane", FulfillmentType: models.DigitalFulfillment}}
+	assert.Nil(t, estimateDelivery(config, order, paidAt))
+
+	config.Delivery.LeadTime = 0
+	config.Delivery.LeadTimes = nil
+	order.LineItems = []*models.LineItem{{Type: "plane"}}
+	assert.Nil(t, estimateDelivery(config, order, paidAt))
+}
+
+func TestPaymentCreateEstimatedDelivery(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Delivery.LeadTime = 2
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		v.(*stripe.Charge).ID = "ch_123"
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	test.Data.firstOrder.PaymentState = models.PendingState
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+	body, err := json.Marshal(&stripePaymentParams{
+		Amount:      test.Data.firstOrder.Total,
+		Currency:    test.Data.firstOrder.Currency,
+		StripeToken: "123456",
+		Provider:    payments.StripeProvider,
+	})
+	require.NoError(t, err)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order", nil, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, order)
+	require.NotNil(t, order.EstimatedDelivery)
+	assert.True(t, order.EstimatedDelivery.After(time.Now()))
+}
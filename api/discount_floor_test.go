@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCreateDiscountFloor(t *testing.T) {
+	payload := `{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [{"path": "/costed-product", "quantity": 2}]
+	}`
+
+	for _, floor := range []struct {
+		name     string
+		floor    string
+		discount uint64
+		total    uint64
+	}{
+		{"Zero", "", 1998, 0},
+		{"Cost", calculator.DiscountFloorCost, 798, 1200},
+	} {
+		t.Run(floor.name, func(t *testing.T) {
+			test := NewRouteTest(t)
+			claims := map[string]string{"email": test.Data.testUser.Email}
+			server := startTestSiteWithSettings(calculator.Settings{
+				DiscountFloor: floor.floor,
+				MemberDiscounts: []*calculator.MemberDiscount{
+					{Claims: claims, Percentage: 60},
+					{Claims: claims, FixedAmount: []*calculator.FixedMemberDiscount{{Amount: "5.00", Currency: "USD"}}},
+				},
+			})
+			defer server.Close()
+			test.Config.SiteURL = server.URL
+
+			recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+			assert.NotContains(t, recorder.Body.String(), `"cost"`)
+			order := &models.Order{}
+			extractPayload(t, http.StatusCreated, recorder, order)
+			assert.Equal(t, floor.discount, order.Discount)
+			assert.Equal(t, floor.total, order.Total)
+			assert.True(t, order.DiscountCapped)
+			require.Len(t, order.LineItems, 1)
+			assert.True(t, order.LineItems[0].CalculationDetail.DiscountCapped)
+			assert.Len(t, order.LineItems[0].CalculationDetail.DiscountItems, 2)
+
+			saved := &models.LineItem{}
+			require.NoError(t, test.DB.First(saved, "order_id = ?", order.ID).Error)
+			assert.Equal(t, uint64(600), saved.Cost)
+		})
+	}
+}
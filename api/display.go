@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// orderDisplay holds pre-formatted display strings for the amounts of an order.
+type orderDisplay struct {
+	SubTotal string `json:"subtotal"`
+	Taxes    string `json:"taxes"`
+	Shipping string `json:"shipping"`
+	Discount string `json:"discount"`
+	NetTotal string `json:"net_total"`
+	Tip      string `json:"tip"`
+	Total    string `json:"total"`
+}
+
+type displayOrder struct {
+	*models.Order
+	Display *orderDisplay `json:"display"`
+}
+
+// wantsFormattedAmounts checks if formatted amounts should be included in the
+// response, either because the instance is configured to do so or the client
+// asked for them with `Accept: application/json; amounts=formatted`.
+func wantsFormattedAmounts(r *http.Request) bool {
+	config := gcontext.GetConfig(r.Context())
+	if config != nil && config.Display.FormattedAmounts {
+		return true
+	}
+	return acceptedAmounts(r) == "formatted"
+}
+
+// acceptedAmounts reads the `amounts` parameter of the Accept header.
+func acceptedAmounts(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && params["amounts"] != "" {
+			return params["amounts"]
+		}
+	}
+	return ""
+}
+
+// presentOrder adds display strings to an order and serializes its amounts
+// as requested. Admin only fields are left out for customers.
+func presentOrder(r *http.Request, order *models.Order) interface{} {
+	return redactForCustomer(r, formatOrder(r, order))
+}
+
+func formatOrder(r *http.Request, order *models.Order) interface{} {
+	formatted := wantsFormattedAmounts(r)
+	if wantsDecimalAmounts(r) {
+		decimal := newDecimalOrder(order)
+		if formatted {
+			decimal.Display = newOrderDisplay(order)
+		}
+		return decimal
+	}
+	if formatted {
+		return &displayOrder{Order: order, Display: newOrderDisplay(order)}
+	}
+	return order
+}
+
+// presentOrders adds display strings to a list of orders and serializes their
+// amounts as requested. Admin only fields are left out for customers.
+func presentOrders(r *http.Request, orders []models.Order) interface{} {
+	if !wantsFormattedAmounts(r) && !wantsDecimalAmounts(r) {
+		return redactForCustomer(r, orders)
+	}
+	display := make([]interface{}, len(orders))
+	for i := range orders {
+		display[i] = formatOrder(r, &orders[i])
+	}
+	return redactForCustomer(r, display)
+}
+
+func newOrderDisplay(order *models.Order) *orderDisplay {
+	return &orderDisplay{
+		SubTotal: formatAmount(order.SubTotal, order.Currency),
+		Taxes:    formatAmount(order.Taxes, order.Currency),
+		Shipping: formatAmount(order.Shipping, order.Currency),
+		Discount: formatAmount(order.Discount, order.Currency),
+		NetTotal: formatAmount(order.NetTotal, order.Currency),
+		Tip:      formatAmount(order.Tip, order.Currency),
+		Total:    formatAmount(order.Total, order.Currency),
+	}
+}
+
+// formatAmount formats an amount in cents using the symbol and number of
+// decimals of the currency, e.g. 1250 USD becomes "$12.50".
+func formatAmount(amount uint64, cur string) string {
+	printer := message.NewPrinter(language.English)
+	unit, err := currency.ParseISO(cur)
+	if err != nil {
+		return fmt.Sprintf("%.2f %s", float64(amount)/100, cur)
+	}
+
+	scale, _ := currency.Standard.Rounding(unit)
+	factor := uint64(math.Pow10(scale))
+	units := (amount*factor + 50) / 100
+	number := printer.Sprintf("%d", units/factor)
+	if scale > 0 {
+		number += fmt.Sprintf(".%0*d", scale, units%factor)
+	}
+
+	symbol := printer.Sprint(currency.NarrowSymbol(unit))
+	if strings.IndexFunc(symbol, unicode.IsLetter) >= 0 {
+		return symbol + " " + number
+	}
+	return symbol + number
+}
@@ -1,18 +1,30 @@
 package api
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/assetstores"
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
 )
 
 const maxIPsPerDay = 50
 
-// DownloadURL returns a signed URL to download a purchased asset.
+// headers of a proxied download passed between the client and the store
+var proxyRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+var proxyResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// DownloadURL delivers a purchased asset the way the instance is configured
+// to: it returns a signed URL to download it, redirects to the signed URL or
+// serves the asset from the store itself.
 func (a *API) DownloadURL(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	downloadID := chi.URLParam(r, "download_id")
@@ -44,6 +56,14 @@ func (a *API) DownloadURL(w http.ResponseWriter, r *http.Request) error {
 		return unauthorizedError("This download has not been paid yet")
 	}
 
+	if order.Withheld() {
+		return unauthorizedError("This download is withheld during review")
+	}
+
+	if download.Expired() {
+		return goneError("This download has expired")
+	}
+
 	rows, err := a.db.Model(&models.Event{}).
 		Select("count(distinct(ip))").
 		Where("order_id = ? and created_at > ? and changes = 'download'", order.ID, time.Now().Add(-24*time.Hour)).
@@ -66,25 +86,125 @@ func (a *API) DownloadURL(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Error signing download").WithInternalError(err)
 	}
 
+	config := gcontext.GetConfig(ctx)
 	tx := a.db.Begin()
-	tx.Model(download).Updates(map[string]interface{}{"download_count": gorm.Expr("download_count + 1")})
+	// proxied downloads that are resumed don't count as new ones, other
+	// requests get a new URL each time and always count
+	if config.Downloads.Delivery != assetstores.ProxyDelivery || !resumesDownload(r) {
+		tx.Model(download).Updates(map[string]interface{}{"download_count": gorm.Expr("download_count + 1")})
+	}
 	var subject string
 	if claims != nil {
 		subject = claims.Subject
 	}
+	// the access is logged either way, it limits the IPs per day
 	models.LogEvent(tx, r.RemoteAddr, subject, order.ID, models.EventUpdated, []string{"download"})
 	tx.Commit()
 
+	switch config.Downloads.Delivery {
+	case assetstores.RedirectDelivery:
+		location, err := absoluteDownloadURL(config.SiteURL, download.URL)
+		if err != nil {
+			return internalServerError("Error signing download").WithInternalError(err)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, location, http.StatusFound)
+		return nil
+	case assetstores.ProxyDelivery:
+		return proxyDownload(w, r, assets, config.SiteURL, download)
+	}
 	return sendJSON(w, http.StatusOK, download)
 }
 
-// DownloadList lists all purchased downloads for an order or a user.
+// resumesDownload reports whether a request asks for the rest of a download
+// that was started before.
+func resumesDownload(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng != "" && !strings.HasPrefix(rng, "bytes=0-")
+}
+
+// absoluteDownloadURL resolves download URLs relative to the site.
+func absoluteDownloadURL(siteURL, downloadURL string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse download URL")
+	}
+	if u.IsAbs() {
+		return u.String(), nil
+	}
+	site, err := url.Parse(siteURL)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse Site URL")
+	}
+	return site.ResolveReference(u).String(), nil
+}
+
+// proxyDownload serves a download from the asset store without revealing its
+// URL. Range requests are passed on to the store, so downloads can be resumed
+// and media streamed.
+func proxyDownload(w http.ResponseWriter, r *http.Request, assets assetstores.Store, siteURL string, download *models.Download) error {
+	location, err := absoluteDownloadURL(siteURL, download.URL)
+	if err != nil {
+		return internalServerError("Error fetching download").WithInternalError(err)
+	}
+
+	header := http.Header{}
+	for _, name := range proxyRequestHeaders {
+		if value := r.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	resp, err := assets.Fetch(location, header)
+	if err != nil {
+		return internalServerError("Error fetching download").WithInternalError(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		return internalServerError("Error fetching download").WithInternalError(errors.Errorf("Asset store responded with status %d", resp.StatusCode))
+	}
+
+	for _, name := range proxyResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+downloadFilename(download)+"\"")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		// the response has started, so the error can only be logged
+		getLogEntry(r).WithError(err).Warn("Failed to proxy download")
+	}
+	return nil
+}
+
+// downloadFilename names a proxied download after the file in its URL.
+func downloadFilename(download *models.Download) string {
+	name := download.Title
+	if u, err := url.Parse(download.URL); err == nil {
+		if base := u.Path[strings.LastIndex(u.Path, "/")+1:]; base != "" {
+			name = base
+		}
+	}
+	return strings.NewReplacer("\"", "", "\\", "", "\r", "", "\n", "").Replace(name)
+}
+
+// DownloadList lists all purchased downloads for an order or a user. Expired
+// downloads are left out unless `include_expired=true` is passed.
 func (a *API) DownloadList(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	orderID := gcontext.GetOrderID(ctx)
 	log := getLogEntry(r)
 	claims := gcontext.GetClaims(ctx)
 
+	page, httpErr := parsePagination(r.URL.Query())
+	if httpErr != nil {
+		return httpErr
+	}
+
 	order := &models.Order{}
 	if orderID != "" {
 		if result := a.db.Where("id = ?", orderID).First(order); result.Error != nil {
@@ -114,19 +234,77 @@ func (a *API) DownloadList(w http.ResponseWriter, r *http.Request) error {
 	if order != nil {
 		query = query.Where(orderTable+".id = ?", order.ID)
 	} else {
-		query = query.Where(orderTable+".user_id = ?", claims.Subject)
+		userID := gcontext.GetUserID(ctx)
+		if userID == "" {
+			userID = claims.Subject
+		}
+		query = query.Where(orderTable+".user_id = ?", userID)
 	}
 
-	offset, limit, err := paginate(w, r, query.Model(&models.Download{}))
-	if err != nil {
-		return badRequestError("Bad Pagination Parameters: %v", err)
+	if r.URL.Query().Get("include_expired") != "true" {
+		query = query.Where(downloadsTable+".expires_at IS NULL OR "+downloadsTable+".expires_at > ?", time.Now())
+	}
+
+	query, httpErr = paginate(query, page, &sortKey{
+		expression: downloadsTable + ".created_at",
+		idColumn:   downloadsTable + ".id",
+		dir:        descending,
+		parse:      parseCursorTime,
+	})
+	if httpErr != nil {
+		return httpErr
 	}
 
 	var downloads []models.Download
-	if result := query.Offset(offset).Limit(limit).Find(&downloads); result.Error != nil {
-		return internalServerError("Error during database query").WithInternalError(err)
+	if result := query.Find(&downloads); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	next := ""
+	if page.hasMore(len(downloads)) {
+		downloads = downloads[:page.Limit]
+		last := &downloads[len(downloads)-1]
+		next = encodeCursor(&pageCursor{Value: formatCursorTime(last.CreatedAt), ID: last.ID})
 	}
 
 	log.WithField("download_count", len(downloads)).Debugf("Successfully retrieved %d downloads", len(downloads))
-	return sendJSON(w, http.StatusOK, downloads)
+	return sendJSON(w, http.StatusOK, &listPage{Items: downloads, NextCursor: next})
+}
+
+type downloadExpiryParams struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// DownloadSetExpiry changes when access to a download expires. A null
+// `expires_at` removes the expiry. Requires admin permissions
+func (a *API) DownloadSetExpiry(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	downloadID := chi.URLParam(r, "download_id")
+	logEntrySetField(r, "download_id", downloadID)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	params := new(downloadExpiryParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read expiry parameters: %v", err)
+	}
+
+	download := &models.Download{}
+	if result := a.db.Where("id = ?", downloadID).First(download); result.Error != nil {
+		if result.RecordNotFound() {
+			return notFoundError("Download not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	tx := a.db.Begin()
+	if result := tx.Model(download).Update("expires_at", params.ExpiresAt); result.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving download expiry").WithInternalError(result.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, download.OrderID, models.EventUpdated, []string{"download_expiry"})
+	tx.Commit()
+
+	log.Infof("Changed expiry of download to %v", params.ExpiresAt)
+	return sendJSON(w, http.StatusOK, download)
 }
@@ -1,11 +1,21 @@
 package api
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/netlify/gocommerce/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDownloadList(t *testing.T) {
@@ -15,7 +25,221 @@ func TestDownloadList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/downloads", nil, token)
 
 		downloads := []models.Download{}
-		extractPayload(t, http.StatusOK, recorder, &downloads)
+		extractPage(t, http.StatusOK, recorder, &downloads)
 		assert.Len(t, downloads, 1)
 	})
+
+	t.Run("ExcludesExpired", func(t *testing.T) {
+		test := NewRouteTest(t)
+		expired := time.Now().Add(-time.Hour)
+		require.NoError(t, test.DB.Model(&models.Download{ID: "first-download"}).Update("expires_at", &expired).Error)
+		token := test.Data.testUserToken
+
+		downloads := []models.Download{}
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads", nil, token)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		assert.Len(t, downloads, 0)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/downloads?include_expired=true", nil, token)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		assert.Len(t, downloads, 1)
+	})
+
+	t.Run("ForUser", func(t *testing.T) {
+		test := NewRouteTest(t)
+		url := "/users/" + test.Data.testUser.ID + "/downloads"
+
+		downloads := []models.Download{}
+		recorder := test.TestEndpoint(http.MethodGet, url, nil, test.Data.testUserToken)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		require.Len(t, downloads, 1)
+		assert.Equal(t, "first-download", downloads[0].ID)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder = test.TestEndpoint(http.MethodGet, url, nil, token)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		assert.Len(t, downloads, 1)
+
+		expired := time.Now().Add(-time.Hour)
+		require.NoError(t, test.DB.Model(&models.Download{ID: "first-download"}).Update("expires_at", &expired).Error)
+		recorder = test.TestEndpoint(http.MethodGet, url, nil, token)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		assert.Len(t, downloads, 0)
+		recorder = test.TestEndpoint(http.MethodGet, url+"?include_expired=true", nil, token)
+		extractPage(t, http.StatusOK, recorder, &downloads)
+		require.Len(t, downloads, 1)
+		assert.True(t, downloads[0].Expired())
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testToken("villian", "villian@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/users/"+test.Data.testUser.ID+"/downloads", nil, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestDownloadURL(t *testing.T) {
+	t.Run("Expired", func(t *testing.T) {
+		test := NewRouteTest(t)
+		expired := time.Now().Add(-time.Hour)
+		require.NoError(t, test.DB.Model(&models.Download{ID: "first-download"}).Update("expires_at", &expired).Error)
+
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		validateError(t, http.StatusGone, recorder)
+	})
+
+	content := "%PDF-1.4 the whole book"
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/assets/book.pdf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		http.ServeContent(w, r, "book.pdf", time.Time{}, strings.NewReader(content))
+	}))
+	defer site.Close()
+
+	deliveryTest := func(t *testing.T, delivery string) *RouteTest {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = site.URL
+		test.Config.Downloads.Delivery = delivery
+		require.NoError(t, test.DB.Model(&models.Download{ID: "first-download"}).Update("url", "/assets/book.pdf").Error)
+		return test
+	}
+	downloadCount := func(t *testing.T, test *RouteTest) uint64 {
+		download := &models.Download{}
+		require.NoError(t, test.DB.First(download, "id = ?", "first-download").Error)
+		return download.DownloadCount
+	}
+
+	t.Run("URL", func(t *testing.T) {
+		test := deliveryTest(t, "")
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		download := &models.Download{}
+		extractPayload(t, http.StatusOK, recorder, download)
+		assert.Equal(t, "/assets/book.pdf", download.URL)
+		assert.EqualValues(t, 1, downloadCount(t, test))
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		test := deliveryTest(t, "redirect")
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, site.URL+"/assets/book.pdf", recorder.Header().Get("Location"))
+		assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+		assert.EqualValues(t, 1, downloadCount(t, test))
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		test := deliveryTest(t, "proxy")
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, content, recorder.Body.String())
+		assert.Equal(t, "application/pdf", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="book.pdf"`, recorder.Header().Get("Content-Disposition"))
+		assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+		assert.NotContains(t, recorder.Body.String(), site.URL)
+		assert.EqualValues(t, 1, downloadCount(t, test))
+	})
+
+	t.Run("ProxyRange", func(t *testing.T) {
+		test := deliveryTest(t, "proxy")
+		header := http.Header{"Range": []string{"bytes=9-"}}
+		recorder := test.TestEndpointWithHeaders(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken, header)
+		require.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "the whole book", recorder.Body.String())
+		assert.Equal(t, fmt.Sprintf("bytes 9-%d/%d", len(content)-1, len(content)), recorder.Header().Get("Content-Range"))
+		assert.EqualValues(t, 0, downloadCount(t, test), "resumed downloads aren't counted again")
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Event{}).Where("order_id = ? AND changes = 'download'", "first-order").Count(&count).Error)
+		assert.Equal(t, 1, count, "the access is logged for the IP limit")
+	})
+
+	t.Run("ProxyMultiRange", func(t *testing.T) {
+		test := deliveryTest(t, "proxy")
+		header := http.Header{"Range": []string{"bytes=0-3,9-13"}}
+		recorder := test.TestEndpointWithHeaders(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken, header)
+		require.Equal(t, http.StatusPartialContent, recorder.Code)
+
+		mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+		assert.Equal(t, strconv.Itoa(recorder.Body.Len()), recorder.Header().Get("Content-Length"))
+		assert.Equal(t, `attachment; filename="book.pdf"`, recorder.Header().Get("Content-Disposition"))
+
+		reader := multipart.NewReader(recorder.Body, params["boundary"])
+		for _, expected := range []struct{ rng, body string }{
+			{"bytes 0-3/23", "%PDF"},
+			{"bytes 9-13/23", "the w"},
+		} {
+			part, err := reader.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, expected.rng, part.Header.Get("Content-Range"))
+			assert.Equal(t, "application/pdf", part.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(part)
+			require.NoError(t, err)
+			assert.Equal(t, expected.body, string(body))
+		}
+		_, err = reader.NextPart()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("ProxyUnsatisfiableRange", func(t *testing.T) {
+		test := deliveryTest(t, "proxy")
+		header := http.Header{"Range": []string{"bytes=100-"}}
+		recorder := test.TestEndpointWithHeaders(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken, header)
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+		assert.Equal(t, fmt.Sprintf("bytes */%d", len(content)), recorder.Header().Get("Content-Range"))
+		assert.Equal(t, "private, no-store", recorder.Header().Get("Cache-Control"))
+		assert.NotContains(t, recorder.Body.String(), content)
+		assert.EqualValues(t, 0, downloadCount(t, test))
+	})
+
+	t.Run("URLRange", func(t *testing.T) {
+		test := deliveryTest(t, "")
+		header := http.Header{"Range": []string{"bytes=1-"}}
+		recorder := test.TestEndpointWithHeaders(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken, header)
+		extractPayload(t, http.StatusOK, recorder, &models.Download{})
+		assert.EqualValues(t, 1, downloadCount(t, test), "only proxied downloads can be resumed")
+	})
+}
+
+func TestDownloadSetExpiry(t *testing.T) {
+	t.Run("Extend", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := strings.NewReader(`{"expires_at": "2100-01-01T00:00:00Z"}`)
+		recorder := test.TestEndpoint(http.MethodPut, "/downloads/first-download/expiry", body, token)
+
+		download := &models.Download{}
+		extractPayload(t, http.StatusOK, recorder, download)
+		require.NotNil(t, download.ExpiresAt)
+		assert.Equal(t, 2100, download.ExpiresAt.Year())
+		assert.False(t, download.Expired())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		test := NewRouteTest(t)
+		expired := time.Now().Add(-time.Hour)
+		require.NoError(t, test.DB.Model(&models.Download{ID: "first-download"}).Update("expires_at", &expired).Error)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPut, "/downloads/first-download/expiry", strings.NewReader(`{"expires_at": null}`), token)
+
+		download := &models.Download{}
+		extractPayload(t, http.StatusOK, recorder, download)
+		assert.Nil(t, download.ExpiresAt)
+
+		saved := &models.Download{}
+		require.NoError(t, test.DB.First(saved, "id = ?", "first-download").Error)
+		assert.Nil(t, saved.ExpiresAt)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := strings.NewReader(`{"expires_at": null}`)
+		recorder := test.TestEndpoint(http.MethodPut, "/downloads/first-download/expiry", body, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
 }
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/eligibility"
+	"github.com/netlify/gocommerce/models"
+)
+
+const birthdateFormat = "2006-01-02"
+
+// checkEligibility asks the eligibility checker whether the customer may
+// purchase the line items of a new order. Orders with ineligible line items are rejected. Unlike address
+// verification, orders are rejected if the checker fails, so restricted
+// products can't be sold without a check.
+func checkEligibility(r *http.Request, order *models.Order, birthdate string) *HTTPError {
+	ctx := r.Context()
+	checker := gcontext.GetEligibilityChecker(ctx)
+	if checker == nil {
+		return nil
+	}
+
+	req := &eligibility.Request{}
+	if birthdate != "" {
+		parsed, err := time.Parse(birthdateFormat, birthdate)
+		if err != nil {
+			return httpError(http.StatusUnprocessableEntity, "Invalid birthdate").WithFieldErrors([]FieldError{
+				{Field: "birthdate", Message: "The birthdate must be formatted as YYYY-MM-DD"},
+			})
+		}
+		req.Birthdate = &parsed
+	}
+	if claims := gcontext.GetClaims(ctx); claims != nil {
+		req.AppMetaData = claims.AppMetaData
+	}
+
+	fieldErrors := []FieldError{}
+	for i, item := range order.LineItems {
+		req.Item = item
+		result, err := checker.CheckEligibility(req)
+		if err != nil {
+			return internalServerError("Error checking the eligibility to purchase %s", item.Sku).WithInternalError(err)
+		}
+		if !result.Eligible {
+			message := result.Reason
+			if message == "" {
+				message = "You are not eligible to purchase this product"
+			}
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("line_items[%d]", i), Message: message})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		getLogEntry(r).WithField("ineligible_items", len(fieldErrors)).Info("Rejected order with ineligible line items")
+		return httpError(http.StatusUnprocessableEntity, "Some products can't be purchased").WithFieldErrors(fieldErrors)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eligibilityPayload(birthdate string) string {
+	return `{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/simple-product", "quantity": 1},
+			{"path": "/download-product", "quantity": 1}
+		]` + birthdate + `
+	}`
+}
+
+func TestOrderCreateEligibility(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	routeTest := func(t *testing.T) *RouteTest {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Eligibility.ProductTypes = conf.EligibilityRules{
+			"E-Book": {Provider: "age", MinimumAge: 21},
+		}
+		return test
+	}
+
+	t.Run("MissingBirthdate", func(t *testing.T) {
+		test := routeTest(t)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload("")), test.Data.testUserToken)
+		body := recorder.Body.String()
+		assert.Contains(t, body, `"line_items[1]"`)
+		assert.NotContains(t, body, `"line_items[0]"`)
+		assert.Contains(t, body, "A birthdate is required")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "can't be purchased")
+	})
+
+	t.Run("TooYoung", func(t *testing.T) {
+		test := routeTest(t)
+		birthdate := time.Now().AddDate(-20, 0, 0).Format("2006-01-02")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload(`, "birthdate": "`+birthdate+`"`)), test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "at least 21 years old")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "can't be purchased")
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Order{}).Where("email = ?", "info@example.com").Count(&count).Error)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("Eligible", func(t *testing.T) {
+		test := routeTest(t)
+		birthdate := time.Now().AddDate(-30, 0, 0).Format("2006-01-02")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload(`, "birthdate": "`+birthdate+`"`)), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Len(t, order.LineItems, 2)
+	})
+
+	t.Run("InvalidBirthdate", func(t *testing.T) {
+		test := routeTest(t)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload(`, "birthdate": "01/02/1990"`)), test.Data.testUserToken)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Invalid birthdate")
+	})
+
+	t.Run("Unrestricted", func(t *testing.T) {
+		test := routeTest(t)
+		test.Config.Eligibility.ProductTypes["E-Book"] = conf.EligibilityRule{}
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload("")), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+
+	t.Run("Reorder", func(t *testing.T) {
+		test := routeTest(t)
+		birthdate := time.Now().AddDate(-30, 0, 0).Format("2006-01-02")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(eligibilityPayload(`, "birthdate": "`+birthdate+`"`)), test.Data.testUserToken)
+		previous := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, previous)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+previous.ID+"/reorder", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "can't be purchased")
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+previous.ID+"/reorder", strings.NewReader(`{"birthdate": "`+birthdate+`"}`), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, &reorderResponse{Order: order})
+		assert.Len(t, order.LineItems, 2)
+	})
+}
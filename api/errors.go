@@ -26,13 +26,24 @@ func unauthorizedError(fmtString string, args ...interface{}) *HTTPError {
 	return httpError(http.StatusUnauthorized, fmtString, args...)
 }
 
+func goneError(fmtString string, args ...interface{}) *HTTPError {
+	return httpError(http.StatusGone, fmtString, args...)
+}
+
 // HTTPError is an error with a message and an HTTP status code.
 type HTTPError struct {
-	Code            int    `json:"code"`
-	Message         string `json:"msg"`
-	InternalError   error  `json:"-"`
-	InternalMessage string `json:"-"`
-	ErrorID         string `json:"error_id,omitempty"`
+	Code            int          `json:"code"`
+	Message         string       `json:"msg"`
+	Errors          []FieldError `json:"errors,omitempty"`
+	InternalError   error        `json:"-"`
+	InternalMessage string       `json:"-"`
+	ErrorID         string       `json:"error_id,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"msg"`
 }
 
 func (e *HTTPError) Error() string {
@@ -56,6 +67,12 @@ func (e *HTTPError) WithInternalError(err error) *HTTPError {
 	return e
 }
 
+// WithFieldErrors adds the invalid fields of the request to the error
+func (e *HTTPError) WithFieldErrors(errs []FieldError) *HTTPError {
+	e.Errors = errs
+	return e
+}
+
 // WithInternalMessage adds internal message information to the error
 func (e *HTTPError) WithInternalMessage(fmtString string, args ...interface{}) *HTTPError {
 	e.InternalMessage = fmt.Sprintf(fmtString, args...)
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeOrderRouteTest(t *testing.T) *RouteTest {
+	test := NewRouteTest(t)
+	test.Config.Webhooks.Payment = "https://example.com/hooks/payment"
+	order := test.Data.firstOrder
+	order.PaymentState = models.PendingState
+	order.Discount = order.SubTotal
+	order.NetTotal = 0
+	order.Taxes = 0
+	order.Total = 0
+	require.NoError(t, test.DB.Save(order).Error)
+	return test
+}
+
+func TestFreeOrderPayment(t *testing.T) {
+	t.Run("Paid", func(t *testing.T) {
+		test := freeOrderRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", strings.NewReader(`{"amount": 0, "currency": "USD"}`), test.Data.testUserToken)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+		assert.Equal(t, models.PaidState, tr.Status)
+		assert.Zero(t, tr.Amount)
+		assert.Empty(t, tr.ProcessorID)
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.PaidState, order.PaymentState)
+		assert.Equal(t, payments.FreeProvider, order.PaymentProcessor)
+		assert.NotNil(t, order.PaidAt)
+		assert.NotZero(t, order.InvoiceNumber)
+
+		hook := &models.Hook{}
+		require.NoError(t, test.DB.First(hook, "order_id = ? AND type = ?", "first-order", "payment").Error)
+		payload := &models.Order{}
+		require.NoError(t, json.Unmarshal([]byte(hook.Payload), payload))
+		assert.Equal(t, models.PaidState, payload.PaymentState)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/downloads", nil, test.Data.testUserToken)
+		downloads := struct {
+			Items []models.Download `json:"items"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &downloads)
+		assert.Len(t, downloads.Items, 1, "the downloads of free orders are available")
+	})
+
+	t.Run("NotFree", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", strings.NewReader(`{"amount": 0, "currency": "USD"}`), test.Data.testUserToken)
+		validateError(t, http.StatusInternalServerError, recorder, "didn't match amount to charge")
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.PendingState, order.PaymentState)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		test := freeOrderRouteTest(t)
+		test.Config.Payment.DisableFreeOrders = true
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", strings.NewReader(`{"amount": 0, "currency": "USD"}`), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "doesn't accept free orders")
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.PendingState, order.PaymentState)
+	})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+)
+
+// addFreeProduct adds the free product of the order's coupon as a line item
+// if the order qualifies for it. The product is loaded like any other line
+// item, but costs nothing. Its stock is reserved with the other line items.
+func (a *API) addFreeProduct(ctx context.Context, order *models.Order) error {
+	coupon := order.Coupon
+	if !coupon.QualifiesForFreeProduct(order.LineItems) {
+		return nil
+	}
+
+	free := coupon.FreeProduct
+	quantity := free.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	item := &models.LineItem{
+		Sku:      free.Sku,
+		Path:     free.Path,
+		Quantity: quantity,
+		OrderID:  order.ID,
+	}
+	if err := a.processLineItem(ctx, order, item, &orderLineItem{Sku: free.Sku, Path: free.Path, Quantity: quantity}); err != nil {
+		return errors.Wrapf(err, "Error processing free product %s of coupon %s", free.Sku, coupon.Code)
+	}
+	item.Free = true
+	item.Price = 0
+	item.AddonPrice = 0
+	item.PriceItems = nil
+	order.LineItems = append(order.LineItems, item)
+	return nil
+}
+
+// removeFreeProduct removes the free line items of an order that doesn't
+// qualify for them anymore, e.g. because the products the coupon applies to
+// were removed or the coupon isn't valid anymore. Their downloads are removed
+// and their reserved stock is released. It reports whether items were removed.
+func removeFreeProduct(tx *gorm.DB, order *models.Order) (bool, error) {
+	if order.Coupon.QualifiesForFreeProduct(order.LineItems) {
+		return false, nil
+	}
+
+	items := []*models.LineItem{}
+	removed := map[int64]bool{}
+	for _, item := range order.LineItems {
+		if !item.Free {
+			items = append(items, item)
+			continue
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return false, errors.Wrap(err, "Error removing free line item")
+		}
+		if err := tx.Where("line_item_id = ?", item.ID).Delete(&models.Download{}).Error; err != nil {
+			return false, errors.Wrap(err, "Error removing downloads of free line item")
+		}
+		if err := models.ReleaseReservation(tx, order.ID, item.Sku, item.Quantity); err != nil {
+			return false, err
+		}
+		removed[item.ID] = true
+	}
+	if len(removed) == 0 {
+		return false, nil
+	}
+
+	downloads := []models.Download{}
+	for _, download := range order.Downloads {
+		if !removed[download.LineItemID] {
+			downloads = append(downloads, download)
+		}
+	}
+	order.LineItems = items
+	order.Downloads = downloads
+	return true, nil
+}
+
+// updateFreeProduct adds or removes the free product of the order's coupon
+// after its line items were changed. An added free product is saved along
+// with its downloads and its stock is reserved.
+func (a *API) updateFreeProduct(ctx context.Context, tx *gorm.DB, config *conf.Configuration, order *models.Order) error {
+	if _, err := removeFreeProduct(tx, order); err != nil {
+		return err
+	}
+	for _, item := range order.LineItems {
+		if item.Free {
+			return nil
+		}
+	}
+
+	count := len(order.LineItems)
+	if err := a.addFreeProduct(ctx, order); err != nil {
+		return err
+	}
+	if len(order.LineItems) == count {
+		return nil
+	}
+	item := order.LineItems[count]
+	if err := tx.Create(item).Error; err != nil {
+		return errors.Wrap(err, "Error saving free line item")
+	}
+	downloads := len(order.Downloads)
+	item.IssueDownloads(order)
+	for i := downloads; i < len(order.Downloads); i++ {
+		if err := tx.Create(&order.Downloads[i]).Error; err != nil {
+			return errors.Wrap(err, "Error saving downloads of free line item")
+		}
+	}
+
+	if config.Inventory.Enabled {
+		expiresAt := time.Now().Add(time.Duration(config.Inventory.ReservationTimeout) * time.Second)
+		added := &models.Order{ID: order.ID, InstanceID: order.InstanceID, LineItems: []*models.LineItem{item}}
+		return models.ReserveInventory(tx, added, expiresAt, config.Inventory.AllowBackorders)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const freeProductOrderBody = `{
+	"email": "info@example.com",
+	"shipping_address": {
+		"name": "Test User",
+		"address1": "610 22nd Street",
+		"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+	},
+	"line_items": [{"path": "/simple-product", "quantity": 1}],
+	"coupon": "BOOK-GIFT"
+}`
+
+func TestOrderCreateFreeProduct(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+	// the coupon grants the weighted product with orders of these product types
+	productTypes := `"Book"`
+	couponServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"coupons": {"BOOK-GIFT": {
+			"product_types": [%s],
+			"free_product": {"sku": "weighted-1", "path": "/weighted-product"}
+		}}}`, productTypes)
+	}))
+	defer couponServer.Close()
+
+	routeTest := func(t *testing.T, types string) *RouteTest {
+		productTypes = types
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Coupons.URL = couponServer.URL
+		return test
+	}
+
+	t.Run("Qualifying", func(t *testing.T) {
+		test := routeTest(t, `"Book"`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(freeProductOrderBody), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		require.Len(t, order.LineItems, 2)
+		free := order.LineItems[1]
+		assert.Equal(t, "weighted-1", free.Sku)
+		assert.True(t, free.Free)
+		assert.Zero(t, free.Price)
+		assert.EqualValues(t, 1, free.Quantity)
+		assert.Zero(t, free.Total)
+		assert.False(t, order.LineItems[0].Free)
+		assert.EqualValues(t, 999, order.Total)
+	})
+
+	t.Run("NotQualifying", func(t *testing.T) {
+		test := routeTest(t, `"E-Book"`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(freeProductOrderBody), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 1)
+		assert.Equal(t, "product-1", order.LineItems[0].Sku)
+	})
+
+	t.Run("OutOfStock", func(t *testing.T) {
+		test := routeTest(t, `"Book"`)
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "weighted-1", Available: 0}).Error)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(freeProductOrderBody), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "weighted-1 is out of stock")
+	})
+
+	t.Run("UpdatedWithLineItems", func(t *testing.T) {
+		test := routeTest(t, `"Book"`)
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "weighted-1", Available: 1}).Error)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(freeProductOrderBody), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 2)
+
+		setCouponTypes := func(types ...string) {
+			saved := &models.Order{}
+			require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+			saved.Coupon.ProductTypes = types
+			require.NoError(t, test.DB.Save(saved).Error)
+		}
+		stock := func() uint64 {
+			item := &models.InventoryItem{}
+			require.NoError(t, test.DB.First(item, "sku = ?", "weighted-1").Error)
+			return item.Available
+		}
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := fmt.Sprintf(`{"line_items": [{"id": %d, "quantity": 2}]}`, order.LineItems[0].ID)
+
+		setCouponTypes("E-Book")
+		recorder = test.TestEndpoint(http.MethodPut, "/orders/"+order.ID, strings.NewReader(body), token)
+		updated := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, updated)
+		require.Len(t, updated.LineItems, 1)
+		assert.Equal(t, "product-1", updated.LineItems[0].Sku)
+		assert.EqualValues(t, 1, stock())
+
+		setCouponTypes("Book")
+		recorder = test.TestEndpoint(http.MethodPut, "/orders/"+order.ID, strings.NewReader(body), token)
+		updated = &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, updated)
+		require.Len(t, updated.LineItems, 2)
+		assert.True(t, updated.LineItems[1].Free)
+
+		saved := &models.Order{}
+		require.NoError(t, orderQuery(test.DB).First(saved, "id = ?", order.ID).Error)
+		assert.Len(t, saved.LineItems, 2)
+		assert.EqualValues(t, 0, stock())
+	})
+
+	t.Run("RemovedOnRecalculation", func(t *testing.T) {
+		test := routeTest(t, `"Book"`)
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "weighted-1", Available: 1}).Error)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(freeProductOrderBody), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 2)
+
+		// the coupon doesn't apply to books anymore
+		productTypes = `"E-Book"`
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/recalculate", nil, test.Data.testUserToken)
+		result := &struct {
+			Order *models.Order `json:"order"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		require.Len(t, result.Order.LineItems, 1)
+		assert.Equal(t, "product-1", result.Order.LineItems[0].Sku)
+
+		saved := &models.Order{}
+		require.NoError(t, orderQuery(test.DB).First(saved, "id = ?", order.ID).Error)
+		assert.Len(t, saved.LineItems, 1)
+		stock := &models.InventoryItem{}
+		require.NoError(t, test.DB.First(stock, "sku = ?", "weighted-1").Error)
+		assert.EqualValues(t, 1, stock.Available)
+	})
+}
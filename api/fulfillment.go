@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type lineItemFulfillmentParams struct {
+	FulfillmentState string `json:"fulfillment_state"`
+}
+
+// LineItemFulfillmentUpdate changes the fulfillment state of a line item of a
+// paid order, e.g. when a physical item was shipped or a service completed.
+// The order is marked fulfilled once all of its line items are. Requires
+// admin permissions
+func (a *API) LineItemFulfillmentUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "line_item_id"), 10, 64)
+	if err != nil {
+		return badRequestError("Invalid line item id: %v", err)
+	}
+
+	params := new(lineItemFulfillmentParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read fulfillment parameters: %v", err)
+	}
+	valid := false
+	for _, state := range models.LineItemFulfillmentStates {
+		if state == params.FulfillmentState {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return badRequestError("Bad fulfillment state: %s", params.FulfillmentState)
+	}
+
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if order.PaymentState != models.PaidState {
+		return badRequestError("Only paid orders can be fulfilled")
+	}
+	switch order.FulfillmentState {
+	case models.OnHoldState, models.RejectedState:
+		return badRequestError("Orders that are %s can't be fulfilled", order.FulfillmentState)
+	}
+
+	var item *models.LineItem
+	for _, i := range order.LineItems {
+		if i.ID == itemID {
+			item = i
+			break
+		}
+	}
+	if item == nil {
+		return notFoundError("Failed to find line item %d in order '%s'", itemID, orderID)
+	}
+	item.FulfillmentState = params.FulfillmentState
+	fulfilled := order.UpdateFulfillmentState()
+
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving line item fulfillment").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventUpdated, []string{"line_item_fulfillment"})
+	if fulfilled {
+		models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventFulfilled, []string{"fulfillment_state"})
+	}
+	if rsp := tx.Commit(); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error committing line item fulfillment").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Line item %d of order %s is %s", item.ID, order.ID, item.FulfillmentState)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
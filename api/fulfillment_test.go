@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineItemFulfillment(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/simple-product", "quantity": 1},
+			{"path": "/download-product", "quantity": 1}
+		]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 2)
+	physical, digital := order.LineItems[0], order.LineItems[1]
+	assert.Equal(t, models.PhysicalFulfillment, physical.FulfillmentType)
+	assert.Equal(t, models.DigitalFulfillment, digital.FulfillmentType)
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	url := fmt.Sprintf("/orders/%s/line_items/%d/fulfillment", order.ID, physical.ID)
+	recorder = test.TestEndpoint(http.MethodPut, url, strings.NewReader(`{"fulfillment_state": "shipped"}`), token)
+	validateError(t, http.StatusBadRequest, recorder, "Only paid orders can be fulfilled")
+
+	// paying the order delivers the digital items
+	order.PaymentState = models.PaidState
+	assert.False(t, order.FulfillDigitalItems())
+	require.NoError(t, test.DB.Save(order).Error)
+	assert.Equal(t, models.FulfilledState, digital.FulfillmentState)
+	assert.Equal(t, models.PendingState, physical.FulfillmentState)
+	assert.Equal(t, models.PartiallyFulfilledState, order.FulfillmentState)
+
+	recorder = test.TestEndpoint(http.MethodPut, url, strings.NewReader(`{"fulfillment_state": "done"}`), token)
+	validateError(t, http.StatusBadRequest, recorder, "Bad fulfillment state: done")
+
+	recorder = test.TestEndpoint(http.MethodPut, url, strings.NewReader(`{"fulfillment_state": "shipped"}`), test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+
+	recorder = test.TestEndpoint(http.MethodPut, url, strings.NewReader(`{"fulfillment_state": "shipped"}`), token)
+	updated := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, updated)
+	assert.Equal(t, models.FulfilledState, updated.FulfillmentState)
+
+	events := []models.Event{}
+	require.NoError(t, test.DB.Where("order_id = ? AND type = ?", order.ID, models.EventFulfilled).Find(&events).Error)
+	assert.Len(t, events, 1)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type inventoryRestockParams struct {
+	Quantity uint64 `json:"quantity"`
+}
+
+type inventoryRestockResponse struct {
+	*models.InventoryItem
+	FulfilledBackorders []*models.Backorder `json:"fulfilled_backorders"`
+}
+
+type inventoryUpdateParams struct {
+	Available *uint64 `json:"available"`
+}
+
+// InventoryList returns the tracked stock of all products. Requires admin permissions
+func (a *API) InventoryList(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	items := []models.InventoryItem{}
+	if rsp := a.db.Where("instance_id = ?", instanceID).Order("sku asc").Find(&items); rsp.Error != nil {
+		return internalServerError("Error while querying inventory").WithInternalError(rsp.Error)
+	}
+
+	return sendJSON(w, http.StatusOK, items)
+}
+
+// InventoryUpdate sets the available stock of a product. Requires admin permissions
+func (a *API) InventoryUpdate(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	sku := chi.URLParam(r, "sku")
+	log := getLogEntry(r)
+
+	params := new(inventoryUpdateParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read inventory parameters: %v", err)
+	}
+	if params.Available == nil {
+		return badRequestError("Updating inventory requires an 'available' count")
+	}
+
+	item := &models.InventoryItem{}
+	rsp := a.db.Where("instance_id = ? AND sku = ?", instanceID, sku).
+		Attrs(models.InventoryItem{InstanceID: instanceID, Sku: sku}).
+		Assign(map[string]interface{}{"available": *params.Available}).
+		FirstOrCreate(item)
+	if rsp.Error != nil {
+		return internalServerError("Error saving inventory").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Set available inventory of %s to %d", sku, item.Available)
+	return sendJSON(w, http.StatusOK, item)
+}
+
+// InventoryRestock adds stock for a product and fulfills waiting backorders
+// with it, oldest first. Requires admin permissions
+func (a *API) InventoryRestock(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	instanceID := gcontext.GetInstanceID(ctx)
+	claims := gcontext.GetClaims(ctx)
+	sku := chi.URLParam(r, "sku")
+	log := getLogEntry(r)
+
+	params := new(inventoryRestockParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read restock parameters: %v", err)
+	}
+	if params.Quantity == 0 {
+		return badRequestError("Restocking requires a 'quantity' greater than 0")
+	}
+
+	tx := a.db.Begin()
+	item, fulfilled, err := models.Restock(tx, instanceID, sku, params.Quantity)
+	if err != nil {
+		tx.Rollback()
+		return internalServerError("Error restocking inventory").WithInternalError(err)
+	}
+
+	for _, backorder := range fulfilled {
+		models.LogEvent(tx, r.RemoteAddr, claims.Subject, backorder.OrderID, models.EventBackorderFulfilled, []string{
+			fmt.Sprintf("%s=%d", backorder.Sku, backorder.Quantity),
+		})
+		enqueueWebhook(tx, config, "order.backorder_fulfilled", claims.Subject, backorder.OrderID, backorder, log)
+	}
+
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error restocking inventory").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Restocked %d x %s, fulfilled %d backorders", params.Quantity, sku, len(fulfilled))
+	return sendJSON(w, http.StatusOK, &inventoryRestockResponse{
+		InventoryItem:       item,
+		FulfilledBackorders: fulfilled,
+	})
+}
+
+// BackorderList returns the backorders still waiting for stock, oldest first.
+// Requires admin permissions
+func (a *API) BackorderList(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	query := a.db.Where("instance_id = ? AND fulfilled_at IS NULL", instanceID)
+	if sku := r.URL.Query().Get("sku"); sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+
+	backorders := []models.Backorder{}
+	if rsp := query.Order("created_at asc, id asc").Find(&backorders); rsp.Error != nil {
+		return internalServerError("Error while querying backorders").WithInternalError(rsp.Error)
+	}
+
+	return sendJSON(w, http.StatusOK, backorders)
+}
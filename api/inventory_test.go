@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+func TestInventoryUpdate(t *testing.T) {
+	t.Run("AsAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPut, "/inventory/product-1", strings.NewReader(`{"available": 5}`), token)
+
+		item := &models.InventoryItem{}
+		extractPayload(t, http.StatusOK, recorder, item)
+		assert.Equal(t, "product-1", item.Sku)
+		assert.Equal(t, uint64(5), item.Available)
+
+		recorder = test.TestEndpoint(http.MethodPut, "/inventory/product-1", strings.NewReader(`{"available": 0}`), token)
+		extractPayload(t, http.StatusOK, recorder, item)
+		assert.Equal(t, uint64(0), item.Available)
+
+		items := []models.InventoryItem{}
+		recorder = test.TestEndpoint(http.MethodGet, "/inventory", nil, token)
+		extractPayload(t, http.StatusOK, recorder, &items)
+		require.Len(t, items, 1)
+		assert.Equal(t, uint64(0), items[0].Available)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodPut, "/inventory/product-1", strings.NewReader(`{"available": 5}`), test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestInventoryReservation(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	t.Run("ReserveAndRelease", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 1}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		item := &models.InventoryItem{}
+		require.NoError(t, test.DB.First(item, "sku = ?", "product-1").Error)
+		assert.Equal(t, uint64(0), item.Available)
+
+		// the last unit is already reserved
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder)
+
+		// expire the reservation
+		rsp := test.DB.Model(&models.Reservation{}).Where("order_id = ?", order.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, rsp.Error)
+		require.NoError(t, models.ReleaseExpiredReservations(test.DB, testLogger))
+
+		require.NoError(t, test.DB.First(item, "sku = ?", "product-1").Error)
+		assert.Equal(t, uint64(1), item.Available)
+	})
+
+	t.Run("CommittedOnPayment", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 1}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		require.NoError(t, models.CommitReservations(test.DB, order.ID))
+		rsp := test.DB.Model(&models.Reservation{}).Where("order_id = ?", order.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, rsp.Error)
+		require.NoError(t, models.ReleaseExpiredReservations(test.DB, testLogger))
+
+		item := &models.InventoryItem{}
+		require.NoError(t, test.DB.First(item, "sku = ?", "product-1").Error)
+		assert.Equal(t, uint64(0), item.Available)
+	})
+
+	t.Run("RenewedOnPayment", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 2}).Error)
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			v.(*stripe.Charge).ID = "ch_123"
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		rsp := test.DB.Model(&models.Reservation{}).Where("order_id = ?", order.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, rsp.Error)
+		require.NoError(t, models.ReleaseExpiredReservations(test.DB, testLogger))
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      order.Total,
+			Currency:    order.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		extractPayload(t, http.StatusOK, recorder, &models.Transaction{})
+
+		item := &models.InventoryItem{}
+		require.NoError(t, test.DB.First(item, "sku = ?", "product-1").Error)
+		assert.Equal(t, uint64(1), item.Available)
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Reservation{}).Where("order_id = ? AND committed_at IS NOT NULL", order.ID).Count(&count).Error)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("SoldBeforePayment", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 1}).Error)
+		charged := false
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			charged = true
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		// the expired reservation's unit is sold to someone else
+		rsp := test.DB.Model(&models.Reservation{}).Where("order_id = ?", order.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, rsp.Error)
+		require.NoError(t, models.ReleaseExpiredReservations(test.DB, testLogger))
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      order.Total,
+			Currency:    order.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "out of stock")
+		assert.False(t, charged)
+	})
+
+	t.Run("QuantityUpdated", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 3}).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		available := func() uint64 {
+			item := &models.InventoryItem{}
+			require.NoError(t, test.DB.First(item, "sku = ?", "product-1").Error)
+			return item.Available
+		}
+		update := func(quantity int) *httptest.ResponseRecorder {
+			body := fmt.Sprintf(`{"line_items": [{"sku": "product-1", "quantity": %d}]}`, quantity)
+			token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+			return test.TestEndpoint(http.MethodPut, "/orders/"+order.ID, strings.NewReader(body), token)
+		}
+		require.Equal(t, uint64(2), available())
+
+		recorder = update(5)
+		validateError(t, http.StatusBadRequest, recorder, "out of stock")
+		assert.Equal(t, uint64(2), available())
+
+		recorder = update(3)
+		extractPayload(t, http.StatusOK, recorder, &models.Order{})
+		assert.Equal(t, uint64(0), available())
+
+		recorder = update(2)
+		extractPayload(t, http.StatusOK, recorder, &models.Order{})
+		assert.Equal(t, uint64(1), available())
+
+		reservation := &models.Reservation{}
+		require.NoError(t, test.DB.First(reservation, "order_id = ?", order.ID).Error)
+		assert.Equal(t, uint64(2), reservation.Quantity)
+	})
+
+	t.Run("Untracked", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Inventory.Enabled = true
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+}
+
+func TestInventoryBackorders(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Inventory.Enabled = true
+	test.Config.Inventory.AllowBackorders = true
+	require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 0}).Error)
+
+	orders := []*models.Order{}
+	for i := 0; i < 3; i++ {
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		orders = append(orders, order)
+	}
+	// the second order is never paid, so it doesn't get any stock
+	for _, order := range []*models.Order{orders[0], orders[2]} {
+		require.NoError(t, test.DB.Model(order).UpdateColumn("payment_state", models.PaidState).Error)
+	}
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	backorders := []models.Backorder{}
+	recorder := test.TestEndpoint(http.MethodGet, "/inventory/backorders", nil, token)
+	extractPayload(t, http.StatusOK, recorder, &backorders)
+	require.Len(t, backorders, 3)
+	assert.Equal(t, orders[0].ID, backorders[0].OrderID)
+
+	result := &inventoryRestockResponse{}
+	recorder = test.TestEndpoint(http.MethodPost, "/inventory/product-1/restock", strings.NewReader(`{"quantity": 1}`), token)
+	extractPayload(t, http.StatusOK, recorder, result)
+	assert.Equal(t, uint64(0), result.Available)
+	require.Len(t, result.FulfilledBackorders, 1)
+	assert.Equal(t, orders[0].ID, result.FulfilledBackorders[0].OrderID)
+
+	result = &inventoryRestockResponse{}
+	recorder = test.TestEndpoint(http.MethodPost, "/inventory/product-1/restock", strings.NewReader(`{"quantity": 5}`), token)
+	extractPayload(t, http.StatusOK, recorder, result)
+	assert.Equal(t, uint64(4), result.Available)
+	require.Len(t, result.FulfilledBackorders, 1)
+	assert.Equal(t, orders[2].ID, result.FulfilledBackorders[0].OrderID)
+
+	events := []models.Event{}
+	require.NoError(t, test.DB.Where("type = ?", models.EventBackorderFulfilled).Find(&events).Error)
+	assert.Len(t, events, 2)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/inventory/backorders", nil, token)
+	extractPayload(t, http.StatusOK, recorder, &backorders)
+	require.Len(t, backorders, 1)
+	assert.Equal(t, orders[1].ID, backorders[0].OrderID)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type creditNoteParams struct {
+	Amount uint64 `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// wantsPDF checks if a document should be sent as PDF, either with
+// `Accept: application/pdf` or `?format=pdf` for plain links.
+func wantsPDF(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "pdf" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/pdf" {
+			return true
+		}
+	}
+	return false
+}
+
+func sendInvoice(w http.ResponseWriter, r *http.Request, invoice, corrects *models.Invoice) error {
+	if !wantsPDF(r) {
+		return sendJSON(w, http.StatusOK, invoice)
+	}
+	name := invoice.Type + "-" + strconv.FormatInt(invoice.Number, 10) + ".pdf"
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+name+"\"")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(renderInvoicePDF(invoice, corrects))
+	return err
+}
+
+// issueInvoice issues the invoice of a paid order, numbered with the invoice
+// number the order got when it was paid.
+func issueInvoice(tx *gorm.DB, config *conf.Configuration, order *models.Order, ip, subject string) (*models.Invoice, error) {
+	invoice := models.NewInvoice(order, models.InvoiceParty{
+		Name:    config.Invoices.SellerName,
+		Address: config.Invoices.SellerAddress,
+		TaxID:   config.Invoices.SellerTaxID,
+		TaxIDs:  config.Invoices.SellerTaxIDs,
+	})
+	invoice.ApplyReverseCharge(config.Invoices.ReverseChargeNote)
+	if rsp := tx.Create(invoice); rsp.Error != nil {
+		return nil, rsp.Error
+	}
+	models.LogEvent(tx, ip, subject, order.ID, models.EventInvoiced, []string{strconv.FormatInt(invoice.Number, 10)})
+	return invoice, nil
+}
+
+// loadInvoice loads the invoice of an order the user has access to. Invoices
+// are issued when orders are paid. Unless issue is false, the invoice of an
+// order paid before is issued if it has none yet.
+func (a *API) loadInvoice(r *http.Request, issue bool) (*models.Invoice, error) {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
+	order := &models.Order{}
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return nil, notFoundError("Order not found")
+	}
+	if rsp.Error != nil {
+		return nil, internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+	if !hasOrderAccess(ctx, order) {
+		return nil, unauthorizedError("You don't have access to this order")
+	}
+
+	invoice, err := models.FindInvoice(a.db, order.ID)
+	if err != nil {
+		return nil, internalServerError("Error loading invoice").WithInternalError(err)
+	}
+	if invoice != nil {
+		return invoice, nil
+	}
+	if order.PaymentState != models.PaidState || order.InvoiceNumber == 0 {
+		return nil, notFoundError("Invoices are only issued for paid orders")
+	}
+	if !issue {
+		return nil, notFoundError("Invoice not found")
+	}
+
+	subject := order.UserID
+	if claims := gcontext.GetClaims(ctx); claims != nil {
+		subject = claims.Subject
+	}
+	tx := a.db.Begin()
+	invoice, err = issueInvoice(tx, config, order, r.RemoteAddr, subject)
+	if err != nil {
+		tx.Rollback()
+		// the invoice may have been issued by a concurrent request
+		if existing, err := models.FindInvoice(a.db, order.ID); err == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, internalServerError("Error issuing invoice").WithInternalError(err)
+	}
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return nil, internalServerError("Error issuing invoice").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Issued invoice %d for order %s", invoice.Number, order.ID)
+	return invoice, nil
+}
+
+// OrderInvoice returns the invoice of a paid order as JSON or PDF. The invoice
+// never changes once it's issued.
+func (a *API) OrderInvoice(w http.ResponseWriter, r *http.Request) error {
+	invoice, err := a.loadInvoice(r, true)
+	if err != nil {
+		return err
+	}
+	return sendInvoice(w, r, invoice, nil)
+}
+
+// CreditNoteView returns a credit note of an order's invoice as JSON or PDF.
+func (a *API) CreditNoteView(w http.ResponseWriter, r *http.Request) error {
+	number, err := strconv.ParseInt(chi.URLParam(r, "number"), 10, 64)
+	if err != nil {
+		return notFoundError("Credit note not found")
+	}
+	invoice, err := a.loadInvoice(r, false)
+	if err != nil {
+		return err
+	}
+	for _, note := range invoice.CreditNotes {
+		if note.Number == number {
+			return sendInvoice(w, r, note, invoice)
+		}
+	}
+	return notFoundError("Credit note not found")
+}
+
+// CreditNoteCreate corrects the invoice of an order by crediting an amount.
+func (a *API) CreditNoteCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := gcontext.GetClaims(ctx)
+	log := getLogEntry(r)
+
+	params := &creditNoteParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read credit note params: %v", err)
+	}
+	if params.Reason == "" {
+		return badRequestError("A credit note requires a 'reason'")
+	}
+
+	invoice, err := a.loadInvoice(r, true)
+	if err != nil {
+		return err
+	}
+	if params.Amount == 0 || params.Amount > invoice.Total-invoice.Credited() {
+		return badRequestError("The amount of the credit note must be between 0 and the amount not credited yet")
+	}
+
+	tx := a.db.Begin()
+	note, err := issueCreditNote(tx, invoice, params.Amount, params.Reason)
+	if err != nil {
+		tx.Rollback()
+		return internalServerError("Error issuing credit note").WithInternalError(err)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, invoice.OrderID, models.EventCredited, []string{strconv.FormatInt(note.Number, 10)})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error issuing credit note").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Issued credit note %d for invoice %d", note.Number, invoice.Number)
+	return sendJSON(w, http.StatusCreated, note)
+}
+
+// issueCreditNote creates a credit note over an amount of an invoice with the
+// next invoice number. The amount is capped at what hasn't been credited yet,
+// no credit note is issued if nothing is left.
+func issueCreditNote(tx *gorm.DB, invoice *models.Invoice, amount uint64, reason string) (*models.Invoice, error) {
+	if remaining := invoice.Total - invoice.Credited(); amount > remaining {
+		amount = remaining
+	}
+	if amount == 0 {
+		return nil, nil
+	}
+	number, err := models.NextInvoiceNumber(tx, invoice.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	note := models.NewCreditNote(invoice, number, amount, reason)
+	if err := tx.Create(note).Error; err != nil {
+		return nil, err
+	}
+	invoice.CreditNotes = append(invoice.CreditNotes, note)
+	return note, nil
+}
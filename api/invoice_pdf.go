@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/netlify/gocommerce/models"
+)
+
+// Invoices are rendered as plain text PDFs on A4 pages with the standard
+// Helvetica fonts, so no fonts or libraries need to be shipped.
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfLineHeight   = 14
+	pdfFontSize     = 10
+	pdfTitleSize    = 18
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+	// roughly what fits between the margins in Helvetica at pdfFontSize
+	pdfCharsPerLine = 90
+)
+
+type pdfText struct {
+	x    int
+	bold bool
+	size int
+	text string
+}
+
+// pdfWriter lays out lines of text top to bottom and breaks them into pages.
+type pdfWriter struct {
+	pages [][][]pdfText
+}
+
+func (p *pdfWriter) line(cells ...pdfText) {
+	if len(p.pages) == 0 || len(p.pages[len(p.pages)-1]) >= pdfLinesPerPage {
+		p.pages = append(p.pages, nil)
+	}
+	last := len(p.pages) - 1
+	p.pages[last] = append(p.pages[last], cells)
+}
+
+func (p *pdfWriter) text(text string) {
+	p.line(pdfText{x: pdfMargin, text: text})
+}
+
+func (p *pdfWriter) bold(text string) {
+	p.line(pdfText{x: pdfMargin, bold: true, text: text})
+}
+
+// paragraph writes text over as many lines as it needs, breaking it between
+// words.
+func (p *pdfWriter) paragraph(text string) {
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > pdfCharsPerLine {
+			p.text(line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		p.text(line)
+	}
+}
+
+func (p *pdfWriter) blank() {
+	p.line()
+}
+
+// bytes serializes the document. Objects 1 and 2 are the catalog and page
+// tree, 3 and 4 the fonts, followed by a page and content stream per page.
+func (p *pdfWriter) bytes() []byte {
+	if len(p.pages) == 0 {
+		p.blank()
+	}
+
+	objects := []string{"", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>"}
+	kids := []string{}
+	for _, lines := range p.pages {
+		pageID := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, pageID+1))
+
+		content := &bytes.Buffer{}
+		for i, cells := range lines {
+			y := pdfPageHeight - pdfMargin - i*pdfLineHeight
+			for _, cell := range cells {
+				font, size := "F1", cell.size
+				if cell.bold {
+					font = "F2"
+				}
+				if size == 0 {
+					size = pdfFontSize
+				}
+				fmt.Fprintf(content, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, size, cell.x, y, pdfEscape(cell.text))
+			}
+		}
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	out := &bytes.Buffer{}
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape encodes text for a PDF string in WinAnsiEncoding. Characters the
+// encoding doesn't cover are replaced with '?'.
+func pdfEscape(text string) string {
+	out := &bytes.Buffer{}
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(byte(r))
+		case r == '€':
+			out.WriteString("\\200")
+		case r >= 0x20 && r < 0x7f:
+			out.WriteByte(byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(out, "\\%03o", r)
+		default:
+			out.WriteByte('?')
+		}
+	}
+	return out.String()
+}
+
+func invoiceTitle(invoice *models.Invoice) string {
+	if invoice.Type == models.CreditNoteDocumentType {
+		return "Credit Note"
+	}
+	return "Invoice"
+}
+
+func writeInvoiceParty(p *pdfWriter, label string, party models.InvoiceParty) {
+	p.bold(label)
+	for _, line := range []string{party.Name, party.Company} {
+		if line != "" {
+			p.text(line)
+		}
+	}
+	for _, line := range party.Address {
+		p.text(line)
+	}
+	if party.Email != "" {
+		p.text(party.Email)
+	}
+	if party.TaxID != "" {
+		p.text("Tax ID: " + party.TaxID)
+	}
+	for _, taxID := range party.TaxIDs {
+		p.text("Tax ID: " + taxID)
+	}
+	p.blank()
+}
+
+// renderInvoicePDF renders an invoice or credit note as a PDF document.
+func renderInvoicePDF(invoice *models.Invoice, corrects *models.Invoice) []byte {
+	p := &pdfWriter{}
+	p.line(pdfText{x: pdfMargin, bold: true, size: pdfTitleSize, text: invoiceTitle(invoice)})
+	p.blank()
+	p.text(fmt.Sprintf("Number: %d", invoice.Number))
+	p.text("Issue date: " + invoice.IssuedAt.Format("2006-01-02"))
+	p.text("Order: " + invoice.OrderID)
+	if corrects != nil {
+		p.text(fmt.Sprintf("Corrects invoice: %d", corrects.Number))
+	}
+	p.blank()
+
+	writeInvoiceParty(p, "Seller", invoice.Seller)
+	writeInvoiceParty(p, "Buyer", invoice.Buyer)
+
+	columns := []int{pdfMargin, 300, 340, 420, 490}
+	header := []string{"Item", "Qty", "Unit price", "Taxes", "Total"}
+	cells := make([]pdfText, len(header))
+	for i, title := range header {
+		cells[i] = pdfText{x: columns[i], bold: true, text: title}
+	}
+	p.line(cells...)
+	for _, line := range invoice.Lines {
+		title := line.Title
+		if line.Sku != "" {
+			title += " (" + line.Sku + ")"
+		}
+		measure := ""
+		if line.MeasureUnit != "" {
+			measure = ", " + models.FormatMeasure(line.Measure, line.MeasureUnit)
+		}
+		if runes := []rune(title); len(runes)+len(measure) > 45 && len(measure) < 42 {
+			title = string(runes[:42-len(measure)]) + "..."
+		}
+		title += measure
+		p.line(
+			pdfText{x: columns[0], text: title},
+			pdfText{x: columns[1], text: fmt.Sprintf("%d", line.Quantity)},
+			pdfText{x: columns[2], text: formatAmount(line.UnitPrice, invoice.Currency)},
+			pdfText{x: columns[3], text: formatAmount(line.Taxes, invoice.Currency)},
+			pdfText{x: columns[4], text: formatAmount(line.Total, invoice.Currency)},
+		)
+		if len(line.Serials) > 0 {
+			p.line(pdfText{x: columns[0], text: "Serial numbers: " + strings.Join(line.Serials, ", ")})
+		}
+	}
+	p.blank()
+
+	totals := []struct {
+		label  string
+		amount uint64
+	}{
+		{"Subtotal", invoice.SubTotal},
+		{"Discount", invoice.Discount},
+		{"Shipping", invoice.Shipping},
+		{"Taxes", invoice.Taxes},
+		{"Tip", invoice.Tip},
+	}
+	for _, total := range totals {
+		if total.amount > 0 || total.label == "Subtotal" || total.label == "Taxes" {
+			p.line(pdfText{x: columns[3], text: total.label}, pdfText{x: columns[4], text: formatAmount(total.amount, invoice.Currency)})
+		}
+	}
+	for _, tax := range invoice.TaxBreakdown {
+		label := fmt.Sprintf("%s %d%%", tax.Jurisdiction, tax.Rate)
+		if tax.Shipping {
+			label += " on shipping"
+		}
+		p.line(pdfText{x: columns[1], text: label}, pdfText{x: columns[4], text: formatAmount(tax.Amount, invoice.Currency)})
+	}
+	p.line(pdfText{x: columns[3], bold: true, text: "Total"}, pdfText{x: columns[4], bold: true, text: formatAmount(invoice.Total, invoice.Currency)})
+	if invoice.Note != "" {
+		p.blank()
+		p.paragraph(invoice.Note)
+	}
+	if consent := invoice.Consent; consent.Given() {
+		p.blank()
+		if consent.TermsVersion != "" {
+			p.paragraph(fmt.Sprintf("The buyer accepted the terms of version %s on %s from %s.", consent.TermsVersion, consent.AcceptedAt.UTC().Format("2006-01-02 15:04 MST"), consent.IP))
+		}
+		if consent.DigitalDelivery {
+			p.paragraph("The buyer consented to the immediate delivery of digital goods and acknowledged the loss of the right of withdrawal.")
+		}
+	}
+	return p.bytes()
+}
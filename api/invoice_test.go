@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+func invoiceRouteTest(t *testing.T) *RouteTest {
+	test := NewRouteTest(t)
+	test.Config.Invoices.SellerName = "Wayne Enterprises"
+	test.Config.Invoices.SellerAddress = []string{"1007 Mountain Drive", "Gotham"}
+	test.Config.Invoices.SellerTaxID = "GB123456789"
+	test.Data.firstOrder.InvoiceNumber = 7
+	test.Data.firstOrder.VATNumber = "DE999999999"
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	return test
+}
+
+func TestOrderInvoice(t *testing.T) {
+	t.Run("Issue", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		invoice := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, invoice)
+
+		assert.Equal(t, models.InvoiceDocumentType, invoice.Type)
+		assert.EqualValues(t, 7, invoice.Number)
+		assert.Equal(t, "first-order", invoice.OrderID)
+		assert.Equal(t, "Wayne Enterprises", invoice.Seller.Name)
+		assert.Equal(t, "GB123456789", invoice.Seller.TaxID)
+		assert.Equal(t, "wayne", invoice.Buyer.Name)
+		assert.Equal(t, "DE999999999", invoice.Buyer.TaxID)
+		assert.Equal(t, []string{"123 cave way", "324234 gotham", "dcland"}, invoice.Buyer.Address)
+		if assert.Len(t, invoice.Lines, 1) {
+			assert.Equal(t, test.Data.firstLineItem.Sku, invoice.Lines[0].Sku)
+			require.EqualValues(t, 2, invoice.Lines[0].Quantity)
+			assert.EqualValues(t, 12, invoice.Lines[0].UnitPrice)
+			assert.EqualValues(t, 24, invoice.Lines[0].Total, "the line total covers every unit")
+		}
+		assert.Equal(t, test.Data.firstOrder.Total, invoice.Total)
+
+		// changes to the order don't affect the issued invoice
+		test.Data.firstOrder.VATNumber = "changed"
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		again := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, again)
+		assert.Equal(t, invoice.ID, again.ID)
+		assert.Equal(t, "DE999999999", again.Buyer.TaxID)
+	})
+
+	t.Run("PDF", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice?format=pdf", nil, test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/pdf", recorder.Header().Get("Content-Type"))
+		body := recorder.Body.Bytes()
+		assert.True(t, bytes.HasPrefix(body, []byte("%PDF-1.4")))
+		assert.True(t, bytes.HasSuffix(body, []byte("%%EOF\n")))
+		assert.Contains(t, string(body), "(Wayne Enterprises)")
+		assert.Contains(t, string(body), "(Tax ID: GB123456789)")
+	})
+
+	t.Run("ReverseCharge", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		test.Config.Invoices.SellerTaxIDs = []string{"XI123456789"}
+		test.Config.Invoices.ReverseChargeNote = "Reverse charge: the recipient is liable for the VAT."
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		invoice := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, invoice)
+		assert.Equal(t, []string{"XI123456789"}, invoice.Seller.TaxIDs)
+		assert.True(t, invoice.ReverseCharge)
+		assert.Equal(t, "Reverse charge: the recipient is liable for the VAT.", invoice.Note)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice?format=pdf", nil, test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "(Tax ID: XI123456789)")
+		assert.Contains(t, recorder.Body.String(), "(Reverse charge: the recipient is liable for the VAT.)")
+	})
+
+	t.Run("IssuedOnPayment", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			v.(*stripe.Charge).ID = "ch_123"
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      test.Data.firstOrder.Total,
+			Currency:    test.Data.firstOrder.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+
+		invoice, err := models.FindInvoice(test.DB, "first-order")
+		require.NoError(t, err)
+		require.NotNil(t, invoice, "the invoice is issued with the payment")
+		assert.Equal(t, tr.InvoiceNumber, invoice.Number)
+
+		// refunds before the invoice is first viewed are credited on it
+		provider := &memProvider{name: payments.StripeProvider}
+		recorder = runItemizedRefund(t, test, provider, `{"amount": 5, "currency": "USD", "reason": "defective"}`)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		viewed := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, viewed)
+		assert.Equal(t, invoice.ID, viewed.ID)
+		if assert.Len(t, viewed.CreditNotes, 1) {
+			assert.EqualValues(t, 5, viewed.CreditNotes[0].Total)
+		}
+	})
+
+	t.Run("Unpaid", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		validateError(t, http.StatusNotFound, recorder, "only issued for paid orders")
+	})
+
+	t.Run("Stranger", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		token := testToken("stranger", "stranger-danger@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Immutable", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		invoice, err := models.FindInvoice(test.DB, "first-order")
+		require.NoError(t, err)
+		invoice.Total = 1
+		assert.Error(t, test.DB.Save(invoice).Error)
+	})
+}
+
+func TestCreditNote(t *testing.T) {
+	t.Run("Create", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		token := testAdminToken("magical-unicorn", "")
+		body := strings.NewReader(`{"amount": 1, "reason": "Wrong shipping fee"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/invoice/credit_notes", body, token)
+		note := &models.Invoice{}
+		extractPayload(t, http.StatusCreated, recorder, note)
+		assert.Equal(t, models.CreditNoteDocumentType, note.Type)
+		assert.EqualValues(t, 1, note.Total)
+		assert.Equal(t, "Wrong shipping fee", note.Reason)
+		assert.NotEqual(t, int64(7), note.Number)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		invoice := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, invoice)
+		if assert.Len(t, invoice.CreditNotes, 1) {
+			assert.Equal(t, note.ID, invoice.CreditNotes[0].ID)
+			assert.Equal(t, invoice.ID, invoice.CreditNotes[0].CorrectsID)
+		}
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice/credit_notes/"+strconv.FormatInt(note.Number, 10)+"?format=pdf", nil, test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "(Credit Note)")
+		assert.Contains(t, recorder.Body.String(), "(Corrects invoice: 7)")
+	})
+
+	t.Run("TooMuch", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		token := testAdminToken("magical-unicorn", "")
+		body := strings.NewReader(`{"amount": 100000, "reason": "Everything"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/invoice/credit_notes", body, token)
+		validateError(t, http.StatusBadRequest, recorder, "amount not credited yet")
+	})
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		body := strings.NewReader(`{"amount": 1, "reason": "Discount"}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/invoice/credit_notes", body, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("UnknownNumber", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice/credit_notes/999", nil, test.Data.testUserToken)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
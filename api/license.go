@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const licenseProviderTimeout = 10 * time.Second
+
+// licenseKeyRequest is sent to the license provider for every license key.
+type licenseKeyRequest struct {
+	OrderID    string `json:"order_id"`
+	LineItemID int64  `json:"line_item_id"`
+	Sku        string `json:"sku"`
+	Email      string `json:"email"`
+	Format     string `json:"format"`
+}
+
+type licenseKeyResponse struct {
+	Key string `json:"key"`
+}
+
+// issueLicenses issues the license keys still missing for the line items of a
+// paid order, one for every unit. Keys are requested from the license
+// provider if one is configured, otherwise they're generated.
+func (a *API) issueLicenses(tx *gorm.DB, config *conf.Configuration, order *models.Order) error {
+	issued := map[int64]uint64{}
+	for _, license := range order.Licenses {
+		issued[license.LineItemID]++
+	}
+
+	for _, item := range order.LineItems {
+		if item.LicenseFormat == "" {
+			continue
+		}
+		for n := issued[item.ID]; n < item.Quantity; n++ {
+			var key string
+			var err error
+			if config.Licenses.URL != "" {
+				key, err = a.requestLicenseKey(config, order, item)
+			} else {
+				key, err = models.NewLicenseKey(item.LicenseFormat)
+			}
+			if err != nil {
+				return err
+			}
+
+			license := models.NewLicense(order, item, key)
+			if err := tx.Create(license).Error; err != nil {
+				return errors.Wrap(err, "Error creating license")
+			}
+			order.Licenses = append(order.Licenses, *license)
+		}
+	}
+	return nil
+}
+
+// issuePaidLicenses issues the licenses of an order once its payment has been
+// committed, so a slow or failing license provider can't hold up or undo the
+// payment. Every license is saved on its own, and the ones that couldn't be
+// issued are issued when the order's licenses are listed. Orders held for
+// review get their licenses once they're approved.
+func (a *API) issuePaidLicenses(config *conf.Configuration, order *models.Order, log logrus.FieldLogger) {
+	if order.Withheld() {
+		return
+	}
+	if err := a.issueLicenses(a.db, config, order); err != nil {
+		log.WithError(err).Error("Failed to issue licenses")
+	}
+}
+
+// requestLicenseKey asks the license provider for a key. Requests are signed
+// with the webhook secret like webhooks.
+func (a *API) requestLicenseKey(config *conf.Configuration, order *models.Order, item *models.LineItem) (string, error) {
+	body, err := json.Marshal(&licenseKeyRequest{
+		OrderID:    order.ID,
+		LineItemID: item.ID,
+		Sku:        item.Sku,
+		Email:      order.Email,
+		Format:     item.LicenseFormat,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.Licenses.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.Webhooks.Secret != "" {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": order.UserID,
+			"exp": time.Now().Add(licenseProviderTimeout).Unix(),
+		})
+		signature, err := token.SignedString([]byte(config.Webhooks.Secret))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-Commerce-Signature", signature)
+	}
+
+	resp, err := a.licenseClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to request license key")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("License provider returned %v", resp.StatusCode)
+	}
+	rsp := &licenseKeyResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(rsp); err != nil {
+		return "", errors.Wrap(err, "Failed to parse license provider response")
+	}
+	if rsp.Key == "" {
+		return "", errors.New("License provider returned no key")
+	}
+	return rsp.Key, nil
+}
+
+// OrderLicenses lists the license keys of a paid order. Keys that couldn't
+// be issued when the order was paid are issued now.
+func (a *API) OrderLicenses(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
+	order := &models.Order{}
+	rsp := a.db.Preload("LineItems").Preload("Licenses").First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Order not found")
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+	if !hasOrderAccess(ctx, order) {
+		return unauthorizedError("You don't have permission to access this order")
+	}
+	if order.PaymentState != models.PaidState {
+		return unauthorizedError("This order has not been completed yet")
+	}
+	if order.Withheld() {
+		return unauthorizedError("The licenses of this order are withheld during review")
+	}
+
+	tx := a.db.Begin()
+	if err := a.issueLicenses(tx, config, order); err != nil {
+		tx.Rollback()
+		return internalServerError("Error issuing licenses").WithInternalError(err)
+	}
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error issuing licenses").WithInternalError(rsp.Error)
+	}
+
+	log.WithField("license_count", len(order.Licenses)).Debugf("Successfully retrieved %d licenses", len(order.Licenses))
+	return sendJSON(w, http.StatusOK, order.Licenses)
+}
+
+func (a *API) loadLicense(r *http.Request) (*models.License, error) {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	key := chi.URLParam(r, "license_key")
+
+	license := &models.License{}
+	rsp := a.db.First(license, "instance_id = ? AND license_key = ?", instanceID, key)
+	if rsp.RecordNotFound() {
+		return nil, notFoundError("License not found")
+	}
+	if rsp.Error != nil {
+		return nil, internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+	return license, nil
+}
+
+// LicenseActivate counts an activation of a license key, e.g. when the
+// software is installed on a new machine. Activations beyond the limit of the
+// license are rejected.
+func (a *API) LicenseActivate(w http.ResponseWriter, r *http.Request) error {
+	license, err := a.loadLicense(r)
+	if err != nil {
+		return err
+	}
+
+	if err := models.ActivateLicense(a.db, license); err != nil {
+		if e, ok := err.(*models.LicenseActivationError); ok {
+			return httpError(http.StatusConflict, "%v", e)
+		}
+		return internalServerError("Error activating license").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, license)
+}
+
+// LicenseDeactivate releases an activation of a license key, so it can be
+// activated somewhere else.
+func (a *API) LicenseDeactivate(w http.ResponseWriter, r *http.Request) error {
+	license, err := a.loadLicense(r)
+	if err != nil {
+		return err
+	}
+
+	if err := models.DeactivateLicense(a.db, license); err != nil {
+		return internalServerError("Error deactivating license").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, license)
+}
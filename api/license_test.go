@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripe "github.com/stripe/stripe-go"
+)
+
+func licenseRouteTest(t *testing.T) *RouteTest {
+	test := NewRouteTest(t)
+	test.Data.firstLineItem.LicenseFormat = "SW-XXXX-XXXX"
+	test.Data.firstLineItem.LicenseActivationLimit = 2
+	require.NoError(t, test.DB.Save(test.Data.firstLineItem).Error)
+	return test
+}
+
+func TestOrderLicenses(t *testing.T) {
+	t.Run("Generated", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		licenses := []models.License{}
+		extractPayload(t, http.StatusOK, recorder, &licenses)
+
+		require.Len(t, licenses, 2)
+		for _, license := range licenses {
+			assert.Regexp(t, `^SW-[A-Z2-9]{4}-[A-Z2-9]{4}$`, license.Key)
+			assert.Equal(t, test.Data.firstLineItem.ID, license.LineItemID)
+			assert.Equal(t, "batwing", license.Title)
+			assert.EqualValues(t, 2, license.ActivationLimit)
+		}
+		assert.NotEqual(t, licenses[0].Key, licenses[1].Key)
+
+		// licenses are only issued once
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		again := []models.License{}
+		extractPayload(t, http.StatusOK, recorder, &again)
+		require.Len(t, again, 2)
+		assert.ElementsMatch(t, []string{licenses[0].Key, licenses[1].Key}, []string{again[0].Key, again[1].Key})
+	})
+
+	t.Run("Provider", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		requests := []licenseKeyRequest{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := licenseKeyRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			requests = append(requests, req)
+			fmt.Fprintf(w, `{"key": "PROVIDED-%d"}`, len(requests))
+		}))
+		defer server.Close()
+		test.Config.Licenses.URL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		licenses := []models.License{}
+		extractPayload(t, http.StatusOK, recorder, &licenses)
+
+		require.Len(t, requests, 2)
+		assert.Equal(t, "first-order", requests[0].OrderID)
+		assert.Equal(t, test.Data.firstLineItem.Sku, requests[0].Sku)
+		assert.Equal(t, "SW-XXXX-XXXX", requests[0].Format)
+		require.Len(t, licenses, 2)
+		assert.Equal(t, "PROVIDED-1", licenses[0].Key)
+		assert.Equal(t, "PROVIDED-2", licenses[1].Key)
+	})
+
+	t.Run("IssuedOnPayment", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			v.(*stripe.Charge).ID = "ch_123"
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      test.Data.firstOrder.Total,
+			Currency:    test.Data.firstOrder.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.License{}).Where("order_id = ?", "first-order").Count(&count).Error)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("ProviderDownOnPayment", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			v.(*stripe.Charge).ID = "ch_123"
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+		available := false
+		issued := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !available {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			issued++
+			fmt.Fprintf(w, `{"key": "PROVIDED-%d"}`, issued)
+		}))
+		defer server.Close()
+		test.Config.Licenses.URL = server.URL
+
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      test.Data.firstOrder.Total,
+			Currency:    test.Data.firstOrder.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.PaidState, order.PaymentState, "the payment doesn't depend on the license provider")
+
+		available = true
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		licenses := []models.License{}
+		extractPayload(t, http.StatusOK, recorder, &licenses)
+		assert.Len(t, licenses, 2, "the missing licenses are issued later")
+	})
+
+	t.Run("Unpaid", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder, "not been completed")
+	})
+
+	t.Run("Stranger", func(t *testing.T) {
+		test := licenseRouteTest(t)
+		token := testToken("stranger", "stranger-danger@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestLicenseActivation(t *testing.T) {
+	test := licenseRouteTest(t)
+	recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+	licenses := []models.License{}
+	extractPayload(t, http.StatusOK, recorder, &licenses)
+	require.NotEmpty(t, licenses)
+	url := "/licenses/" + licenses[0].Key
+
+	license := &models.License{}
+	for i := 1; i <= 2; i++ {
+		recorder = test.TestEndpoint(http.MethodPost, url+"/activate", nil, nil)
+		extractPayload(t, http.StatusOK, recorder, license)
+		assert.EqualValues(t, i, license.Activations)
+	}
+
+	recorder = test.TestEndpoint(http.MethodPost, url+"/activate", nil, nil)
+	validateError(t, http.StatusConflict, recorder, "already been activated 2 times")
+
+	recorder = test.TestEndpoint(http.MethodPost, url+"/deactivate", nil, nil)
+	extractPayload(t, http.StatusOK, recorder, license)
+	assert.EqualValues(t, 1, license.Activations)
+
+	recorder = test.TestEndpoint(http.MethodPost, url+"/activate", nil, nil)
+	extractPayload(t, http.StatusOK, recorder, license)
+	assert.EqualValues(t, 2, license.Activations)
+
+	recorder = test.TestEndpoint(http.MethodPost, "/licenses/UNKNOWN/activate", nil, nil)
+	validateError(t, http.StatusNotFound, recorder)
+}
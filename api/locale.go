@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+)
+
+// defaultCurrency is the currency of orders that don't specify one and
+// whose currency couldn't be inferred.
+const defaultCurrency = "USD"
+
+// inferRegion determines the country of a request from the geolocation header
+// set by a CDN or proxy, falling back to the most preferred language of the
+// Accept-Language header that names a country.
+func inferRegion(r *http.Request, config *conf.Configuration) (language.Region, bool) {
+	if config.Locale.GeoHeader != "" {
+		region, err := language.ParseRegion(strings.TrimSpace(r.Header.Get(config.Locale.GeoHeader)))
+		if err == nil && region.IsCountry() {
+			return region, true
+		}
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return language.Region{}, false
+	}
+	for _, tag := range tags {
+		// languages without a region, e.g. "de", only give a guess
+		if region, confidence := tag.Region(); confidence == language.Exact && region.IsCountry() {
+			return region, true
+		}
+	}
+	return language.Region{}, false
+}
+
+// lookupLocale finds the value for an ISO 3166 country code in a configured
+// map, ignoring the case of the keys.
+func lookupLocale(values map[string]string, code string) (string, bool) {
+	for key, value := range values {
+		if strings.EqualFold(strings.TrimSpace(key), code) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// inferLocale infers the country and currency of a new order from the
+// request. The country is named as configured for it, the ISO 3166 code
+// otherwise. Either is empty if it can't be inferred, and the currency is
+// only inferred if orders in it are supported.
+func inferLocale(r *http.Request, config *conf.Configuration) (country string, currencyCode string) {
+	if !config.Locale.Infer {
+		return "", ""
+	}
+	region, ok := inferRegion(r, config)
+	if !ok {
+		return "", ""
+	}
+
+	code := region.String()
+	country = code
+	if name, ok := lookupLocale(config.Locale.CountryNames, code); ok {
+		country = name
+	}
+	if c, ok := lookupLocale(config.Locale.Currencies, code); ok {
+		currencyCode = strings.ToUpper(c)
+	} else if unit, ok := currency.FromRegion(region); ok {
+		currencyCode = unit.String()
+	}
+	if currencyCode != "" && !currencyEnabled(config, currencyCode) {
+		currencyCode = ""
+	}
+	return country, currencyCode
+}
+
+// applyInferredLocale fills in the currency of a new order and the country of
+// its new addresses if the client left them out. Values set by the client
+// always win. It returns the inferred values that were used, so they can be
+// recorded on the order.
+func applyInferredLocale(r *http.Request, config *conf.Configuration, params *orderRequestParams) (country string, currencyCode string) {
+	inferredCountry, inferredCurrency := inferLocale(r, config)
+	if params.Currency == "" {
+		params.Currency = defaultCurrency
+		if inferredCurrency != "" {
+			params.Currency = inferredCurrency
+			currencyCode = inferredCurrency
+		}
+	}
+
+	if inferredCountry != "" {
+		for _, address := range []*models.Address{params.ShippingAddress, params.BillingAddress} {
+			if address != nil && strings.TrimSpace(address.Country) == "" {
+				address.Country = inferredCountry
+				country = inferredCountry
+			}
+		}
+	}
+	return country, currencyCode
+}
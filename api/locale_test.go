@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferLocale(t *testing.T) {
+	config := &conf.Configuration{}
+	config.Locale.Infer = true
+	config.Locale.GeoHeader = "CF-IPCountry"
+
+	infer := func(acceptLanguage, geo string) (string, string) {
+		r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		r.Header.Set("CF-IPCountry", geo)
+		return inferLocale(r, config)
+	}
+
+	country, currency := infer("de-DE,de;q=0.9,en;q=0.8", "")
+	assert.Equal(t, "DE", country)
+	assert.Equal(t, "EUR", currency)
+
+	country, currency = infer("en;q=0.5,fr-CH", "")
+	assert.Equal(t, "CH", country, "the most preferred language with a country wins")
+	assert.Equal(t, "CHF", currency)
+
+	country, currency = infer("de-DE", "gb")
+	assert.Equal(t, "GB", country, "the geolocation header wins")
+	assert.Equal(t, "GBP", currency)
+
+	country, currency = infer("de-DE", "XX")
+	assert.Equal(t, "DE", country, "unknown locations are ignored")
+
+	country, currency = infer("de, es-419", "")
+	assert.Empty(t, country)
+	assert.Empty(t, currency)
+
+	config.Locale.CountryNames = map[string]string{"de": "Germany"}
+	config.Locale.Currencies = map[string]string{"DE": "usd"}
+	country, currency = infer("de-DE", "")
+	assert.Equal(t, "Germany", country)
+	assert.Equal(t, "USD", currency)
+
+	config.Payment.Currencies = []string{"EUR"}
+	country, currency = infer("de-DE", "")
+	assert.Equal(t, "Germany", country)
+	assert.Empty(t, currency, "currencies that aren't supported aren't inferred")
+
+	config.Locale.Infer = false
+	country, currency = infer("de-DE", "")
+	assert.Empty(t, country)
+	assert.Empty(t, currency)
+}
+
+func localePayload(currency string) string {
+	return `{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "Unter den Linden 1",
+			"city": "Berlin", "zip": "10117"
+		},
+		"line_items": [{"path": "/simple-product", "quantity": 1}]` + currency + `
+	}`
+}
+
+func TestOrderCreateInferredLocale(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	routeTest := func(t *testing.T) *RouteTest {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Locale.Infer = true
+		test.Config.Locale.CountryNames = map[string]string{"DE": "Germany"}
+		// the test products are only priced in USD
+		test.Config.Locale.Currencies = map[string]string{"DE": "USD"}
+		return test
+	}
+	header := http.Header{"Accept-Language": []string{"de-DE,de;q=0.9"}}
+
+	t.Run("Inferred", func(t *testing.T) {
+		test := routeTest(t)
+		recorder := test.TestEndpointWithHeaders(http.MethodPost, "/orders", strings.NewReader(localePayload("")), test.Data.testUserToken, header)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "USD", order.Currency)
+		assert.Equal(t, "Germany", order.ShippingAddress.Country)
+		assert.Equal(t, "Germany", order.InferredCountry)
+		assert.Equal(t, "USD", order.InferredCurrency)
+		assert.NotZero(t, order.Taxes, "taxes are calculated for the inferred country")
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, "Germany", saved.InferredCountry)
+		assert.Equal(t, "USD", saved.InferredCurrency)
+	})
+
+	t.Run("Explicit", func(t *testing.T) {
+		test := routeTest(t)
+		recorder := test.TestEndpointWithHeaders(http.MethodPost, "/orders", strings.NewReader(localePayload(`, "currency": "USD"`)), test.Data.testUserToken, header)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, "USD", order.Currency)
+		assert.Empty(t, order.InferredCurrency)
+		assert.Equal(t, "Germany", order.InferredCountry)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		test := routeTest(t)
+		test.Config.Locale.Infer = false
+		recorder := test.TestEndpointWithHeaders(http.MethodPost, "/orders", strings.NewReader(localePayload("")), test.Data.testUserToken, header)
+		validateError(t, http.StatusBadRequest, recorder, "Required field missing: country")
+	})
+}
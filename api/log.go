@@ -10,12 +10,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func newStructuredLogger(logger *logrus.Logger) func(next http.Handler) http.Handler {
-	return chimiddleware.RequestLogger(&structuredLogger{logger})
+func newStructuredLogger(logger *logrus.Logger, nodeID string) func(next http.Handler) http.Handler {
+	return chimiddleware.RequestLogger(&structuredLogger{logger, nodeID})
 }
 
 type structuredLogger struct {
 	Logger *logrus.Logger
+	NodeID string
 }
 
 func (l *structuredLogger) NewLogEntry(r *http.Request) chimiddleware.LogEntry {
@@ -31,6 +32,9 @@ func (l *structuredLogger) NewLogEntry(r *http.Request) chimiddleware.LogEntry {
 	if reqID := gcontext.GetRequestID(r.Context()); reqID != "" {
 		logFields["request_id"] = reqID
 	}
+	if l.NodeID != "" {
+		logFields["node_id"] = l.NodeID
+	}
 
 	entry.Logger = entry.Logger.WithFields(logFields)
 	entry.Logger.Infoln("request started")
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const manualPaymentExpiryPeriod = time.Hour
+
+type manualPaymentConfirmParams struct {
+	// Reference identifies the payment, e.g. the ID of the bank transfer.
+	Reference string `json:"reference"`
+}
+
+// awaitManualPayment puts an order on hold until its manual payment is
+// confirmed. Its inventory stays reserved until the payment is due.
+func awaitManualPayment(tx *gorm.DB, r *http.Request, config *conf.Configuration, order *models.Order, log logrus.FieldLogger) {
+	dueAt := time.Now().AddDate(0, 0, config.Payment.Manual.ExpireAfter)
+	order.PaymentState = models.AwaitingPaymentState
+	order.PaymentDueAt = &dueAt
+	tx.Save(order)
+
+	if err := models.ExtendReservations(tx, order.ID, dueAt); err != nil {
+		log.WithError(err).Error("Failed to extend inventory reservations")
+	}
+	models.LogEvent(tx, r.RemoteAddr, order.UserID, order.ID, models.EventAwaitingPayment, []string{"payment_state"})
+	log.Infof("Order %s is awaiting a manual payment until %v", order.ID, dueAt)
+}
+
+// ManualPaymentConfirm marks an order awaiting a manual payment as paid once
+// the money arrived. Requires admin permissions.
+func (a *API) ManualPaymentConfirm(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	config := gcontext.GetConfig(ctx)
+	claims := gcontext.GetClaims(ctx)
+	log := getLogEntry(r)
+
+	params := &manualPaymentConfirmParams{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+			return badRequestError("Could not read params: %v", err)
+		}
+	}
+
+	tx := a.db.Begin()
+	order := &models.Order{}
+	if rsp := orderQuery(tx).First(order, "id = ?", orderID); rsp.Error != nil {
+		tx.Rollback()
+		if rsp.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+
+	// the state is changed conditionally, so the payment can't be confirmed
+	// twice or after it expired
+	rsp := tx.Model(&models.Order{}).
+		Where("id = ? AND payment_state = ?", order.ID, models.AwaitingPaymentState).
+		UpdateColumn("payment_state", models.PaidState)
+	if rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error confirming payment").WithInternalError(rsp.Error)
+	}
+	if rsp.RowsAffected == 0 {
+		tx.Rollback()
+		return badRequestError("This order isn't awaiting a manual payment")
+	}
+
+	var tr *models.Transaction
+	for _, t := range order.Transactions {
+		if t.Type == models.ChargeTransactionType && t.Status == models.PendingState {
+			tr = t
+		}
+	}
+	if tr == nil {
+		tx.Rollback()
+		return internalServerError("The pending payment of order %s is missing", order.ID)
+	}
+	tr.Status = models.PaidState
+	tr.ProcessorID = params.Reference
+	rsp = tx.Model(tr).Updates(map[string]interface{}{"status": tr.Status, "processor_id": tr.ProcessorID})
+	if rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error confirming payment").WithInternalError(rsp.Error)
+	}
+
+	a.completePayment(tx, r, config, order, tr, log)
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventPaymentConfirmed, []string{"payment_state"})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error confirming payment").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Confirmed the manual payment of order %s", order.ID)
+	a.issuePaidLicenses(config, order, log)
+	// set after saving, the transaction is among the order's own transactions
+	tr.Order = order
+	a.sendPaymentMails(gcontext.GetMailer(ctx), tr, config, log)
+	return sendJSON(w, http.StatusOK, presentTransaction(r, tr))
+}
+
+// expireManualPayments cancels the orders of an instance whose manual payment
+// wasn't confirmed before it was due. Their payment fails and the reserved
+// inventory is released once the reservations expire.
+func expireManualPayments(db *gorm.DB, instanceID string, log logrus.FieldLogger) error {
+	ids := []string{}
+	rsp := db.Model(&models.Order{}).
+		Where("instance_id = ? AND payment_state = ? AND payment_due_at < ?", instanceID, models.AwaitingPaymentState, time.Now()).
+		Pluck("id", &ids)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for overdue orders")
+	}
+
+	expired := 0
+	for _, id := range ids {
+		tx := db.Begin()
+		rsp := tx.Model(&models.Order{}).
+			Where("id = ? AND payment_state = ?", id, models.AwaitingPaymentState).
+			UpdateColumn("payment_state", models.FailedState)
+		if rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error cancelling order")
+		}
+		if rsp.RowsAffected == 0 {
+			// confirmed in the meantime
+			tx.Rollback()
+			continue
+		}
+		rsp = tx.Model(&models.Transaction{}).
+			Where("order_id = ? AND type = ? AND status = ?", id, models.ChargeTransactionType, models.PendingState).
+			Updates(map[string]interface{}{
+				"status":              models.FailedState,
+				"failure_description": "The payment wasn't received in time",
+			})
+		if rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error cancelling payment")
+		}
+		order := &models.Order{}
+		if rsp := tx.Select("coupon_code").First(order, "id = ?", id); rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error querying for order")
+		}
+		if order.CouponCode != "" {
+			if err := models.ReleaseCoupon(tx, instanceID, order.CouponCode); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		models.LogEvent(tx, "", "", id, models.EventPaymentExpired, []string{"payment_state"})
+		if rsp := tx.Commit(); rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error cancelling order")
+		}
+		expired++
+	}
+	if expired > 0 {
+		log.Infof("Cancelled %d orders whose manual payment wasn't received", expired)
+	}
+	return nil
+}
+
+// RunManualPaymentExpiry creates a goroutine that cancels the orders whose
+// manual payment is overdue every hour. Without a config, as in multi
+// instance mode, all instances are handled.
+func RunManualPaymentExpiry(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry) {
+	go func() {
+		for {
+			forEachInstance(db, globalConfig, config, log, func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) {
+				if err := expireManualPayments(db, instanceID, log); err != nil {
+					log.WithError(err).Error("Error cancelling overdue manual payments")
+				}
+			})
+			time.Sleep(manualPaymentExpiryPeriod)
+		}
+	}()
+}
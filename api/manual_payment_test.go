@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func manualPaymentRouteTest(t *testing.T) *RouteTest {
+	test := NewRouteTest(t)
+	test.Config.Payment.Manual.Enabled = true
+	test.Config.Payment.Manual.Instructions = "Transfer the amount to DE00 1234 with the reference {invoice_number}"
+	test.Config.Payment.Manual.ExpireAfter = 3
+	test.Data.firstOrder.PaymentState = models.PendingState
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	return test
+}
+
+func createManualPayment(t *testing.T, test *RouteTest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(&stripePaymentParams{
+		Amount:   test.Data.firstOrder.Total,
+		Currency: test.Data.firstOrder.Currency,
+		Provider: payments.ManualProvider,
+	})
+	require.NoError(t, err)
+	return test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+}
+
+func TestManualPayment(t *testing.T) {
+	t.Run("AwaitingPayment", func(t *testing.T) {
+		test := manualPaymentRouteTest(t)
+		recorder := createManualPayment(t, test)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+		assert.Equal(t, models.PendingState, tr.Status)
+		assert.Regexp(t, `^Transfer the amount to DE00 1234 with the reference \d+$`, tr.Instructions)
+		assert.NotContains(t, tr.Instructions, "{invoice_number}")
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.AwaitingPaymentState, order.PaymentState)
+		assert.Equal(t, payments.ManualProvider, order.PaymentProcessor)
+		require.NotNil(t, order.PaymentDueAt)
+		assert.WithinDuration(t, time.Now().AddDate(0, 0, 3), *order.PaymentDueAt, time.Minute)
+		assert.Nil(t, order.PaidAt)
+
+		recorder = createManualPayment(t, test)
+		validateError(t, http.StatusBadRequest, recorder, "already awaiting a manual payment")
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		test := manualPaymentRouteTest(t)
+		recorder := createManualPayment(t, test)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		body := strings.NewReader(`{"reference": "transfer-42"}`)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/payments/manual/confirm", body, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body = strings.NewReader(`{"reference": "transfer-42"}`)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/payments/manual/confirm", body, token)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+		assert.Equal(t, models.PaidState, tr.Status)
+		assert.Equal(t, "transfer-42", tr.ProcessorID)
+
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.PaidState, order.PaymentState)
+		assert.NotNil(t, order.PaidAt)
+		assert.Nil(t, order.PaymentDueAt)
+
+		saved := &models.Transaction{}
+		require.NoError(t, test.DB.First(saved, "id = ?", tr.ID).Error)
+		assert.Equal(t, models.PaidState, saved.Status)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/payments/manual/confirm", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "isn't awaiting a manual payment")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		test := manualPaymentRouteTest(t)
+		coupon := &models.GeneratedCoupon{Code: "SINGLE-USE", Template: &models.Coupon{Percentage: 10}, MaxRedemptions: 1}
+		require.NoError(t, test.DB.Create(coupon).Error)
+		test.Data.firstOrder.CouponCode = coupon.Code
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		recorder := createManualPayment(t, test)
+		tr := &models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, tr)
+		require.NoError(t, test.DB.First(coupon, coupon.ID).Error)
+		assert.Equal(t, uint64(1), coupon.Redemptions)
+
+		require.NoError(t, expireManualPayments(test.DB, "", testLogger))
+		order := &models.Order{}
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.AwaitingPaymentState, order.PaymentState, "the payment isn't due yet")
+
+		require.NoError(t, test.DB.Model(order).UpdateColumn("payment_due_at", time.Now().Add(-time.Minute)).Error)
+		require.NoError(t, expireManualPayments(test.DB, "", testLogger))
+		require.NoError(t, test.DB.First(order, "id = ?", "first-order").Error)
+		assert.Equal(t, models.FailedState, order.PaymentState)
+
+		saved := &models.Transaction{}
+		require.NoError(t, test.DB.First(saved, "id = ?", tr.ID).Error)
+		assert.Equal(t, models.FailedState, saved.Status)
+		assert.NotEmpty(t, saved.FailureDescription)
+		require.NoError(t, test.DB.First(coupon, coupon.ID).Error)
+		assert.Equal(t, uint64(0), coupon.Redemptions, "the coupon can be used again")
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/payments/manual/confirm", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "isn't awaiting a manual payment")
+	})
+}
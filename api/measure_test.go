@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func measureOrderBody(lineItem string) string {
+	return fmt.Sprintf(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [%s]
+	}`, lineItem)
+}
+
+func TestOrderCreateMeasure(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	t.Run("Priced", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := measureOrderBody(`{"path": "/measured-product", "quantity": 2, "measure": 1.5}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		require.Len(t, order.LineItems, 1)
+		item := order.LineItems[0]
+		assert.Equal(t, 1.5, item.Measure)
+		assert.Equal(t, "kg", item.MeasureUnit)
+		assert.EqualValues(t, 2499, item.UnitPrice)
+		// 24.99 x 1.5 = 37.485, rounded to the nearest cent
+		assert.EqualValues(t, 3749, item.Price)
+		assert.EqualValues(t, 7498, order.SubTotal)
+
+		saved := &models.Order{}
+		require.NoError(t, orderQuery(test.DB).First(saved, "id = ?", order.ID).Error)
+		require.Len(t, saved.LineItems, 1)
+		assert.Equal(t, 1.5, saved.LineItems[0].Measure)
+		assert.Equal(t, "kg", saved.LineItems[0].MeasureUnit)
+	})
+
+	cases := []struct {
+		name     string
+		lineItem string
+		message  string
+	}{
+		{"TooPrecise", `{"path": "/measured-product", "quantity": 1, "measure": 1.555}`, "The measure of product coffee-1 can have at most 2 decimals"},
+		{"MeasureMissing", `{"path": "/measured-product", "quantity": 1}`, "Product coffee-1 is sold by kg, a measure is required"},
+		{"NotSoldByMeasure", `{"path": "/simple-product", "quantity": 1, "measure": 2}`, "Product product-1 isn't sold by measure"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			test := NewRouteTest(t)
+			test.Config.SiteURL = server.URL
+			recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(measureOrderBody(c.lineItem)), test.Data.testUserToken)
+			validateError(t, http.StatusUnprocessableEntity, recorder, c.message)
+		})
+	}
+
+	t.Run("Negative", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := measureOrderBody(`{"path": "/measured-product", "quantity": 1, "measure": -1}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "Measure can't be negative")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Invalid order parameters")
+	})
+}
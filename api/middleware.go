@@ -8,11 +8,14 @@ import (
 	"net/http"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/netlify/gocommerce/addressverifiers"
 	"github.com/netlify/gocommerce/assetstores"
 	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/eligibility"
 	"github.com/netlify/gocommerce/mailer"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/pkg/errors"
 )
 
@@ -43,6 +46,29 @@ func addGetBody(w http.ResponseWriter, req *http.Request) (context.Context, erro
 	return req.Context(), nil
 }
 
+// limitBody rejects request bodies larger than the configured maximum with a
+// 413 before any handler starts parsing them.
+func limitBody(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	config := gcontext.GetConfig(req.Context())
+	max := config.Limits.MaxBodySize
+	if max <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return req.Context(), nil
+	}
+	if req.ContentLength > max {
+		return nil, httpError(http.StatusRequestEntityTooLarge, "Request body exceeds the limit of %d bytes", max)
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		return nil, internalServerError("Error reading body").WithInternalError(err)
+	}
+	if int64(len(buf)) > max {
+		return nil, httpError(http.StatusRequestEntityTooLarge, "Request body exceeds the limit of %d bytes", max)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return req.Context(), nil
+}
+
 func (api *API) verifyOperatorRequest(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 	c, _, err := api.extractOperatorRequest(w, req)
 	return c, err
@@ -100,7 +126,7 @@ func (api *API) loadInstanceConfig(w http.ResponseWriter, r *http.Request) (cont
 	}
 	logEntrySetField(r, "site_url", config.SiteURL)
 
-	ctx, err = WithInstanceConfig(ctx, api.config.SMTP, config, instanceID)
+	ctx, err = WithInstanceConfig(ctx, api.config, config, instanceID)
 	if err != nil {
 		return nil, internalServerError("Error loading instance config").WithInternalError(err)
 	}
@@ -108,23 +134,37 @@ func (api *API) loadInstanceConfig(w http.ResponseWriter, r *http.Request) (cont
 	return ctx, nil
 }
 
-func WithInstanceConfig(ctx context.Context, smtp conf.SMTPConfiguration, config *conf.Configuration, instanceID string) (context.Context, error) {
+func WithInstanceConfig(ctx context.Context, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, instanceID string) (context.Context, error) {
+	guard := ssrf.NewGuard(globalConfig.Outbound.AllowedHosts)
+
 	ctx = gcontext.WithInstanceID(ctx, instanceID)
 	ctx = gcontext.WithConfig(ctx, config)
-	ctx, err := gcontext.WithCoupons(ctx, config)
+	ctx, err := gcontext.WithCoupons(ctx, config, guard)
 	if err != nil {
 		return nil, err
 	}
 
-	mailer := mailer.NewMailer(smtp, config)
+	mailer := mailer.NewMailer(globalConfig.SMTP, config)
 	ctx = gcontext.WithMailer(ctx, mailer)
 
-	store, err := assetstores.NewStore(config)
+	store, err := assetstores.NewStore(config, guard)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error initializing asset store")
 	}
 	ctx = gcontext.WithAssetStore(ctx, store)
 
+	verifier, err := addressverifiers.NewVerifier(config, guard)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error initializing address verifier")
+	}
+	ctx = gcontext.WithAddressVerifier(ctx, verifier)
+
+	checker, err := eligibility.NewChecker(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error initializing eligibility checker")
+	}
+	ctx = gcontext.WithEligibilityChecker(ctx, checker)
+
 	provs, err := createPaymentProviders(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating payment providers")
@@ -132,6 +172,12 @@ func WithInstanceConfig(ctx context.Context, smtp conf.SMTPConfiguration, config
 	if len(provs) == 0 {
 		return nil, errors.New("No payment providers enabled")
 	}
+	if err := validatePaymentRoutes(config, provs); err != nil {
+		return nil, errors.Wrap(err, "error routing payment providers")
+	}
+	if err := validateWebhookCategories(config); err != nil {
+		return nil, errors.Wrap(err, "error configuring webhooks")
+	}
 	ctx = gcontext.WithPaymentProviders(ctx, provs)
 
 	return ctx, nil
@@ -1,11 +1,15 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-chi/chi"
@@ -13,21 +17,51 @@ import (
 	"github.com/mattes/vat"
 	"github.com/netlify/gocommerce/calculator"
 	"github.com/netlify/gocommerce/claims"
+	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
 	"github.com/pborman/uuid"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/text/currency"
 )
 
 // MaxConcurrentLookups controls the number of simultaneous HTTP Order lookups
 const MaxConcurrentLookups = 10
 
 type orderLineItem struct {
+	ID       int64                  `json:"id"`
 	Sku      string                 `json:"sku"`
 	Path     string                 `json:"path"`
 	Quantity uint64                 `json:"quantity"`
+	Measure  float64                `json:"measure"`
 	Addons   []orderAddon           `json:"addons"`
 	MetaData map[string]interface{} `json:"meta"`
+
+	// the quantity and price as sent, checked by validateOrderParams
+	rawQuantity json.RawMessage
+	rawPrice    json.RawMessage
+}
+
+// UnmarshalJSON reads a line item. Malformed quantities and prices are kept
+// as sent instead of failing, so they can be reported per item.
+func (i *orderLineItem) UnmarshalJSON(data []byte) error {
+	type plainLineItem orderLineItem
+	raw := &struct {
+		*plainLineItem
+		Quantity json.RawMessage `json:"quantity"`
+		Price    json.RawMessage `json:"price"`
+	}{plainLineItem: (*plainLineItem)(i)}
+	if err := json.Unmarshal(data, raw); err != nil {
+		return err
+	}
+	i.rawQuantity = raw.Quantity
+	i.rawPrice = raw.Price
+	if quantity, err := parseJSONNumber(raw.Quantity); err == nil {
+		if n, err := quantity.Int64(); err == nil && n > 0 {
+			i.Quantity = uint64(n)
+		}
+	}
+	return nil
 }
 
 type orderAddon struct {
@@ -58,6 +92,26 @@ type orderRequestParams struct {
 	FulfillmentState string `json:"fulfillment_state"`
 
 	CouponCode string `json:"coupon"`
+
+	Tip uint64 `json:"tip"`
+
+	Attribution *models.Attribution `json:"attribution"`
+
+	Consent *consentParams `json:"consent"`
+
+	// Birthdate is only used to check the eligibility to purchase the line
+	// items, formatted as YYYY-MM-DD.
+	Birthdate string `json:"birthdate"`
+}
+
+type consentParams struct {
+	TermsVersion    string `json:"terms_version"`
+	DigitalDelivery bool   `json:"digital_delivery"`
+}
+
+type orderTaxOverrideParams struct {
+	Taxes  *uint64 `json:"taxes"`
+	Reason string  `json:"reason"`
 }
 
 type receiptParams struct {
@@ -230,7 +284,7 @@ func (a *API) ResendOrderReceipt(w http.ResponseWriter, r *http.Request) error {
 // OrderList can query based on
 //  - orders since        &from=iso8601      - default = 0
 //  - orders before       &to=iso8601        - default = now
-//  - sort asc or desc    &sort=[asc | desc] - default = desc
+//  - sort by a field     &sort=total+asc    - default = created_at desc
 // And you can filter on
 //  - fullfilment_state=pending   - only orders pending shipping
 //  - payment_state=pending       - only paid orders
@@ -238,6 +292,45 @@ func (a *API) ResendOrderReceipt(w http.ResponseWriter, r *http.Request) error {
 //  - email
 //  - items
 
+type orderSortField struct {
+	column string
+	value  func(order *models.Order) string
+	parse  func(value string) (interface{}, error)
+}
+
+var orderSortFields = map[string]orderSortField{
+	"created_at": {
+		column: "created_at",
+		value:  func(o *models.Order) string { return formatCursorTime(o.CreatedAt) },
+		parse:  parseCursorTime,
+	},
+	"updated_at": {
+		column: "updated_at",
+		value:  func(o *models.Order) string { return formatCursorTime(o.UpdatedAt) },
+		parse:  parseCursorTime,
+	},
+	"email": {
+		column: "email",
+		value:  func(o *models.Order) string { return o.Email },
+		parse:  parseCursorString,
+	},
+	"taxes": {
+		column: "taxes",
+		value:  func(o *models.Order) string { return strconv.FormatUint(o.Taxes, 10) },
+		parse:  parseCursorNumber,
+	},
+	"subtotal": {
+		column: "sub_total",
+		value:  func(o *models.Order) string { return strconv.FormatUint(o.SubTotal, 10) },
+		parse:  parseCursorNumber,
+	},
+	"total": {
+		column: "total",
+		value:  func(o *models.Order) string { return strconv.FormatUint(o.Total, 10) },
+		parse:  parseCursorNumber,
+	},
+}
+
 // OrderList lists orders selected by the query parameters provided.
 func (a *API) OrderList(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
@@ -245,38 +338,66 @@ func (a *API) OrderList(w http.ResponseWriter, r *http.Request) error {
 	claims := gcontext.GetClaims(ctx)
 	instanceID := gcontext.GetInstanceID(ctx)
 
-	var err error
 	params := r.URL.Query()
+	page, httpErr := parsePagination(params)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	sortName, dir, err := parseSort(params.Get("sort"), ascending)
+	if err != nil {
+		return err
+	}
+	if sortName == "" {
+		sortName, dir = "created_at", descending
+	}
+	field, ok := orderSortFields[sortName]
+	if !ok {
+		return badRequestError("Bad field for sort '%v'", sortName)
+	}
+
 	query := orderQuery(a.db)
 	query, err = parseOrderParams(query, params)
 	if err != nil {
 		return badRequestError("Bad parameters in query: %v", err)
 	}
-	query = query.Where("instance_id = ?", instanceID)
+	orderTable := query.NewScope(models.Order{}).QuotedTableName()
+	query = query.Where(orderTable+".instance_id = ?", instanceID)
 
 	userID := gcontext.GetUserID(ctx)
 	if userID == "" {
 		userID = claims.Subject
 	}
 	if userID != "all" {
-		orderTable := query.NewScope(models.Order{}).QuotedTableName()
 		query = query.Where(orderTable+".user_id = ?", userID)
 	}
 	log.WithField("query_user_id", userID).Debug("URL parsed and query perpared")
 
-	offset, limit, err := paginate(w, r, query.Model(&models.Order{}))
-	if err != nil {
-		return badRequestError("Bad Pagination Parameters: %v", err)
+	query, httpErr = paginate(query, page, &sortKey{
+		expression: orderTable + "." + field.column,
+		idColumn:   orderTable + ".id",
+		dir:        dir,
+		parse:      field.parse,
+	})
+	if httpErr != nil {
+		return httpErr
 	}
 
 	var orders []models.Order
-	result := query.Offset(offset).Limit(limit).Find(&orders)
+	result := query.Find(&orders)
 	if result.Error != nil {
 		return internalServerError("Error during database query").WithInternalError(result.Error)
 	}
 
+	next := ""
+	if page.hasMore(len(orders)) {
+		orders = orders[:page.Limit]
+		last := &orders[len(orders)-1]
+		next = encodeCursor(&pageCursor{Value: field.value(last), ID: last.ID})
+	}
+
 	log.WithField("order_count", len(orders)).Debugf("Successfully retrieved %d orders", len(orders))
-	return sendJSON(w, http.StatusOK, orders)
+	return sendJSON(w, http.StatusOK, &listPage{Items: presentOrders(r, orders), NextCursor: next})
 }
 
 // OrderView will request a specific order using the 'id' parameter.
@@ -299,32 +420,62 @@ func (a *API) OrderView(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	log.Debugf("Successfully got order %s", order.ID)
-	return sendJSON(w, http.StatusOK, order)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
 }
 
 // OrderCreate endpoint
 func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
-	ctx := r.Context()
-	config := gcontext.GetConfig(ctx)
-	instanceID := gcontext.GetInstanceID(ctx)
-
-	params := &orderRequestParams{Currency: "USD"}
+	params := &orderRequestParams{}
 	jsonDecoder := json.NewDecoder(r.Body)
 	err := jsonDecoder.Decode(params)
 	if err != nil {
 		return badRequestError("Could not read Order params: %v", err)
 	}
+	if httpError := checkLineItemCount(r, params); httpError != nil {
+		return httpError
+	}
+	if httpError := validateOrderParams(params, false); httpError != nil {
+		return httpError
+	}
+
+	order, err := a.createOrder(w, r, params)
+	if err != nil {
+		return err
+	}
+	return sendJSON(w, http.StatusCreated, presentOrder(r, order))
+}
+
+// createOrder creates a pending order from the request parameters. Line items
+// are priced with the current product data of the site.
+func (a *API) createOrder(w http.ResponseWriter, r *http.Request, params *orderRequestParams) (*models.Order, error) {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	instanceID := gcontext.GetInstanceID(ctx)
 
 	claims := gcontext.GetClaims(ctx)
+	inferredCountry, inferredCurrency := applyInferredLocale(r, config, params)
+	if !currencyEnabled(config, params.Currency) {
+		return nil, badRequestError("Orders in %s are not supported", params.Currency)
+	}
 	order := models.NewOrder(instanceID, params.SessionID, params.Email, params.Currency)
+	order.WeightUnit = config.Weight.Unit
+	order.InferredCountry = inferredCountry
+	order.InferredCurrency = inferredCurrency
+
+	if params.Attribution != nil {
+		if err := params.Attribution.Validate(); err != nil {
+			return nil, badRequestError("%v", err)
+		}
+		order.Attribution = *params.Attribution
+	}
 
 	if params.CouponCode != "" {
 		coupon, err := a.lookupCoupon(ctx, w, params.CouponCode)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !coupon.Valid() {
-			return badRequestError("This coupon is not valid at this time")
+			return nil, badRequestError("This coupon is not valid at this time")
 		}
 
 		order.CouponCode = coupon.Code
@@ -341,25 +492,33 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 	}).Debug("Created order, starting to process request")
 	tx := a.db.Begin()
 
-	order.IP = r.RemoteAddr
+	order.IP = clientIP(r)
+	order.UserAgent = clientUserAgent(r)
+	recordConsent(order, params.Consent)
 	order.MetaData = params.MetaData
+	order.Tip = params.Tip
 	httpError := setOrderEmail(tx, order, claims, log)
 	if httpError != nil {
 		log.WithError(httpError).Info("Failed to set the order email from the token")
 		tx.Rollback()
-		return httpError
+		return nil, httpError
 	}
 
 	log.WithField("order_user_id", order.UserID).Debug("Successfully set the order's ID")
 
+	if httpError := checkPendingOrders(r, tx, config, order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
 	shipping, httpError := a.processAddress(tx, order, "Shipping Address", params.ShippingAddress, params.ShippingAddressID)
 	if httpError != nil {
 		tx.Rollback()
-		return httpError
+		return nil, httpError
 	}
 	if shipping == nil {
 		tx.Rollback()
-		return badRequestError("Shipping Address Required")
+		return nil, badRequestError("Shipping Address Required")
 	}
 	order.ShippingAddress = *shipping
 	order.ShippingAddressID = shipping.ID
@@ -367,7 +526,7 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 	billing, httpError := a.processAddress(tx, order, "Billing Address", params.BillingAddress, params.BillingAddressID)
 	if httpError != nil {
 		tx.Rollback()
-		return httpError
+		return nil, httpError
 	}
 	if billing != nil {
 		order.BillingAddress = *billing
@@ -377,20 +536,37 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 		order.BillingAddressID = shipping.ID
 	}
 
+	if httpError := checkSalesCountries(r, config, order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
+	if httpError := verifyShippingAddress(r, order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
 	if httpError := persistUserName(tx, order, claims); httpError != nil {
 		tx.Rollback()
-		return httpError
+		return nil, httpError
 	}
 
 	if params.VATNumber != "" {
-		valid, err := vat.IsValidVAT(params.VATNumber)
+		country, err := models.ParseTaxID(params.VATNumber)
 		if err != nil {
 			tx.Rollback()
-			return internalServerError("Error verifying VAT number").WithInternalError(err)
+			return nil, badRequestError("Vat number %v is not valid: %v", params.VATNumber, err)
 		}
-		if !valid {
-			tx.Rollback()
-			return badRequestError("Vat number %v is not valid", order.VATNumber)
+		if models.VIESTaxID(country) {
+			valid, err := vat.IsValidVAT(params.VATNumber)
+			if err != nil {
+				tx.Rollback()
+				return nil, internalServerError("Error verifying VAT number").WithInternalError(err)
+			}
+			if !valid {
+				tx.Rollback()
+				return nil, badRequestError("Vat number %v is not valid", params.VATNumber)
+			}
 		}
 		order.VATNumber = params.VATNumber
 	}
@@ -398,24 +574,63 @@ func (a *API) OrderCreate(w http.ResponseWriter, r *http.Request) error {
 	if httpError := a.createLineItems(ctx, tx, order, params.LineItems, log); httpError != nil {
 		log.WithError(httpError).Error("Failed to create order line items")
 		tx.Rollback()
-		return httpError
+		return nil, httpError
 	}
 
 	log.WithField("subtotal", order.SubTotal).Debug("Successfully processed all the line items")
 
-	tx.Create(order)
-	models.LogEvent(tx, r.RemoteAddr, order.UserID, order.ID, models.EventCreated, nil)
-	if config.Webhooks.Order != "" {
-		hook, err := models.NewHook("order", config.SiteURL, config.Webhooks.Order, order.UserID, config.Webhooks.Secret, order)
-		if err != nil {
-			log.WithError(err).Error("Failed to process webhook")
+	if httpError := validateQuantityLimits(tx, config, order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
+	if httpError := validateMeasures(order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
+	if httpError := validateConsent(config, order); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
+	if httpError := checkEligibility(r, order, params.Birthdate); httpError != nil {
+		tx.Rollback()
+		return nil, httpError
+	}
+
+	if order.CouponCode != "" {
+		if err := models.CheckCouponRedemptions(tx, instanceID, order.CouponCode); err != nil {
+			tx.Rollback()
+			if redeemed, ok := err.(*models.CouponRedeemedError); ok {
+				return nil, badRequestError("Coupon %s has already been redeemed", redeemed.Code)
+			}
+			return nil, internalServerError("Error querying coupons").WithInternalError(err)
+		}
+	}
+
+	if config.Inventory.Enabled {
+		expiresAt := time.Now().Add(time.Duration(config.Inventory.ReservationTimeout) * time.Second)
+		if err := models.ReserveInventory(tx, order, expiresAt, config.Inventory.AllowBackorders); err != nil {
+			tx.Rollback()
+			if outOfStock, ok := err.(*models.OutOfStockError); ok {
+				return nil, badRequestError("Product %s is out of stock", outOfStock.Sku)
+			}
+			return nil, internalServerError("Error reserving inventory").WithInternalError(err)
 		}
-		tx.Save(hook)
 	}
+
+	tx.Create(order)
+	models.LogClientEvent(tx, order.IP, order.UserAgent, order.UserID, order.ID, models.EventCreated, nil)
+	enqueueWebhook(tx, config, "order", order.UserID, order.ID, order, log)
 	tx.Commit()
 
+	if config.Claims.Enabled && order.UserID == "" {
+		sendOrderClaimMail(ctx, order, log)
+	}
+
 	log.Infof("Successfully created order %s", order.ID)
-	return sendJSON(w, http.StatusCreated, order)
+	return order, nil
 }
 
 // OrderUpdate will allow an ADMIN only to update the details of a record
@@ -437,6 +652,12 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return badRequestError("Could not read Order Parameters: %v", err)
 	}
+	if httpError := checkLineItemCount(r, orderParams); httpError != nil {
+		return httpError
+	}
+	if httpError := validateOrderParams(orderParams, true); httpError != nil {
+		return httpError
+	}
 
 	// verify that the order exists
 	existingOrder := new(models.Order)
@@ -473,6 +694,9 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 		if alreadyPaid {
 			return badRequestError("Can't update the currency after payment has been processed")
 		}
+		if !currencyEnabled(config, orderParams.Currency) {
+			return badRequestError("Orders in %s are not supported", orderParams.Currency)
+		}
 		log.Debugf("Updating currency from '%v' to '%v'", existingOrder.Currency, orderParams.Currency)
 		existingOrder.Currency = orderParams.Currency
 		changes = append(changes, "currency")
@@ -481,6 +705,9 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 		if alreadyPaid {
 			return badRequestError("Can't update the VAT number after payment has been processed")
 		}
+		if _, err := models.ParseTaxID(orderParams.VATNumber); err != nil {
+			return badRequestError("Vat number %v is not valid: %v", orderParams.VATNumber, err)
+		}
 
 		log.Debugf("Updating vat number from '%v' to '%v'", existingOrder.VATNumber, orderParams.VATNumber)
 		existingOrder.VATNumber = orderParams.VATNumber
@@ -541,6 +768,14 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 			tx.Rollback()
 			return badRequestError("Bad fulfillment state: " + orderParams.FulfillmentState)
 		}
+		if orderParams.FulfillmentState == models.FulfilledState {
+			for _, item := range existingOrder.LineItems {
+				if item.Outstanding() {
+					tx.Rollback()
+					return badRequestError("Can't mark the order as fulfilled before all of its line items are")
+				}
+			}
+		}
 		existingOrder.FulfillmentState = orderParams.FulfillmentState
 		changes = append(changes, "fulfillment_state")
 	}
@@ -548,21 +783,66 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	//
 	// handle the line items
 	//
-	updatedItems := make(map[string]*orderLineItem)
+	// line items are matched by their ID, since several lines can share a SKU.
+	// Updates without an ID apply to all lines with the SKU.
+	updatedItemsByID := make(map[int64]*orderLineItem)
+	updatedItemsBySku := make(map[string]*orderLineItem)
 	for _, item := range orderParams.LineItems {
-		updatedItems[item.Sku] = item
+		if item.ID != 0 {
+			updatedItemsByID[item.ID] = item
+		} else {
+			updatedItemsBySku[item.Sku] = item
+		}
 	}
 
+	// the stock held for the order is resized to the new quantities
+	previousQuantities := make(map[string]uint64)
+	quantities := make(map[string]uint64)
 	for _, item := range existingOrder.LineItems {
-		if update, exists := updatedItems[item.Sku]; exists {
+		// free items come and go with the items qualifying for them
+		if item.Free {
+			continue
+		}
+		previousQuantities[item.Sku] += item.Quantity
+		update, exists := updatedItemsByID[item.ID]
+		if !exists {
+			update, exists = updatedItemsBySku[item.Sku]
+		}
+		if exists {
 			item.Quantity = update.Quantity
 			if update.Path != "" {
 				item.Path = update.Path
 			}
+			if update.MetaData != nil {
+				item.MetaData = update.MetaData
+			}
 		}
+		quantities[item.Sku] += item.Quantity
 	}
 
-	if len(updatedItems) > 0 {
+	if len(orderParams.LineItems) > 0 {
+		if httpError := validateQuantityLimits(tx, config, existingOrder); httpError != nil {
+			tx.Rollback()
+			return httpError
+		}
+		if config.Inventory.Enabled && !alreadyPaid {
+			for sku, quantity := range quantities {
+				if err := models.ResizeReservations(tx, existingOrder, sku, previousQuantities[sku], quantity, config.Inventory.AllowBackorders); err != nil {
+					tx.Rollback()
+					if outOfStock, ok := err.(*models.OutOfStockError); ok {
+						return badRequestError("Product %s is out of stock", outOfStock.Sku)
+					}
+					return internalServerError("Error updating inventory reservations").WithInternalError(err)
+				}
+			}
+		}
+		if err := a.updateFreeProduct(ctx, tx, config, existingOrder); err != nil {
+			tx.Rollback()
+			if outOfStock, ok := err.(*models.OutOfStockError); ok {
+				return badRequestError("Product %s is out of stock", outOfStock.Sku)
+			}
+			return internalServerError("Error updating free product").WithInternalError(err)
+		}
 		changes = append(changes, "line_items")
 	}
 
@@ -573,20 +853,121 @@ func (a *API) OrderUpdate(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	models.LogEvent(tx, r.RemoteAddr, claims.Subject, existingOrder.ID, models.EventUpdated, changes)
-	if config.Webhooks.Update != "" {
-		// TODO should this be claims.Subject or existingOrder.UserID ?
-		hook, err := models.NewHook("update", config.SiteURL, config.Webhooks.Update, claims.Subject, config.Webhooks.Secret, existingOrder)
-		if err != nil {
-			log.WithError(err).Error("Failed to process web hook")
-		}
-		tx.Save(hook)
-	}
+	// TODO should this be claims.Subject or existingOrder.UserID ?
+	enqueueWebhook(tx, config, "update", claims.Subject, existingOrder.ID, existingOrder, log)
 	if rsp := tx.Commit(); rsp.Error != nil {
 		tx.Rollback()
 		return internalServerError("Error committing order updates").WithInternalError(rsp.Error)
 	}
 
-	return sendJSON(w, http.StatusOK, existingOrder)
+	return sendJSON(w, http.StatusOK, presentOrder(r, existingOrder))
+}
+
+// OrderTaxOverride allows an ADMIN to set an explicit tax amount on an order.
+// The override is kept when the order total is recalculated.
+func (a *API) OrderTaxOverride(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	params := new(orderTaxOverrideParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read tax override parameters: %v", err)
+	}
+	if params.Taxes == nil {
+		return badRequestError("Overriding taxes requires a 'taxes' amount")
+	}
+	if strings.TrimSpace(params.Reason) == "" {
+		return badRequestError("Overriding taxes requires a 'reason'")
+	}
+
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if order.PaymentState == models.PaidState {
+		return badRequestError("Taxes can't be overridden once the order has been paid")
+	}
+
+	log.WithFields(logrus.Fields{
+		"old_taxes": order.Taxes,
+		"new_taxes": *params.Taxes,
+	}).Infof("Overriding taxes of order %s", order.ID)
+	order.OverrideTaxes(*params.Taxes, params.Reason)
+
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving tax override").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventTaxOverridden, []string{
+		fmt.Sprintf("taxes=%d", *params.Taxes),
+		"reason=" + params.Reason,
+	})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error committing tax override").WithInternalError(rsp.Error)
+	}
+
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
+
+// OrderApprove releases an order held for review, so it can be fulfilled. Its
+// digital items are delivered and its licenses issued right away.
+func (a *API) OrderApprove(w http.ResponseWriter, r *http.Request) error {
+	return a.reviewOrder(w, r, models.PendingState, models.EventApproved, "order.approved")
+}
+
+// OrderReject rejects an order held for review.
+func (a *API) OrderReject(w http.ResponseWriter, r *http.Request) error {
+	return a.reviewOrder(w, r, models.RejectedState, models.EventRejected, "order.rejected")
+}
+
+func (a *API) reviewOrder(w http.ResponseWriter, r *http.Request, fulfillmentState string, eventType models.EventType, webhookType string) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+	config := gcontext.GetConfig(ctx)
+
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+
+	if order.FulfillmentState != models.OnHoldState {
+		return badRequestError("Only orders on hold can be reviewed")
+	}
+
+	order.FulfillmentState = fulfillmentState
+	fulfilled := fulfillmentState == models.PendingState && order.FulfillDigitalItems()
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving order review").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, eventType, []string{"fulfillment_state"})
+	if fulfilled {
+		models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventFulfilled, []string{"fulfillment_state"})
+	}
+	enqueueWebhook(tx, config, webhookType, claims.Subject, order.ID, order, log)
+	if rsp := tx.Commit(); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error committing order review").WithInternalError(rsp.Error)
+	}
+
+	a.issuePaidLicenses(config, order, log)
+	log.Infof("Order %s was %s", order.ID, eventType)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
 }
 
 // An order's email is determined by a few things. The rules guiding it are:
@@ -630,6 +1011,30 @@ func setOrderEmail(tx *gorm.DB, order *models.Order, claims *claims.JWTClaims, l
 }
 
 func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Order, items []*orderLineItem, log logrus.FieldLogger) *HTTPError {
+	if httpError := a.lookupLineItems(ctx, order, items); httpError != nil {
+		return httpError
+	}
+
+	for _, item := range order.LineItems {
+		order.SubTotal = order.SubTotal + (item.Price+item.AddonPrice)*item.Quantity
+		if err := tx.Save(&item).Error; err != nil {
+			return internalServerError("Error creating line item").WithInternalError(err)
+		}
+		item.IssueDownloads(order)
+	}
+
+	for _, download := range order.Downloads {
+		if err := tx.Create(&download).Error; err != nil {
+			return internalServerError("Error creating download item").WithInternalError(err)
+		}
+	}
+
+	return a.calculateOrderTotal(ctx, order, log)
+}
+
+// lookupLineItems adds the requested line items to an order, priced with the
+// product data of the site, along with the free product of its coupon.
+func (a *API) lookupLineItems(ctx context.Context, order *models.Order, items []*orderLineItem) *HTTPError {
 	sem := make(chan int, MaxConcurrentLookups)
 	var wg sync.WaitGroup
 	sharedErr := verificationError{}
@@ -637,6 +1042,7 @@ func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Or
 		lineItem := &models.LineItem{
 			Sku:      orderItem.Sku,
 			Quantity: orderItem.Quantity,
+			Measure:  orderItem.Measure,
 			MetaData: orderItem.MetaData,
 			Path:     orderItem.Path,
 			OrderID:  order.ID,
@@ -664,26 +1070,194 @@ func (a *API) createLineItems(ctx context.Context, tx *gorm.DB, order *models.Or
 	if sharedErr.err != nil {
 		return internalServerError("Error processing line item").WithInternalError(sharedErr.err)
 	}
+	if err := a.addFreeProduct(ctx, order); err != nil {
+		return internalServerError("Error adding free product").WithInternalError(err)
+	}
+	return nil
+}
+
+// calculateOrderTotal calculates the totals of an order with the current
+// settings of the site.
+func (a *API) calculateOrderTotal(ctx context.Context, order *models.Order, log logrus.FieldLogger) *HTTPError {
+	settings, err := a.loadSettings(ctx)
+	if err != nil {
+		return internalServerError(err.Error()).WithInternalError(err)
+	}
+
+	if err := order.CalculateTotal(settings, gcontext.GetClaimsAsMap(ctx), log); err != nil {
+		return internalServerError("Error calculating the order total: %v", err).WithInternalError(err)
+	}
+	return nil
+}
+
+// checkLineItemCount rejects order parameters with more line items than the
+// configured maximum.
+func checkLineItemCount(r *http.Request, params *orderRequestParams) *HTTPError {
+	config := gcontext.GetConfig(r.Context())
+	max := config.Limits.MaxLineItems
+	if max > 0 && len(params.LineItems) > max {
+		return httpError(http.StatusRequestEntityTooLarge, "Orders are limited to %d line items", max)
+	}
+	return nil
+}
+
+// validateOrderParams checks the currency and line items of an order before
+// anything is looked up. All invalid fields are reported at once. Line items
+// of new orders are identified by their path, updates by their ID or SKU.
+func validateOrderParams(params *orderRequestParams, update bool) *HTTPError {
+	errs := []FieldError{}
+	if params.Currency != "" {
+		if _, err := currency.ParseISO(params.Currency); err != nil {
+			errs = append(errs, FieldError{Field: "currency", Message: fmt.Sprintf("Unknown currency '%s'", params.Currency)})
+		}
+	}
+
+	for index, item := range params.LineItems {
+		field := func(name string) string {
+			return fmt.Sprintf("line_items[%d].%s", index, name)
+		}
+		if item == nil {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("line_items[%d]", index), Message: "Line item can't be empty"})
+			continue
+		}
+
+		if update {
+			if item.ID == 0 && strings.TrimSpace(item.Sku) == "" {
+				errs = append(errs, FieldError{Field: field("sku"), Message: "An id or sku is required"})
+			}
+		} else if strings.TrimSpace(item.Path) == "" {
+			errs = append(errs, FieldError{Field: field("path"), Message: "A path is required"})
+		}
+
+		if len(item.rawQuantity) == 0 || string(item.rawQuantity) == "null" {
+			errs = append(errs, FieldError{Field: field("quantity"), Message: "A quantity is required"})
+		} else if quantity, err := parseJSONNumber(item.rawQuantity); err != nil {
+			errs = append(errs, FieldError{Field: field("quantity"), Message: "Quantity must be a number"})
+		} else if n, err := quantity.Int64(); err != nil {
+			errs = append(errs, FieldError{Field: field("quantity"), Message: "Quantity must be a whole number"})
+		} else if n < 1 {
+			errs = append(errs, FieldError{Field: field("quantity"), Message: "Quantity must be at least 1"})
+		}
+
+		if item.Measure < 0 {
+			errs = append(errs, FieldError{Field: field("measure"), Message: "Measure can't be negative"})
+		}
+
+		if len(item.rawPrice) > 0 && string(item.rawPrice) != "null" {
+			if price, err := parseJSONNumber(item.rawPrice); err != nil {
+				errs = append(errs, FieldError{Field: field("price"), Message: "Price must be a number"})
+			} else if value, err := price.Float64(); err != nil || value < 0 {
+				errs = append(errs, FieldError{Field: field("price"), Message: "Price can't be negative"})
+			}
+		}
+
+		for addonIndex, addon := range item.Addons {
+			if strings.TrimSpace(addon.Sku) == "" {
+				errs = append(errs, FieldError{Field: field(fmt.Sprintf("addons[%d].sku", addonIndex)), Message: "An addon sku is required"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return httpError(http.StatusUnprocessableEntity, "Invalid order parameters").WithFieldErrors(errs)
+	}
+	return nil
+}
 
+// parseJSONNumber reads a JSON value that must be a number, not a string.
+func parseJSONNumber(data json.RawMessage) (json.Number, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return "", fmt.Errorf("%s is not a number", data)
+	}
+	return number, nil
+}
+
+// validateMeasures makes sure products sold by measure are ordered with a
+// measure of the precision they allow, and others without one.
+func validateMeasures(order *models.Order) *HTTPError {
 	for _, item := range order.LineItems {
-		order.SubTotal = order.SubTotal + (item.Price+item.AddonPrice)*item.Quantity
-		if err := tx.Save(&item).Error; err != nil {
-			return internalServerError("Error creating line item").WithInternalError(err)
+		switch {
+		case item.MeasureUnit == "" && item.Measure > 0:
+			return httpError(http.StatusUnprocessableEntity, "Product %s isn't sold by measure", item.Sku)
+		case item.MeasureUnit != "" && item.Measure <= 0:
+			return httpError(http.StatusUnprocessableEntity, "Product %s is sold by %s, a measure is required", item.Sku, item.MeasureUnit)
+		case item.MeasureUnit != "" && !models.MeasurePrecise(item.Measure, item.MeasurePrecision):
+			return httpError(http.StatusUnprocessableEntity, "The measure of product %s can have at most %d decimals", item.Sku, item.MeasurePrecision)
 		}
 	}
+	return nil
+}
 
-	for _, download := range order.Downloads {
-		if err := tx.Create(&download).Error; err != nil {
-			return internalServerError("Error creating download item").WithInternalError(err)
+// validateQuantityLimits makes sure no product of an order exceeds its own or
+// the global cap per order, or the cap per customer within the configured
+// window. Quantities of lines sharing a SKU add up.
+func validateQuantityLimits(tx *gorm.DB, config *conf.Configuration, order *models.Order) *HTTPError {
+	skus := []string{}
+	items := make(map[string]*models.LineItem)
+	quantities := make(map[string]uint64)
+	for _, item := range order.LineItems {
+		if _, exists := items[item.Sku]; !exists {
+			skus = append(skus, item.Sku)
+			items[item.Sku] = item
 		}
+		quantities[item.Sku] += item.Quantity
 	}
 
-	settings, err := a.loadSettings(ctx)
-	if err != nil {
-		return internalServerError(err.Error()).WithInternalError(err)
+	since := time.Now().Add(-time.Duration(config.Limits.CustomerWindow) * time.Second)
+	for _, sku := range skus {
+		item := items[sku]
+		max := config.Limits.MaxQuantityPerOrder
+		if item.MaxQuantityPerOrder > 0 && (max == 0 || item.MaxQuantityPerOrder < max) {
+			max = item.MaxQuantityPerOrder
+		}
+		if max > 0 && quantities[sku] > max {
+			return httpError(http.StatusUnprocessableEntity, "Product %s is limited to %d per order", sku, max)
+		}
+
+		if item.MaxPerCustomer == 0 {
+			continue
+		}
+		purchased, err := models.PurchasedQuantity(tx, order, sku, since)
+		if err != nil {
+			return internalServerError("Error checking purchase limits").WithInternalError(err)
+		}
+		if purchased+quantities[sku] > item.MaxPerCustomer {
+			return httpError(http.StatusUnprocessableEntity, "Product %s is limited to %d per customer", sku, item.MaxPerCustomer)
+		}
+	}
+	return nil
+}
+
+// checkPendingOrders rejects a new order if its customer already has as many
+// open unpaid orders as allowed. Pending orders older than the pending order
+// window and overdue manual payments are abandoned and don't count, neither do
+// paid, failed and refunded orders. Orders created by admins aren't checked.
+func checkPendingOrders(r *http.Request, tx *gorm.DB, config *conf.Configuration, order *models.Order) *HTTPError {
+	max := config.Limits.MaxPendingOrders
+	if max <= 0 || gcontext.IsAdmin(r.Context()) {
+		return nil
 	}
 
-	order.CalculateTotal(settings, gcontext.GetClaimsAsMap(ctx), log)
+	since := time.Now().Add(-time.Duration(config.Limits.PendingOrderWindow) * time.Second)
+	count, err := models.PendingOrderCount(tx, order, since)
+	if err != nil {
+		return internalServerError("Error checking pending orders").WithInternalError(err)
+	}
+	if count >= max {
+		getLogEntry(r).WithFields(logrus.Fields{
+			"order_email":    order.Email,
+			"order_user_id":  order.UserID,
+			"pending_orders": count,
+		}).Warn("Rejected an order of a customer with too many pending orders")
+		return httpError(http.StatusTooManyRequests, "You already have %d unpaid orders", count)
+	}
 	return nil
 }
 
@@ -791,6 +1365,10 @@ func orderQuery(db *gorm.DB) *gorm.DB {
 	return db.
 		Preload("LineItems").
 		Preload("Downloads").
+		Preload("Licenses").
+		Preload("SerialNumbers", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at, serial")
+		}).
 		Preload("ShippingAddress").
 		Preload("BillingAddress").
 		Preload("Transactions")
@@ -17,6 +17,8 @@ import (
 
 	"github.com/netlify/gocommerce/calculator"
 	"github.com/netlify/gocommerce/claims"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
 	"github.com/stretchr/testify/require"
 )
@@ -95,6 +97,37 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, stored.UserID, order.UserID)
 	})
 
+	t.Run("SameSkuDifferentMeta", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [
+				{"path": "/download-product", "quantity": 1, "meta": {"engraving": "For Alice"}},
+				{"path": "/download-product", "quantity": 2, "meta": {"engraving": "For Bob"}}
+			]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Len(t, order.LineItems, 2)
+		assert.Equal(t, "For Alice", order.LineItems[0].MetaData["engraving"])
+		assert.Equal(t, uint64(1), order.LineItems[0].Quantity)
+		assert.Equal(t, "For Bob", order.LineItems[1].MetaData["engraving"])
+		assert.Equal(t, uint64(2), order.LineItems[1].Quantity)
+		assert.Equal(t, uint64(499*3), order.SubTotal)
+
+		require.Len(t, order.Downloads, 2)
+		assert.Equal(t, order.LineItems[0].ID, order.Downloads[0].LineItemID)
+		assert.Equal(t, order.LineItems[1].ID, order.Downloads[1].LineItemID)
+	})
+
 	t.Run("NameBackwardsCompatible", func(t *testing.T) {
 		test := NewRouteTest(t)
 		test.Config.SiteURL = server.URL
@@ -139,6 +172,10 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, "Germany", order.BillingAddress.Country)
 		assert.Equal(t, total, order.Total, fmt.Sprintf("Total should be 1069, was %v", order.Total))
 		assert.Equal(t, taxes, order.Taxes, fmt.Sprintf("Total should be 70, was %v", order.Total))
+		if assert.Len(t, order.TaxBreakdown, 1) {
+			assert.Equal(t, "Germany", order.TaxBreakdown[0].Jurisdiction)
+			assert.Equal(t, taxes, order.TaxBreakdown[0].Amount)
+		}
 	})
 
 	t.Run("BundleWithTaxes", func(t *testing.T) {
@@ -249,6 +286,104 @@ func TestOrderCreate(t *testing.T) {
 		assert.Equal(t, uint64(15), discountItem.Percentage)
 		assert.Equal(t, uint64(0), discountItem.Fixed)
 	})
+
+	t.Run("BodyTooLarge", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Limits.MaxBodySize = 64
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		validateError(t, http.StatusRequestEntityTooLarge, recorder, "Request body exceeds the limit of 64 bytes")
+	})
+
+	t.Run("TooManyLineItems", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Limits.MaxLineItems = 1
+
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}, {"path": "/download-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+		validateError(t, http.StatusRequestEntityTooLarge, recorder, "Orders are limited to 1 line items")
+	})
+
+	t.Run("WithTip", func(t *testing.T) {
+		test := NewRouteTest(t)
+
+		settings := calculator.Settings{
+			Taxes: []*calculator.Tax{&calculator.Tax{Percentage: 10}},
+		}
+		server := startTestSiteWithSettings(settings)
+		defer server.Close()
+		test.Config.SiteURL = server.URL
+
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"tip": 200,
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, uint64(999), order.SubTotal)
+		assert.Equal(t, uint64(100), order.Taxes)
+		assert.Equal(t, uint64(200), order.Tip)
+		assert.Equal(t, uint64(999+100+200), order.Total)
+	})
+
+	t.Run("WithAttribution", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"attribution": {"source": "newsletter", "medium": "email", "campaign": "spring-sale", "referrer": "https://example.com/blog"},
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "/simple-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, models.Attribution{
+			Source:   "newsletter",
+			Medium:   "email",
+			Campaign: "spring-sale",
+			Referrer: "https://example.com/blog",
+		}, order.Attribution)
+
+		stored := &models.Order{}
+		require.NoError(t, test.DB.First(stored, "id = ?", order.ID).Error)
+		assert.Equal(t, order.Attribution, stored.Attribution)
+	})
+
+	t.Run("AttributionTooLong", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := strings.NewReader(`{
+			"email": "info@example.com",
+			"attribution": {"campaign": "` + strings.Repeat("a", models.MaxAttributionLength+1) + `"},
+			"line_items": [{"path": "/simple-product", "quantity": 1}]
+		}`)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Attribution campaign can't be longer than 255 characters")
+	})
 }
 
 func TestOrderCreateNewUser(t *testing.T) {
@@ -331,6 +466,172 @@ func TestOrderCreateNewUser(t *testing.T) {
 // LIST
 // ------------------------------------------------------------------------------------------------
 
+func TestOrderQuantityLimits(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	orderPayload := func(path string, quantity uint64) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf(`{
+			"email": "info@example.com",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [{"path": "%s", "quantity": %d}]
+		}`, path, quantity))
+	}
+
+	t.Run("PerOrder", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 3), test.Data.testUserToken)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Product limited-1 is limited to 2 per order")
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 2), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Limits.MaxQuantityPerOrder = 1
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 2), test.Data.testUserToken)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Product product-1 is limited to 1 per order")
+	})
+
+	t.Run("PerCustomer", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Limits.CustomerWindow = 60 * 60
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 2), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 2), test.Data.testUserToken)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Product limited-1 is limited to 3 per customer")
+
+		// other customers aren't affected
+		token := testToken("harley-quinn", "harley@joker.org")
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 2), token)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 1), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+
+	t.Run("PendingOrders", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Limits.PendingOrderWindow = 60 * 60
+		test.Config.Limits.MaxPendingOrders = 2
+
+		// the paid orders of the test user don't count
+		first := &models.Order{}
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, first)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), test.Data.testUserToken)
+		validateError(t, http.StatusTooManyRequests, recorder, "You already have 2 unpaid orders")
+
+		// other customers aren't affected
+		token := testToken("harley-quinn", "harley@joker.org")
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), token)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		require.NoError(t, test.DB.Model(first).Update("payment_state", models.PaidState).Error)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), test.Data.testUserToken)
+		second := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, second)
+
+		// abandoned orders don't count
+		require.NoError(t, test.DB.Model(second).Update("created_at", time.Now().Add(-2*time.Hour)).Error)
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/simple-product", 1), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload("/limited-product", 1), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		op := &orderRequestParams{
+			LineItems: []*orderLineItem{&orderLineItem{Sku: "limited-1", Quantity: 3}},
+		}
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder = runOrderUpdate(test, order, op, token)
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Product limited-1 is limited to 2 per order")
+	})
+}
+
+func TestOrderLineItemValidation(t *testing.T) {
+	orderPayload := func(currency, lineItems string) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf(`{
+			"email": "info@example.com",
+			"currency": "%s",
+			"shipping_address": {
+				"name": "Test User",
+				"address1": "610 22nd Street",
+				"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+			},
+			"line_items": [%s]
+		}`, currency, lineItems))
+	}
+	fieldErrors := func(t *testing.T, recorder *httptest.ResponseRecorder) []FieldError {
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code, "code mismatch: %v", recorder.Body)
+		rsp := &HTTPError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(rsp))
+		return rsp.Errors
+	}
+
+	cases := []struct {
+		name      string
+		currency  string
+		lineItems string
+		expected  []FieldError
+	}{
+		{"NegativeQuantity", "USD", `{"path": "/simple-product", "quantity": -1}`, []FieldError{{"line_items[0].quantity", "Quantity must be at least 1"}}},
+		{"ZeroQuantity", "USD", `{"path": "/simple-product", "quantity": 0}`, []FieldError{{"line_items[0].quantity", "Quantity must be at least 1"}}},
+		{"MissingQuantity", "USD", `{"path": "/simple-product"}`, []FieldError{{"line_items[0].quantity", "A quantity is required"}}},
+		{"TextQuantity", "USD", `{"path": "/simple-product", "quantity": "two"}`, []FieldError{{"line_items[0].quantity", "Quantity must be a number"}}},
+		{"FractionalQuantity", "USD", `{"path": "/simple-product", "quantity": 1.5}`, []FieldError{{"line_items[0].quantity", "Quantity must be a whole number"}}},
+		{"NegativePrice", "USD", `{"path": "/simple-product", "quantity": 1, "price": -100}`, []FieldError{{"line_items[0].price", "Price can't be negative"}}},
+		{"TextPrice", "USD", `{"path": "/simple-product", "quantity": 1, "price": "free"}`, []FieldError{{"line_items[0].price", "Price must be a number"}}},
+		{"MissingPath", "USD", `{"sku": "product-1", "quantity": 1}`, []FieldError{{"line_items[0].path", "A path is required"}}},
+		{"MissingAddonSku", "USD", `{"path": "/simple-product", "quantity": 1, "addons": [{"sku": ""}]}`, []FieldError{{"line_items[0].addons[0].sku", "An addon sku is required"}}},
+		{"UnknownCurrency", "XYZ", `{"path": "/simple-product", "quantity": 1}`, []FieldError{{"currency", "Unknown currency 'XYZ'"}}},
+		{"Several", "USD", `{"path": "/simple-product", "quantity": 1}, {"quantity": -2}`, []FieldError{
+			{"line_items[1].path", "A path is required"},
+			{"line_items[1].quantity", "Quantity must be at least 1"},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			test := NewRouteTest(t)
+			recorder := test.TestEndpoint(http.MethodPost, "/orders", orderPayload(c.currency, c.lineItems), test.Data.testUserToken)
+			assert.Equal(t, c.expected, fieldErrors(t, recorder))
+		})
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		body := strings.NewReader(`{"line_items": [{"quantity": 2}, {"sku": "abc", "quantity": 0}]}`)
+		recorder := test.TestEndpoint(http.MethodPut, "/orders/"+test.Data.firstOrder.ID, body, token)
+		assert.Equal(t, []FieldError{
+			{"line_items[0].sku", "An id or sku is required"},
+			{"line_items[1].quantity", "Quantity must be at least 1"},
+		}, fieldErrors(t, recorder))
+	})
+}
+
 func TestOrdersList(t *testing.T) {
 	t.Run("AsTheUser", func(t *testing.T) {
 		test := NewRouteTest(t)
@@ -338,7 +639,7 @@ func TestOrdersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/orders", nil, token)
 
 		orders := []models.Order{}
-		extractPayload(t, http.StatusOK, recorder, &orders)
+		extractPage(t, http.StatusOK, recorder, &orders)
 		assert.Len(t, orders, 2)
 		validateAllOrders(t, orders, test.Data)
 	})
@@ -348,7 +649,7 @@ func TestOrdersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/orders", nil, token)
 
 		orders := []models.Order{}
-		extractPayload(t, http.StatusOK, recorder, &orders)
+		extractPage(t, http.StatusOK, recorder, &orders)
 		assert.Len(t, orders, 0)
 	})
 	t.Run("AsExpiredToken", func(t *testing.T) {
@@ -364,7 +665,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?email=bruce", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 		})
 		t.Run("EmailFilterAsTheUserEmptyResponse", func(t *testing.T) {
@@ -373,7 +674,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?email=gmail.com", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 0)
 		})
 		t.Run("ItemFilterAsTheUser", func(t *testing.T) {
@@ -382,7 +683,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?items=batwing", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 		})
 		t.Run("BillingNameFilterAsTheUser", func(t *testing.T) {
@@ -391,7 +692,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?billing_name=whatname", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 0)
 		})
 		t.Run("ShippingNameFilterAsTheUser", func(t *testing.T) {
@@ -400,7 +701,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?shipping_name=whatname", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 0)
 		})
 		t.Run("ItemTypeFilterAsTheUser", func(t *testing.T) {
@@ -409,7 +710,7 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?item_type=plane", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 		})
 		t.Run("CouponCodeFilterAsTheUser", func(t *testing.T) {
@@ -418,30 +719,63 @@ func TestOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/orders?coupon_code=zerodiscount", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 		})
 		t.Run("RangeWithParams", func(t *testing.T) {
 			test := NewRouteTest(t)
 			token := test.Data.testUserToken
-			url := fmt.Sprintf("/orders?per_page=50&page=1&from=%d&billing_countries=dcland", test.Data.firstOrder.CreatedAt.Unix())
+			url := fmt.Sprintf("/orders?limit=50&from=%d&billing_countries=dcland", test.Data.firstOrder.CreatedAt.Unix())
 			recorder := test.TestEndpoint(http.MethodGet, url, nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 		})
 	})
 	t.Run("Pagination", func(t *testing.T) {
 		test := NewRouteTest(t)
 		token := test.Data.testUserToken
-		reqUrl := "/orders?per_page=1"
-		recorder := test.TestEndpoint(http.MethodGet, reqUrl, nil, token)
+		cheaper, pricier := test.Data.firstOrder, test.Data.secondOrder
+		if cheaper.Total > pricier.Total {
+			cheaper, pricier = pricier, cheaper
+		}
+		recorder := test.TestEndpoint(http.MethodGet, "/orders?limit=1&sort=total+asc", nil, token)
 
 		orders := []models.Order{}
-		extractPayload(t, http.StatusOK, recorder, &orders)
-		assert.Len(t, orders, 1)
-		validatePagination(t, recorder, reqUrl, 2, 1, 1, 2)
+		cursor := extractPage(t, http.StatusOK, recorder, &orders)
+		require.Len(t, orders, 1)
+		assert.Equal(t, cheaper.ID, orders[0].ID)
+		require.NotEmpty(t, cursor)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/orders?limit=1&sort=total+asc&cursor="+cursor, nil, token)
+		orders = []models.Order{}
+		cursor = extractPage(t, http.StatusOK, recorder, &orders)
+		require.Len(t, orders, 1)
+		assert.Equal(t, pricier.ID, orders[0].ID)
+		assert.Empty(t, cursor)
+	})
+	t.Run("PaginationDefaultSort", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := test.Data.testUserToken
+		seen := map[string]bool{}
+		cursor := ""
+		for i := 0; i < 2; i++ {
+			recorder := test.TestEndpoint(http.MethodGet, "/orders?limit=1&cursor="+cursor, nil, token)
+			orders := []models.Order{}
+			cursor = extractPage(t, http.StatusOK, recorder, &orders)
+			require.Len(t, orders, 1)
+			seen[orders[0].ID] = true
+		}
+		assert.Empty(t, cursor)
+		assert.True(t, seen[test.Data.firstOrder.ID])
+		assert.True(t, seen[test.Data.secondOrder.ID])
+	})
+	t.Run("BadCursor", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := test.Data.testUserToken
+		recorder := test.TestEndpoint(http.MethodGet, "/orders?cursor=not-a-cursor!", nil, token)
+		validateError(t, http.StatusBadRequest, recorder)
 	})
 }
 
@@ -452,7 +786,7 @@ func TestUserOrdersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders", nil, token)
 
 		orders := []models.Order{}
-		extractPayload(t, http.StatusOK, recorder, &orders)
+		extractPage(t, http.StatusOK, recorder, &orders)
 		assert.Len(t, orders, 2)
 		validateAllOrders(t, orders, test.Data)
 	})
@@ -468,7 +802,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?payment_state=pending", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 			singleOrder := orders[0]
 			assert.Equal(t, pendingOrder.ID, singleOrder.ID)
@@ -485,7 +819,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?payment_state=paid", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 			validateAllOrders(t, orders, test.Data)
 		})
@@ -495,7 +829,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?payment_state=failed", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 0)
 		})
 		t.Run("PaymentStateInvalid", func(t *testing.T) {
@@ -515,7 +849,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?fulfillment_state=pending", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 			validateAllOrders(t, orders, test.Data)
 		})
@@ -530,7 +864,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?fulfillment_state=shipped", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 			singleOrder := orders[0]
 			assert.Equal(t, shippedOrder.ID, singleOrder.ID)
@@ -565,7 +899,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, "/users/all/orders?shipping_countries=Denmark", nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 1)
 			singleOrder := orders[0]
 			assert.Equal(t, singleOrder.Email, "antboy@hasselbalch.dk")
@@ -579,7 +913,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, url, nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 			for _, o := range orders {
 				switch o.Email {
@@ -603,7 +937,7 @@ func TestUserOrdersList(t *testing.T) {
 			recorder := test.TestEndpoint(http.MethodGet, url, nil, token)
 
 			orders := []models.Order{}
-			extractPayload(t, http.StatusOK, recorder, &orders)
+			extractPage(t, http.StatusOK, recorder, &orders)
 			assert.Len(t, orders, 2)
 			validateAllOrders(t, orders, test.Data)
 		})
@@ -674,6 +1008,143 @@ func TestOrderView(t *testing.T) {
 		validateAddress(t, test.Data.firstOrder.BillingAddress, order.BillingAddress)
 		validateAddress(t, test.Data.firstOrder.ShippingAddress, order.ShippingAddress)
 	})
+
+	t.Run("WithFormattedAmounts", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Display.FormattedAmounts = true
+		token := testToken(test.Data.testUser.ID, "marp@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, test.Data.urlForFirstOrder, nil, token)
+
+		order := new(displayOrder)
+		extractPayload(t, http.StatusOK, recorder, order)
+		validateOrder(t, test.Data.firstOrder, order.Order)
+		require.NotNil(t, order.Display)
+		assert.Equal(t, formatAmount(test.Data.firstOrder.Total, test.Data.firstOrder.Currency), order.Display.Total)
+	})
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		amount   uint64
+		currency string
+		expected string
+	}{
+		{1250, "USD", "$12.50"},
+		{123456789, "USD", "$1,234,567.89"},
+		{999, "EUR", "€9.99"},
+		{100000, "JPY", "¥1,000"},
+		{1250, "CHF", "CHF 12.50"},
+		{1250, "BHD", "BHD 12.500"},
+		{1250, "monopoly-dollars", "12.50 monopoly-dollars"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, formatAmount(c.amount, c.currency))
+	}
+}
+
+func TestDecimalAmount(t *testing.T) {
+	cases := []struct {
+		amount   decimalAmount
+		expected string
+	}{
+		{1250, "12.50"},
+		{5, "0.05"},
+		{0, "0.00"},
+		{123456789, "1234567.89"},
+		{-1250, "-12.50"},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(c.amount)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, string(data))
+	}
+}
+
+func TestWantsDecimalAmounts(t *testing.T) {
+	request := func(config *conf.Configuration, accept string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return r.WithContext(gcontext.WithConfig(r.Context(), config))
+	}
+	integer := &conf.Configuration{}
+	decimal := &conf.Configuration{}
+	decimal.Display.Amounts = DecimalAmounts
+
+	assert.False(t, wantsDecimalAmounts(request(integer, "")))
+	assert.True(t, wantsDecimalAmounts(request(decimal, "")))
+	assert.True(t, wantsDecimalAmounts(request(integer, "application/json; amounts=decimal")))
+	assert.False(t, wantsDecimalAmounts(request(decimal, "application/json; amounts=integer")))
+	assert.True(t, wantsDecimalAmounts(request(decimal, "application/json; amounts=formatted")))
+}
+
+func TestDecimalAmountsResponses(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Display.Amounts = DecimalAmounts
+		test.Config.Display.FormattedAmounts = true
+		token := testToken(test.Data.testUser.ID, "marp@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, test.Data.urlForFirstOrder, nil, token)
+
+		order := struct {
+			ID        string      `json:"id"`
+			Total     json.Number `json:"total"`
+			SubTotal  json.Number `json:"subtotal"`
+			LineItems []struct {
+				Price    json.Number `json:"price"`
+				Quantity json.Number `json:"quantity"`
+			} `json:"line_items"`
+			Display *orderDisplay `json:"display"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &order)
+		assert.Equal(t, test.Data.firstOrder.ID, order.ID)
+		assert.Equal(t, decimalString(test.Data.firstOrder.Total), order.Total.String())
+		assert.Equal(t, decimalString(test.Data.firstOrder.SubTotal), order.SubTotal.String())
+		require.Len(t, order.LineItems, 1)
+		item := order.LineItems[0]
+		assert.Equal(t, decimalString(test.Data.firstLineItem.Price), item.Price.String())
+		assert.Equal(t, fmt.Sprintf("%d", test.Data.firstLineItem.Quantity), item.Quantity.String())
+		require.NotNil(t, order.Display)
+		assert.Equal(t, formatAmount(test.Data.firstOrder.Total, test.Data.firstOrder.Currency), order.Display.Total)
+	})
+
+	t.Run("Payments", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Display.Amounts = DecimalAmounts
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/payments/"+test.Data.firstTransaction.ID, nil, token)
+
+		trans := struct {
+			ID     string      `json:"id"`
+			Amount json.Number `json:"amount"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &trans)
+		assert.Equal(t, test.Data.firstTransaction.ID, trans.ID)
+		assert.Equal(t, decimalString(test.Data.firstTransaction.Amount), trans.Amount.String())
+	})
+
+	t.Run("SalesReport", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Display.Amounts = DecimalAmounts
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales", nil, token)
+
+		report := []struct {
+			Total    json.Number `json:"total"`
+			Currency string      `json:"currency"`
+			Orders   json.Number `json:"orders"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &report)
+		require.Len(t, report, 1)
+		assert.Equal(t, "0.79", report[0].Total.String())
+		assert.Equal(t, "2", report[0].Orders.String())
+	})
+}
+
+func decimalString(amount uint64) string {
+	data, _ := json.Marshal(decimalAmount(amount))
+	return string(data)
 }
 
 // --------------------------------------------------------------------------------------------------------------------
@@ -781,7 +1252,7 @@ func TestOrderUpdate(t *testing.T) {
 
 		op := &orderRequestParams{
 			Email:            "mrfreeze@dc.com",
-			Currency:         "monopoly-dollars",
+			Currency:         "EUR",
 			FulfillmentState: "shipping",
 		}
 		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
@@ -796,12 +1267,12 @@ func TestOrderUpdate(t *testing.T) {
 		require.False(t, rsp.RecordNotFound())
 
 		assert.Equal("mrfreeze@dc.com", rspOrder.Email)
-		assert.Equal("monopoly-dollars", rspOrder.Currency)
+		assert.Equal("EUR", rspOrder.Currency)
 		assert.Equal("shipping", rspOrder.FulfillmentState)
 
 		// did it get persisted to the db
 		assert.Equal("mrfreeze@dc.com", saved.Email)
-		assert.Equal("monopoly-dollars", saved.Currency)
+		assert.Equal("EUR", saved.Currency)
 		assert.Equal("shipping", saved.FulfillmentState)
 		validateOrder(t, saved, rspOrder)
 
@@ -908,6 +1379,22 @@ func TestOrderUpdate(t *testing.T) {
 		assert.Equal(t, op.MetaData, order.MetaData, "Order metadata should have been updated")
 	})
 
+	t.Run("LineItemByID", func(t *testing.T) {
+		test := NewRouteTest(t)
+		item := test.Data.firstOrder.LineItems[0]
+		op := &orderRequestParams{
+			LineItems: []*orderLineItem{{ID: item.ID, Quantity: 5, MetaData: map[string]interface{}{"engraving": "For Alice"}}},
+		}
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := runOrderUpdate(test, test.Data.firstOrder, op, token)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		require.Len(t, order.LineItems, 1)
+		assert.Equal(t, uint64(5), order.LineItems[0].Quantity)
+		assert.Equal(t, "For Alice", order.LineItems[0].MetaData["engraving"])
+	})
+
 	t.Run("InvalidFulfilmentState", func(t *testing.T) {
 		test := NewRouteTest(t)
 		op := &orderRequestParams{
@@ -919,6 +1406,157 @@ func TestOrderUpdate(t *testing.T) {
 	})
 }
 
+func TestOrderTaxOverride(t *testing.T) {
+	t.Run("Simple", func(t *testing.T) {
+		test := NewRouteTest(t)
+		require.NoError(t, test.DB.Model(test.Data.firstOrder).Update("payment_state", models.PendingState).Error)
+		body := strings.NewReader(`{"taxes": 0, "reason": "exemption certificate"}`)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPatch, "/orders/first-order/tax", body, token)
+
+		rspOrder := new(models.Order)
+		extractPayload(t, http.StatusOK, recorder, rspOrder)
+		assert.Equal(t, uint64(0), rspOrder.Taxes)
+		assert.Equal(t, rspOrder.NetTotal, rspOrder.Total)
+		require.NotNil(t, rspOrder.TaxOverride)
+		assert.Equal(t, "exemption certificate", rspOrder.TaxOverrideReason)
+
+		saved := new(models.Order)
+		require.NoError(t, test.DB.Preload("LineItems").First(saved, "id = ?", "first-order").Error)
+		require.NotNil(t, saved.TaxOverride)
+		assert.Equal(t, uint64(0), *saved.TaxOverride)
+
+		event := new(models.Event)
+		require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventTaxOverridden).Error)
+		assert.Equal(t, "admin-yo", event.UserID)
+		assert.Contains(t, event.Changes, "exemption certificate")
+
+		// recalculating must keep the override
+		saved.CalculateTotal(&calculator.Settings{PricesIncludeTaxes: false, Taxes: []*calculator.Tax{{
+			Percentage:   10,
+			ProductTypes: []string{"plane"},
+		}}}, nil, testLogger)
+		assert.Equal(t, uint64(0), saved.Taxes)
+		assert.Equal(t, saved.NetTotal, saved.Total)
+	})
+
+	t.Run("MissingReason", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := strings.NewReader(`{"taxes": 0}`)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPatch, "/orders/first-order/tax", body, token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("MissingTaxes", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := strings.NewReader(`{"reason": "exemption certificate"}`)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPatch, "/orders/first-order/tax", body, token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("Paid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := strings.NewReader(`{"taxes": 0, "reason": "exemption certificate"}`)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPatch, "/orders/first-order/tax", body, token)
+		validateError(t, http.StatusBadRequest, recorder, "paid")
+
+		saved := new(models.Order)
+		require.NoError(t, test.DB.First(saved, "id = ?", "first-order").Error)
+		assert.Nil(t, saved.TaxOverride)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		body := strings.NewReader(`{"taxes": 0, "reason": "exemption certificate"}`)
+		token := testToken("villian", "villian@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPatch, "/orders/first-order/tax", body, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestOrderReview(t *testing.T) {
+	holdOrder := func(test *RouteTest) {
+		test.Data.firstOrder.FulfillmentState = models.OnHoldState
+		test.Data.firstLineItem.FulfillmentType = models.DigitalFulfillment
+		test.Data.firstLineItem.LicenseFormat = models.DefaultLicenseFormat
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	}
+
+	t.Run("Approve", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Webhooks.Categories = map[string]string{"review": "https://review.example.com/hooks"}
+		holdOrder(test)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/approve", nil, token)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.Equal(t, models.FulfilledState, order.FulfillmentState, "the digital items are delivered")
+
+		event := &models.Event{}
+		require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventApproved).Error)
+		assert.Equal(t, "admin-yo", event.UserID)
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.License{}).Where("order_id = ?", "first-order").Count(&count).Error)
+		assert.Equal(t, 2, count)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		hook := &models.Hook{}
+		require.NoError(t, test.DB.First(hook, "order_id = ? AND type = ?", "first-order", "order.approved").Error)
+		assert.Equal(t, "https://review.example.com/hooks", hook.URL)
+	})
+
+	t.Run("Held", func(t *testing.T) {
+		test := NewRouteTest(t)
+		holdOrder(test)
+		recorder := test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder, "withheld")
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/licenses", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder, "withheld")
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Webhooks.Categories = map[string]string{"review": "https://review.example.com/hooks"}
+		holdOrder(test)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/reject", nil, token)
+
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.Equal(t, models.RejectedState, order.FulfillmentState)
+
+		event := &models.Event{}
+		require.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventRejected).Error)
+
+		hook := &models.Hook{}
+		require.NoError(t, test.DB.First(hook, "order_id = ? AND type = ?", "first-order", "order.rejected").Error)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/downloads/first-download", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder, "withheld")
+	})
+
+	t.Run("NotOnHold", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/approve", nil, token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		holdOrder(test)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/approve", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
 // -------------------------------------------------------------------------------------------------------------------
 // CLAIMS
 // -------------------------------------------------------------------------------------------------------------------
@@ -1,70 +1,161 @@
 package api
 
 import (
-	"fmt"
-	"net/http"
+	"encoding/base64"
+	"encoding/json"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
 
-const defaultPerPage = 50
+const defaultPageSize = 50
+const maxPageSize = 250
 
-func calculateTotalPages(perPage, total uint64) uint64 {
-	pages := total / perPage
-	if total%perPage > 0 {
-		return pages + 1
-	}
-	return pages
+// listPage is the response of all list endpoints. NextCursor is empty on the
+// last page.
+type listPage struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// pageCursor marks the position after the last item of a page by the value of
+// its sort key and its ID. Clients only see it as an opaque string.
+type pageCursor struct {
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(c *pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
-func addPaginationHeaders(w http.ResponseWriter, r *http.Request, page, perPage, total uint64) {
-	totalPages := calculateTotalPages(perPage, total)
-	url, _ := url.ParseRequestURI(r.URL.RequestURI())
-	query := url.Query()
-	header := ""
-	if totalPages > page {
-		query.Set("page", fmt.Sprintf("%v", page+1))
-		url.RawQuery = query.Encode()
-		header += "<" + url.String() + ">; rel=\"next\", "
+func decodeCursor(value string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	c := new(pageCursor)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
 	}
-	query.Set("page", fmt.Sprintf("%v", totalPages))
-	url.RawQuery = query.Encode()
-	header += "<" + url.String() + ">; rel=\"last\""
+	return c, nil
+}
 
-	w.Header().Add("Link", header)
-	w.Header().Add("X-Total-Count", fmt.Sprintf("%v", total))
+// pagination holds the params shared by all list endpoints:
+// limit     # of items to return, max 250
+// cursor    the `next_cursor` of the previous page
+type pagination struct {
+	Limit  int
+	Cursor *pageCursor
 }
 
-func paginate(w http.ResponseWriter, r *http.Request, query *gorm.DB) (offset int, limit int, err error) {
-	params := r.URL.Query()
-	queryPage := params.Get("page")
-	queryPerPage := params.Get("per_page")
-	var page uint64 = 1
-	var perPage uint64 = defaultPerPage
-	if queryPage != "" {
-		page, err = strconv.ParseUint(queryPage, 10, 64)
-		if err != nil {
-			return
+func parsePagination(params url.Values) (*pagination, *HTTPError) {
+	p := &pagination{Limit: defaultPageSize}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, badRequestError("Bad Pagination Parameters: invalid limit '%v'", value)
 		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		p.Limit = limit
 	}
-	if queryPerPage != "" {
-		perPage, err = strconv.ParseUint(queryPerPage, 10, 64)
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
 		if err != nil {
-			return
+			return nil, badRequestError("Bad Pagination Parameters: invalid cursor")
 		}
+		p.Cursor = cursor
 	}
+	return p, nil
+}
+
+// hasMore checks if more items follow a page. Queries fetch one item more than
+// the limit to find out.
+func (p *pagination) hasMore(count int) bool {
+	return count > p.Limit
+}
+
+// sortKey is the expression a list is ordered by. The ID column breaks ties,
+// so every item has a unique position to continue from.
+type sortKey struct {
+	expression string
+	idColumn   string
+	dir        sortDirection
+	aggregate  bool
+	parse      func(value string) (interface{}, error)
+}
+
+// paginate orders a query by the sort key and restricts it to the page after
+// the cursor.
+func paginate(query *gorm.DB, p *pagination, key *sortKey) (*gorm.DB, *HTTPError) {
+	if p.Cursor != nil {
+		op := ">"
+		if key.dir == descending {
+			op = "<"
+		}
 
-	var total uint64
-	if result := query.Count(&total); result.Error != nil {
-		err = result.Error
-		return
+		var condition string
+		var args []interface{}
+		if key.expression == "" {
+			condition = key.idColumn + " " + op + " ?"
+			args = []interface{}{p.Cursor.ID}
+		} else {
+			value, err := key.parse(p.Cursor.Value)
+			if err != nil {
+				return nil, badRequestError("Bad Pagination Parameters: invalid cursor")
+			}
+			condition = "(" + key.expression + " " + op + " ? OR (" + key.expression + " = ? AND " + key.idColumn + " " + op + " ?))"
+			args = []interface{}{value, value, p.Cursor.ID}
+		}
+
+		if key.aggregate {
+			query = query.Having(condition, args...)
+		} else {
+			query = query.Where(condition, args...)
+		}
+	}
+
+	if key.expression != "" {
+		query = query.Order(key.expression + " " + string(key.dir))
 	}
+	return query.Order(key.idColumn + " " + string(key.dir)).Limit(p.Limit + 1), nil
+}
 
-	offset = int((page - 1) * perPage)
-	limit = int(perPage)
-	addPaginationHeaders(w, r, page, perPage, total)
+// parseSort reads a `sort` param like `created_at desc`.
+func parseSort(value string, defaultDir sortDirection) (string, sortDirection, error) {
+	parts := strings.Split(value, " ")
+	dir := defaultDir
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case string(ascending):
+			dir = ascending
+		case string(descending):
+			dir = descending
+		default:
+			return "", dir, badRequestError("Bad direction for sort '%v', only 'asc' and 'desc' allowed", parts[1])
+		}
+	}
+	return parts[0], dir, nil
+}
+
+func formatCursorTime(value time.Time) string {
+	return value.Format(time.RFC3339Nano)
+}
+
+func parseCursorTime(value string) (interface{}, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func parseCursorNumber(value string) (interface{}, error) {
+	return strconv.ParseUint(value, 10, 64)
+}
 
-	return
+func parseCursorString(value string) (interface{}, error) {
+	return value, nil
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -17,15 +18,6 @@ type sortDirection string
 const ascending sortDirection = "asc"
 const descending sortDirection = "desc"
 
-var sortFields = map[string]string{
-	"created_at": "created_at",
-	"updated_at": "updated_at",
-	"email":      "email",
-	"taxes":      "taxes",
-	"subtotal":   "subtotal",
-	"total":      "total",
-}
-
 func parsePaymentQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
 	transactionTable := query.NewScope(models.Transaction{}).QuotedTableName()
 	query = addFilters(query, transactionTable, params, []string{
@@ -46,6 +38,10 @@ func parsePaymentQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error
 		query = query.Where(transactionTable+".amount <= ?", values[0])
 	}
 
+	if values, exists := params["metadata_key"]; exists {
+		query = filterProviderMetadata(query, transactionTable, values[0], params.Get("metadata_value"))
+	}
+
 	query, err := parseLimitQueryParam(query, params)
 	if err != nil {
 		return nil, err
@@ -75,15 +71,11 @@ func parseUserQueryParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
 		"email",
 	})
 
-	query, err := parseLimitQueryParam(query, params)
-	if err != nil {
-		return nil, err
+	if search := params.Get("search"); search != "" {
+		query = query.Where(userTable+".email LIKE ? OR "+userTable+".name LIKE ?", "%"+search+"%", "%"+search+"%")
 	}
-	return parseTimeQueryParams(query, userTable, params)
-}
 
-func sortField(value string) string {
-	return sortFields[value]
+	return parseTimeQueryParams(query, userTable, params)
 }
 
 func addAddressFilter(query *gorm.DB, params url.Values, queryField string, dbField string) *gorm.DB {
@@ -138,30 +130,6 @@ func parseOrderParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
 	query = addNegativeAddressFilter(query, params, "countries", "country")
 	query = addAddressFilter(query, params, "name", "name")
 
-	if values, exists := params["sort"]; exists {
-		for _, value := range values {
-			parts := strings.Split(value, " ")
-			field := sortField(parts[0])
-			if field == "" {
-				return nil, fmt.Errorf("bad field for sort '%v'", field)
-			}
-			dir := ascending
-			if len(parts) == 2 {
-				switch strings.ToLower(parts[1]) {
-				case string(ascending):
-					dir = ascending
-				case string(descending):
-					dir = descending
-				default:
-					return nil, fmt.Errorf("bad direction for sort '%v', only 'asc' and 'desc' allowed", parts[1])
-				}
-			}
-			query = query.Order(field + " " + string(dir))
-		}
-	} else {
-		query = query.Order("created_at desc")
-	}
-
 	if items := params.Get("items"); items != "" {
 		lineItemTable := query.NewScope(models.LineItem{}).QuotedTableName()
 		statement := "JOIN " + lineItemTable + " as line_item on line_item.order_id = " +
@@ -185,6 +153,10 @@ func parseOrderParams(query *gorm.DB, params url.Values) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if params.Get("include_archived") != "true" {
+		query = query.Where(orderTable+".archived = ?", false)
+	}
+
 	query = addFilters(query, orderTable, params, []string{
 		"invoice_number",
 	})
@@ -285,3 +257,30 @@ func addFilterChoices(query *gorm.DB, table string, params url.Values, filterFie
 
 	return query.Where(fmt.Sprintf("%s.%s IN (?)", table, filterField), filterValues), nil
 }
+
+// filterProviderMetadata matches transactions whose provider metadata contains
+// a key, optionally with a value. The key can be namespaced by the provider,
+// e.g. `stripe.risk_level`.
+func filterProviderMetadata(query *gorm.DB, table, key, value string) *gorm.DB {
+	column := table + ".provider_metadata"
+	if parts := strings.SplitN(key, ".", 2); len(parts) == 2 {
+		query = query.Where(column+" LIKE ?", "%"+jsonKey(parts[0])+"{%")
+		key = parts[1]
+	}
+
+	if value == "" {
+		return query.Where(column+" LIKE ?", "%"+jsonKey(key)+"%")
+	}
+	quoted, _ := json.Marshal(value)
+	return query.Where(
+		"("+column+" LIKE ? OR "+column+" LIKE ? OR "+column+" LIKE ?)",
+		"%"+jsonKey(key)+string(quoted)+"%",
+		"%"+jsonKey(key)+value+",%",
+		"%"+jsonKey(key)+value+"}%",
+	)
+}
+
+func jsonKey(key string) string {
+	quoted, _ := json.Marshal(key)
+	return string(quoted) + ":"
+}
@@ -8,11 +8,13 @@ import (
 	"strconv"
 
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 
 	"github.com/jinzhu/gorm"
 	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"mime"
@@ -20,8 +22,12 @@ import (
 	"github.com/netlify/gocommerce/claims"
 	"github.com/netlify/gocommerce/conf"
 	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/mailer"
 	"github.com/netlify/gocommerce/models"
 	"github.com/netlify/gocommerce/payments"
+	"github.com/netlify/gocommerce/payments/braintree"
+	"github.com/netlify/gocommerce/payments/free"
+	"github.com/netlify/gocommerce/payments/manual"
 	"github.com/netlify/gocommerce/payments/paypal"
 	"github.com/netlify/gocommerce/payments/stripe"
 )
@@ -34,6 +40,48 @@ type PaymentParams struct {
 	Description  string `json:"description"`
 }
 
+// RefundParams holds the parameters for a refund.
+type RefundParams struct {
+	PaymentParams
+	Reason models.RefundReason `json:"reason"`
+
+	// OverrideMaxAge allows refunds of orders paid longer ago than the
+	// configured maximum refund age.
+	OverrideMaxAge bool `json:"override_max_age"`
+
+	// LineItems allocate the refund to line items of the order. Their sum
+	// is the amount of the refund if it isn't given.
+	LineItems []refundLineItemParams `json:"line_items"`
+}
+
+// paymentHookPayload is the order sent with payment webhooks, together with
+// the card it was paid with.
+type paymentHookPayload struct {
+	*models.Order
+	Card *paymentHookCard `json:"card,omitempty"`
+}
+
+type paymentHookCard struct {
+	Brand string `json:"brand"`
+	Last4 string `json:"last4"`
+}
+
+func newPaymentHookPayload(order *models.Order, tr *models.Transaction) *paymentHookPayload {
+	payload := &paymentHookPayload{Order: order}
+	if tr.CardBrand != "" || tr.CardLast4 != "" {
+		payload.Card = &paymentHookCard{Brand: tr.CardBrand, Last4: tr.CardLast4}
+	}
+	return payload
+}
+
+// transactionDetail is a transaction with the redacted response of the
+// provider, which is only shown to admins.
+type transactionDetail struct {
+	*models.Transaction
+	Amount      interface{}     `json:"amount"`
+	RawResponse json.RawMessage `json:"raw_response" visibility:"admin"`
+}
+
 // PaymentListForUser is the endpoint for listing transactions for a user.
 // The ID in the claim and the ID in the path must match (or have admin override)
 func (a *API) PaymentListForUser(w http.ResponseWriter, r *http.Request) error {
@@ -49,7 +97,7 @@ func (a *API) PaymentListForUser(w http.ResponseWriter, r *http.Request) error {
 	if httpErr != nil {
 		return httpErr
 	}
-	return sendJSON(w, http.StatusOK, trans)
+	return sendJSON(w, http.StatusOK, presentTransactions(r, trans))
 }
 
 // PaymentListForOrder is the endpoint for listing transactions for an order. You must be the owner
@@ -77,7 +125,10 @@ func (a *API) PaymentListForOrder(w http.ResponseWriter, r *http.Request) error
 	}
 
 	log.Debugf("Returning %d transactions", len(order.Transactions))
-	return sendJSON(w, http.StatusOK, order.Transactions)
+	if wantsDecimalAmounts(r) {
+		return sendJSON(w, http.StatusOK, redactForCustomer(r, newDecimalTransactions(order.Transactions)))
+	}
+	return sendJSON(w, http.StatusOK, redactForCustomer(r, order.Transactions))
 }
 
 // PaymentCreate is the endpoint for creating a payment for an order
@@ -92,15 +143,36 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return badRequestError("Could not read params: %v", err)
 	}
-	if params.ProviderType == "" {
-		return badRequestError("Creating a payment requires specifying a 'provider'")
+	var provider payments.Provider
+	if params.Amount == 0 {
+		if config.Payment.DisableFreeOrders {
+			return badRequestError("This shop doesn't accept free orders")
+		}
+		// free orders are marked as paid without charging any provider
+		provider = free.NewPaymentProvider()
+	} else if paymentRoutingEnabled(config) {
+		provider = routePaymentProvider(config, gcontext.GetPaymentProviders(ctx), params.Currency)
+		if provider == nil {
+			return badRequestError("No payment provider is available for payments in %s", params.Currency)
+		}
+		if params.ProviderType != "" && strings.ToLower(params.ProviderType) != provider.Name() {
+			return badRequestError("Payments in %s must use the '%s' provider", params.Currency, provider.Name())
+		}
+	} else {
+		if params.ProviderType == "" {
+			return badRequestError("Creating a payment requires specifying a 'provider'")
+		}
+		provider = gcontext.GetPaymentProviders(ctx)[strings.ToLower(params.ProviderType)]
+		if provider == nil {
+			return badRequestError("Payment provider '%s' not configured", params.ProviderType)
+		}
 	}
-
-	provider := gcontext.GetPaymentProviders(ctx)[strings.ToLower(params.ProviderType)]
-	if provider == nil {
-		return badRequestError("Payment provider '%s' not configured", params.ProviderType)
+	chargeCtx := ctx
+	capturer, canCapture := provider.(payments.Capturer)
+	if canCapture && config.Payment.Capture.Delay > 0 {
+		chargeCtx = payments.WithAuthorizeOnly(ctx)
 	}
-	charge, err := provider.NewCharger(ctx, r)
+	charge, err := provider.NewCharger(chargeCtx, r)
 	if err != nil {
 		return badRequestError("Error creating payment provider: %v", err)
 	}
@@ -125,6 +197,10 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		tx.Rollback()
 		return badRequestError("This order has already been paid")
 	}
+	if order.PaymentState == models.AwaitingPaymentState && provider.Name() == payments.ManualProvider {
+		tx.Rollback()
+		return badRequestError("This order is already awaiting a manual payment")
+	}
 
 	if order.Currency != params.Currency {
 		tx.Rollback()
@@ -155,6 +231,10 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		tx.Rollback()
 		return internalServerError("We failed to authorize the amount for this order: %v", err)
 	}
+	if min := minimumCharge(config, order.Currency); params.Amount > 0 && params.Amount < min {
+		tx.Rollback()
+		return badRequestError("Payments in %s must be at least %d", order.Currency, min)
+	}
 
 	invoiceNumber, err := models.NextInvoiceNumber(tx, order.InstanceID)
 	if err != nil {
@@ -162,48 +242,163 @@ func (a *API) PaymentCreate(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("We failed to generate a valid invoice ID, please try again later: %v", err)
 	}
 
+	if !config.Payment.SkipTotalCheck {
+		if err := order.VerifyTotal(); err != nil {
+			tx.Rollback()
+			log.WithError(err).WithFields(logrus.Fields{
+				"order_id":  order.ID,
+				"total":     order.Total,
+				"subtotal":  order.SubTotal,
+				"discount":  order.Discount,
+				"net_total": order.NetTotal,
+				"taxes":     order.Taxes,
+				"shipping":  order.Shipping,
+				"tip":       order.Tip,
+			}).Error("Refusing to charge an order with an inconsistent total")
+			return internalServerError("The total of this order is inconsistent, it can't be charged")
+		}
+	}
+
+	// the coupon only counts as redeemed once the order is paid
+	if order.CouponCode != "" {
+		if err := models.RedeemCoupon(tx, order.InstanceID, order.CouponCode); err != nil {
+			tx.Rollback()
+			if redeemed, ok := err.(*models.CouponRedeemedError); ok {
+				return badRequestError("Coupon %s has already been redeemed", redeemed.Code)
+			}
+			return internalServerError("Error redeeming coupon").WithInternalError(err)
+		}
+	}
+
+	if config.Inventory.Enabled {
+		expiresAt := time.Now().Add(time.Duration(config.Inventory.ReservationTimeout) * time.Second)
+		if err := models.HoldReservations(tx, order, expiresAt, config.Inventory.AllowBackorders); err != nil {
+			tx.Rollback()
+			if outOfStock, ok := err.(*models.OutOfStockError); ok {
+				return badRequestError("Product %s is out of stock", outOfStock.Sku)
+			}
+			return internalServerError("Error reserving inventory").WithInternalError(err)
+		}
+	}
+
 	tr := models.NewTransaction(order)
-	processorID, err := charge(params.Amount, params.Currency, order, invoiceNumber)
-	tr.ProcessorID = processorID
+	result, err := charge(params.Amount, params.Currency, order, invoiceNumber)
+	if result != nil {
+		tr.ProcessorID = result.ID
+		tr.SetProviderMetadata(provider.Name(), result.Metadata)
+		if result.Card != nil {
+			tr.SetCardDetails(result.Card.Brand, result.Card.Last4, result.Card.Funding)
+		}
+		if result.Response != nil {
+			if raw, err := payments.RedactResponse(result.Response); err != nil {
+				log.WithError(err).Warn("Failed to store the provider response")
+			} else {
+				tr.RawResponse = raw
+			}
+		}
+	}
 	tr.InvoiceNumber = invoiceNumber
 
+	if httpErr := providerRateLimited(w, err); httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
 	if err != nil {
 		tr.FailureCode = strconv.FormatInt(http.StatusInternalServerError, 10)
 		tr.FailureDescription = err.Error()
 		tr.Status = models.FailedState
 		tx.Create(tr)
+		if order.CouponCode != "" {
+			if err := models.ReleaseCoupon(tx, order.InstanceID, order.CouponCode); err != nil {
+				log.WithError(err).Error("Failed to release coupon")
+			}
+		}
 		tx.Commit()
 		return internalServerError("There was an error charging your card: %v", err).WithInternalError(err)
 	}
 
+	if result != nil && result.Pending {
+		tr.Status = models.PendingState
+		tr.Instructions = result.Instructions
+		tx.Create(tr)
+		order.PaymentProcessor = provider.Name()
+		order.InvoiceNumber = invoiceNumber
+		awaitManualPayment(tx, r, config, order, log)
+		tx.Commit()
+
+		go func() {
+			if err := mailer.PaymentInstructionsMail(tr); err != nil {
+				log.WithError(err).Error("Error sending payment instructions mail")
+			}
+		}()
+		return sendJSON(w, http.StatusOK, presentTransaction(r, tr))
+	}
+
 	// mark order and transaction as paid
 	tr.Status = models.PaidState
+	if result != nil && result.Authorized && canCapture {
+		captureAt := scheduleCapture(config, capturer, time.Now())
+		tr.CaptureAt = &captureAt
+	}
 	tx.Create(tr)
 	order.PaymentProcessor = provider.Name()
-	order.PaymentState = models.PaidState
 	order.InvoiceNumber = invoiceNumber
+	a.completePayment(tx, r, config, order, tr, log)
+	tx.Commit()
+
+	a.issuePaidLicenses(config, order, log)
+	a.sendPaymentMails(mailer, tr, config, log)
+	return sendJSON(w, http.StatusOK, presentTransaction(r, tr))
+}
+
+// completePayment marks an order as paid and starts everything that follows
+// the payment, like the delivery of digital items and the payment webhook.
+// The caller commits tx, then issues the licenses and sends the payment mails.
+func (a *API) completePayment(tx *gorm.DB, r *http.Request, config *conf.Configuration, order *models.Order, tr *models.Transaction, log logrus.FieldLogger) {
+	order.PaymentState = models.PaidState
+	order.PaymentDueAt = nil
+	paidAt := time.Now()
+	order.PaidAt = &paidAt
+	order.EstimatedDelivery = estimateDelivery(config, order, paidAt)
+	// retried in case the confirmation email sent below never goes out
+	confirmationRetryAt := paidAt.Add(confirmationBackoff)
+	order.ConfirmationRetryAt = &confirmationRetryAt
+	if config.Review.HoldOverAmount > 0 && order.Total > config.Review.HoldOverAmount {
+		log.Infof("Holding order %s with a total of %d for review", order.ID, order.Total)
+		order.FulfillmentState = models.OnHoldState
+		models.LogEvent(tx, r.RemoteAddr, order.UserID, order.ID, models.EventHeld, []string{"fulfillment_state"})
+		enqueueWebhook(tx, config, "order.held", order.UserID, order.ID, order, log)
+	}
+	// digital items of held orders are delivered once they're approved
+	if !order.Withheld() && order.FulfillDigitalItems() {
+		models.LogEvent(tx, r.RemoteAddr, order.UserID, order.ID, models.EventFulfilled, []string{"fulfillment_state"})
+	}
 	tx.Save(order)
 
-	if config.Webhooks.Payment != "" {
-		hook, err := models.NewHook("payment", config.SiteURL, config.Webhooks.Payment, order.UserID, config.Webhooks.Secret, order)
-		if err != nil {
-			log.WithError(err).Error("Failed to process webhook")
+	if order.InvoiceNumber != 0 {
+		if _, err := issueInvoice(tx, config, order, r.RemoteAddr, order.UserID); err != nil {
+			log.WithError(err).Error("Failed to issue invoice")
 		}
-		tx.Save(hook)
 	}
 
-	tx.Commit()
+	if err := models.CommitReservations(tx, order.ID); err != nil {
+		log.WithError(err).Error("Failed to commit inventory reservations")
+	}
 
-	go func() {
-		err1 := mailer.OrderConfirmationMail(tr)
-		err2 := mailer.OrderReceivedMail(tr)
+	enqueueWebhook(tx, config, "payment", order.UserID, order.ID, newPaymentHookPayload(order, tr), log)
+}
 
-		if err1 != nil || err2 != nil {
-			log.Errorf("Error sending order confirmation mails: %v %v", err1, err2)
+// sendPaymentMails sends the order confirmation and notifies the shop of a
+// paid order in the background.
+func (a *API) sendPaymentMails(m mailer.Mailer, tr *models.Transaction, config *conf.Configuration, log logrus.FieldLogger) {
+	go func() {
+		if err := sendConfirmation(a.db, m, tr, config.Mailer.ConfirmationAttempts, log); err != nil {
+			log.WithError(err).Error("Error sending order confirmation mail")
+		}
+		if err := m.OrderReceivedMail(tr); err != nil {
+			log.WithError(err).Error("Error sending order received mail")
 		}
 	}()
-
-	return sendJSON(w, http.StatusOK, tr)
 }
 
 // PaymentList will list all the payments that meet the criteria. It is only available to admins.
@@ -221,7 +416,7 @@ func (a *API) PaymentList(w http.ResponseWriter, r *http.Request) error {
 	if httpErr != nil {
 		return httpErr
 	}
-	return sendJSON(w, http.StatusOK, trans)
+	return sendJSON(w, http.StatusOK, presentTransactions(r, trans))
 }
 
 // PaymentView returns information about a single payment. It is only available to admins.
@@ -231,7 +426,15 @@ func (a *API) PaymentView(w http.ResponseWriter, r *http.Request) error {
 	if httpErr != nil {
 		return httpErr
 	}
-	return sendJSON(w, http.StatusOK, trans)
+	if trans.RawResponse == "" {
+		return sendJSON(w, http.StatusOK, presentTransaction(r, trans))
+	}
+
+	detail := &transactionDetail{Transaction: trans, Amount: trans.Amount, RawResponse: json.RawMessage(trans.RawResponse)}
+	if wantsDecimalAmounts(r) {
+		detail.Amount = decimalAmount(trans.Amount)
+	}
+	return sendJSON(w, http.StatusOK, detail)
 }
 
 // PaymentRefund refunds a transaction for a specific amount. This allows partial
@@ -239,7 +442,7 @@ func (a *API) PaymentView(w http.ResponseWriter, r *http.Request) error {
 func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	config := gcontext.GetConfig(ctx)
-	params := PaymentParams{Currency: "USD"}
+	params := RefundParams{PaymentParams: PaymentParams{Currency: "USD"}}
 	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
 		return badRequestError("Could not read params: %v", err)
@@ -255,6 +458,20 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Currencies do not match - %v vs %v", trans.Currency, params.Currency)
 	}
 
+	var refundItems []*models.RefundItem
+	if len(params.LineItems) > 0 {
+		items, total, httpErr := allocateRefund(a.db, trans.OrderID, params.LineItems)
+		if httpErr != nil {
+			return httpErr
+		}
+		if params.Amount == 0 {
+			params.Amount = total
+		} else if params.Amount != total {
+			return badRequestError("The refund amount %d doesn't match the sum of its line items %d", params.Amount, total)
+		}
+		refundItems = items
+	}
+
 	if params.Amount <= 0 || params.Amount > trans.Amount {
 		return badRequestError("The balance of the refund must be between 0 and the total amount")
 	}
@@ -267,6 +484,17 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Can't refund a transaction that hasn't been paid")
 	}
 
+	if trans.CaptureAt != nil {
+		return badRequestError("Can't refund a payment before it's captured")
+	}
+
+	if params.Reason == "" {
+		return badRequestError("A refund requires a 'reason'")
+	}
+	if !params.Reason.Valid() {
+		return badRequestError("Unknown refund reason '%s', must be one of %v", params.Reason, models.RefundReasons)
+	}
+
 	log := getLogEntry(r)
 	order, httpErr := queryForOrder(a.db, trans.OrderID, log)
 	if httpErr != nil {
@@ -276,6 +504,21 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Order does not specify a payment provider")
 	}
 
+	if maxAge := time.Duration(config.Payment.MaxRefundAge) * time.Second; maxAge > 0 {
+		// orders paid before the payment time was recorded fall back to
+		// the time of the charge
+		paidAt := trans.CreatedAt
+		if order.PaidAt != nil {
+			paidAt = *order.PaidAt
+		}
+		if age := time.Since(paidAt); age > maxAge {
+			if !params.OverrideMaxAge {
+				return httpError(http.StatusUnprocessableEntity, "Order was paid %s ago, refunds are only allowed within %s", formatAge(age), formatAge(maxAge))
+			}
+			log.Infof("Refunding order %s paid %s ago, overriding the maximum refund age", order.ID, formatAge(age))
+		}
+	}
+
 	provider := gcontext.GetPaymentProviders(ctx)[order.PaymentProcessor]
 	if provider == nil {
 		return badRequestError("Payment provider '%s' not configured", order.PaymentProcessor)
@@ -295,34 +538,50 @@ func (a *API) PaymentRefund(w http.ResponseWriter, r *http.Request) error {
 		OrderID:    trans.OrderID,
 		Type:       models.RefundTransactionType,
 		Status:     models.PendingState,
+
+		RefundReason: params.Reason,
+		RefundItems:  refundItems,
 	}
 
 	tx := a.db.Begin()
 	tx.Create(m)
 	provID := provider.Name()
 	log.Debugf("Starting refund to %s", provID)
-	refundID, err := refund(trans.ProcessorID, params.Amount, params.Currency)
+	result, err := refund(trans.ProcessorID, params.Amount, params.Currency, params.Reason)
+	if httpErr := providerRateLimited(w, err); httpErr != nil {
+		tx.Rollback()
+		return httpErr
+	}
 	if err != nil {
 		log.WithError(err).Info("Failed to refund value")
 		m.FailureCode = strconv.FormatInt(http.StatusInternalServerError, 10)
 		m.FailureDescription = err.Error()
 		m.Status = models.FailedState
 	} else {
-		m.ProcessorID = refundID
+		m.ProcessorID = result.ID
 		m.Status = models.PaidState
+		if result.Fees != nil {
+			m.Fee = &result.Fees.Fee
+			m.NetAmount = &result.Fees.Net
+		} else {
+			log.Infof("%s didn't report the fees of refund %s", provID, result.ID)
+		}
 	}
 
 	log.Infof("Finished transaction with %s: %s", provID, m.ProcessorID)
 	tx.Save(m)
-	if config.Webhooks.Refund != "" {
-		hook, err := models.NewHook("refund", config.SiteURL, config.Webhooks.Refund, m.UserID, config.Webhooks.Secret, m)
-		if err != nil {
-			log.WithError(err).Error("Failed to process webhook")
+	if m.Status == models.PaidState {
+		if invoice, err := models.FindInvoice(tx, order.ID); err != nil {
+			log.WithError(err).Error("Error loading invoice for credit note")
+		} else if invoice != nil {
+			if _, err := issueCreditNote(tx, invoice, m.Amount, "Refund: "+string(m.RefundReason)); err != nil {
+				log.WithError(err).Error("Error issuing credit note for refund")
+			}
 		}
-		tx.Save(hook)
 	}
+	enqueueWebhook(tx, config, "refund", m.UserID, m.OrderID, m, log)
 	tx.Commit()
-	return sendJSON(w, http.StatusOK, m)
+	return sendJSON(w, http.StatusOK, presentTransaction(r, m))
 }
 
 // PreauthorizePayment creates a new payment that can be authorized in the browser
@@ -368,6 +627,9 @@ func (a *API) PreauthorizePayment(w http.ResponseWriter, r *http.Request) error
 	}
 
 	paymentResult, err := preauthorize(params.Amount, params.Currency, params.Description)
+	if httpErr := providerRateLimited(w, err); httpErr != nil {
+		return httpErr
+	}
 	if err != nil {
 		return internalServerError("Error preauthorizing payment: %v", err).WithInternalError(err)
 	}
@@ -378,6 +640,17 @@ func (a *API) PreauthorizePayment(w http.ResponseWriter, r *http.Request) error
 // ------------------------------------------------------------------------------------------------
 // Helpers
 // ------------------------------------------------------------------------------------------------
+// providerRateLimited returns a 429 if the payment provider rate limited a
+// request, telling the client to try again when the provider asked us to.
+func providerRateLimited(w http.ResponseWriter, err error) *HTTPError {
+	e, ok := errors.Cause(err).(*payments.RateLimitError)
+	if !ok {
+		return nil
+	}
+	setRetryAfter(w, e.RetryAfter)
+	return httpError(http.StatusTooManyRequests, "The payment provider is rate limiting requests, try again in %v", e.RetryAfter.Round(time.Second)).WithInternalError(err)
+}
+
 func (a *API) getTransaction(payID string) (*models.Transaction, *HTTPError) {
 	trans, err := models.GetTransaction(a.db, payID)
 	if err != nil {
@@ -397,6 +670,15 @@ func (a *API) verifyAmount(ctx context.Context, order *models.Order, amount uint
 	return nil
 }
 
+// formatAge formats a duration in whole days, or hours and minutes if it's
+// shorter than two days.
+func formatAge(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.Round(time.Minute).String()
+}
+
 func queryForOrder(db *gorm.DB, orderID string, log logrus.FieldLogger) (*models.Order, *HTTPError) {
 	order := &models.Order{}
 	if rsp := db.Preload("Transactions").Find(order, "id = ?", orderID); rsp.Error != nil {
@@ -420,6 +702,35 @@ func queryForTransactions(db *gorm.DB, log logrus.FieldLogger, clause, id string
 	return trans, nil
 }
 
+// paymentRoutingEnabled reports whether payments are routed to providers by
+// their currency.
+func paymentRoutingEnabled(c *conf.Configuration) bool {
+	return len(c.Payment.Routing.Currencies) > 0 || c.Payment.Routing.Default != ""
+}
+
+// routePaymentProvider picks the provider configured for a currency, falling
+// back to the default provider. It returns nil if the currency can't be routed.
+func routePaymentProvider(c *conf.Configuration, provs map[string]payments.Provider, currency string) payments.Provider {
+	name, ok := c.Payment.Routing.Currencies[strings.ToUpper(currency)]
+	if !ok {
+		name = c.Payment.Routing.Default
+	}
+	return provs[strings.ToLower(name)]
+}
+
+// validatePaymentRoutes makes sure every provider the routing refers to is enabled.
+func validatePaymentRoutes(c *conf.Configuration, provs map[string]payments.Provider) error {
+	for currency, name := range c.Payment.Routing.Currencies {
+		if provs[strings.ToLower(name)] == nil {
+			return fmt.Errorf("Payments in %s are routed to '%s', which is not enabled", currency, name)
+		}
+	}
+	if name := c.Payment.Routing.Default; name != "" && provs[strings.ToLower(name)] == nil {
+		return fmt.Errorf("The default payment provider '%s' is not enabled", name)
+	}
+	return nil
+}
+
 // createPaymentProviders creates instance(s) of Provider based on the configuration
 // provided.
 func createPaymentProviders(c *conf.Configuration) (map[string]payments.Provider, error) {
@@ -444,5 +755,28 @@ func createPaymentProviders(c *conf.Configuration) (map[string]payments.Provider
 		}
 		provs[p.Name()] = p
 	}
+	if c.Payment.Braintree.Enabled {
+		p, err := braintree.NewPaymentProvider(braintree.Config{
+			Env:        c.Payment.Braintree.Env,
+			MerchantID: c.Payment.Braintree.MerchantID,
+			PublicKey:  c.Payment.Braintree.PublicKey,
+			PrivateKey: c.Payment.Braintree.PrivateKey,
+
+			MerchantAccounts: c.Payment.Braintree.MerchantAccounts,
+		})
+		if err != nil {
+			return nil, err
+		}
+		provs[p.Name()] = p
+	}
+	if c.Payment.Manual.Enabled {
+		p, err := manual.NewPaymentProvider(manual.Config{
+			Instructions: c.Payment.Manual.Instructions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		provs[p.Name()] = p
+	}
 	return provs, nil
 }
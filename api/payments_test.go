@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/stretchr/testify/assert"
@@ -181,6 +182,32 @@ func TestPaymentsView(t *testing.T) {
 	})
 }
 
+func TestPaymentsListByProviderMetadata(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Data.firstTransaction.SetProviderMetadata("stripe", map[string]interface{}{"risk_level": "elevated", "risk_score": 65})
+	require.NoError(t, test.DB.Save(test.Data.firstTransaction).Error)
+	test.Data.secondTransaction.SetProviderMetadata("paypal", map[string]interface{}{"state": "approved"})
+	require.NoError(t, test.DB.Save(test.Data.secondTransaction).Error)
+
+	token := testAdminToken("magical-unicorn", "")
+	for query, expected := range map[string][]string{
+		"metadata_key=risk_level":                                {test.Data.firstTransaction.ID},
+		"metadata_key=stripe.risk_level&metadata_value=elevated": {test.Data.firstTransaction.ID},
+		"metadata_key=risk_score&metadata_value=65":              {test.Data.firstTransaction.ID},
+		"metadata_key=paypal.risk_level":                         {},
+		"metadata_key=state&metadata_value=approved":             {test.Data.secondTransaction.ID},
+	} {
+		recorder := test.TestEndpoint(http.MethodGet, "/payments?"+query, nil, token)
+		trans := []models.Transaction{}
+		extractPayload(t, http.StatusOK, recorder, &trans)
+		ids := []string{}
+		for _, tr := range trans {
+			ids = append(ids, tr.ID)
+		}
+		assert.ElementsMatch(t, expected, ids, query)
+	}
+}
+
 func TestPaymentsRefund(t *testing.T) {
 	t.Run("MismatchedCurrency", func(t *testing.T) {
 		test := NewRouteTest(t)
@@ -235,7 +262,7 @@ func TestPaymentsRefund(t *testing.T) {
 
 		globalConfig := new(conf.GlobalConfiguration)
 		provider := &memProvider{name: payments.StripeProvider}
-		ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+		ctx, err := WithInstanceConfig(context.Background(), globalConfig, test.Config, "")
 		require.NoError(t, err)
 		ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
 
@@ -243,6 +270,7 @@ func TestPaymentsRefund(t *testing.T) {
 			Amount:      1,
 			Currency:    test.Data.firstTransaction.Currency,
 			StripeToken: "123",
+			Reason:      "defective",
 		}
 		body, err := json.Marshal(params)
 		require.NoError(t, err)
@@ -268,7 +296,118 @@ func TestPaymentsRefund(t *testing.T) {
 			assert.Empty(t, payment.FailureDescription)
 			assert.Equal(t, models.RefundTransactionType, payment.Type)
 			assert.Equal(t, models.PaidState, payment.Status)
+			assert.Equal(t, models.RefundReasonDefective, payment.RefundReason)
 		}
+		require.Len(t, provider.refundCalls, 1)
+		assert.Equal(t, models.RefundReasonDefective, provider.refundCalls[0].reason)
+		assert.Nil(t, stored.Fee, "fees the provider didn't report are unknown")
+		assert.Nil(t, stored.NetAmount)
+	})
+
+	t.Run("Fees", func(t *testing.T) {
+		test := NewRouteTest(t)
+		url := "/payments/" + test.Data.firstTransaction.ID + "/refund"
+		test.Config.Payment.Stripe.Enabled = true
+		test.Config.Payment.Stripe.SecretKey = "secret"
+
+		provider := &memProvider{name: payments.StripeProvider, refundFees: &payments.RefundFees{Fee: 25, Net: 26}}
+		ctx, err := WithInstanceConfig(context.Background(), new(conf.GlobalConfiguration), test.Config, "")
+		require.NoError(t, err)
+		ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
+
+		body := `{"amount": 1, "currency": "USD", "reason": "defective"}`
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", url, strings.NewReader(body))
+		require.NoError(t, signHTTPRequest(r, testAdminToken("magical-unicorn", ""), test.Config.JWT.Secret))
+		NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, defaultVersion).handler.ServeHTTP(w, r)
+
+		rsp := new(models.Transaction)
+		extractPayload(t, http.StatusOK, w, rsp)
+		stored := &models.Transaction{}
+		require.NoError(t, test.DB.First(stored, "id = ?", rsp.ID).Error)
+		for _, payment := range []*models.Transaction{stored, rsp} {
+			require.NotNil(t, payment.Fee)
+			require.NotNil(t, payment.NetAmount)
+			assert.EqualValues(t, 25, *payment.Fee)
+			assert.EqualValues(t, 26, *payment.NetAmount)
+		}
+	})
+
+	t.Run("MissingReason", func(t *testing.T) {
+		test := NewRouteTest(t)
+		url := "/payments/" + test.Data.firstTransaction.ID + "/refund"
+		w := runPaymentRefund(test, url, &stripePaymentParams{
+			Amount:   1,
+			Currency: test.Data.firstTransaction.Currency,
+		})
+		validateError(t, http.StatusBadRequest, w, "requires a 'reason'")
+	})
+
+	t.Run("UnknownReason", func(t *testing.T) {
+		test := NewRouteTest(t)
+		url := "/payments/" + test.Data.firstTransaction.ID + "/refund"
+		w := runPaymentRefund(test, url, &stripePaymentParams{
+			Amount:   1,
+			Currency: test.Data.firstTransaction.Currency,
+			Reason:   "bored",
+		})
+		validateError(t, http.StatusBadRequest, w, "Unknown refund reason")
+	})
+
+	t.Run("MaxAge", func(t *testing.T) {
+		refund := func(test *RouteTest, override bool) *httptest.ResponseRecorder {
+			test.Config.Payment.MaxRefundAge = 30 * 24 * 60 * 60
+			paidAt := time.Now().Add(-45 * 24 * time.Hour)
+			test.Data.firstOrder.PaidAt = &paidAt
+			require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+			globalConfig := new(conf.GlobalConfiguration)
+			provider := &memProvider{name: payments.StripeProvider}
+			ctx, err := WithInstanceConfig(context.Background(), globalConfig, test.Config, "")
+			require.NoError(t, err)
+			ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
+
+			body, err := json.Marshal(map[string]interface{}{
+				"amount":           1,
+				"currency":         test.Data.firstTransaction.Currency,
+				"reason":           "defective",
+				"override_max_age": override,
+			})
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/payments/"+test.Data.firstTransaction.ID+"/refund", bytes.NewBuffer(body))
+			require.NoError(t, signHTTPRequest(r, testAdminToken("magical-unicorn", ""), test.Config.JWT.Secret))
+			NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, defaultVersion).handler.ServeHTTP(w, r)
+			return w
+		}
+
+		t.Run("TooOld", func(t *testing.T) {
+			test := NewRouteTest(t)
+			w := refund(test, false)
+			validateError(t, http.StatusUnprocessableEntity, w, "Order was paid 45 days ago, refunds are only allowed within 30 days")
+		})
+
+		t.Run("Override", func(t *testing.T) {
+			test := NewRouteTest(t)
+			w := refund(test, true)
+			rsp := new(models.Transaction)
+			extractPayload(t, http.StatusOK, w, rsp)
+			assert.Equal(t, models.PaidState, rsp.Status)
+		})
+
+		t.Run("WithinWindow", func(t *testing.T) {
+			test := NewRouteTest(t)
+			test.Config.Payment.MaxRefundAge = 30 * 24 * 60 * 60
+			paidAt := time.Now().Add(-24 * time.Hour)
+			test.Data.firstOrder.PaidAt = &paidAt
+			require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+			w := runPaymentRefund(test, "/payments/"+test.Data.firstTransaction.ID+"/refund", &stripePaymentParams{
+				Amount:   1,
+				Currency: test.Data.firstTransaction.Currency,
+				Reason:   "defective",
+			})
+			assert.NotEqual(t, http.StatusUnprocessableEntity, w.Code)
+		})
 	})
 
 	t.Run("PayPal", func(t *testing.T) {
@@ -302,6 +441,7 @@ func TestPaymentsRefund(t *testing.T) {
 			Currency:     test.Data.secondTransaction.Currency,
 			PaypalID:     "123",
 			PaypalUserID: "456",
+			Reason:       "changed_mind",
 		}
 
 		body, err := json.Marshal(params)
@@ -316,6 +456,44 @@ func TestPaymentsRefund(t *testing.T) {
 		assert.Equal(t, 1, loginCount, "too many login calls")
 		assert.Equal(t, 1, refundCount, "too many refund calls")
 	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		test := NewRouteTest(t)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/oauth2/token":
+				w.Header().Add("Content-Type", "application/json")
+				fmt.Fprint(w, `{"access_token":"EEwJ6tF9x5WCIZDYzyZGaz6Khbw7raYRIBV_WxVvgmsG","expires_in":100000}`)
+			case "/v1/payments/sale/" + test.Data.secondTransaction.ProcessorID + "/refund":
+				w.Header().Add("Content-Type", "application/json")
+				w.Header().Add("Retry-After", "12")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"name":"RATE_LIMIT_REACHED","message":"Too many requests"}`)
+			default:
+				w.WriteHeader(500)
+				t.Fatalf("unknown PayPal API call to %s", r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		test.Config.Payment.PayPal.Enabled = true
+		test.Config.Payment.PayPal.ClientID = "clientid"
+		test.Config.Payment.PayPal.Secret = "secret"
+		test.Config.Payment.PayPal.Env = server.URL
+
+		params := &paypalPaymentParams{
+			Amount:   1,
+			Currency: test.Data.secondTransaction.Currency,
+			Reason:   "changed_mind",
+		}
+		recorder := runPaymentRefund(test, "/payments/"+test.Data.secondTransaction.ID+"/refund", params)
+		validateError(t, http.StatusTooManyRequests, recorder, "try again in 12s")
+		assert.Equal(t, "12", recorder.Header().Get("Retry-After"))
+
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Transaction{}).Where("type = ?", models.RefundTransactionType).Count(&count).Error)
+		assert.Equal(t, 0, count)
+	})
 }
 
 func runPaymentRefund(test *RouteTest, url string, params interface{}) *httptest.ResponseRecorder {
@@ -432,6 +610,16 @@ func TestPaymentCreate(t *testing.T) {
 				fmt.Println("meta:", payload.Metadata)
 				assert.Equal(t, test.Data.firstOrder.ID, payload.Metadata["order_id"])
 				assert.Equal(t, "1", payload.Metadata["invoice_number"])
+				assert.Equal(t, "203.0.113.7", payload.Metadata["customer_ip"])
+				assert.Equal(t, "Mozilla/5.0 (Macintosh)", payload.Metadata["user_agent"])
+				charge := v.(*stripe.Charge)
+				charge.ID = "ch_123"
+				charge.Outcome = &stripe.ChargeOutcome{NetworkStatus: "approved_by_network", RiskLevel: "normal", RiskScore: 12}
+				charge.Source = &stripe.PaymentSource{Card: &stripe.Card{
+					Brand:   stripe.CardBrandVisa,
+					Funding: stripe.CardFundingCredit,
+					Last4:   "4242",
+				}}
 				callCount++
 			default:
 				t.Fatalf("unknown Stripe API call to %s", path)
@@ -440,6 +628,8 @@ func TestPaymentCreate(t *testing.T) {
 		defer stripe.SetBackend(stripe.APIBackend, nil)
 
 		test.Data.firstOrder.PaymentState = models.PendingState
+		test.Data.firstOrder.IP = "203.0.113.7"
+		test.Data.firstOrder.UserAgent = "Mozilla/5.0 (Macintosh)"
 		rsp := test.DB.Save(test.Data.firstOrder)
 		require.NoError(t, rsp.Error, "Failed to update order")
 
@@ -458,8 +648,217 @@ func TestPaymentCreate(t *testing.T) {
 		trans := models.Transaction{}
 		extractPayload(t, http.StatusOK, recorder, &trans)
 		assert.Equal(t, models.PaidState, trans.Status)
+		assert.Equal(t, "ch_123", trans.ProcessorID)
+		assert.Equal(t, "Visa", trans.CardBrand)
+		assert.Equal(t, "4242", trans.CardLast4)
+		assert.Equal(t, "credit", trans.CardFunding)
 		assert.Equal(t, 1, callCount)
+
+		stored, err := models.GetTransaction(test.DB, trans.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "4242", stored.CardLast4)
+		metadata, ok := stored.ProviderMetadata[payments.StripeProvider].(map[string]interface{})
+		require.True(t, ok)
+		assert.Equal(t, "normal", metadata["risk_level"])
+		assert.EqualValues(t, 12, metadata["risk_score"])
+		assert.NotContains(t, recorder.Body.String(), "raw_response")
+
+		token := testAdminToken("magical-unicorn", "")
+		recorder = test.TestEndpoint(http.MethodGet, "/payments/"+trans.ID, nil, token)
+		detail := struct {
+			ID          string          `json:"id"`
+			RawResponse json.RawMessage `json:"raw_response"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &detail)
+		assert.Equal(t, trans.ID, detail.ID)
+		assert.Contains(t, string(detail.RawResponse), "ch_123")
+		assert.Contains(t, string(detail.RawResponse), "approved_by_network")
+	})
+}
+
+func TestPaymentCreateHoldForReview(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.Review.HoldOverAmount = 1
+	test.Config.Webhooks.Categories = map[string]string{"review": "https://review.example.com/hooks"}
+
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		if path != "/charges" {
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	test.Data.firstOrder.PaymentState = models.PendingState
+	rsp := test.DB.Save(test.Data.firstOrder)
+	require.NoError(t, rsp.Error, "Failed to update order")
+	require.NoError(t, test.DB.Model(test.Data.firstLineItem).Updates(map[string]interface{}{
+		"fulfillment_type": models.DigitalFulfillment,
+		"license_format":   models.DefaultLicenseFormat,
+	}).Error)
+
+	params := &stripePaymentParams{
+		Amount:      test.Data.firstOrder.Total,
+		Currency:    test.Data.firstOrder.Currency,
+		StripeToken: "123456",
+		Provider:    payments.StripeProvider,
+	}
+
+	body, err := json.Marshal(params)
+	require.NoError(t, err)
+
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	extractPayload(t, http.StatusOK, recorder, &models.Transaction{})
+
+	saved := &models.Order{}
+	require.NoError(t, test.DB.First(saved, "id = ?", "first-order").Error)
+	assert.Equal(t, models.PaidState, saved.PaymentState)
+	assert.NotNil(t, saved.PaidAt)
+	assert.Equal(t, models.OnHoldState, saved.FulfillmentState)
+
+	event := &models.Event{}
+	assert.NoError(t, test.DB.First(event, "order_id = ? AND type = ?", "first-order", models.EventHeld).Error)
+
+	hook := &models.Hook{}
+	require.NoError(t, test.DB.First(hook, "order_id = ? AND type = ?", "first-order", "order.held").Error)
+	assert.Equal(t, "https://review.example.com/hooks", hook.URL)
+
+	item := &models.LineItem{}
+	require.NoError(t, test.DB.First(item, "id = ?", test.Data.firstLineItem.ID).Error)
+	assert.NotEqual(t, models.FulfilledState, item.FulfillmentState, "digital items wait for the review")
+	count := 0
+	require.NoError(t, test.DB.Model(&models.License{}).Where("order_id = ?", "first-order").Count(&count).Error)
+	assert.Equal(t, 0, count)
+}
+
+func TestPaymentCreateTotalCheck(t *testing.T) {
+	pay := func(t *testing.T, skip bool) (*httptest.ResponseRecorder, int) {
+		test := NewRouteTest(t)
+		test.Config.Payment.SkipTotalCheck = skip
+
+		callCount := 0
+		stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+			callCount++
+		}))
+		defer stripe.SetBackend(stripe.APIBackend, nil)
+
+		test.Data.firstOrder.PaymentState = models.PendingState
+		test.Data.firstOrder.Taxes++
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		body, err := json.Marshal(&stripePaymentParams{
+			Amount:      test.Data.firstOrder.Total,
+			Currency:    test.Data.firstOrder.Currency,
+			StripeToken: "123456",
+			Provider:    payments.StripeProvider,
+		})
+		require.NoError(t, err)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+		return recorder, callCount
+	}
+
+	t.Run("Inconsistent", func(t *testing.T) {
+		recorder, callCount := pay(t, false)
+		validateError(t, http.StatusInternalServerError, recorder, "total of this order is inconsistent")
+		assert.Equal(t, 0, callCount)
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		recorder, callCount := pay(t, true)
+		assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, 1, callCount)
+	})
+}
+
+func TestPaymentCreateRouting(t *testing.T) {
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		if path != "/charges" {
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	pay := func(test *RouteTest, currency, provider string) *httptest.ResponseRecorder {
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		params := &stripePaymentParams{
+			Amount:      test.Data.firstOrder.Total,
+			Currency:    currency,
+			StripeToken: "123456",
+			Provider:    provider,
+		}
+		body, err := json.Marshal(params)
+		require.NoError(t, err)
+		return test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	}
+
+	t.Run("ByCurrency", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Payment.Routing.Currencies = map[string]string{"USD": "stripe"}
+
+		trans := &models.Transaction{}
+		extractPayload(t, http.StatusOK, pay(test, "USD", ""), trans)
+		assert.Equal(t, models.PaidState, trans.Status)
+	})
+
+	t.Run("Unroutable", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Payment.Routing.Currencies = map[string]string{"USD": "stripe"}
+		validateError(t, http.StatusBadRequest, pay(test, "EUR", ""), "No payment provider is available for payments in EUR")
+	})
+
+	t.Run("ProviderMismatch", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Payment.Routing.Default = "stripe"
+		validateError(t, http.StatusBadRequest, pay(test, "USD", payments.PayPalProvider), "Payments in USD must use the 'stripe' provider")
 	})
+
+	t.Run("DisabledProvider", func(t *testing.T) {
+		config := &conf.Configuration{}
+		config.Payment.Routing.Currencies = map[string]string{"BRL": "braintree"}
+		assert.Error(t, validatePaymentRoutes(config, map[string]payments.Provider{}))
+	})
+}
+
+func TestPaymentWebhookAttribution(t *testing.T) {
+	stripe.SetBackend(stripe.APIBackend, NewTrackingStripeBackend(func(method, path, key string, params stripe.ParamsContainer, v interface{}) {
+		if path != "/charges" {
+			t.Fatalf("unknown Stripe API call to %s", path)
+		}
+		v.(*stripe.Charge).Source = &stripe.PaymentSource{Card: &stripe.Card{Brand: stripe.CardBrandVisa, Last4: "4242"}}
+	}))
+	defer stripe.SetBackend(stripe.APIBackend, nil)
+
+	test := NewRouteTest(t)
+	test.Config.Webhooks.Payment = "https://example.com/hooks/payment"
+	test.Data.firstOrder.PaymentState = models.PendingState
+	test.Data.firstOrder.Attribution = models.Attribution{Source: "newsletter", Campaign: "spring-sale"}
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+	params := &stripePaymentParams{
+		Amount:      test.Data.firstOrder.Total,
+		Currency:    test.Data.firstOrder.Currency,
+		StripeToken: "123456",
+		Provider:    payments.StripeProvider,
+	}
+	body, err := json.Marshal(params)
+	require.NoError(t, err)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/payments", bytes.NewBuffer(body), test.Data.testUserToken)
+	extractPayload(t, http.StatusOK, recorder, &models.Transaction{})
+
+	hook := &models.Hook{}
+	require.NoError(t, test.DB.First(hook, "order_id = ? AND type = ?", test.Data.firstOrder.ID, "payment").Error)
+	payload := &models.Order{}
+	require.NoError(t, json.Unmarshal([]byte(hook.Payload), payload))
+	assert.Equal(t, test.Data.firstOrder.ID, payload.ID)
+	assert.Equal(t, "newsletter", payload.Attribution.Source)
+	assert.Equal(t, "spring-sale", payload.Attribution.Campaign)
+
+	card := struct {
+		Card map[string]string `json:"card"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(hook.Payload), &card))
+	assert.Equal(t, map[string]string{"brand": "Visa", "last4": "4242"}, card.Card)
 }
 
 func TestPaymentPreauthorize(t *testing.T) {
@@ -514,7 +913,7 @@ func TestPaymentPreauthorize(t *testing.T) {
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
 
 			globalConfig := new(conf.GlobalConfiguration)
-			ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+			ctx, err := WithInstanceConfig(context.Background(), globalConfig, test.Config, "")
 			require.NoError(t, err)
 			NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, "").handler.ServeHTTP(recorder, req)
 
@@ -555,7 +954,7 @@ func TestPaymentPreauthorize(t *testing.T) {
 			req.Header.Set("Content-Type", "application/json")
 
 			globalConfig := new(conf.GlobalConfiguration)
-			ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, test.Config, "")
+			ctx, err := WithInstanceConfig(context.Background(), globalConfig, test.Config, "")
 			require.NoError(t, err)
 			NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, "").handler.ServeHTTP(recorder, req)
 
@@ -572,6 +971,43 @@ func TestPaymentPreauthorize(t *testing.T) {
 			assert.Equal(t, "test", createData.Transactions[0].Description)
 		})
 	})
+	t.Run("Braintree", func(t *testing.T) {
+		var tokenCount int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/merchants/merchantid/client_token":
+				w.Header().Add("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `<client-token><value>client-token-123</value></client-token>`)
+				tokenCount++
+			default:
+				w.WriteHeader(500)
+				t.Fatalf("unknown Braintree API call to %s", r.URL.Path)
+			}
+		}))
+		defer server.Close()
+
+		test := NewRouteTest(t)
+		test.Config.Payment.Braintree.Enabled = true
+		test.Config.Payment.Braintree.MerchantID = "merchantid"
+		test.Config.Payment.Braintree.PublicKey = "publickey"
+		test.Config.Payment.Braintree.PrivateKey = "privatekey"
+		test.Config.Payment.Braintree.Env = server.URL
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, baseURL+"/braintree", strings.NewReader(`{"provider":"braintree"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		globalConfig := new(conf.GlobalConfiguration)
+		ctx, err := WithInstanceConfig(context.Background(), globalConfig, test.Config, "")
+		require.NoError(t, err)
+		NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, "").handler.ServeHTTP(recorder, req)
+
+		rsp := payments.PreauthorizationResult{}
+		extractPayload(t, http.StatusOK, recorder, &rsp)
+		assert.Equal(t, "client-token-123", rsp.ClientToken)
+		assert.Equal(t, 1, tokenCount)
+	})
 }
 
 // ------------------------------------------------------------------------------------------------
@@ -612,6 +1048,7 @@ type stripePaymentParams struct {
 	Currency    string `json:"currency"`
 	StripeToken string `json:"stripe_token"`
 	Provider    string `json:"provider"`
+	Reason      string `json:"reason,omitempty"`
 }
 
 type paypalPaymentParams struct {
@@ -621,6 +1058,7 @@ type paypalPaymentParams struct {
 	PaypalUserID string `json:"paypal_user_id"`
 	Provider     string `json:"provider"`
 	OrderID      string `json:"order_id"`
+	Reason       string `json:"reason,omitempty"`
 }
 
 type paypalPreauthorizeParams struct {
@@ -647,6 +1085,7 @@ type paypalPaymentCreateParams struct {
 
 type memProvider struct {
 	refundCalls []refundCall
+	refundFees  *payments.RefundFees
 	name        string
 }
 
@@ -654,6 +1093,7 @@ type refundCall struct {
 	amount   uint64
 	id       string
 	currency string
+	reason   models.RefundReason
 }
 
 func (mp *memProvider) Name() string {
@@ -669,11 +1109,11 @@ func (mp *memProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (p
 	return mp.preauthorize, nil
 }
 
-func (mp *memProvider) charge(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
-	return "", errors.New("Shouldn't have called this")
+func (mp *memProvider) charge(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+	return nil, errors.New("Shouldn't have called this")
 }
 
-func (mp *memProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
+func (mp *memProvider) refund(transactionID string, amount uint64, currency string, reason models.RefundReason) (*payments.RefundResult, error) {
 	if mp.refundCalls == nil {
 		mp.refundCalls = []refundCall{}
 	}
@@ -681,9 +1121,10 @@ func (mp *memProvider) refund(transactionID string, amount uint64, currency stri
 		amount:   amount,
 		id:       transactionID,
 		currency: currency,
+		reason:   reason,
 	})
 
-	return fmt.Sprintf("trans-%d", len(mp.refundCalls)), nil
+	return &payments.RefundResult{ID: fmt.Sprintf("trans-%d", len(mp.refundCalls)), Fees: mp.refundFees}, nil
 }
 
 func (mp *memProvider) preauthorize(amount uint64, currency string, description string) (*payments.PreauthorizationResult, error) {
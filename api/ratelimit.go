@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	gcontext "github.com/netlify/gocommerce/context"
+)
+
+const userListRateLimit = 60
+const userListRateWindow = time.Minute
+
+// rateLimiter allows a fixed number of requests per key within a time window.
+type rateLimiter struct {
+	sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow counts a request for the key. If the limit has been reached, it
+// returns false and the time until the next request is allowed.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.window {
+		l.prune(now)
+		win = &rateWindow{start: now}
+		l.windows[key] = win
+	}
+
+	if win.count >= l.limit {
+		return false, win.start.Add(l.window).Sub(now)
+	}
+	win.count++
+	return true, 0
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	for key, win := range l.windows {
+		if now.Sub(win.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// setRetryAfter tells clients how many seconds to wait before trying again.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// rateLimit limits requests per user, or per IP for anonymous requests.
+func rateLimit(l *rateLimiter) middlewareHandler {
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		key := r.RemoteAddr
+		if claims := gcontext.GetClaims(r.Context()); claims != nil && claims.Subject != "" {
+			key = claims.Subject
+		}
+
+		if ok, retryAfter := l.allow(key); !ok {
+			setRetryAfter(w, retryAfter)
+			return nil, httpError(http.StatusTooManyRequests, "Rate limit exceeded, try again in %v", retryAfter.Round(time.Second))
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/netlify/gocommerce/calculator"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+// orderTotals are the amounts of an order that depend on the pricing rules.
+type orderTotals struct {
+	CouponCode   string               `json:"coupon,omitempty"`
+	SubTotal     uint64               `json:"subtotal"`
+	Discount     uint64               `json:"discount"`
+	NetTotal     uint64               `json:"net_total"`
+	Taxes        uint64               `json:"taxes"`
+	TaxBreakdown []calculator.TaxItem `json:"tax_breakdown"`
+	Tip          uint64               `json:"tip"`
+	Total        uint64               `json:"total"`
+}
+
+func newOrderTotals(order *models.Order) *orderTotals {
+	return &orderTotals{
+		CouponCode:   order.CouponCode,
+		SubTotal:     order.SubTotal,
+		Discount:     order.Discount,
+		NetTotal:     order.NetTotal,
+		Taxes:        order.Taxes,
+		TaxBreakdown: order.TaxBreakdown,
+		Tip:          order.Tip,
+		Total:        order.Total,
+	}
+}
+
+type recalculationResponse struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+	Order  interface{} `json:"order"`
+}
+
+// OrderRecalculate calculates the totals of a pending order again with the
+// current site settings, product data and coupon definitions, e.g. after tax
+// rates changed. The line items are loaded from their products again, like
+// when the order was created. A coupon that no longer exists or isn't valid
+// anymore is removed from the order. The response holds the totals before and
+// after the recalculation.
+func (a *API) OrderRecalculate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	config := gcontext.GetConfig(ctx)
+	claims := gcontext.GetClaims(ctx)
+	log := getLogEntry(r)
+
+	order := &models.Order{}
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Order not found")
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+
+	if !hasOrderAccess(ctx, order) {
+		return unauthorizedError("You don't have access to this order")
+	}
+	if order.PaymentState != models.PendingState {
+		return badRequestError("Only pending orders can be recalculated")
+	}
+
+	before := newOrderTotals(order)
+	changes := []string{"totals"}
+
+	if order.CouponCode != "" {
+		coupon, err := a.lookupCoupon(ctx, w, order.CouponCode)
+		if err != nil {
+			if httpErr, ok := err.(*HTTPError); !ok || httpErr.Code != http.StatusNotFound {
+				return err
+			}
+		}
+		// pending orders haven't redeemed their coupon yet, so there's no
+		// redemption to give back when it's removed
+		if coupon == nil || !coupon.Valid() {
+			log.Infof("Removing coupon %s from order %s, it's not valid anymore", order.CouponCode, order.ID)
+			order.CouponCode = ""
+			order.Coupon = nil
+			order.RawCoupon = ""
+			changes = append(changes, "coupon")
+		} else {
+			order.Coupon = coupon
+		}
+	}
+
+	for _, item := range order.LineItems {
+		if item.Free {
+			continue
+		}
+		orderItem := &orderLineItem{Sku: item.Sku, Path: item.Path, Quantity: item.Quantity, Measure: item.Measure}
+		if err := a.processLineItem(ctx, order, item, orderItem); err != nil {
+			return internalServerError("Error processing line item").WithInternalError(err)
+		}
+	}
+	changes = append(changes, "line_items")
+
+	settings, err := a.loadSettings(ctx)
+	if err != nil {
+		return internalServerError("%v", err).WithInternalError(err)
+	}
+
+	tx := a.db.Begin()
+	if err := a.updateFreeProduct(ctx, tx, config, order); err != nil {
+		tx.Rollback()
+		if outOfStock, ok := err.(*models.OutOfStockError); ok {
+			return badRequestError("Product %s is out of stock", outOfStock.Sku)
+		}
+		return internalServerError("Error updating free product").WithInternalError(err)
+	}
+	if err := order.CalculateTotal(settings, gcontext.GetClaimsAsMap(ctx), log); err != nil {
+		tx.Rollback()
+		return internalServerError("Error calculating the order total: %v", err).WithInternalError(err)
+	}
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving recalculated order").WithInternalError(rsp.Error)
+	}
+
+	subject := order.UserID
+	if claims != nil {
+		subject = claims.Subject
+	}
+	models.LogEvent(tx, r.RemoteAddr, subject, order.ID, models.EventRecalculated, changes)
+	enqueueWebhook(tx, config, "update", subject, order.ID, order, log)
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error committing recalculated order").WithInternalError(rsp.Error)
+	}
+
+	log.WithFields(logrus.Fields{
+		"old_total": before.Total,
+		"new_total": order.Total,
+	}).Infof("Recalculated order %s", order.ID)
+	return sendJSON(w, http.StatusOK, &recalculationResponse{
+		Before: presentOrderTotals(r, before),
+		After:  presentOrderTotals(r, newOrderTotals(order)),
+		Order:  presentOrder(r, order),
+	})
+}
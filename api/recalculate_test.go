@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recalculationPayload struct {
+	Before orderTotals   `json:"before"`
+	After  orderTotals   `json:"after"`
+	Order  *models.Order `json:"order"`
+}
+
+func TestOrderRecalculate(t *testing.T) {
+	settings := &calculator.Settings{}
+	coupons := `{"coupons": {"spring": {"code": "spring", "percentage": 10}}}`
+	price := "9.99"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gocommerce/settings.json":
+			json.NewEncoder(w).Encode(settings)
+		case "/coupons.json":
+			fmt.Fprintln(w, coupons)
+		case "/repriced-product":
+			fmt.Fprintf(w, `<script class="gocommerce-product">
+				{"sku": "repriced-1", "title": "Repriced", "type": "Book", "prices": [{"amount": "%s", "currency": "USD"}]}
+				</script>`, price)
+		default:
+			handleTestProducts(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("TaxChange", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		settings.Taxes = nil
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Equal(t, uint64(0), order.Taxes)
+
+		settings.Taxes = []*calculator.Tax{{Percentage: 10, Countries: []string{"USA"}}}
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/recalculate", nil, test.Data.testUserToken)
+		result := &recalculationPayload{}
+		extractPayload(t, http.StatusOK, recorder, result)
+
+		assert.Equal(t, uint64(0), result.Before.Taxes)
+		assert.Equal(t, order.Total, result.Before.Total)
+		assert.Equal(t, uint64(100), result.After.Taxes)
+		assert.Equal(t, order.Total+100, result.After.Total)
+		if assert.Len(t, result.After.TaxBreakdown, 1) {
+			assert.Equal(t, "USA", result.After.TaxBreakdown[0].Jurisdiction)
+		}
+		assert.Equal(t, result.After.Total, result.Order.Total)
+
+		stored := &models.Order{}
+		require.NoError(t, test.DB.First(stored, "id = ?", order.ID).Error)
+		assert.Equal(t, uint64(100), stored.Taxes)
+		assert.Equal(t, result.After.Total, stored.Total)
+	})
+
+	t.Run("CouponRemoved", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Coupons.URL = server.URL + "/coupons.json"
+		settings.Taxes = nil
+
+		payload := strings.Replace(defaultPayload, `"email"`, `"coupon": "spring", "email"`, 1)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Equal(t, "spring", order.CouponCode)
+		require.NotZero(t, order.Discount)
+
+		coupons = `{"coupons": {}}`
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/recalculate", nil, test.Data.testUserToken)
+		result := &recalculationPayload{}
+		extractPayload(t, http.StatusOK, recorder, result)
+
+		assert.Equal(t, "spring", result.Before.CouponCode)
+		assert.Equal(t, order.Discount, result.Before.Discount)
+		assert.Empty(t, result.After.CouponCode)
+		assert.Equal(t, uint64(0), result.After.Discount)
+		assert.Equal(t, order.Total+order.Discount, result.After.Total)
+		assert.Empty(t, result.Order.LineItems[0].CalculationDetail.DiscountItems)
+
+		stored := &models.Order{}
+		require.NoError(t, test.DB.First(stored, "id = ?", order.ID).Error)
+		assert.Empty(t, stored.CouponCode)
+	})
+
+	t.Run("PriceChange", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		settings.Taxes = nil
+		price = "9.99"
+
+		payload := strings.Replace(defaultPayload, "/simple-product", "/repriced-product", 1)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.Equal(t, uint64(999), order.LineItems[0].Price)
+
+		price = "12.00"
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/recalculate", nil, test.Data.testUserToken)
+		result := &recalculationPayload{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, uint64(1200), result.Order.LineItems[0].Price)
+		assert.Equal(t, uint64(1200), result.After.SubTotal)
+
+		stored := &models.Order{}
+		require.NoError(t, orderQuery(test.DB).First(stored, "id = ?", order.ID).Error)
+		assert.Equal(t, uint64(1200), stored.LineItems[0].Price)
+		assert.Equal(t, result.After.Total, stored.Total)
+	})
+
+	t.Run("Free", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		settings.Taxes = nil
+		price = "9.99"
+
+		payload := strings.Replace(defaultPayload, "/simple-product", "/repriced-product", 1)
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(payload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		require.NotZero(t, order.Total)
+
+		price = "0.00"
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/recalculate", nil, test.Data.testUserToken)
+		result := &recalculationPayload{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, uint64(0), result.After.Total)
+		assert.Equal(t, uint64(0), result.Order.Total)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/payments", strings.NewReader(`{"amount": 0, "currency": "USD"}`), test.Data.testUserToken)
+		extractPayload(t, http.StatusOK, recorder, &models.Transaction{})
+	})
+
+	t.Run("Paid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/recalculate", nil, test.Data.testUserToken)
+		validateError(t, http.StatusBadRequest, recorder, "Only pending orders can be recalculated")
+	})
+
+	t.Run("Stranger", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+
+		token := testToken("stranger", "stranger-danger@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/first-order/recalculate", nil, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	gcontext "github.com/netlify/gocommerce/context"
+)
+
+// Fields of models tagged with `visibility:"admin"` are internal to the shop,
+// like provider responses or notes. They are stripped from order and payment
+// responses to anyone but admins, wherever the models are nested.
+const visibilityTag = "visibility"
+
+// redactForCustomer removes the admin only fields from a response unless the
+// request was made by an admin.
+func redactForCustomer(r *http.Request, obj interface{}) interface{} {
+	if gcontext.IsAdmin(r.Context()) {
+		return obj
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		// sendJSON reports the error
+		return obj
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var redacted interface{}
+	if err := decoder.Decode(&redacted); err != nil {
+		return obj
+	}
+	stripAdminFields(reflect.ValueOf(obj), redacted)
+	return redacted
+}
+
+// stripAdminFields walks a value and its decoded JSON side by side and
+// deletes the JSON of fields only admins may see.
+func stripAdminFields(v reflect.Value, data interface{}) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if field.Anonymous && name == "" {
+				// the fields of embedded structs are inlined
+				stripAdminFields(v.Field(i), fields)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if field.Tag.Get(visibilityTag) == "admin" {
+				delete(fields, name)
+				continue
+			}
+			if value, ok := fields[name]; ok {
+				stripAdminFields(v.Field(i), value)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := data.([]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < v.Len() && i < len(items); i++ {
+			stripAdminFields(v.Index(i), items[i])
+		}
+	case reflect.Map:
+		entries, ok := data.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			if value, ok := entries[key.String()]; ok {
+				stripAdminFields(v.MapIndex(key), value)
+			}
+		}
+	}
+}
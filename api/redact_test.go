@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderViewRedaction(t *testing.T) {
+	test := NewRouteTest(t)
+	reason := "Tax exempt according to the CRM"
+	taxes := uint64(0)
+	test.Data.firstOrder.IP = "203.0.113.7"
+	test.Data.firstOrder.TaxOverride = &taxes
+	test.Data.firstOrder.TaxOverrideReason = reason
+	require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+	test.Data.firstTransaction.SetProviderMetadata("stripe", map[string]interface{}{"risk_score": 87})
+	require.NoError(t, test.DB.Save(test.Data.firstTransaction).Error)
+
+	adminToken := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	urls := []string{
+		"/orders/first-order",
+		"/orders",
+		"/orders/first-order/payments",
+		"/users/" + test.Data.testUser.ID + "/payments",
+	}
+	for _, amounts := range []string{IntegerAmounts, DecimalAmounts} {
+		test.Config.Display.Amounts = amounts
+		for _, url := range urls {
+			recorder := test.TestEndpoint(http.MethodGet, url, nil, test.Data.testUserToken)
+			require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+			body := recorder.Body.String()
+			assert.NotContains(t, body, "203.0.113.7", url)
+			assert.NotContains(t, body, reason, url)
+			assert.NotContains(t, body, "risk_score", url)
+			assert.NotContains(t, body, `"notes"`, url)
+		}
+	}
+
+	test.Config.Display.Amounts = IntegerAmounts
+	recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order", nil, adminToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, order)
+	assert.Equal(t, "203.0.113.7", order.IP)
+	assert.Equal(t, reason, order.TaxOverrideReason)
+	require.Len(t, order.Transactions, 1)
+	assert.Contains(t, order.Transactions[0].ProviderMetadata, "stripe")
+
+	// everything else is shown to customers
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order", nil, test.Data.testUserToken)
+	fields := map[string]interface{}{}
+	extractPayload(t, http.StatusOK, recorder, &fields)
+	assert.Equal(t, "first-order", fields["id"])
+	assert.Contains(t, fields, "line_items")
+	assert.Contains(t, fields, "tax_override")
+	assert.NotContains(t, fields, "ip")
+}
+
+func TestStripAdminFields(t *testing.T) {
+	type inner struct {
+		Public   string `json:"public"`
+		Internal string `json:"internal" visibility:"admin"`
+	}
+	type embedded struct {
+		Secret string `json:"secret" visibility:"admin"`
+	}
+	type outer struct {
+		embedded
+		Items  []*inner          `json:"items"`
+		ByName map[string]inner  `json:"by_name"`
+		Any    interface{}       `json:"any"`
+		Raw    json.RawMessage   `json:"raw"`
+		Skip   string            `json:"-" visibility:"admin"`
+		Extra  map[string]string `json:"extra,omitempty"`
+	}
+
+	value := &outer{
+		embedded: embedded{Secret: "s"},
+		Items:    []*inner{{Public: "a", Internal: "b"}, nil},
+		ByName:   map[string]inner{"x": {Public: "c", Internal: "d"}},
+		Any:      inner{Public: "e", Internal: "f"},
+		Raw:      json.RawMessage(`{"internal": "kept"}`),
+	}
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	decoded := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	stripAdminFields(reflect.ValueOf(value), decoded)
+	expected := map[string]interface{}{
+		"items":   []interface{}{map[string]interface{}{"public": "a"}, nil},
+		"by_name": map[string]interface{}{"x": map[string]interface{}{"public": "c"}},
+		"any":     map[string]interface{}{"public": "e"},
+		"raw":     map[string]interface{}{"internal": "kept"},
+	}
+	assert.Equal(t, expected, decoded)
+}
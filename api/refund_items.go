@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/models"
+)
+
+// refundLineItemParams allocates part of a refund to a line item. Either the
+// amount or the quantity is required, a quantity alone refunds what was
+// charged for its units.
+type refundLineItemParams struct {
+	ID       int64  `json:"id"`
+	Quantity uint64 `json:"quantity"`
+	Amount   uint64 `json:"amount"`
+}
+
+// unitTotal returns what was charged for a single unit of a line item,
+// including taxes and discounts.
+func unitTotal(item *models.LineItem) uint64 {
+	if item.CalculationDetail == nil || item.Total < 0 {
+		return 0
+	}
+	return uint64(item.Total)
+}
+
+// allocateRefund validates the line items of a refund against what's left to
+// refund of each of them and returns the refund items with their sum.
+func allocateRefund(db *gorm.DB, orderID string, params []refundLineItemParams) ([]*models.RefundItem, uint64, *HTTPError) {
+	lineItems := []*models.LineItem{}
+	if err := db.Where("order_id = ?", orderID).Find(&lineItems).Error; err != nil {
+		return nil, 0, internalServerError("Error loading line items").WithInternalError(err)
+	}
+	refunded, err := models.RefundedLineItems(db, orderID)
+	if err != nil {
+		return nil, 0, internalServerError("Error loading previous refunds").WithInternalError(err)
+	}
+
+	items := []*models.RefundItem{}
+	seen := map[int64]bool{}
+	var total uint64
+	for _, p := range params {
+		var lineItem *models.LineItem
+		for _, li := range lineItems {
+			if li.ID == p.ID {
+				lineItem = li
+				break
+			}
+		}
+		if lineItem == nil {
+			return nil, 0, badRequestError("Line item %d isn't part of the order", p.ID)
+		}
+		if seen[p.ID] {
+			return nil, 0, badRequestError("Line item %d is listed more than once", p.ID)
+		}
+		seen[p.ID] = true
+		if p.Amount == 0 && p.Quantity == 0 {
+			return nil, 0, badRequestError("Line item %d needs an amount or a quantity to refund", p.ID)
+		}
+
+		lineTotal := unitTotal(lineItem) * lineItem.Quantity
+		var refundedAmount, refundedQuantity uint64
+		if previous, ok := refunded[lineItem.ID]; ok {
+			refundedAmount, refundedQuantity = previous.Amount, previous.Quantity
+		}
+
+		if p.Quantity > 0 {
+			if refundedQuantity+p.Quantity > lineItem.Quantity {
+				return nil, 0, badRequestError("Line item %d has only %d units left to refund", p.ID, lineItem.Quantity-refundedQuantity)
+			}
+			if p.Amount == 0 {
+				p.Amount = unitTotal(lineItem) * p.Quantity
+			}
+		}
+		if refundedAmount+p.Amount > lineTotal {
+			return nil, 0, badRequestError("Line item %d has only %d left to refund", p.ID, lineTotal-refundedAmount)
+		}
+
+		items = append(items, &models.RefundItem{
+			LineItemID: lineItem.ID,
+			Sku:        lineItem.Sku,
+			Path:       lineItem.Path,
+			Quantity:   p.Quantity,
+			Amount:     p.Amount,
+		})
+		total += p.Amount
+	}
+	return items, total, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runItemizedRefund(t *testing.T, test *RouteTest, provider *memProvider, body string) *httptest.ResponseRecorder {
+	ctx, err := WithInstanceConfig(context.Background(), test.GlobalConfig, test.Config, "")
+	require.NoError(t, err)
+	ctx = gcontext.WithPaymentProviders(ctx, map[string]payments.Provider{payments.StripeProvider: provider})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/payments/"+test.Data.firstTransaction.ID+"/refund", strings.NewReader(body))
+	require.NoError(t, signHTTPRequest(r, testAdminToken("magical-unicorn", ""), test.Config.JWT.Secret))
+	NewAPIWithVersion(ctx, test.GlobalConfig, test.DB, defaultVersion).handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestPaymentsRefundLineItems(t *testing.T) {
+	t.Run("Quantity", func(t *testing.T) {
+		test := NewRouteTest(t)
+		provider := &memProvider{name: payments.StripeProvider}
+		w := runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11, "quantity": 1}]}`)
+		rsp := &models.Transaction{}
+		extractPayload(t, http.StatusOK, w, rsp)
+		assert.EqualValues(t, 12, rsp.Amount, "the amount is what was charged for the unit")
+		require.Len(t, provider.refundCalls, 1)
+		assert.EqualValues(t, 12, provider.refundCalls[0].amount)
+
+		stored, err := models.GetTransaction(test.DB, rsp.ID)
+		require.NoError(t, err)
+		require.Len(t, stored.RefundItems, 1)
+		assert.EqualValues(t, 11, stored.RefundItems[0].LineItemID)
+		assert.Equal(t, "123-i-can-fly-456", stored.RefundItems[0].Sku)
+		assert.EqualValues(t, 1, stored.RefundItems[0].Quantity)
+		assert.EqualValues(t, 12, stored.RefundItems[0].Amount)
+
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11, "quantity": 2}]}`)
+		validateError(t, http.StatusBadRequest, w, "has only 1 units left to refund")
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11, "amount": 13}]}`)
+		validateError(t, http.StatusBadRequest, w, "has only 12 left to refund")
+	})
+
+	t.Run("Amount", func(t *testing.T) {
+		test := NewRouteTest(t)
+		provider := &memProvider{name: payments.StripeProvider}
+		w := runItemizedRefund(t, test, provider, `{"amount": 5, "currency": "USD", "reason": "defective", "line_items": [{"id": 11, "amount": 5}]}`)
+		rsp := &models.Transaction{}
+		extractPayload(t, http.StatusOK, w, rsp)
+		assert.EqualValues(t, 5, rsp.Amount)
+		require.Len(t, rsp.RefundItems, 1)
+		assert.EqualValues(t, 5, rsp.RefundItems[0].Amount)
+		assert.Zero(t, rsp.RefundItems[0].Quantity)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		provider := &memProvider{name: payments.StripeProvider}
+		w := runItemizedRefund(t, test, provider, `{"amount": 10, "currency": "USD", "reason": "defective", "line_items": [{"id": 11, "amount": 5}]}`)
+		validateError(t, http.StatusBadRequest, w, "doesn't match the sum of its line items")
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 21, "amount": 5}]}`)
+		validateError(t, http.StatusBadRequest, w, "isn't part of the order")
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11}]}`)
+		validateError(t, http.StatusBadRequest, w, "needs an amount or a quantity")
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11, "amount": 1}, {"id": 11, "amount": 1}]}`)
+		validateError(t, http.StatusBadRequest, w, "listed more than once")
+		w = runItemizedRefund(t, test, provider, `{"currency": "USD", "reason": "defective", "line_items": [{"id": 11, "amount": 25}]}`)
+		validateError(t, http.StatusBadRequest, w, "has only 24 left to refund")
+		assert.Empty(t, provider.refundCalls)
+	})
+}
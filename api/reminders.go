@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const abandonedCartPeriod = 10 * time.Minute
+
+// reminderSentKey is the order metadata key recording when an abandoned cart
+// reminder was sent, so it's only sent once.
+const reminderSentKey = "abandoned_cart_reminder_sent_at"
+
+// reminderConsentKey is the order metadata key for the customer's consent to
+// reminders. Guest orders are only reminded with consent, orders of users
+// unless they declined.
+const reminderConsentKey = "reminder_consent"
+
+// wantsReminder checks if the customer of an order can be reminded of it.
+func wantsReminder(order *models.Order) bool {
+	if order.Email == "" {
+		return false
+	}
+	if _, sent := order.MetaData[reminderSentKey]; sent {
+		return false
+	}
+	consent, given := order.MetaData[reminderConsentKey].(bool)
+	if order.UserID == "" {
+		return given && consent
+	}
+	return !given || consent
+}
+
+// sendAbandonedCartReminders emails the customers of pending orders created
+// longer than the configured delay but less than the window ago.
+func sendAbandonedCartReminders(db *gorm.DB, instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) error {
+	now := time.Now()
+	orders := []*models.Order{}
+	rsp := db.Preload("LineItems").
+		Where("instance_id = ? AND payment_state = ? AND email <> ''", instanceID, models.PendingState).
+		Where("created_at < ? AND created_at > ?",
+			now.Add(-time.Duration(config.AbandonedCart.Delay)*time.Second),
+			now.Add(-time.Duration(config.AbandonedCart.Window)*time.Second)).
+		Find(&orders)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for abandoned carts")
+	}
+
+	for _, order := range orders {
+		if !wantsReminder(order) {
+			continue
+		}
+		if err := m.AbandonedCartMail(order); err != nil {
+			log.WithError(err).Errorf("Error sending abandoned cart reminder for order %s", order.ID)
+			continue
+		}
+
+		if order.MetaData == nil {
+			order.MetaData = map[string]interface{}{}
+		}
+		order.MetaData[reminderSentKey] = now.UTC().Format(time.RFC3339)
+		data, err := json.Marshal(order.MetaData)
+		if err != nil {
+			return err
+		}
+		if rsp := db.Model(order).UpdateColumn("raw_meta_data", string(data)); rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error recording abandoned cart reminder")
+		}
+		log.Debugf("Sent abandoned cart reminder for order %s", order.ID)
+	}
+	return nil
+}
+
+// RunAbandonedCartReminders creates a goroutine that sends abandoned cart
+// reminders every 10 minutes. Without a config, as in multi instance mode,
+// all instances that enabled reminders are handled.
+func RunAbandonedCartReminders(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry) {
+	go func() {
+		for {
+			forEachInstance(db, globalConfig, config, log, func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger) {
+				if !config.AbandonedCart.Enabled {
+					return
+				}
+				if err := sendAbandonedCartReminders(db, instanceID, config, m, log); err != nil {
+					log.WithError(err).Error("Error sending abandoned cart reminders")
+				}
+			})
+			time.Sleep(abandonedCartPeriod)
+		}
+	}()
+}
+
+// forEachInstance calls fn with the configuration and mailer of every
+// instance. With a config, as in single instance mode, only that one is used.
+func forEachInstance(db *gorm.DB, globalConfig *conf.GlobalConfiguration, config *conf.Configuration, log *logrus.Entry, fn func(instanceID string, config *conf.Configuration, m mailer.Mailer, log logrus.FieldLogger)) {
+	if config != nil {
+		fn("", config, mailer.NewMailer(globalConfig.SMTP, config), log)
+		return
+	}
+
+	instances := []*models.Instance{}
+	if rsp := db.Find(&instances); rsp.Error != nil {
+		log.WithError(rsp.Error).Error("Error querying for instances")
+	}
+	for _, instance := range instances {
+		instanceConfig, err := instance.Config()
+		if err != nil {
+			continue
+		}
+		fn(instance.ID, instanceConfig, mailer.NewMailer(globalConfig.SMTP, instanceConfig), log.WithField("instance_id", instance.ID))
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/mailer"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reminderMailer struct {
+	mailer.Mailer
+	sent []string
+}
+
+func (m *reminderMailer) AbandonedCartMail(order *models.Order) error {
+	m.sent = append(m.sent, order.ID)
+	return nil
+}
+
+func TestAbandonedCartReminders(t *testing.T) {
+	test := NewRouteTest(t)
+	test.Config.AbandonedCart.Delay = 60 * 60
+	test.Config.AbandonedCart.Window = 24 * 60 * 60
+
+	createOrder := func(id, userID string, age time.Duration, meta map[string]interface{}) {
+		order := models.NewOrder("", "session-"+id, "customer@example.com", "USD")
+		order.ID = id
+		order.UserID = userID
+		order.MetaData = meta
+		require.NoError(t, test.DB.Create(order).Error)
+		require.NoError(t, test.DB.Model(order).UpdateColumn("created_at", time.Now().Add(-age)).Error)
+	}
+	createOrder("abandoned", test.Data.testUser.ID, 2*time.Hour, nil)
+	createOrder("too-recent", test.Data.testUser.ID, 10*time.Minute, nil)
+	createOrder("too-old", test.Data.testUser.ID, 48*time.Hour, nil)
+	createOrder("declined", test.Data.testUser.ID, 2*time.Hour, map[string]interface{}{"reminder_consent": false})
+	createOrder("guest", "", 2*time.Hour, nil)
+	createOrder("guest-consent", "", 2*time.Hour, map[string]interface{}{"reminder_consent": true})
+
+	m := &reminderMailer{}
+	require.NoError(t, sendAbandonedCartReminders(test.DB, "", test.Config, m, testLogger))
+	assert.ElementsMatch(t, []string{"abandoned", "guest-consent"}, m.sent)
+
+	stored := &models.Order{}
+	require.NoError(t, test.DB.First(stored, "id = ?", "abandoned").Error)
+	assert.Contains(t, stored.MetaData, reminderSentKey)
+
+	// reminders are only sent once
+	m.sent = nil
+	require.NoError(t, sendAbandonedCartReminders(test.DB, "", test.Config, m, testLogger))
+	assert.Empty(t, m.sent)
+}
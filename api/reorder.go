@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type unavailableItem struct {
+	Sku       string `json:"sku"`
+	Path      string `json:"path"`
+	Quantity  uint64 `json:"quantity"`
+	Available uint64 `json:"available"`
+}
+
+// reorderParams are the optional params of a reorder. The birthdate isn't
+// stored with an order, so it has to be given again for products that
+// require an eligibility check.
+type reorderParams struct {
+	Birthdate string `json:"birthdate"`
+}
+
+type reorderResponse struct {
+	Order            interface{}        `json:"order"`
+	UnavailableItems []*unavailableItem `json:"unavailable_items"`
+}
+
+// OrderReorder creates a new pending order with the line items of an earlier
+// order of the user. Prices, taxes and discounts are calculated from the
+// current product data and settings. Products that don't have enough stock
+// left are not added to the new order, but listed as unavailable items.
+func (a *API) OrderReorder(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	instanceID := gcontext.GetInstanceID(ctx)
+	orderID := gcontext.GetOrderID(ctx)
+	claims := gcontext.GetClaims(ctx)
+	log := getLogEntry(r)
+
+	reorder := &reorderParams{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(reorder); err != nil {
+			return badRequestError("Could not read params: %v", err)
+		}
+	}
+
+	previous := &models.Order{}
+	if result := orderQuery(a.db).First(previous, "id = ?", orderID); result.Error != nil {
+		if result.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+	if previous.UserID == "" || previous.UserID != claims.Subject {
+		return unauthorizedError("You can only reorder your own orders")
+	}
+
+	params := &orderRequestParams{
+		Email:             previous.Email,
+		Currency:          previous.Currency,
+		ShippingAddressID: previous.ShippingAddressID,
+		BillingAddressID:  previous.BillingAddressID,
+		VATNumber:         previous.VATNumber,
+		Birthdate:         reorder.Birthdate,
+	}
+
+	// free items aren't reordered, the coupon that granted them isn't used again
+	items := []*models.LineItem{}
+	for _, item := range previous.LineItems {
+		if !item.Free {
+			items = append(items, item)
+		}
+	}
+
+	quantities := make(map[string]uint64)
+	for _, item := range items {
+		quantities[item.Sku] += item.Quantity
+	}
+
+	unavailable := []*unavailableItem{}
+	for _, item := range items {
+		if config.Inventory.Enabled && !config.Inventory.AllowBackorders {
+			stock := &models.InventoryItem{}
+			rsp := a.db.Where("instance_id = ? AND sku = ?", instanceID, item.Sku).First(stock)
+			if rsp.Error != nil && !rsp.RecordNotFound() {
+				return internalServerError("Error querying inventory").WithInternalError(rsp.Error)
+			}
+			if !rsp.RecordNotFound() && stock.Available < quantities[item.Sku] {
+				unavailable = append(unavailable, &unavailableItem{
+					Sku:       item.Sku,
+					Path:      item.Path,
+					Quantity:  item.Quantity,
+					Available: stock.Available,
+				})
+				continue
+			}
+		}
+
+		params.LineItems = append(params.LineItems, &orderLineItem{
+			Sku:      item.Sku,
+			Path:     item.Path,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			MetaData: item.MetaData,
+		})
+	}
+	if len(params.LineItems) == 0 {
+		return badRequestError("None of the products of order %s are available", previous.ID)
+	}
+
+	order, err := a.createOrder(w, r, params)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("previous_order_id", previous.ID).Infof("Reordered %d line items, %d unavailable", len(params.LineItems), len(unavailable))
+	return sendJSON(w, http.StatusCreated, &reorderResponse{
+		Order:            presentOrder(r, order),
+		UnavailableItems: unavailable,
+	})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderReorder(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	body := `{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/simple-product", "quantity": 2},
+			{"path": "/download-product", "quantity": 1}
+		]
+	}`
+
+	t.Run("Simple", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		previous := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, previous)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+previous.ID+"/reorder", nil, test.Data.testUserToken)
+		order := &models.Order{}
+		result := &reorderResponse{Order: order}
+		extractPayload(t, http.StatusCreated, recorder, result)
+		assert.NotEqual(t, previous.ID, order.ID)
+		assert.Equal(t, models.PendingState, order.PaymentState)
+		assert.Equal(t, previous.ShippingAddressID, order.ShippingAddressID)
+		require.Len(t, order.LineItems, 2)
+		assert.Equal(t, uint64(2), order.LineItems[0].Quantity)
+		assert.Equal(t, previous.Total, order.Total)
+		assert.Empty(t, result.UnavailableItems)
+	})
+
+	t.Run("OutOfStock", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		previous := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, previous)
+
+		test.Config.Inventory.Enabled = true
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "product-1", Available: 1}).Error)
+		require.NoError(t, test.DB.Create(&models.InventoryItem{Sku: "download-1", Available: 5}).Error)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/"+previous.ID+"/reorder", nil, test.Data.testUserToken)
+		order := &models.Order{}
+		result := &reorderResponse{Order: order}
+		extractPayload(t, http.StatusCreated, recorder, result)
+		require.Len(t, order.LineItems, 1)
+		assert.Equal(t, "download-1", order.LineItems[0].Sku)
+		require.Len(t, result.UnavailableItems, 1)
+		assert.Equal(t, "product-1", result.UnavailableItems[0].Sku)
+		assert.Equal(t, uint64(2), result.UnavailableItems[0].Quantity)
+		assert.Equal(t, uint64(1), result.UnavailableItems[0].Available)
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testToken("harley-quinn", "harley@joker.org")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders/"+test.Data.firstOrder.ID+"/reorder", nil, token)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
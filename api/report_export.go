@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/netlify/gocommerce/conf"
+)
+
+// GenericCSVFormat exports a report as CSV with a column per field of its
+// rows, named like the JSON fields.
+const GenericCSVFormat = "csv"
+
+// defaultReportDateFormat is the layout of dates in export formats that
+// don't configure one.
+const defaultReportDateFormat = "2006-01-02"
+
+// salesExportFields are the fields export format columns can be filled with.
+// The date is the end of the reported period, from its start if one was given.
+var salesExportFields = map[string]bool{
+	"date": true, "from": true,
+	"total": true, "subtotal": true, "taxes": true, "tips": true,
+	"currency": true, "orders": true,
+	"source": true, "medium": true, "campaign": true, "referrer": true,
+}
+
+// reportFormat looks up the export format requested with `format=`. It
+// returns nil for JSON.
+func reportFormat(config *conf.Configuration, name string) (*conf.ReportFormat, *HTTPError) {
+	switch name {
+	case "", "json":
+		return nil, nil
+	case GenericCSVFormat:
+		return &conf.ReportFormat{}, nil
+	}
+	format, ok := config.Reports.Formats[name]
+	if !ok {
+		return nil, badRequestError("Unknown report format '%s'", name)
+	}
+	if len(format.Columns) == 0 {
+		return nil, internalServerError("Report format '%s' has no columns", name)
+	}
+	for _, column := range format.Columns {
+		if column.Field != "" && !salesExportFields[column.Field] {
+			return nil, internalServerError("Report format '%s' has an unknown field '%s'", name, column.Field)
+		}
+	}
+	if _, err := reportDelimiter(&format); err != nil {
+		return nil, internalServerError("Report format '%s' has an invalid delimiter", name).WithInternalError(err)
+	}
+	return &format, nil
+}
+
+// reportDelimiter returns the single character separating the columns of a
+// format, a comma by default.
+func reportDelimiter(format *conf.ReportFormat) (rune, error) {
+	if format.Delimiter == "" {
+		return ',', nil
+	}
+	delimiter, size := utf8.DecodeRuneInString(format.Delimiter)
+	if size != len(format.Delimiter) || delimiter == utf8.RuneError || delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+		return 0, fmt.Errorf("bad delimiter %q", format.Delimiter)
+	}
+	return delimiter, nil
+}
+
+// salesExportValue formats a field of a sales report row.
+func salesExportValue(row *salesRow, field string, from, to *time.Time, dateFormat string, decimal bool) string {
+	amount := func(value uint64) string {
+		if decimal {
+			return decimalAmount(value).String()
+		}
+		return strconv.FormatUint(value, 10)
+	}
+	switch field {
+	case "date":
+		if to == nil {
+			now := time.Now()
+			to = &now
+		}
+		return to.UTC().Format(dateFormat)
+	case "from":
+		if from == nil {
+			return ""
+		}
+		return from.UTC().Format(dateFormat)
+	case "total":
+		return amount(row.Total)
+	case "subtotal":
+		return amount(row.SubTotal)
+	case "taxes":
+		return amount(row.Taxes)
+	case "tips":
+		return amount(row.Tips)
+	case "currency":
+		return row.Currency
+	case "orders":
+		return strconv.FormatUint(row.Orders, 10)
+	}
+	if row.Attribution == nil {
+		return ""
+	}
+	return *attributionField(row.Attribution, field)
+}
+
+// salesExportRecords lays out a sales report in an export format. The generic
+// format has a column per field, with amounts in the units the JSON report
+// would use. Accounting formats always have amounts in major units.
+func salesExportRecords(r *http.Request, format *conf.ReportFormat, rows []*salesRow, groupBy []string) ([][]string, error) {
+	from, to, err := getTimeQueryParams(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	columns := format.Columns
+	decimal := true
+	if len(columns) == 0 {
+		for _, field := range append([]string{"total", "subtotal", "taxes", "tips", "currency", "orders"}, groupBy...) {
+			columns = append(columns, conf.ReportColumn{Header: field, Field: field})
+		}
+		decimal = wantsDecimalAmounts(r)
+	}
+	dateFormat := format.DateFormat
+	if dateFormat == "" {
+		dateFormat = defaultReportDateFormat
+	}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	records := [][]string{header}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if column.Field == "" {
+				record[i] = column.Value
+				continue
+			}
+			record[i] = salesExportValue(row, column.Field, from, to, dateFormat, decimal)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// escapeCSVFormula keeps spreadsheets from evaluating a cell as a formula,
+// e.g. an attribution source set by a customer. Cells starting with a
+// character that begins a formula are prefixed with a quote, unless they're a
+// plain number like a negative amount.
+func escapeCSVFormula(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@", rune(cell[0])) {
+		return cell
+	}
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+	return "'" + cell
+}
+
+// sendCSV writes records as a CSV attachment. Cells that could be evaluated
+// as formulas are escaped.
+func sendCSV(w http.ResponseWriter, name string, format *conf.ReportFormat, records [][]string) error {
+	delimiter, err := reportDelimiter(format)
+	if err != nil {
+		return internalServerError("Invalid report delimiter").WithInternalError(err)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	w.WriteHeader(http.StatusOK)
+	for _, record := range records {
+		for i, cell := range record {
+			record[i] = escapeCSVFormula(cell)
+		}
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	return writer.WriteAll(records)
+}
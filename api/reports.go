@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	gcontext "github.com/netlify/gocommerce/context"
 	"github.com/netlify/gocommerce/models"
@@ -11,8 +12,20 @@ type salesRow struct {
 	Total    uint64 `json:"total"`
 	SubTotal uint64 `json:"subtotal"`
 	Taxes    uint64 `json:"taxes"`
+	Tips     uint64 `json:"tips"`
 	Currency string `json:"currency"`
 	Orders   uint64 `json:"orders"`
+
+	Attribution *models.Attribution `json:"attribution,omitempty"`
+}
+
+// salesGroupColumns are the attribution fields the sales report can be
+// grouped by in addition to the currency
+var salesGroupColumns = map[string]string{
+	"source":   "attribution_source",
+	"medium":   "attribution_medium",
+	"campaign": "attribution_campaign",
+	"referrer": "attribution_referrer",
 }
 
 type productsRow struct {
@@ -22,19 +35,66 @@ type productsRow struct {
 	Currency string `json:"currency"`
 }
 
-// SalesReport lists the sales numbers for a period
+// refundsRow sums up refunds. Total is the gross amount refunded, Net and
+// Fees only cover the refunds with known fees, UnknownFees counts the others.
+type refundsRow struct {
+	Reason      string `json:"reason"`
+	Total       uint64 `json:"total"`
+	Net         uint64 `json:"net"`
+	Fees        int64  `json:"fees"`
+	Currency    string `json:"currency"`
+	Refunds     uint64 `json:"refunds"`
+	UnknownFees uint64 `json:"unknown_fees"`
+}
+
+// productRefundsRow sums up the refunds allocated to a product. Quantity only
+// counts the units of refunds that listed them.
+type productRefundsRow struct {
+	Sku      string `json:"sku"`
+	Path     string `json:"path"`
+	Total    uint64 `json:"total"`
+	Quantity uint64 `json:"quantity"`
+	Currency string `json:"currency"`
+	Refunds  uint64 `json:"refunds"`
+}
+
+// SalesReport lists the sales numbers for a period. The numbers can also be
+// grouped by attribution with e.g. `group_by=source,campaign`. With `format=`
+// the report is exported as generic CSV or in a configured accounting format.
 func (a *API) SalesReport(w http.ResponseWriter, r *http.Request) error {
-	instanceID := gcontext.GetInstanceID(r.Context())
+	ctx := r.Context()
+	instanceID := gcontext.GetInstanceID(ctx)
+	config := gcontext.GetConfig(ctx)
+
+	format, httpErr := reportFormat(config, r.URL.Query().Get("format"))
+	if httpErr != nil {
+		return httpErr
+	}
+
+	selects := []string{"sum(total) as total", "sum(sub_total) as subtotal", "sum(taxes) as taxes", "sum(tip) as tips", "currency", "count(*) as orders"}
+	groups := []string{"currency"}
+	var groupBy []string
+	if param := r.URL.Query().Get("group_by"); param != "" {
+		for _, field := range strings.Split(param, ",") {
+			column, ok := salesGroupColumns[field]
+			if !ok {
+				return badRequestError("Can't group sales by '%s'", field)
+			}
+			selects = append(selects, "coalesce("+column+", '') as "+field)
+			groups = append(groups, column)
+			groupBy = append(groupBy, field)
+		}
+	}
 
 	query := a.db.
 		Model(&models.Order{}).
-		Select("sum(total) as total, sum(sub_total) as subtotal, sum(taxes) as taxes, currency, count(*) as orders").
+		Select(strings.Join(selects, ", ")).
 		Where("payment_state = 'paid' AND instance_id = ?", instanceID).
-		Group("currency")
+		Group(strings.Join(groups, ", "))
 
 	query, err := parseTimeQueryParams(query, query.NewScope(models.Order{}).QuotedTableName(), r.URL.Query())
 	if err != nil {
-		return badRequestError(err.Error())
+		return badRequestError("%v", err)
 	}
 
 	rows, err := query.Rows()
@@ -45,14 +105,41 @@ func (a *API) SalesReport(w http.ResponseWriter, r *http.Request) error {
 	result := []*salesRow{}
 	for rows.Next() {
 		row := &salesRow{}
-		err = rows.Scan(&row.Total, &row.SubTotal, &row.Taxes, &row.Currency, &row.Orders)
+		dest := []interface{}{&row.Total, &row.SubTotal, &row.Taxes, &row.Tips, &row.Currency, &row.Orders}
+		if len(groupBy) > 0 {
+			row.Attribution = &models.Attribution{}
+			for _, field := range groupBy {
+				dest = append(dest, attributionField(row.Attribution, field))
+			}
+		}
+		err = rows.Scan(dest...)
 		if err != nil {
 			return internalServerError("Database error").WithInternalError(err)
 		}
 		result = append(result, row)
 	}
 
-	return sendJSON(w, http.StatusOK, result)
+	if format != nil {
+		records, err := salesExportRecords(r, format, result, groupBy)
+		if err != nil {
+			return badRequestError("%v", err)
+		}
+		return sendCSV(w, "sales.csv", format, records)
+	}
+	return sendJSON(w, http.StatusOK, presentSalesReport(r, result))
+}
+
+func attributionField(attribution *models.Attribution, field string) *string {
+	switch field {
+	case "source":
+		return &attribution.Source
+	case "medium":
+		return &attribution.Medium
+	case "campaign":
+		return &attribution.Campaign
+	default:
+		return &attribution.Referrer
+	}
 }
 
 // ProductsReport list the products sold within a period
@@ -70,7 +157,7 @@ func (a *API) ProductsReport(w http.ResponseWriter, r *http.Request) error {
 	query = query.Where(ordersTable+".instance_id = ?", instanceID)
 	from, to, err := getTimeQueryParams(r.URL.Query())
 	if err != nil {
-		return badRequestError(err.Error())
+		return badRequestError("%v", err)
 	}
 	if from != nil {
 		query = query.Where(ordersTable+".created_at >= ?", from)
@@ -94,5 +181,76 @@ func (a *API) ProductsReport(w http.ResponseWriter, r *http.Request) error {
 		result = append(result, row)
 	}
 
-	return sendJSON(w, http.StatusOK, result)
+	return sendJSON(w, http.StatusOK, presentProductsReport(r, result))
+}
+
+// RefundsReport lists the successful refunds within a period by reason
+func (a *API) RefundsReport(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+
+	query := a.db.
+		Model(&models.Transaction{}).
+		Select("refund_reason, sum(amount) as total, coalesce(sum(net_amount), 0) as net, coalesce(sum(fee), 0) as fees, currency, count(*) as refunds, count(*) - count(net_amount) as unknown_fees").
+		Where("type = ? AND status = ? AND instance_id = ?", models.RefundTransactionType, models.PaidState, instanceID).
+		Group("refund_reason, currency").
+		Order("total desc")
+
+	query, err := parseTimeQueryParams(query, query.NewScope(models.Transaction{}).QuotedTableName(), r.URL.Query())
+	if err != nil {
+		return badRequestError("%v", err)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return internalServerError("Database error").WithInternalError(err)
+	}
+	defer rows.Close()
+	result := []*refundsRow{}
+	for rows.Next() {
+		row := &refundsRow{}
+		err = rows.Scan(&row.Reason, &row.Total, &row.Net, &row.Fees, &row.Currency, &row.Refunds, &row.UnknownFees)
+		if err != nil {
+			return internalServerError("Database error").WithInternalError(err)
+		}
+		result = append(result, row)
+	}
+
+	return sendJSON(w, http.StatusOK, presentRefundsReport(r, result))
+}
+
+// ProductRefundsReport lists the successful refunds within a period by the
+// products they were allocated to. Refunds without line items aren't included.
+func (a *API) ProductRefundsReport(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	transactionsTable := a.db.NewScope(models.Transaction{}).QuotedTableName()
+	itemsTable := a.db.NewScope(models.RefundItem{}).QuotedTableName()
+	query := a.db.
+		Model(&models.RefundItem{}).
+		Select("sku, path, sum("+itemsTable+".amount) as total, sum(quantity) as quantity, currency, count(distinct transaction_id) as refunds").
+		Joins("JOIN "+transactionsTable+" ON "+transactionsTable+".id = "+itemsTable+".transaction_id").
+		Where(transactionsTable+".type = ? AND "+transactionsTable+".status = ? AND "+transactionsTable+".instance_id = ?", models.RefundTransactionType, models.PaidState, instanceID).
+		Group("sku, path, currency").
+		Order("total desc")
+
+	query, err := parseTimeQueryParams(query, transactionsTable, r.URL.Query())
+	if err != nil {
+		return badRequestError("%v", err)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return internalServerError("Database error").WithInternalError(err)
+	}
+	defer rows.Close()
+	result := []*productRefundsRow{}
+	for rows.Next() {
+		row := &productRefundsRow{}
+		err = rows.Scan(&row.Sku, &row.Path, &row.Total, &row.Quantity, &row.Currency, &row.Refunds)
+		if err != nil {
+			return internalServerError("Database error").WithInternalError(err)
+		}
+		result = append(result, row)
+	}
+
+	return sendJSON(w, http.StatusOK, presentProductRefundsReport(r, result))
 }
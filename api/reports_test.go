@@ -1,10 +1,16 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSalesReport(t *testing.T) {
@@ -23,6 +29,118 @@ func TestSalesReport(t *testing.T) {
 		assert.Equal(t, "USD", row.Currency)
 		assert.Equal(t, uint64(2), row.Orders)
 	})
+
+	t.Run("Tips", func(t *testing.T) {
+		test := NewRouteTest(t)
+		rsp := test.DB.Model(test.Data.firstOrder).UpdateColumns(map[string]interface{}{"tip": 15, "total": test.Data.firstOrder.Total + 15})
+		require.NoError(t, rsp.Error)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales", nil, token)
+
+		report := []salesRow{}
+		extractPayload(t, http.StatusOK, recorder, &report)
+		require.Len(t, report, 1)
+		row := report[0]
+		assert.Equal(t, uint64(94), row.Total)
+		assert.Equal(t, uint64(79), row.SubTotal)
+		assert.Equal(t, uint64(15), row.Tips)
+	})
+
+	t.Run("GroupByAttribution", func(t *testing.T) {
+		test := NewRouteTest(t)
+		rsp := test.DB.Model(test.Data.firstOrder).UpdateColumns(map[string]interface{}{"attribution_source": "newsletter", "attribution_campaign": "spring-sale"})
+		require.NoError(t, rsp.Error)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales?group_by=source,campaign", nil, token)
+
+		report := []salesRow{}
+		extractPayload(t, http.StatusOK, recorder, &report)
+		require.Len(t, report, 2)
+		for _, row := range report {
+			require.NotNil(t, row.Attribution)
+			assert.Equal(t, uint64(1), row.Orders)
+			if row.Attribution.Source == "newsletter" {
+				assert.Equal(t, "spring-sale", row.Attribution.Campaign)
+				assert.Equal(t, test.Data.firstOrder.Total, row.Total)
+			} else {
+				assert.Equal(t, models.Attribution{}, *row.Attribution)
+			}
+		}
+
+		recorder = test.TestEndpoint(http.MethodGet, "/reports/sales?group_by=email", nil, token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		test := NewRouteTest(t)
+		rsp := test.DB.Model(test.Data.firstOrder).UpdateColumn("attribution_source", "newsletter")
+		require.NoError(t, rsp.Error)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales?format=csv&group_by=source", nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, "text/csv; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="sales.csv"`, recorder.Header().Get("Content-Disposition"))
+		lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+		require.Len(t, lines, 3)
+		assert.Equal(t, "total,subtotal,taxes,tips,currency,orders,source", lines[0])
+		assert.Contains(t, lines[1:], fmt.Sprintf("%d,%d,0,0,USD,1,newsletter", test.Data.firstOrder.Total, test.Data.firstOrder.SubTotal))
+	})
+
+	t.Run("CSVFormula", func(t *testing.T) {
+		test := NewRouteTest(t)
+		rsp := test.DB.Model(test.Data.firstOrder).UpdateColumn("attribution_source", `=HYPERLINK("http://evil.example.com")`)
+		require.NoError(t, rsp.Error)
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales?format=csv&group_by=source", nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Contains(t, recorder.Body.String(), `,"'=HYPERLINK(""http://evil.example.com"")"`)
+		assert.NotContains(t, recorder.Body.String(), `,=HYPERLINK`)
+	})
+
+	t.Run("AccountingFormat", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.ApplyDefaults()
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		to := time.Date(2099, time.March, 31, 12, 0, 0, 0, time.UTC)
+
+		recorder := test.TestEndpoint(http.MethodGet, fmt.Sprintf("/reports/sales?format=quickbooks&to=%d", to.Unix()), nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, "Date,Description,Amount\n03/31/2099,Sales,0.79\n", recorder.Body.String())
+
+		recorder = test.TestEndpoint(http.MethodGet, fmt.Sprintf("/reports/sales?format=xero&to=%d", to.Unix()), nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, "*Date,*Amount,Payee,Description,Reference\n31/03/2099,0.79,Online customers,Sales,USD\n", recorder.Body.String())
+	})
+
+	t.Run("ConfiguredFormat", func(t *testing.T) {
+		test := NewRouteTest(t)
+		require.NoError(t, test.Config.Reports.Formats.Decode(`{"datev": {
+			"delimiter": ";",
+			"columns": [
+				{"header": "Umsatz", "field": "total"},
+				{"header": "Steuer", "field": "taxes"},
+				{"header": "Konto", "value": "8400"}
+			]
+		}}`))
+		test.Config.ApplyDefaults()
+		require.Contains(t, test.Config.Reports.Formats, "quickbooks", "the defaults are kept")
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodGet, "/reports/sales?format=datev", nil, token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Equal(t, "Umsatz;Steuer;Konto\n0.79;0.00;8400\n", recorder.Body.String())
+
+		test.Config.Reports.Formats["broken"] = conf.ReportFormat{Columns: []conf.ReportColumn{{Header: "Email", Field: "email"}}}
+		recorder = test.TestEndpoint(http.MethodGet, "/reports/sales?format=broken", nil, token)
+		validateError(t, http.StatusInternalServerError, recorder)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/reports/sales?format=sage", nil, token)
+		validateError(t, http.StatusBadRequest, recorder, "Unknown report format 'sage'")
+	})
 }
 
 func TestProductsReport(t *testing.T) {
@@ -43,3 +161,86 @@ func TestProductsReport(t *testing.T) {
 	assert.Equal(t, "456-i-rollover-all-things", prod3.Sku)
 	assert.Equal(t, uint64(10), prod3.Total)
 }
+
+func TestRefundsReport(t *testing.T) {
+	test := NewRouteTest(t)
+	amounts := []uint64{10, 20, 50}
+	for i, reason := range []models.RefundReason{models.RefundReasonDefective, models.RefundReasonDefective, models.RefundReasonFraudulent} {
+		refund := models.NewTransaction(test.Data.firstOrder)
+		refund.Type = models.RefundTransactionType
+		refund.Status = models.PaidState
+		refund.Amount = amounts[i]
+		refund.RefundReason = reason
+		if i == 0 {
+			fee, net := int64(-2), uint64(8)
+			refund.Fee = &fee
+			refund.NetAmount = &net
+		}
+		require.NoError(t, test.DB.Create(refund).Error)
+	}
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder := test.TestEndpoint(http.MethodGet, "/reports/refunds", nil, token)
+
+	report := []refundsRow{}
+	extractPayload(t, http.StatusOK, recorder, &report)
+	require.Len(t, report, 2)
+	assert.Equal(t, "fraudulent", report[0].Reason)
+	assert.Equal(t, uint64(50), report[0].Total)
+	assert.Equal(t, uint64(1), report[0].Refunds)
+	assert.Equal(t, "defective", report[1].Reason)
+	assert.Equal(t, uint64(30), report[1].Total)
+	assert.Equal(t, uint64(2), report[1].Refunds)
+	assert.Equal(t, uint64(8), report[1].Net)
+	assert.Equal(t, int64(-2), report[1].Fees)
+	assert.Equal(t, uint64(1), report[1].UnknownFees)
+	assert.Equal(t, uint64(1), report[0].UnknownFees)
+}
+
+func TestProductRefundsReport(t *testing.T) {
+	test := NewRouteTest(t)
+	allocations := [][]*models.RefundItem{
+		{{LineItemID: 11, Sku: "123-i-can-fly-456", Path: "/i/believe/i/can/fly", Quantity: 1, Amount: 12}},
+		{{LineItemID: 11, Sku: "123-i-can-fly-456", Path: "/i/believe/i/can/fly", Amount: 5}},
+	}
+	for _, items := range allocations {
+		refund := models.NewTransaction(test.Data.firstOrder)
+		refund.Type = models.RefundTransactionType
+		refund.Status = models.PaidState
+		refund.RefundReason = models.RefundReasonDefective
+		refund.RefundItems = items
+		for _, item := range items {
+			refund.Amount += item.Amount
+		}
+		require.NoError(t, test.DB.Create(refund).Error)
+	}
+	failed := models.NewTransaction(test.Data.firstOrder)
+	failed.Type = models.RefundTransactionType
+	failed.Status = models.FailedState
+	failed.Amount = 7
+	failed.RefundItems = []*models.RefundItem{{LineItemID: 11, Sku: "123-i-can-fly-456", Amount: 7}}
+	require.NoError(t, test.DB.Create(failed).Error)
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder := test.TestEndpoint(http.MethodGet, "/reports/refunds/products", nil, token)
+
+	report := []productRefundsRow{}
+	extractPayload(t, http.StatusOK, recorder, &report)
+	require.Len(t, report, 1)
+	assert.Equal(t, "123-i-can-fly-456", report[0].Sku)
+	assert.Equal(t, "/i/believe/i/can/fly", report[0].Path)
+	assert.Equal(t, uint64(17), report[0].Total)
+	assert.Equal(t, uint64(1), report[0].Quantity)
+	assert.Equal(t, uint64(2), report[0].Refunds)
+	assert.Equal(t, "USD", report[0].Currency)
+}
+
+func TestEscapeCSVFormula(t *testing.T) {
+	assert.Equal(t, "'=1+1", escapeCSVFormula("=1+1"))
+	assert.Equal(t, "'+1+1", escapeCSVFormula("+1+1"))
+	assert.Equal(t, "'-1+cmd", escapeCSVFormula("-1+cmd"))
+	assert.Equal(t, "'@SUM(A1)", escapeCSVFormula("@SUM(A1)"))
+	assert.Equal(t, "-12.50", escapeCSVFormula("-12.50"), "negative amounts stay numbers")
+	assert.Equal(t, "newsletter", escapeCSVFormula("newsletter"))
+	assert.Equal(t, "", escapeCSVFormula(""))
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type returnParams struct {
+	LineItems []int64 `json:"line_items"`
+}
+
+// ReturnCreate records the return of some fulfilled line items of a paid
+// order. The order is marked returned once all of its line items are. Refunds
+// are issued separately. Requires admin permissions
+func (a *API) ReturnCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	params := new(returnParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read return parameters: %v", err)
+	}
+	order, httpErr := a.loadFulfillmentOrder(orderID, "returned")
+	if httpErr != nil {
+		return httpErr
+	}
+	returned, httpErr := selectLineItems(order, params.LineItems)
+	if httpErr != nil {
+		return httpErr
+	}
+	for _, item := range returned {
+		if !item.Fulfilled() {
+			return badRequestError("Line item %d can't be returned while it's %s", item.ID, item.FulfillmentState)
+		}
+	}
+
+	for _, item := range returned {
+		item.FulfillmentState = models.ReturnedState
+	}
+	order.UpdateFulfillmentState()
+
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving return").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventUpdated, []string{"return"})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error committing return").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Returned %d line items of order %s", len(returned), order.ID)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReturnCreate(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/simple-product", "quantity": 1},
+			{"path": "/limited-product", "quantity": 1}
+		]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 2)
+	first, second := order.LineItems[0], order.LineItems[1]
+	order.PaymentState = models.PaidState
+	require.NoError(t, test.DB.Save(order).Error)
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	url := "/orders/" + order.ID + "/returns"
+	items := func(ids ...int64) *strings.Reader {
+		list := []string{}
+		for _, id := range ids {
+			list = append(list, fmt.Sprint(id))
+		}
+		return strings.NewReader(`{"line_items": [` + strings.Join(list, ",") + `]}`)
+	}
+
+	recorder = test.TestEndpoint(http.MethodPost, url, items(first.ID), test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, items(first.ID), token)
+	validateError(t, http.StatusBadRequest, recorder, "can't be returned while it's pending")
+
+	// the first item is delivered, the second one only shipped
+	recorder = test.TestEndpoint(http.MethodPut, fmt.Sprintf("/orders/%s/line_items/%d/fulfillment", order.ID, first.ID), strings.NewReader(`{"fulfillment_state": "delivered"}`), token)
+	updated := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, updated)
+	assert.Equal(t, models.PartiallyFulfilledState, updated.FulfillmentState)
+	assert.Equal(t, models.DeliveredState, updated.LineItems[0].FulfillmentState, "the state of every line item is shown")
+	assert.Equal(t, models.PendingState, updated.LineItems[1].FulfillmentState)
+
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/shipments", items(second.ID), token)
+	updated = &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, updated)
+	assert.Equal(t, models.FulfilledState, updated.FulfillmentState)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, items(first.ID), token)
+	updated = &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, updated)
+	assert.Equal(t, models.FulfilledState, updated.FulfillmentState, "returned items don't reopen the order")
+	assert.Equal(t, models.ReturnedState, updated.LineItems[0].FulfillmentState)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, items(first.ID), token)
+	validateError(t, http.StatusBadRequest, recorder, "can't be returned while it's returned")
+
+	recorder = test.TestEndpoint(http.MethodPost, "/orders/"+order.ID+"/shipments", items(first.ID), token)
+	validateError(t, http.StatusBadRequest, recorder, "was already returned")
+
+	recorder = test.TestEndpoint(http.MethodPost, url, items(second.ID), token)
+	updated = &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, updated)
+	assert.Equal(t, models.ReturnedState, updated.FulfillmentState)
+
+	saved := &models.Order{}
+	require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, models.ReturnedState, saved.FulfillmentState)
+}
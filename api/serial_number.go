@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/pkg/errors"
+)
+
+type serialNumberParams struct {
+	Serials []string `json:"serials"`
+}
+
+// SerialNumberAssign assigns serial numbers to the units of a line item of a
+// paid order when they're fulfilled. Serials are taken from the request, or
+// generated for all units still missing one if the request has none. Assigned
+// serial numbers can't be changed. Requires admin permissions.
+func (a *API) SerialNumberAssign(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	itemID, err := strconv.ParseInt(chi.URLParam(r, "line_item_id"), 10, 64)
+	if err != nil {
+		return badRequestError("Invalid line item id: %v", err)
+	}
+
+	params := new(serialNumberParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read serial number parameters: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, serial := range params.Serials {
+		serial = strings.TrimSpace(serial)
+		if serial == "" {
+			return badRequestError("Serial numbers can't be empty")
+		}
+		if seen[serial] {
+			return badRequestError("Serial number %s is assigned more than once", serial)
+		}
+		seen[serial] = true
+		params.Serials[i] = serial
+	}
+
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if order.PaymentState != models.PaidState {
+		return badRequestError("Only paid orders can be fulfilled")
+	}
+	switch order.FulfillmentState {
+	case models.OnHoldState, models.RejectedState:
+		return badRequestError("Orders that are %s can't be fulfilled", order.FulfillmentState)
+	}
+
+	var item *models.LineItem
+	for _, i := range order.LineItems {
+		if i.ID == itemID {
+			item = i
+			break
+		}
+	}
+	if item == nil {
+		return notFoundError("Failed to find line item %d in order '%s'", itemID, orderID)
+	}
+
+	// the quantity can be lowered below the number of assigned serials
+	assigned := uint64(len(order.SerialsFor(item)))
+	if assigned >= item.Quantity {
+		return badRequestError("All units of line item %d already have a serial number", item.ID)
+	}
+	missing := item.Quantity - assigned
+	if uint64(len(params.Serials)) > missing {
+		return badRequestError("Line item %d only has %d units without a serial number", item.ID, missing)
+	}
+	if len(params.Serials) == 0 {
+		format := config.SerialNumbers.Format
+		if format == "" {
+			format = models.DefaultSerialNumberFormat
+		}
+		for n := uint64(0); n < missing; n++ {
+			serial, err := models.GenerateSerial(format)
+			if err != nil {
+				return internalServerError("Error generating serial number").WithInternalError(err)
+			}
+			params.Serials = append(params.Serials, serial)
+		}
+	}
+
+	instanceID := gcontext.GetInstanceID(ctx)
+	taken := []models.SerialNumber{}
+	if rsp := a.db.Where("instance_id = ? AND serial IN (?)", instanceID, params.Serials).Find(&taken); rsp.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+	if len(taken) > 0 {
+		return httpError(http.StatusConflict, "Serial number %s is already assigned", taken[0].Serial)
+	}
+
+	tx := a.db.Begin()
+	for _, serial := range params.Serials {
+		serialNumber := models.NewSerialNumber(order, item, serial)
+		if rsp := tx.Create(serialNumber); rsp.Error != nil {
+			tx.Rollback()
+			return internalServerError("Error assigning serial number").WithInternalError(errors.Wrap(rsp.Error, serial))
+		}
+		order.SerialNumbers = append(order.SerialNumbers, *serialNumber)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventUpdated, []string{"serial_numbers"})
+	if rsp := tx.Commit(); rsp.Error != nil {
+		return internalServerError("Error committing serial numbers").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Assigned %d serial numbers to line item %d of order %s", len(params.Serials), item.ID, order.ID)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
+
+// SerialNumberView looks up a serial number, e.g. for a warranty claim, with
+// the order and line item it was assigned to. Requires admin permissions.
+func (a *API) SerialNumberView(w http.ResponseWriter, r *http.Request) error {
+	instanceID := gcontext.GetInstanceID(r.Context())
+	serial := chi.URLParam(r, "serial")
+
+	serialNumber := &models.SerialNumber{}
+	rsp := a.db.First(serialNumber, "instance_id = ? AND serial = ?", instanceID, serial)
+	if rsp.RecordNotFound() {
+		return notFoundError("Serial number not found")
+	}
+	if rsp.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(rsp.Error)
+	}
+	return sendJSON(w, http.StatusOK, serialNumber)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialNumbers(t *testing.T) {
+	t.Run("Supplied", func(t *testing.T) {
+		test := invoiceRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		url := fmt.Sprintf("/orders/first-order/line_items/%d/serials", test.Data.firstLineItem.ID)
+
+		recorder := test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0001"]}`), token)
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		require.Len(t, order.SerialNumbers, 1)
+		assert.Equal(t, "BW-0001", order.SerialNumbers[0].Serial)
+		assert.Equal(t, test.Data.firstLineItem.ID, order.SerialNumbers[0].LineItemID)
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0002", "BW-0003"]}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "only has 1 units without a serial number")
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0001"]}`), token)
+		validateError(t, http.StatusConflict, recorder, "BW-0001 is already assigned")
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0002"]}`), token)
+		extractPayload(t, http.StatusOK, recorder, order)
+		require.Len(t, order.SerialNumbers, 2)
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0003"]}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "already have a serial number")
+
+		// the customer sees the serials of their order
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order", nil, test.Data.testUserToken)
+		order = &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		assert.Len(t, order.SerialNumbers, 2)
+
+		// and on the invoice
+		recorder = test.TestEndpoint(http.MethodGet, "/orders/first-order/invoice", nil, test.Data.testUserToken)
+		invoice := &models.Invoice{}
+		extractPayload(t, http.StatusOK, recorder, invoice)
+		require.NotEmpty(t, invoice.Lines)
+		assert.Equal(t, []string{"BW-0001", "BW-0002"}, invoice.Lines[0].Serials)
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SerialNumbers.Format = "BAT-XXXX"
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		url := fmt.Sprintf("/orders/first-order/line_items/%d/serials", test.Data.firstLineItem.ID)
+
+		recorder := test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{}`), token)
+		order := &models.Order{}
+		extractPayload(t, http.StatusOK, recorder, order)
+		require.Len(t, order.SerialNumbers, 2)
+		for _, serial := range order.SerialNumbers {
+			assert.Regexp(t, `^BAT-[A-Z2-9]{4}$`, serial.Serial)
+		}
+		assert.NotEqual(t, order.SerialNumbers[0].Serial, order.SerialNumbers[1].Serial)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		url := fmt.Sprintf("/orders/first-order/line_items/%d/serials", test.Data.firstLineItem.ID)
+
+		recorder := test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-1", "BW-1"]}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "more than once")
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": [" "]}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "can't be empty")
+
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-1"]}`), test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/orders/first-order/line_items/999999/serials", strings.NewReader(`{"serials": ["BW-1"]}`), token)
+		validateError(t, http.StatusNotFound, recorder)
+
+		test.Data.firstOrder.PaymentState = models.PendingState
+		require.NoError(t, test.DB.Save(test.Data.firstOrder).Error)
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-1"]}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "Only paid orders")
+	})
+
+	t.Run("QuantityLowered", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		url := fmt.Sprintf("/orders/first-order/line_items/%d/serials", test.Data.firstLineItem.ID)
+
+		recorder := test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0001", "BW-0002"]}`), token)
+		extractPayload(t, http.StatusOK, recorder, &models.Order{})
+
+		test.Data.firstLineItem.Quantity = 1
+		require.NoError(t, test.DB.Save(test.Data.firstLineItem).Error)
+		recorder = test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{}`), token)
+		validateError(t, http.StatusBadRequest, recorder, "already have a serial number")
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		url := fmt.Sprintf("/orders/first-order/line_items/%d/serials", test.Data.firstLineItem.ID)
+		recorder := test.TestEndpoint(http.MethodPost, url, strings.NewReader(`{"serials": ["BW-0001"]}`), token)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		recorder = test.TestEndpoint(http.MethodGet, "/serials/BW-0001", nil, token)
+		serial := &models.SerialNumber{}
+		extractPayload(t, http.StatusOK, recorder, serial)
+		assert.Equal(t, "first-order", serial.OrderID)
+		assert.Equal(t, test.Data.firstLineItem.ID, serial.LineItemID)
+		assert.Equal(t, test.Data.firstLineItem.Sku, serial.Sku)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/serials/BW-0001", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/serials/BW-9999", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
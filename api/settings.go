@@ -27,6 +27,10 @@ func (a *API) ViewSettings(w http.ResponseWriter, r *http.Request) error {
 		pms.PayPal.ClientID = config.Payment.PayPal.ClientID
 		pms.PayPal.Environment = config.Payment.PayPal.Env
 	}
+	if config.Payment.Braintree.Enabled {
+		pms.Braintree.Enabled = true
+		pms.Braintree.Environment = config.Payment.Braintree.Env
+	}
 	settings.PaymentMethods = pms
 
 	sendJSON(w, 200, settings)
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+type shipmentParams struct {
+	LineItems []int64 `json:"line_items"`
+}
+
+// ShipmentCreate records a shipment of some line items of a paid order and
+// notifies the customer. While items remain they get a partial shipment notice
+// listing what shipped and what's still to come, the shipment completing the
+// order is announced with an order complete notice. Requires admin permissions
+func (a *API) ShipmentCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+	claims := gcontext.GetClaims(ctx)
+
+	params := new(shipmentParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read shipment parameters: %v", err)
+	}
+	order, httpErr := a.loadFulfillmentOrder(orderID, "shipped")
+	if httpErr != nil {
+		return httpErr
+	}
+	shipped, httpErr := selectLineItems(order, params.LineItems)
+	if httpErr != nil {
+		return httpErr
+	}
+	for _, item := range shipped {
+		if !item.Outstanding() {
+			return badRequestError("Line item %d was already %s", item.ID, item.FulfillmentState)
+		}
+	}
+
+	for _, item := range shipped {
+		item.FulfillmentState = models.ShippedState
+	}
+	fulfilled := order.UpdateFulfillmentState()
+
+	tx := a.db.Begin()
+	if rsp := tx.Save(order); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error saving shipment").WithInternalError(rsp.Error)
+	}
+	models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventUpdated, []string{"shipment"})
+	if fulfilled {
+		models.LogEvent(tx, r.RemoteAddr, claims.Subject, order.ID, models.EventFulfilled, []string{"fulfillment_state"})
+	}
+	if rsp := tx.Commit(); rsp.Error != nil {
+		tx.Rollback()
+		return internalServerError("Error committing shipment").WithInternalError(rsp.Error)
+	}
+
+	log.Infof("Shipped %d line items of order %s", len(shipped), order.ID)
+	sendShipmentMail(ctx, order, shipped, log)
+	return sendJSON(w, http.StatusOK, presentOrder(r, order))
+}
+
+// sendShipmentMail notifies the customer of a shipment in the background.
+func sendShipmentMail(ctx context.Context, order *models.Order, shipped []*models.LineItem, log logrus.FieldLogger) {
+	mailer := gcontext.GetMailer(ctx)
+	go func() {
+		if err := mailer.ShipmentMail(order, shipped); err != nil {
+			log.WithError(err).Error("Error sending shipment mail")
+		}
+	}()
+}
+
+// loadFulfillmentOrder loads a paid order whose line items can be shipped or
+// returned.
+func (a *API) loadFulfillmentOrder(orderID string, action string) (*models.Order, *HTTPError) {
+	order := new(models.Order)
+	rsp := orderQuery(a.db).First(order, "id = ?", orderID)
+	if rsp.RecordNotFound() {
+		return nil, notFoundError("Failed to find order with id '%s'", orderID)
+	}
+	if rsp.Error != nil {
+		return nil, internalServerError("Error while querying for order").WithInternalError(rsp.Error)
+	}
+	if order.PaymentState != models.PaidState {
+		return nil, badRequestError("Only paid orders can be %s", action)
+	}
+	switch order.FulfillmentState {
+	case models.OnHoldState, models.RejectedState:
+		return nil, badRequestError("Orders that are %s can't be %s", order.FulfillmentState, action)
+	}
+	return order, nil
+}
+
+// selectLineItems finds the line items of an order listed in a shipment or
+// return. Every line item can only be listed once.
+func selectLineItems(order *models.Order, ids []int64) ([]*models.LineItem, *HTTPError) {
+	if len(ids) == 0 {
+		return nil, badRequestError("At least one line item is required")
+	}
+	items := []*models.LineItem{}
+	seen := map[int64]bool{}
+	for _, itemID := range ids {
+		if seen[itemID] {
+			return nil, badRequestError("Line item %d is listed more than once", itemID)
+		}
+		seen[itemID] = true
+
+		var item *models.LineItem
+		for _, i := range order.LineItems {
+			if i.ID == itemID {
+				item = i
+				break
+			}
+		}
+		if item == nil {
+			return nil, notFoundError("Failed to find line item %d in order '%s'", itemID, order.ID)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
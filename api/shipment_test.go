@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShipmentCreate(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/simple-product", "quantity": 1},
+			{"path": "/limited-product", "quantity": 1},
+			{"path": "/download-product", "quantity": 1}
+		]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+	require.Len(t, order.LineItems, 3)
+	first, second, digital := order.LineItems[0], order.LineItems[1], order.LineItems[2]
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	url := "/orders/" + order.ID + "/shipments"
+	shipment := func(ids string) *strings.Reader {
+		return strings.NewReader(`{"line_items": [` + ids + `]}`)
+	}
+	id := func(item *models.LineItem) string {
+		return strconv.FormatInt(item.ID, 10)
+	}
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(first)), token)
+	validateError(t, http.StatusBadRequest, recorder, "Only paid orders can be shipped")
+
+	order.PaymentState = models.PaidState
+	order.FulfillDigitalItems()
+	require.NoError(t, test.DB.Save(order).Error)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(first)), test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(""), token)
+	validateError(t, http.StatusBadRequest, recorder, "At least one line item")
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(first)+","+id(first)), token)
+	validateError(t, http.StatusBadRequest, recorder, "listed more than once")
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment("999999"), token)
+	validateError(t, http.StatusNotFound, recorder, "Failed to find line item 999999")
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(digital)), token)
+	validateError(t, http.StatusBadRequest, recorder, "already fulfilled")
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(first)), token)
+	partial := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, partial)
+	assert.Equal(t, models.PartiallyFulfilledState, partial.FulfillmentState, "the order isn't complete yet")
+	saved := &models.LineItem{}
+	require.NoError(t, test.DB.First(saved, first.ID).Error)
+	assert.Equal(t, models.ShippedState, saved.FulfillmentState)
+	saved = &models.LineItem{}
+	require.NoError(t, test.DB.First(saved, second.ID).Error)
+	assert.Equal(t, models.PendingState, saved.FulfillmentState)
+
+	recorder = test.TestEndpoint(http.MethodPost, url, shipment(id(second)), token)
+	complete := &models.Order{}
+	extractPayload(t, http.StatusOK, recorder, complete)
+	assert.Equal(t, models.FulfilledState, complete.FulfillmentState)
+
+	count := 0
+	require.NoError(t, test.DB.Model(&models.Event{}).Where("order_id = ? AND type = ?", order.ID, models.EventFulfilled).Count(&count).Error)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const taxCodeOrderBody = `{
+	"email": "info@example.com",
+	"shipping_address": {
+		"name": "Test User",
+		"address1": "Friedrichstraße 1",
+		"city": "Berlin", "country": "Germany", "zip": "10117"
+	},
+	"line_items": [
+		{"path": "/reduced-product", "quantity": 1},
+		{"path": "/simple-product", "quantity": 1}
+	]
+}`
+
+func TestOrderCreateTaxCode(t *testing.T) {
+	t.Run("Known", func(t *testing.T) {
+		server := startTestSiteWithSettings(&calculator.Settings{
+			Taxes: []*calculator.Tax{
+				{Code: "reduced", Percentage: 7, Countries: []string{"Germany"}},
+				{Percentage: 19, Countries: []string{"Germany"}},
+			},
+		})
+		defer server.Close()
+
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(taxCodeOrderBody), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		require.Len(t, order.LineItems, 2)
+		for _, item := range order.LineItems {
+			if item.Sku == "reduced-1" {
+				assert.Equal(t, "reduced", item.TaxCode)
+				assert.Equal(t, uint64(70), item.Taxes)
+			} else {
+				assert.Empty(t, item.TaxCode)
+				assert.Equal(t, uint64(190), item.Taxes)
+			}
+		}
+		assert.Equal(t, []calculator.TaxItem{
+			{Jurisdiction: "Germany", Code: "reduced", Rate: 7, Amount: 70},
+			{Jurisdiction: "Germany", Rate: 19, Amount: 190},
+		}, order.TaxBreakdown)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		server := startTestSiteWithSettings(&calculator.Settings{
+			Taxes: []*calculator.Tax{
+				{Percentage: 19, Countries: []string{"Germany"}},
+			},
+		})
+		defer server.Close()
+
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(taxCodeOrderBody), test.Data.testUserToken)
+		validateError(t, http.StatusInternalServerError, recorder, "Unknown tax code 'reduced'")
+	})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+type taxEstimateParams struct {
+	Currency        string                 `json:"currency"`
+	ShippingAddress *models.AddressRequest `json:"shipping_address"`
+	LineItems       []*orderLineItem       `json:"line_items"`
+	CouponCode      string                 `json:"coupon"`
+	Tip             uint64                 `json:"tip"`
+}
+
+type taxEstimate struct {
+	*orderTotals
+	Currency  string             `json:"currency"`
+	LineItems []*models.LineItem `json:"line_items"`
+}
+
+// TaxEstimate calculates the taxes of a cart shipped to an address without
+// creating an order. The line items are priced and taxed exactly like those of
+// a new order, so storefronts can show the taxes before checkout.
+func (a *API) TaxEstimate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	log := getLogEntry(r)
+
+	params := &taxEstimateParams{Currency: "USD"}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read tax estimate params: %v", err)
+	}
+	orderParams := &orderRequestParams{Currency: params.Currency, LineItems: params.LineItems}
+	if httpError := checkLineItemCount(r, orderParams); httpError != nil {
+		return httpError
+	}
+	if httpError := validateOrderParams(orderParams, false); httpError != nil {
+		return httpError
+	}
+	if params.ShippingAddress == nil || params.ShippingAddress.Country == "" {
+		return badRequestError("A shipping address with a country is required")
+	}
+	if !currencyEnabled(config, params.Currency) {
+		return badRequestError("Orders in %s are not supported", params.Currency)
+	}
+
+	order := models.NewOrder(gcontext.GetInstanceID(ctx), "", "", params.Currency)
+	order.ShippingAddress.AddressRequest = *params.ShippingAddress
+	order.Tip = params.Tip
+	if params.CouponCode != "" {
+		coupon, err := a.lookupCoupon(ctx, w, params.CouponCode)
+		if err != nil {
+			return err
+		}
+		if !coupon.Valid() {
+			return badRequestError("This coupon is not valid at this time")
+		}
+		order.CouponCode = coupon.Code
+		order.Coupon = coupon
+	}
+
+	if httpError := a.lookupLineItems(ctx, order, params.LineItems); httpError != nil {
+		return httpError
+	}
+	if httpError := a.calculateOrderTotal(ctx, order, log); httpError != nil {
+		return httpError
+	}
+
+	estimate := &taxEstimate{
+		orderTotals: newOrderTotals(order),
+		Currency:    order.Currency,
+		LineItems:   order.LineItems,
+	}
+	return sendJSON(w, http.StatusOK, redactForCustomer(r, estimate))
+}
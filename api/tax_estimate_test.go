@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaxEstimate(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	cart := `"line_items": [
+		{"path": "/simple-product", "quantity": 2},
+		{"path": "/download-product", "quantity": 1}
+	]`
+	address := `"shipping_address": {
+		"name": "Test User",
+		"address1": "Unter den Linden 1",
+		"city": "Berlin", "country": "Germany", "zip": "10117"
+	}`
+
+	t.Run("MatchesOrder", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+
+		var lineItems, orders int
+		require.NoError(t, test.DB.Model(&models.LineItem{}).Count(&lineItems).Error)
+		require.NoError(t, test.DB.Model(&models.Order{}).Count(&orders).Error)
+
+		recorder := test.TestEndpoint(http.MethodPost, "/tax/estimate", strings.NewReader(`{`+address+`, `+cart+`}`), nil)
+		estimate := struct {
+			orderTotals
+			Currency  string             `json:"currency"`
+			LineItems []*models.LineItem `json:"line_items"`
+		}{}
+		extractPayload(t, http.StatusOK, recorder, &estimate)
+		assert.Equal(t, "USD", estimate.Currency)
+		assert.Len(t, estimate.LineItems, 2)
+		assert.Equal(t, []calculator.TaxItem{
+			{Jurisdiction: "Germany", Rate: 7, Amount: 140},
+			{Jurisdiction: "Germany", Rate: 19, Amount: 95},
+		}, estimate.TaxBreakdown)
+
+		var afterLineItems, afterOrders int
+		require.NoError(t, test.DB.Model(&models.LineItem{}).Count(&afterLineItems).Error)
+		require.NoError(t, test.DB.Model(&models.Order{}).Count(&afterOrders).Error)
+		assert.Equal(t, lineItems, afterLineItems)
+		assert.Equal(t, orders, afterOrders)
+
+		body := `{"email": "info@example.com", ` + address + `, ` + cart + `}`
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, order.SubTotal, estimate.SubTotal)
+		assert.Equal(t, order.Taxes, estimate.Taxes)
+		assert.Equal(t, order.TaxBreakdown, estimate.TaxBreakdown)
+		assert.Equal(t, order.Total, estimate.Total)
+	})
+
+	t.Run("MissingCountry", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		recorder := test.TestEndpoint(http.MethodPost, "/tax/estimate", strings.NewReader(`{`+cart+`}`), nil)
+		validateError(t, http.StatusBadRequest, recorder, "A shipping address with a country is required")
+	})
+
+	t.Run("InvalidLineItem", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		body := `{` + address + `, "line_items": [{"path": "/simple-product", "quantity": 0}]}`
+		recorder := test.TestEndpoint(http.MethodPost, "/tax/estimate", strings.NewReader(body), nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+}
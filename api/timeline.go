@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+)
+
+// Types of the entries on an order's timeline. Transactions use their own
+// type, models.ChargeTransactionType or models.RefundTransactionType.
+const (
+	timelineEvent           = "event"
+	timelineNote            = "note"
+	timelineWebhookDelivery = "webhook_delivery"
+)
+
+// timelineEntry is something that happened to an order. Data is the record
+// the entry is based on, e.g. the event or transaction.
+type timelineEntry struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// OrderTimeline lists the events, transactions, notes and webhook deliveries
+// of an order, oldest first. Requires admin permissions
+func (a *API) OrderTimeline(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+
+	order := &models.Order{}
+	if result := a.db.First(order, "id = ?", orderID); result.Error != nil {
+		if result.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	events := []models.Event{}
+	transactions := []models.Transaction{}
+	notes := []models.OrderNote{}
+	deliveries := []models.WebhookDelivery{}
+	for _, records := range []interface{}{&events, &transactions, &notes, &deliveries} {
+		if result := a.db.Where("order_id = ?", order.ID).Order("created_at asc").Find(records); result.Error != nil {
+			return internalServerError("Error during database query").WithInternalError(result.Error)
+		}
+	}
+
+	entries := []timelineEntry{}
+	for i := range events {
+		entries = append(entries, timelineEntry{Type: timelineEvent, Time: events[i].CreatedAt, Data: &events[i]})
+	}
+	for i := range transactions {
+		entries = append(entries, timelineEntry{Type: transactions[i].Type, Time: transactions[i].CreatedAt, Data: presentTransaction(r, &transactions[i])})
+	}
+	for i := range notes {
+		entries = append(entries, timelineEntry{Type: timelineNote, Time: notes[i].CreatedAt, Data: &notes[i]})
+	}
+	for i := range deliveries {
+		entries = append(entries, timelineEntry{Type: timelineWebhookDelivery, Time: deliveries[i].CreatedAt, Data: &deliveries[i]})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return sendJSON(w, http.StatusOK, entries)
+}
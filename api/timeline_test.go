@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timelinePayload struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+func TestOrderTimeline(t *testing.T) {
+	t.Run("Sorted", func(t *testing.T) {
+		test := NewRouteTest(t)
+		start := time.Now().Add(-time.Hour)
+		require.NoError(t, test.DB.Model(test.Data.firstTransaction).UpdateColumn("created_at", start.Add(2*time.Minute)).Error)
+		require.NoError(t, test.DB.Create(&models.Event{OrderID: "first-order", Type: string(models.EventCreated), CreatedAt: start}).Error)
+		require.NoError(t, test.DB.Create(&models.OrderNote{OrderID: "first-order", Text: "Customer called", CreatedAt: start.Add(time.Minute)}).Error)
+		require.NoError(t, test.DB.Create(&models.WebhookDelivery{OrderID: "first-order", Type: "payment", StatusCode: 200, CreatedAt: start.Add(3 * time.Minute)}).Error)
+		require.NoError(t, test.DB.Create(&models.Event{OrderID: "second-order", Type: string(models.EventCreated), CreatedAt: start}).Error)
+
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/timeline", nil, token)
+		entries := []timelinePayload{}
+		extractPayload(t, http.StatusOK, recorder, &entries)
+
+		types := []string{}
+		for _, entry := range entries {
+			types = append(types, entry.Type)
+		}
+		assert.Equal(t, []string{"event", "note", "charge", "webhook_delivery"}, types)
+	})
+
+	t.Run("NotAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/first-order/timeline", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodGet, "/orders/missing/timeline", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+}
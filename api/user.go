@@ -2,9 +2,9 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/jinzhu/gorm"
@@ -77,12 +77,33 @@ func persistUserName(tx *gorm.DB, order *models.Order, claims *claims.JWTClaims)
 // since     iso8601 date
 // before		 iso8601 date
 // email     email
+// search    part of the email or name
 // user_id   id
-// limit     # of records to return (max)
+// sort      created_at, email, order_count or lifetime_spend, optionally followed by asc or desc
+// The list is paginated with keyset pagination, which stays fast for large
+// customer bases.
 func (a *API) UserList(w http.ResponseWriter, r *http.Request) error {
 	log := getLogEntry(r)
+	params := r.URL.Query()
 
-	query, err := parseUserQueryParams(a.db, r.URL.Query())
+	page, httpErr := parsePagination(params)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	sortName, dir, err := parseSort(params.Get("sort"), ascending)
+	if err != nil {
+		return err
+	}
+	if sortName == "" {
+		sortName = "created_at"
+	}
+	field, ok := userSortFields[sortName]
+	if !ok {
+		return badRequestError("Bad field for sort '%v'", sortName)
+	}
+
+	query, err := parseUserQueryParams(a.db, params)
 	if err != nil {
 		return badRequestError("Bad parameters in query: %v", err)
 	}
@@ -97,27 +118,31 @@ func (a *API) UserList(w http.ResponseWriter, r *http.Request) error {
 	instanceID := gcontext.GetInstanceID(r.Context())
 	query = query.Where(userTable+".instance_id = ?", instanceID)
 
-	offset, limit, err := paginate(w, r, query.Model(&models.User{}))
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return sendJSON(w, http.StatusOK, []string{})
-		}
-		return badRequestError("Bad Pagination Parameters: %v", err)
+	query, httpErr = paginate(query, page, &sortKey{
+		expression: field.expression(userTable, orderTable),
+		idColumn:   userTable + ".id",
+		dir:        dir,
+		aggregate:  field.aggregate,
+		parse:      field.parse,
+	})
+	if httpErr != nil {
+		return httpErr
 	}
 
-	query = query.Select("" +
-		"COUNT(" + orderTable + ".id) AS order_count, " +
-		"MAX(" + orderTable + ".created_at) AS last_order_at, " +
-		userTable + ".*")
-
 	users := []models.User{}
-	if err := query.Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+	if err := query.Select(userListSelect(userTable, orderTable)).Find(&users).Error; err != nil {
 		return internalServerError("Failed to execute request").WithInternalError(err)
 	}
 
-	numUsers := len(users)
-	log.WithField("user_count", numUsers).Debugf("Successfully retrieved %d users", numUsers)
-	return sendJSON(w, http.StatusOK, users)
+	next := ""
+	if page.hasMore(len(users)) {
+		users = users[:page.Limit]
+		last := &users[len(users)-1]
+		next = encodeCursor(&pageCursor{Value: field.value(last), ID: last.ID})
+	}
+
+	log.WithField("user_count", len(users)).Debugf("Successfully retrieved %d users", len(users))
+	return sendJSON(w, http.StatusOK, &listPage{Items: users, NextCursor: next})
 }
 
 // UserView will return the user specified.
@@ -289,3 +314,48 @@ func (a *API) CreateNewAddress(w http.ResponseWriter, r *http.Request) error {
 
 	return sendJSON(w, http.StatusOK, &struct{ ID string }{ID: addr.ID})
 }
+
+// userListSelect selects the users together with their order aggregates.
+func userListSelect(userTable, orderTable string) string {
+	return "" +
+		"COUNT(" + orderTable + ".id) AS order_count, " +
+		userLifetimeSpend(orderTable) + " AS lifetime_spend, " +
+		"MAX(" + orderTable + ".created_at) AS last_order_at, " +
+		userTable + ".*"
+}
+
+func userLifetimeSpend(orderTable string) string {
+	return "COALESCE(SUM(CASE WHEN " + orderTable + ".payment_state = '" + models.PaidState + "' THEN " + orderTable + ".total ELSE 0 END), 0)"
+}
+
+type userSortField struct {
+	expression func(userTable, orderTable string) string
+	aggregate  bool
+	value      func(user *models.User) string
+	parse      func(value string) (interface{}, error)
+}
+
+var userSortFields = map[string]userSortField{
+	"created_at": {
+		expression: func(userTable, orderTable string) string { return userTable + ".created_at" },
+		value:      func(u *models.User) string { return formatCursorTime(u.CreatedAt) },
+		parse:      parseCursorTime,
+	},
+	"email": {
+		expression: func(userTable, orderTable string) string { return userTable + ".email" },
+		value:      func(u *models.User) string { return u.Email },
+		parse:      parseCursorString,
+	},
+	"order_count": {
+		expression: func(userTable, orderTable string) string { return "COUNT(" + orderTable + ".id)" },
+		aggregate:  true,
+		value:      func(u *models.User) string { return strconv.FormatInt(u.OrderCount, 10) },
+		parse:      parseCursorNumber,
+	},
+	"lifetime_spend": {
+		expression: func(userTable, orderTable string) string { return userLifetimeSpend(orderTable) },
+		aggregate:  true,
+		value:      func(u *models.User) string { return strconv.FormatUint(u.LifetimeSpend, 10) },
+		parse:      parseCursorNumber,
+	},
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,7 +41,7 @@ func TestUsersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/users", nil, token)
 
 		users := []models.User{}
-		extractPayload(t, http.StatusOK, recorder, &users)
+		extractPage(t, http.StatusOK, recorder, &users)
 		require.Len(t, users, 2)
 		for _, u := range users {
 			switch u.ID {
@@ -67,7 +68,7 @@ func TestUsersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, "/users?email=dc.com", nil, token)
 
 		users := []models.User{}
-		extractPayload(t, http.StatusOK, recorder, &users)
+		extractPage(t, http.StatusOK, recorder, &users)
 		require.Len(t, users, 1)
 		assert.Equal(t, "villian", users[0].ID)
 	})
@@ -76,13 +77,20 @@ func TestUsersList(t *testing.T) {
 		createUser(test, "villian", "twoface@dc.com", "Harvey Dent")
 
 		token := testAdminToken("magical-unicorn", "")
-		reqUrl := "/users?per_page=1"
-		recorder := test.TestEndpoint(http.MethodGet, reqUrl, nil, token)
+		recorder := test.TestEndpoint(http.MethodGet, "/users?limit=1&sort=email", nil, token)
 
 		users := []models.User{}
-		extractPayload(t, http.StatusOK, recorder, &users)
+		cursor := extractPage(t, http.StatusOK, recorder, &users)
 		require.Len(t, users, 1)
-		validatePagination(t, recorder, reqUrl, 2, 1, 1, 2)
+		assert.Equal(t, test.Data.testUser.ID, users[0].ID)
+		require.NotEmpty(t, cursor)
+
+		recorder = test.TestEndpoint(http.MethodGet, "/users?limit=1&sort=email&cursor="+cursor, nil, token)
+		users = []models.User{}
+		cursor = extractPage(t, http.StatusOK, recorder, &users)
+		require.Len(t, users, 1)
+		assert.Equal(t, "villian", users[0].ID)
+		assert.Empty(t, cursor)
 	})
 	t.Run("MultipleIDs", func(t *testing.T) {
 		test := NewRouteTest(t)
@@ -93,7 +101,7 @@ func TestUsersList(t *testing.T) {
 		recorder := test.TestEndpoint(http.MethodGet, fmt.Sprintf("/users?id=%s&id=%s", test.Data.testUser.ID, "cop"), nil, token)
 
 		users := []models.User{}
-		extractPayload(t, http.StatusOK, recorder, &users)
+		extractPage(t, http.StatusOK, recorder, &users)
 		require.Len(t, users, 2)
 		for _, u := range users {
 			switch u.ID {
@@ -110,6 +118,65 @@ func TestUsersList(t *testing.T) {
 	})
 }
 
+func TestUsersListKeyset(t *testing.T) {
+	t.Run("Search", func(t *testing.T) {
+		test := NewRouteTest(t)
+		createUser(test, "villian", "twoface@dc.com", "Harvey Dent")
+
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodGet, "/users?search=harvey", nil, token)
+
+		users := []models.User{}
+		extractPage(t, http.StatusOK, recorder, &users)
+		require.Len(t, users, 1)
+		assert.Equal(t, "villian", users[0].ID)
+	})
+	t.Run("SortByOrderCount", func(t *testing.T) {
+		test := NewRouteTest(t)
+		createUser(test, "villian", "twoface@dc.com", "Harvey Dent")
+		createUser(test, "cop", "james.gordon@dc.com", "James Gordon")
+
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodGet, "/users?sort=order_count+desc&limit=2", nil, token)
+
+		users := []models.User{}
+		cursor := extractPage(t, http.StatusOK, recorder, &users)
+		require.Len(t, users, 2)
+		assert.Equal(t, test.Data.testUser.ID, users[0].ID)
+		assert.Equal(t, int64(2), users[0].OrderCount)
+		assert.Equal(t, test.Data.firstOrder.Total+test.Data.secondOrder.Total, users[0].LifetimeSpend)
+		assert.Equal(t, "villian", users[1].ID)
+
+		require.NotEmpty(t, cursor)
+		recorder = test.TestEndpoint(http.MethodGet, "/users?sort=order_count+desc&limit=2&cursor="+cursor, nil, token)
+
+		users = []models.User{}
+		cursor = extractPage(t, http.StatusOK, recorder, &users)
+		require.Len(t, users, 1)
+		assert.Equal(t, "cop", users[0].ID)
+		assert.Empty(t, cursor)
+	})
+	t.Run("BadSort", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("magical-unicorn", "")
+		recorder := test.TestEndpoint(http.MethodGet, "/users?sort=password", nil, token)
+		validateError(t, http.StatusBadRequest, recorder)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Minute)
+	ok, _ := limiter.allow("admin")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("admin")
+	assert.True(t, ok)
+	ok, retryAfter := limiter.allow("admin")
+	assert.False(t, ok)
+	assert.True(t, retryAfter > 0)
+	ok, _ = limiter.allow("other-admin")
+	assert.True(t, ok)
+}
+
 func TestUsersView(t *testing.T) {
 	t.Run("AsUser", func(t *testing.T) {
 		test := NewRouteTest(t)
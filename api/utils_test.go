@@ -9,9 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
-	"strconv"
 	"testing"
 	"time"
 
@@ -70,6 +68,8 @@ func testConfig() (*conf.GlobalConfiguration, *conf.Configuration) {
 	globalConfig := new(conf.GlobalConfiguration)
 	globalConfig.DB.Automigrate = true
 	globalConfig.DB.Namespace = "test"
+	// test servers listen on the loopback interface
+	globalConfig.Outbound.AllowedHosts = []string{"127.0.0.1"}
 
 	config := new(conf.Configuration)
 	config.JWT.Secret = "testsecret"
@@ -331,28 +331,6 @@ func validateAddress(t *testing.T, expected models.Address, actual models.Addres
 	assert.Equal(expected.Zip, actual.Zip)
 }
 
-func validatePagination(t *testing.T, r *httptest.ResponseRecorder, reqUrl string, total int, page int, perPage int, totalPages int) {
-	assert := assert.New(t)
-
-	// build expected link header
-	linkHeader := ""
-	url, err := url.ParseRequestURI(reqUrl)
-	assert.NoError(err)
-	query := url.Query()
-	if totalPages > page {
-		query.Set("page", fmt.Sprintf("%v", page+1))
-		url.RawQuery = query.Encode()
-		linkHeader += "<" + url.String() + ">; rel=\"next\", "
-	}
-	query.Set("page", fmt.Sprintf("%v", totalPages))
-	url.RawQuery = query.Encode()
-	linkHeader += "<" + url.String() + ">; rel=\"last\""
-
-	headers := r.HeaderMap
-	assert.Equal(strconv.Itoa(total), headers["X-Total-Count"][0])
-	assert.Equal(linkHeader, headers["Link"][0])
-}
-
 // ------------------------------------------------------------------------------------------------
 // HELPERS
 // ------------------------------------------------------------------------------------------------
@@ -363,6 +341,18 @@ func extractPayload(t *testing.T, code int, recorder *httptest.ResponseRecorder,
 	require.NoError(t, err, "Failed to extract body: %s", string(recorder.Body.Bytes()))
 }
 
+// extractPage decodes the items of a list response and returns the cursor of
+// the next page.
+func extractPage(t *testing.T, code int, recorder *httptest.ResponseRecorder, items interface{}) string {
+	page := struct {
+		Items      json.RawMessage `json:"items"`
+		NextCursor string          `json:"next_cursor"`
+	}{}
+	extractPayload(t, code, recorder, &page)
+	require.NoError(t, json.Unmarshal(page.Items, items), "Failed to extract items: %s", string(page.Items))
+	return page.NextCursor
+}
+
 type RouteTest struct {
 	DB           *gorm.DB
 	GlobalConfig *conf.GlobalConfiguration
@@ -377,14 +367,21 @@ func NewRouteTest(t *testing.T) *RouteTest {
 }
 
 func (r *RouteTest) TestEndpoint(method string, url string, body io.Reader, token *jwt.Token) *httptest.ResponseRecorder {
+	return r.TestEndpointWithHeaders(method, url, body, token, nil)
+}
+
+// TestEndpointWithHeaders is TestEndpoint with additional request headers.
+func (r *RouteTest) TestEndpointWithHeaders(method string, url string, body io.Reader, token *jwt.Token, header http.Header) *httptest.ResponseRecorder {
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest(method, baseURL+url, body)
+	for name, values := range header {
+		req.Header[name] = values
+	}
 
 	if token != nil {
 		require.NoError(r.T, signHTTPRequest(req, token, r.Config.JWT.Secret))
 	}
-	globalConfig := new(conf.GlobalConfiguration)
-	ctx, err := WithInstanceConfig(context.Background(), globalConfig.SMTP, r.Config, "")
+	ctx, err := WithInstanceConfig(context.Background(), r.GlobalConfig, r.Config, "")
 	require.NoError(r.T, err)
 	NewAPIWithVersion(ctx, r.GlobalConfig, r.DB, "").handler.ServeHTTP(recorder, req)
 
@@ -432,6 +429,92 @@ func handleTestProducts(w http.ResponseWriter, r *http.Request) {
 				</script>
 			</body>
 			</html>`)
+	case "/download-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "download-1", "title": "Download 1", "type": "E-Book", "prices": [
+					{"amount": "4.99", "currency": "USD"}
+				], "downloads": [
+					{"title": "PDF", "format": "pdf", "url": "/downloads/download-1.pdf"}
+				]}
+				</script>
+			</body>
+			</html>`)
+	case "/limited-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "limited-1", "title": "Limited 1", "type": "Book", "prices": [
+					{"amount": "9.99", "currency": "USD"}
+				], "max_quantity_per_order": 2, "max_per_customer": 3}
+				</script>
+			</body>
+			</html>`)
+	case "/weighted-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "weighted-1", "title": "Weighted 1", "type": "Book", "prices": [
+					{"amount": "9.99", "currency": "USD"}
+				], "weight": 2, "weight_unit": "lb"}
+				</script>
+			</body>
+			</html>`)
+	case "/measured-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "coffee-1", "title": "Coffee 1", "type": "Food", "prices": [
+					{"amount": "24.99", "currency": "USD"}
+				], "measure": {"unit": "kg", "precision": 2}}
+				</script>
+			</body>
+			</html>`)
+	case "/weighed-measured-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "coffee-2", "title": "Coffee 2", "type": "Food", "prices": [
+					{"amount": "24.99", "currency": "USD"}
+				], "measure": {"unit": "kg", "precision": 2}, "weight": 1, "weight_unit": "kg"}
+				</script>
+			</body>
+			</html>`)
+	case "/costed-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "costed-1", "title": "Costed 1", "type": "Book", "prices": [
+					{"amount": "9.99", "currency": "USD", "cost": "6.00"}
+				]}
+				</script>
+			</body>
+			</html>`)
+	case "/reduced-product":
+		fmt.Fprintln(w, `<!doctype html>
+			<html>
+			<head><title>Test Product</title></head>
+			<body>
+				<script class="gocommerce-product">
+				{"sku": "reduced-1", "title": "Reduced 1", "type": "E-Book", "prices": [
+					{"amount": "10.00", "currency": "USD"}
+				], "tax_code": "reduced"}
+				</script>
+			</body>
+			</html>`)
 	case "/bundle-product":
 		fmt.Fprintln(w, `<!doctype html>
 			<html>
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/netlify/gocommerce/addressverifiers"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+// verifyShippingAddress checks if the shipping address of a new order is
+// deliverable and stores the result on the order. Undeliverable addresses
+// are rejected in block mode. Orders are accepted if the verifier fails, so
+// an outage of the provider doesn't stop sales.
+func verifyShippingAddress(r *http.Request, order *models.Order) *HTTPError {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	mode := config.AddressVerification.Mode
+	if mode == "" || mode == addressverifiers.OffMode {
+		return nil
+	}
+
+	log := getLogEntry(r)
+	verifier := gcontext.GetAddressVerifier(ctx)
+	result, err := verifier.VerifyAddress(order.ShippingAddress.AddressRequest)
+	if err != nil {
+		log.WithError(err).Warn("Failed to verify the shipping address, accepting it")
+		return nil
+	}
+	if result.Deliverable {
+		order.AddressVerification = models.DeliverableAddress
+		order.AddressVerificationMessage = ""
+		return nil
+	}
+
+	order.AddressVerification = models.UndeliverableAddress
+	order.AddressVerificationMessage = result.Message
+	log.WithFields(logrus.Fields{
+		"shipping_country": order.ShippingAddress.Country,
+		"message":          result.Message,
+		"mode":             mode,
+	}).Info("Shipping address is not deliverable")
+	if mode != addressverifiers.BlockMode {
+		return nil
+	}
+
+	message := result.Message
+	if message == "" {
+		message = "We can't deliver to this address"
+	}
+	return httpError(http.StatusUnprocessableEntity, "Shipping address is not deliverable").WithFieldErrors([]FieldError{
+		{Field: "shipping_address", Message: message},
+	})
+}
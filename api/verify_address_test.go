@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCreateAddressVerification(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+	deliverable := false
+	verified := []models.AddressRequest{}
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address := models.AddressRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&address))
+		verified = append(verified, address)
+		fmt.Fprintf(w, `{"deliverable": %v, "message": "Unknown street"}`, deliverable)
+	}))
+	defer verifier.Close()
+
+	routeTest := func(t *testing.T, mode string) *RouteTest {
+		verified = nil
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.AddressVerification.Mode = mode
+		test.Config.AddressVerification.Provider = "http"
+		test.Config.AddressVerification.URL = verifier.URL
+		return test
+	}
+
+	t.Run("Warn", func(t *testing.T) {
+		test := routeTest(t, "warn")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, models.UndeliverableAddress, order.AddressVerification)
+		assert.Equal(t, "Unknown street", order.AddressVerificationMessage)
+		require.Len(t, verified, 1)
+		assert.Equal(t, "610 22nd Street", verified[0].Address1)
+
+		saved := &models.Order{}
+		require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+		assert.Equal(t, models.UndeliverableAddress, saved.AddressVerification)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		test := routeTest(t, "block")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		assert.Contains(t, recorder.Body.String(), "Unknown street")
+		validateError(t, http.StatusUnprocessableEntity, recorder, "Shipping address is not deliverable")
+
+		deliverable = true
+		defer func() { deliverable = false }()
+		recorder = test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Equal(t, models.DeliverableAddress, order.AddressVerification)
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		test := routeTest(t, "off")
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+		assert.Empty(t, order.AddressVerification)
+		assert.Empty(t, verified)
+	})
+}
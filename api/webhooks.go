@@ -0,0 +1,273 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	gcontext "github.com/netlify/gocommerce/context"
+	"github.com/netlify/gocommerce/models"
+	"github.com/sirupsen/logrus"
+)
+
+// signatureAcceptedHeader can be set by a receiver to report whether it could
+// verify the signature of a webhook.
+const signatureAcceptedHeader = "X-Commerce-Signature-Accepted"
+
+// webhookCategories groups the event types webhooks are sent for by what they
+// are about, so one endpoint can subscribe to all events of a category.
+var webhookCategories = map[string][]string{
+	"orders":    {"order", "update"},
+	"payments":  {"payment", "refund"},
+	"inventory": {"order.backorder_fulfilled"},
+	"review":    {"order.held", "order.approved", "order.rejected"},
+}
+
+// webhookEventType reports whether webhooks are sent for an event type.
+func webhookEventType(eventType string) bool {
+	for _, types := range webhookCategories {
+		for _, t := range types {
+			if t == eventType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webhookTypeURL returns the URL configured for an event type.
+func webhookTypeURL(config *conf.Configuration, eventType string) string {
+	switch eventType {
+	case "order":
+		return config.Webhooks.Order
+	case "payment":
+		return config.Webhooks.Payment
+	case "update":
+		return config.Webhooks.Update
+	case "refund":
+		return config.Webhooks.Refund
+	case "order.backorder_fulfilled":
+		return config.Webhooks.BackorderFulfilled
+	}
+	return ""
+}
+
+// webhookCategoryURL returns the URL configured for a category, ignoring the
+// case of the configured names.
+func webhookCategoryURL(config *conf.Configuration, category string) string {
+	for name, hookURL := range config.Webhooks.Categories {
+		if strings.EqualFold(strings.TrimSpace(name), category) {
+			return hookURL
+		}
+	}
+	return ""
+}
+
+// webhookURLs returns the URLs an event is sent to: the one configured for its
+// type and the one for its category, each only once.
+func webhookURLs(config *conf.Configuration, eventType string) []string {
+	urls := []string{}
+	if hookURL := webhookTypeURL(config, eventType); hookURL != "" {
+		urls = append(urls, hookURL)
+	}
+	for category, types := range webhookCategories {
+		for _, t := range types {
+			if t != eventType {
+				continue
+			}
+			if hookURL := webhookCategoryURL(config, category); hookURL != "" && (len(urls) == 0 || urls[0] != hookURL) {
+				urls = append(urls, hookURL)
+			}
+		}
+	}
+	return urls
+}
+
+// validateWebhookCategories makes sure endpoints only subscribe to known
+// categories.
+func validateWebhookCategories(c *conf.Configuration) error {
+	for name := range c.Webhooks.Categories {
+		if _, ok := webhookCategories[strings.ToLower(strings.TrimSpace(name))]; !ok {
+			return fmt.Errorf("Unknown webhook category '%s', must be one of orders, payments, inventory or review", name)
+		}
+	}
+	return nil
+}
+
+// webhookOptions returns how webhooks are delivered to a URL, with the
+// settings of its endpoint taking precedence over those of all webhooks.
+func webhookOptions(config *conf.Configuration, hookURL string) models.HookOptions {
+	options := models.HookOptions{
+		Secret:      config.Webhooks.Secret,
+		MaxInFlight: config.Webhooks.MaxInFlight,
+		Ordered:     config.Webhooks.Ordered,
+	}
+	if endpoint, ok := config.Webhooks.Endpoints[hookURL]; ok {
+		if endpoint.MaxInFlight > 0 {
+			options.MaxInFlight = endpoint.MaxInFlight
+		}
+		options.Compress = endpoint.Compress
+	}
+	return options
+}
+
+// enqueueWebhook queues an event for the endpoints subscribed to its type or
+// its category, unless webhooks for the type have been disabled.
+func enqueueWebhook(tx *gorm.DB, config *conf.Configuration, eventType, userID, orderID string, payload interface{}, log logrus.FieldLogger) {
+	urls := webhookURLs(config, eventType)
+	if len(urls) == 0 || !webhookEnabled(config, eventType, log) {
+		return
+	}
+	for _, hookURL := range urls {
+		hook, err := models.NewHook(eventType, config.SiteURL, hookURL, userID, orderID, webhookOptions(config, hookURL), payload)
+		if err != nil {
+			log.WithError(err).Error("Failed to process webhook")
+		} else if err := hook.Enqueue(tx); err != nil {
+			log.WithError(err).Error("Failed to enqueue webhook")
+		}
+	}
+}
+
+// webhookEnabled checks that webhooks for an event type haven't been disabled.
+// Suppressed events are logged.
+func webhookEnabled(config *conf.Configuration, eventType string, log logrus.FieldLogger) bool {
+	for _, disabled := range config.Webhooks.Disabled {
+		if disabled == eventType {
+			log.WithField("event_type", eventType).Info("Suppressed webhook for disabled event type")
+			return false
+		}
+	}
+	return true
+}
+
+type webhookPing struct {
+	Type     string    `json:"type"`
+	Endpoint string    `json:"endpoint"`
+	Message  string    `json:"message"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+type webhookTestResult struct {
+	Endpoint          string `json:"endpoint"`
+	URL               string `json:"url"`
+	Status            int    `json:"status"`
+	LatencyMs         int64  `json:"latency_ms"`
+	SignatureAccepted *bool  `json:"signature_accepted"`
+	Error             string `json:"error,omitempty"`
+}
+
+// WebhookTest sends a ping event to one of the configured webhooks, by event
+// type or category, and reports how the receiver responded. Requires admin
+// permissions
+func (a *API) WebhookTest(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := gcontext.GetConfig(ctx)
+	endpoint := chi.URLParam(r, "endpoint")
+	log := getLogEntry(r)
+
+	var hookURL string
+	if _, ok := webhookCategories[endpoint]; ok {
+		hookURL = webhookCategoryURL(config, endpoint)
+	} else if webhookEventType(endpoint) {
+		// the URL of the event type, or of its category if it has none
+		if urls := webhookURLs(config, endpoint); len(urls) > 0 {
+			hookURL = urls[0]
+		}
+	} else {
+		return notFoundError("Unknown webhook endpoint '%s'", endpoint)
+	}
+	if hookURL == "" {
+		return notFoundError("No webhook configured for '%s'", endpoint)
+	}
+
+	userID := ""
+	if claims := gcontext.GetClaims(ctx); claims != nil {
+		userID = claims.Subject
+	}
+	ping := &webhookPing{
+		Type:     "ping",
+		Endpoint: endpoint,
+		Message:  "This is a test event sent from gocommerce",
+		SentAt:   time.Now().UTC(),
+	}
+	hook, err := models.NewHook("ping", config.SiteURL, hookURL, userID, "", webhookOptions(config, hookURL), ping)
+	if err != nil {
+		return badRequestError("Invalid webhook configuration: %v", err)
+	}
+
+	result := &webhookTestResult{
+		Endpoint: endpoint,
+		URL:      hook.URL,
+	}
+	start := time.Now()
+	resp, err := hook.Trigger(a.hookClient, log)
+	result.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		log.WithError(err).Infof("Webhook test for %s failed", endpoint)
+		result.Error = err.Error()
+		return sendJSON(w, http.StatusOK, result)
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	if accepted, err := strconv.ParseBool(resp.Header.Get(signatureAcceptedHeader)); err == nil {
+		result.SignatureAccepted = &accepted
+	}
+
+	log.Infof("Webhook test for %s responded with %d", endpoint, resp.StatusCode)
+	return sendJSON(w, http.StatusOK, result)
+}
+
+// OrderWebhookDeliveries lists the delivery attempts of webhooks sent for an
+// order, oldest first. Requires admin permissions
+func (a *API) OrderWebhookDeliveries(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	orderID := gcontext.GetOrderID(ctx)
+	log := getLogEntry(r)
+
+	page, httpErr := parsePagination(r.URL.Query())
+	if httpErr != nil {
+		return httpErr
+	}
+
+	order := &models.Order{}
+	if result := a.db.First(order, "id = ?", orderID); result.Error != nil {
+		if result.RecordNotFound() {
+			return notFoundError("Order not found")
+		}
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	query, httpErr := paginate(a.db.Where("order_id = ?", order.ID), page, &sortKey{
+		expression: "created_at",
+		idColumn:   "id",
+		dir:        ascending,
+		parse:      parseCursorTime,
+	})
+	if httpErr != nil {
+		return httpErr
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if result := query.Find(&deliveries); result.Error != nil {
+		return internalServerError("Error during database query").WithInternalError(result.Error)
+	}
+
+	next := ""
+	if page.hasMore(len(deliveries)) {
+		deliveries = deliveries[:page.Limit]
+		last := &deliveries[len(deliveries)-1]
+		next = encodeCursor(&pageCursor{
+			Value: formatCursorTime(last.CreatedAt),
+			ID:    strconv.FormatUint(last.ID, 10),
+		})
+	}
+
+	log.Debugf("Found %d webhook deliveries for order %s", len(deliveries), order.ID)
+	return sendJSON(w, http.StatusOK, &listPage{Items: deliveries, NextCursor: next})
+}
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookTest(t *testing.T) {
+	t.Run("SignatureAccepted", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.Webhooks.Secret = "webhook-secret"
+
+		var ping webhookPing
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&ping))
+			_, err := jwt.Parse(r.Header.Get("X-Commerce-Signature"), func(token *jwt.Token) (interface{}, error) {
+				return []byte("webhook-secret"), nil
+			})
+			if err != nil {
+				w.Header().Set(signatureAcceptedHeader, "false")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set(signatureAcceptedHeader, "true")
+		}))
+		defer server.Close()
+		test.Config.Webhooks.Order = server.URL
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/order/test", nil, token)
+
+		result := &webhookTestResult{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, http.StatusOK, result.Status)
+		require.NotNil(t, result.SignatureAccepted)
+		assert.True(t, *result.SignatureAccepted)
+		assert.Equal(t, "ping", ping.Type)
+		assert.Equal(t, "order", ping.Endpoint)
+	})
+
+	t.Run("NoEcho", func(t *testing.T) {
+		test := NewRouteTest(t)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+		test.Config.Webhooks.Payment = server.URL
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/payment/test", nil, token)
+
+		result := &webhookTestResult{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, http.StatusNotFound, result.Status)
+		assert.Nil(t, result.SignatureAccepted)
+	})
+
+	t.Run("Category", func(t *testing.T) {
+		test := NewRouteTest(t)
+		var ping webhookPing
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&ping))
+		}))
+		defer server.Close()
+		test.Config.Webhooks.Categories = map[string]string{"payments": server.URL}
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/payments/test", nil, token)
+
+		result := &webhookTestResult{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, http.StatusOK, result.Status)
+		assert.Equal(t, "payments", ping.Endpoint)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/webhooks/orders/test", nil, token)
+		validateError(t, http.StatusNotFound, recorder, "No webhook configured")
+	})
+
+	t.Run("EventType", func(t *testing.T) {
+		test := NewRouteTest(t)
+		var ping webhookPing
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&ping))
+		}))
+		defer server.Close()
+		test.Config.Webhooks.BackorderFulfilled = server.URL
+
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/order.backorder_fulfilled/test", nil, token)
+
+		result := &webhookTestResult{}
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, http.StatusOK, result.Status)
+		assert.Equal(t, "order.backorder_fulfilled", ping.Endpoint)
+
+		test.Config.Webhooks.BackorderFulfilled = ""
+		test.Config.Webhooks.Categories = map[string]string{"inventory": server.URL}
+		recorder = test.TestEndpoint(http.MethodPost, "/webhooks/order.backorder_fulfilled/test", nil, token)
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, server.URL, result.URL, "falls back to the URL of the category")
+
+		test.Config.Webhooks.Categories = map[string]string{"review": server.URL}
+		recorder = test.TestEndpoint(http.MethodPost, "/webhooks/order.held/test", nil, token)
+		extractPayload(t, http.StatusOK, recorder, result)
+		assert.Equal(t, server.URL, result.URL)
+		assert.Equal(t, "order.held", ping.Endpoint)
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		test := NewRouteTest(t)
+		token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/refund/test", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+
+		recorder = test.TestEndpoint(http.MethodPost, "/webhooks/unknown/test", nil, token)
+		validateError(t, http.StatusNotFound, recorder)
+	})
+
+	t.Run("NonAdmin", func(t *testing.T) {
+		test := NewRouteTest(t)
+		recorder := test.TestEndpoint(http.MethodPost, "/webhooks/order/test", nil, test.Data.testUserToken)
+		validateError(t, http.StatusUnauthorized, recorder)
+	})
+}
+
+func TestOrderWebhookDeliveries(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+	test.Config.Webhooks.Order = receiver.URL
+
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+
+	hook := &models.Hook{}
+	require.NoError(t, test.DB.First(hook, "order_id = ?", order.ID).Error)
+	hook.Deliver(test.DB, http.DefaultClient, testLogger)
+	hook.Deliver(test.DB, http.DefaultClient, testLogger)
+
+	token := testAdminToken("admin-yo", "admin@wayneindustries.com")
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID+"/webhooks", nil, token)
+	deliveries := []models.WebhookDelivery{}
+	extractPage(t, http.StatusOK, recorder, &deliveries)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "order", deliveries[0].Type)
+	assert.Equal(t, hook.EventID, deliveries[0].EventID)
+	assert.Equal(t, 1, deliveries[0].Attempt)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+	assert.Equal(t, 2, deliveries[1].Attempt)
+	assert.Equal(t, http.StatusOK, deliveries[1].StatusCode)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID+"/webhooks?limit=1", nil, token)
+	page := []models.WebhookDelivery{}
+	cursor := extractPage(t, http.StatusOK, recorder, &page)
+	require.Len(t, page, 1)
+	assert.Equal(t, deliveries[0].ID, page[0].ID)
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID+"/webhooks?limit=1&cursor="+cursor, nil, token)
+	page = []models.WebhookDelivery{}
+	cursor = extractPage(t, http.StatusOK, recorder, &page)
+	require.Len(t, page, 1)
+	assert.Equal(t, deliveries[1].ID, page[0].ID)
+	assert.Empty(t, cursor)
+
+	recorder = test.TestEndpoint(http.MethodGet, "/orders/"+order.ID+"/webhooks", nil, test.Data.testUserToken)
+	validateError(t, http.StatusUnauthorized, recorder)
+}
+
+func TestHookDeliveryFailures(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	createHook := func(t *testing.T, test *RouteTest, receiverURL string) *models.Hook {
+		test.Config.SiteURL = server.URL
+		test.Config.Webhooks.Order = receiverURL
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		order := &models.Order{}
+		extractPayload(t, http.StatusCreated, recorder, order)
+
+		hook := &models.Hook{}
+		require.NoError(t, test.DB.First(hook, "order_id = ?", order.ID).Error)
+		return hook
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		test := NewRouteTest(t)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer receiver.Close()
+
+		hook := createHook(t, test, receiver.URL)
+		guard := ssrf.NewGuard([]string{"127.0.0.1"})
+		hook.Deliver(test.DB, models.NewHookClient(50*time.Millisecond, guard, ""), testLogger)
+
+		assert.False(t, hook.Done)
+		assert.NotNil(t, hook.RunAfter)
+		require.NotNil(t, hook.ErrorMessage)
+		assert.Contains(t, *hook.ErrorMessage, "Timeout")
+	})
+
+	t.Run("InternalRedirect", func(t *testing.T) {
+		test := NewRouteTest(t)
+		internal := false
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			internal = true
+		}))
+		defer target.Close()
+		receiver := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+		defer receiver.Close()
+
+		// only the receiver is allowed, not the address it redirects to
+		receiverURL := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+		hook := createHook(t, test, receiverURL)
+		guard := ssrf.NewGuard([]string{"localhost"})
+		hook.Deliver(test.DB, models.NewHookClient(time.Second, guard, ""), testLogger)
+
+		assert.False(t, internal)
+		assert.False(t, hook.Done)
+		assert.NotNil(t, hook.RunAfter)
+		require.NotNil(t, hook.ErrorMessage)
+		assert.Contains(t, *hook.ErrorMessage, "internal address")
+	})
+}
+
+func TestDisabledWebhookEvents(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	countHooks := func(test *RouteTest) int {
+		count := 0
+		require.NoError(t, test.DB.Model(&models.Hook{}).Where("type = ?", "order").Count(&count).Error)
+		return count
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Webhooks.Order = "https://example.com/hooks/order"
+		test.Config.Webhooks.Disabled = []string{"update", "order"}
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+		assert.Equal(t, 0, countHooks(test))
+	})
+
+	t.Run("OtherEventDisabled", func(t *testing.T) {
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Webhooks.Order = "https://example.com/hooks/order"
+		test.Config.Webhooks.Disabled = []string{"payment"}
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+		assert.Equal(t, 1, countHooks(test))
+	})
+}
+
+func TestWebhookCategories(t *testing.T) {
+	t.Run("URLs", func(t *testing.T) {
+		config := &conf.Configuration{}
+		config.Webhooks.Payment = "https://example.com/hooks/payment"
+		config.Webhooks.Categories = map[string]string{
+			"Payments":  "https://billing.example.com/hooks",
+			"inventory": "https://catalog.example.com/hooks",
+		}
+		assert.Equal(t, []string{"https://example.com/hooks/payment", "https://billing.example.com/hooks"}, webhookURLs(config, "payment"))
+		assert.Equal(t, []string{"https://billing.example.com/hooks"}, webhookURLs(config, "refund"))
+		assert.Equal(t, []string{"https://catalog.example.com/hooks"}, webhookURLs(config, "order.backorder_fulfilled"))
+		assert.Empty(t, webhookURLs(config, "order"))
+
+		config.Webhooks.Payment = "https://billing.example.com/hooks"
+		assert.Equal(t, []string{"https://billing.example.com/hooks"}, webhookURLs(config, "payment"), "a URL gets an event only once")
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		os.Setenv("GOCOMMERCE_SITE_URL", "https://example.com")
+		os.Setenv("GOCOMMERCE_WEBHOOKS_CATEGORIES", "orders:https://fulfillment.example.com/hooks,payments:https://billing.example.com:8443/hooks")
+		defer os.Unsetenv("GOCOMMERCE_SITE_URL")
+		defer os.Unsetenv("GOCOMMERCE_WEBHOOKS_CATEGORIES")
+
+		config, err := conf.LoadConfig("")
+		require.NoError(t, err)
+		assert.Equal(t, conf.WebhookCategories{
+			"orders":   "https://fulfillment.example.com/hooks",
+			"payments": "https://billing.example.com:8443/hooks",
+		}, config.Webhooks.Categories)
+		assert.NoError(t, validateWebhookCategories(config))
+
+		os.Setenv("GOCOMMERCE_WEBHOOKS_CATEGORIES", "orders")
+		_, err = conf.LoadConfig("")
+		assert.Error(t, err)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		config := &conf.Configuration{}
+		config.Webhooks.Categories = map[string]string{"orders": "https://example.com/hooks"}
+		assert.NoError(t, validateWebhookCategories(config))
+		config.Webhooks.Categories["catalog"] = "https://example.com/hooks"
+		assert.Error(t, validateWebhookCategories(config))
+	})
+
+	t.Run("Enqueued", func(t *testing.T) {
+		server := startTestSite()
+		defer server.Close()
+
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Webhooks.Categories = map[string]string{"orders": "https://orders.example.com/hooks", "payments": "https://billing.example.com/hooks"}
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		hooks := []models.Hook{}
+		require.NoError(t, test.DB.Find(&hooks).Error)
+		require.Len(t, hooks, 1)
+		assert.Equal(t, "order", hooks[0].Type)
+		assert.Equal(t, "https://orders.example.com/hooks", hooks[0].URL)
+	})
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	t.Run("Options", func(t *testing.T) {
+		config := &conf.Configuration{}
+		config.Webhooks.Secret = "webhook-secret"
+		config.Webhooks.MaxInFlight = 4
+		config.Webhooks.Endpoints = conf.WebhookEndpoints{
+			"https://slow.example.com/hooks": {MaxInFlight: 1},
+			"https://gzip.example.com/hooks": {Compress: true},
+		}
+		assert.Equal(t, models.HookOptions{Secret: "webhook-secret", MaxInFlight: 1}, webhookOptions(config, "https://slow.example.com/hooks"))
+		assert.Equal(t, models.HookOptions{Secret: "webhook-secret", MaxInFlight: 4, Compress: true}, webhookOptions(config, "https://gzip.example.com/hooks"))
+		assert.Equal(t, models.HookOptions{Secret: "webhook-secret", MaxInFlight: 4}, webhookOptions(config, "https://example.com/hooks"))
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		os.Setenv("GOCOMMERCE_SITE_URL", "https://example.com")
+		os.Setenv("GOCOMMERCE_WEBHOOKS_ENDPOINTS", `{"https://slow.example.com/hooks": {"max_in_flight": 1, "compress": true}}`)
+		defer os.Unsetenv("GOCOMMERCE_SITE_URL")
+		defer os.Unsetenv("GOCOMMERCE_WEBHOOKS_ENDPOINTS")
+
+		config, err := conf.LoadConfig("")
+		require.NoError(t, err)
+		assert.Equal(t, conf.WebhookEndpoints{"https://slow.example.com/hooks": {MaxInFlight: 1, Compress: true}}, config.Webhooks.Endpoints)
+	})
+
+	t.Run("Enqueued", func(t *testing.T) {
+		server := startTestSite()
+		defer server.Close()
+
+		test := NewRouteTest(t)
+		test.Config.SiteURL = server.URL
+		test.Config.Webhooks.Order = "https://orders.example.com/hooks"
+		test.Config.Webhooks.Endpoints = conf.WebhookEndpoints{"https://orders.example.com/hooks": {MaxInFlight: 2}}
+
+		recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(defaultPayload), test.Data.testUserToken)
+		extractPayload(t, http.StatusCreated, recorder, &models.Order{})
+
+		hooks := []models.Hook{}
+		require.NoError(t, test.DB.Find(&hooks).Error)
+		require.Len(t, hooks, 1)
+		assert.Equal(t, 2, hooks[0].MaxInFlight)
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderCreateWeight(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Weight.Unit = "kg"
+	body := strings.NewReader(`{
+		"email": "info@example.com",
+		"shipping_address": {
+			"name": "Test User",
+			"address1": "610 22nd Street",
+			"city": "San Francisco", "state": "CA", "country": "USA", "zip": "94107"
+		},
+		"line_items": [
+			{"path": "/weighted-product", "quantity": 2},
+			{"path": "/simple-product", "quantity": 1}
+		]
+	}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", body, test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+
+	require.Len(t, order.LineItems, 2)
+	for _, item := range order.LineItems {
+		if item.Sku == "weighted-1" {
+			assert.Equal(t, 0.907, item.Weight)
+		} else {
+			assert.Zero(t, item.Weight)
+		}
+	}
+	assert.Equal(t, "kg", order.WeightUnit)
+	assert.Equal(t, 1.814, order.TotalWeight)
+
+	saved := &models.Order{}
+	require.NoError(t, test.DB.First(saved, "id = ?", order.ID).Error)
+	assert.Equal(t, 1.814, saved.TotalWeight)
+}
+
+func TestOrderCreateWeightByMeasure(t *testing.T) {
+	server := startTestSite()
+	defer server.Close()
+
+	test := NewRouteTest(t)
+	test.Config.SiteURL = server.URL
+	test.Config.Weight.Unit = "kg"
+	body := measureOrderBody(`{"path": "/weighed-measured-product", "quantity": 2, "measure": 1.5}`)
+	recorder := test.TestEndpoint(http.MethodPost, "/orders", strings.NewReader(body), test.Data.testUserToken)
+	order := &models.Order{}
+	extractPayload(t, http.StatusCreated, recorder, order)
+
+	require.Len(t, order.LineItems, 1)
+	assert.Equal(t, 1.0, order.LineItems[0].Weight)
+	// 2 bags of 1.5 kg each
+	assert.Equal(t, 3.0, order.TotalWeight)
+}
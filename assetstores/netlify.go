@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/pkg/errors"
 )
 
@@ -15,13 +16,13 @@ type netlifyProvider struct {
 	token  string
 }
 
-func newNetlifyProvider(token string) (*netlifyProvider, error) {
+func newNetlifyProvider(token string, guard *ssrf.Guard) (*netlifyProvider, error) {
 	if token == "" {
 		return nil, errors.New("No access token configured for Netlify")
 	}
 
 	return &netlifyProvider{
-		client: &http.Client{},
+		client: guard.Client(),
 		token:  token,
 	}, nil
 }
@@ -69,3 +70,7 @@ func (n *netlifyProvider) SignURL(downloadURL string) (string, error) {
 
 	return signature.URL, nil
 }
+
+func (n *netlifyProvider) Fetch(signedURL string, header http.Header) (*http.Response, error) {
+	return fetch(n.client, signedURL, header)
+}
@@ -1,11 +1,23 @@
 package assetstores
 
-type noopProvider struct{}
+import (
+	"net/http"
 
-func newNoopProvider() (*noopProvider, error) {
-	return &noopProvider{}, nil
+	"github.com/netlify/gocommerce/ssrf"
+)
+
+type noopProvider struct {
+	client *http.Client
+}
+
+func newNoopProvider(guard *ssrf.Guard) (*noopProvider, error) {
+	return &noopProvider{client: guard.Client()}, nil
 }
 
 func (n *noopProvider) SignURL(url string) (string, error) {
 	return url, nil
 }
+
+func (n *noopProvider) Fetch(url string, header http.Header) (*http.Response, error) {
+	return fetch(n.client, url, header)
+}
@@ -2,23 +2,57 @@ package assetstores
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/ssrf"
+)
+
+// The ways downloads can be delivered: URLDelivery returns the signed URL,
+// RedirectDelivery redirects to it and ProxyDelivery serves the bytes from the
+// store without revealing the URL.
+const (
+	URLDelivery      = "url"
+	RedirectDelivery = "redirect"
+	ProxyDelivery    = "proxy"
 )
 
 // Store is the interface wrapping an asset store that can sign download URLs.
 type Store interface {
 	SignURL(string) (string, error)
+
+	// Fetch requests a signed URL from the store, forwarding the given
+	// headers, e.g. a Range.
+	Fetch(string, http.Header) (*http.Response, error)
 }
 
-// NewStore creates an asset store based on the provided configuration.
-func NewStore(config *conf.Configuration) (Store, error) {
+// NewStore creates an asset store based on the provided configuration. The
+// guard restricts which addresses the store can connect to.
+func NewStore(config *conf.Configuration, guard *ssrf.Guard) (Store, error) {
+	switch config.Downloads.Delivery {
+	case "", URLDelivery, RedirectDelivery, ProxyDelivery:
+	default:
+		return nil, fmt.Errorf("Unknown download delivery '%v'", config.Downloads.Delivery)
+	}
+
 	switch config.Downloads.Provider {
 	case "netlify":
-		return newNetlifyProvider(config.Downloads.NetlifyToken)
+		return newNetlifyProvider(config.Downloads.NetlifyToken, guard)
 	case "":
-		return newNoopProvider()
+		return newNoopProvider(guard)
 	default:
 		return nil, fmt.Errorf("Unknown asset store provider '%v'", config.Downloads.Provider)
 	}
 }
+
+// fetch requests a URL with the client, forwarding the headers.
+func fetch(client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return client.Do(req)
+}
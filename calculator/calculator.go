@@ -1,7 +1,9 @@
 package calculator
 
 import (
+	"fmt"
 	"math"
+	"sort"
 	"strconv"
 
 	"github.com/netlify/gocommerce/claims"
@@ -24,6 +26,12 @@ type Price struct {
 	NetTotal uint64
 	Taxes    uint64
 	Total    int64
+
+	// DiscountCapped is set if the discount of any item was limited by the
+	// discount floor.
+	DiscountCapped bool
+
+	TaxBreakdown []TaxItem
 }
 
 // ItemPrice is the price of a single line item.
@@ -36,7 +44,9 @@ type ItemPrice struct {
 	Taxes    uint64
 	Total    int64
 
-	DiscountItems []DiscountItem
+	DiscountItems  []DiscountItem
+	DiscountCapped bool
+	TaxBreakdown   []TaxItem
 }
 
 // PaymentMethods settings
@@ -50,26 +60,63 @@ type PaymentMethods struct {
 		ClientID    string `json:"client_id,omitempty"`
 		Environment string `json:"environment,omitempty"`
 	} `json:"paypal"`
+	Braintree struct {
+		Enabled     bool   `json:"enabled"`
+		Environment string `json:"environment,omitempty"`
+	} `json:"braintree"`
 }
 
+// DiscountFloorZero and DiscountFloorCost are the lowest prices items can be
+// discounted to, after all discounts are applied.
+const (
+	DiscountFloorZero = "zero"
+	DiscountFloorCost = "cost"
+)
+
 // Settings represent the site-wide settings for price calculation.
 type Settings struct {
 	PricesIncludeTaxes bool              `json:"prices_include_taxes"`
 	Taxes              []*Tax            `json:"taxes,omitempty"`
 	MemberDiscounts    []*MemberDiscount `json:"member_discounts,omitempty"`
+	DiscountFloor      string            `json:"discount_floor,omitempty"`
 	PaymentMethods     *PaymentMethods   `json:"payment_methods,omitempty"`
+	ShippingTaxes      string            `json:"shipping_taxes,omitempty"`
 }
 
 // Tax represents a tax, potentially specific to countries and product types.
+// A tax levied by several jurisdictions lists its parts as components, their
+// percentages add up to the percentage of the tax. A tax with a code only
+// applies to items with that tax code.
 type Tax struct {
-	Percentage   uint64   `json:"percentage"`
-	ProductTypes []string `json:"product_types"`
-	Countries    []string `json:"countries"`
+	Code         string          `json:"code,omitempty"`
+	Percentage   uint64          `json:"percentage"`
+	ProductTypes []string        `json:"product_types"`
+	Countries    []string        `json:"countries"`
+	Jurisdiction string          `json:"jurisdiction,omitempty"`
+	Components   []*TaxComponent `json:"components,omitempty"`
+}
+
+// TaxComponent is the part of a combined tax levied by a single jurisdiction,
+// e.g. the state or city part of a sales tax.
+type TaxComponent struct {
+	Jurisdiction string `json:"jurisdiction"`
+	Percentage   uint64 `json:"percentage"`
+}
+
+// TaxItem is the amount of taxes levied by a single jurisdiction at a rate.
+// Taxes on shipping are kept apart from the taxes on the line items.
+type TaxItem struct {
+	Jurisdiction string `json:"jurisdiction"`
+	Code         string `json:"code,omitempty"`
+	Rate         uint64 `json:"rate"`
+	Amount       uint64 `json:"amount"`
+	Shipping     bool   `json:"shipping,omitempty"`
 }
 
 type taxAmount struct {
 	price      uint64
 	percentage uint64
+	tax        *Tax
 }
 
 // FixedMemberDiscount represents a fixed discount given to members.
@@ -126,8 +173,10 @@ func (d *MemberDiscount) ValidForProduct(productSku string) bool {
 type Item interface {
 	ProductSku() string
 	PriceInLowestUnit() uint64
+	CostInLowestUnit() uint64
 	ProductType() string
 	FixedVAT() uint64
+	ProductTaxCode() string
 	TaxableItems() []Item
 	GetQuantity() uint64
 }
@@ -182,50 +231,171 @@ func (t *Tax) AppliesTo(country, productType string) bool {
 	return applies
 }
 
-func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLogger, jwtClaims map[string]interface{}, params PriceParameters, item Item, multiplier uint64) ItemPrice {
-	itemPrice := ItemPrice{Quantity: item.GetQuantity()}
+// findTax returns the tax for an item of a product type with a tax code in a
+// country, if any. Taxes with a code are only considered for items with the
+// same code.
+func findTax(settings *Settings, country, productType, code string) *Tax {
+	for _, t := range settings.Taxes {
+		if t.Code == code && t.AppliesTo(country, productType) {
+			return t
+		}
+	}
+	return nil
+}
 
-	singlePrice := item.PriceInLowestUnit() * multiplier
-	_, itemPrice.Subtotal = calculateTaxes(singlePrice, item, params, settings)
+// CheckTaxCodes makes sure the tax codes of all items are listed in the tax
+// settings. An item with an unknown code can't be taxed correctly, so prices
+// shouldn't be calculated for it.
+func CheckTaxCodes(settings *Settings, items []Item) error {
+	known := map[string]bool{}
+	if settings != nil {
+		for _, t := range settings.Taxes {
+			known[t.Code] = true
+		}
+	}
+	for _, item := range items {
+		codes := []string{item.ProductTaxCode()}
+		for _, sub := range item.TaxableItems() {
+			codes = append(codes, sub.ProductTaxCode())
+		}
+		for _, code := range codes {
+			if code != "" && !known[code] {
+				return fmt.Errorf("Unknown tax code '%v' for item %v", code, item.ProductSku())
+			}
+		}
+	}
+	return nil
+}
+
+// itemDiscounts returns the coupon and member discounts that apply to an item.
+func itemDiscounts(settings *Settings, jwtClaims map[string]interface{}, params PriceParameters, item Item, multiplier uint64) []DiscountItem {
+	var discountItems []DiscountItem
 
-	// apply discount to original price
 	coupon := params.Coupon
 	if coupon != nil && coupon.ValidForType(item.ProductType()) && coupon.ValidForProduct(item.ProductSku()) {
-		discountItem := DiscountItem{
+		discountItems = append(discountItems, DiscountItem{
 			Type:       DiscountTypeCoupon,
 			Percentage: coupon.PercentageDiscount(),
 			Fixed:      coupon.FixedDiscount(params.Currency) * multiplier,
-		}
-		itemPrice.Discount = calculateDiscount(singlePrice, discountItem.Percentage, discountItem.Fixed)
-		itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+		})
 	}
 	if settings != nil && settings.MemberDiscounts != nil {
 		for _, discount := range settings.MemberDiscounts {
-
 			if jwtClaims != nil && claims.HasClaims(jwtClaims, discount.Claims) && discount.ValidForType(item.ProductType()) && discount.ValidForProduct(item.ProductSku()) {
-				lineLogger = lineLogger.WithField("discount", discount.Claims)
-				discountItem := DiscountItem{
+				discountItems = append(discountItems, DiscountItem{
 					Type:       DiscountTypeMember,
 					Percentage: discount.Percentage,
 					Fixed:      discount.FixedDiscount(params.Currency) * multiplier,
-				}
-				itemPrice.Discount += calculateDiscount(singlePrice, discountItem.Percentage, discountItem.Fixed)
-				itemPrice.DiscountItems = append(itemPrice.DiscountItems, discountItem)
+				})
 			}
 		}
 	}
 
-	discountedPrice := uint64(0)
-	if itemPrice.Discount < singlePrice {
-		discountedPrice = singlePrice - itemPrice.Discount
+	return discountItems
+}
+
+// calculateAmountsForSingleItem calculates the price of an item multiplied by
+// multiplier. If percentageDiscount is set it is used as the already rounded
+// amount of all percentage discounts for the item, otherwise every discount is
+// rounded on its own.
+func calculateAmountsForSingleItem(settings *Settings, lineLogger logrus.FieldLogger, jwtClaims map[string]interface{}, params PriceParameters, item Item, multiplier uint64, percentageDiscount *uint64) ItemPrice {
+	itemPrice := ItemPrice{Quantity: item.GetQuantity()}
+
+	singlePrice := item.PriceInLowestUnit() * multiplier
+	_, itemPrice.Subtotal, _ = calculateTaxes(singlePrice, item, params, settings)
+
+	// apply discount to original price
+	itemPrice.DiscountItems = itemDiscounts(settings, jwtClaims, params, item, multiplier)
+	for _, discountItem := range itemPrice.DiscountItems {
+		if percentageDiscount != nil {
+			itemPrice.Discount += calculateDiscount(singlePrice, 0, discountItem.Fixed)
+		} else {
+			itemPrice.Discount += calculateDiscount(singlePrice, discountItem.Percentage, discountItem.Fixed)
+		}
+	}
+	if percentageDiscount != nil {
+		itemPrice.Discount += *percentageDiscount
+	}
+	if len(itemPrice.DiscountItems) > 0 {
+		lineLogger.WithField("discounts", itemPrice.DiscountItems).Debug("applying discounts")
 	}
 
-	itemPrice.Taxes, itemPrice.NetTotal = calculateTaxes(discountedPrice, item, params, settings)
+	floor := discountFloor(settings, item, multiplier)
+	if floor > singlePrice {
+		floor = singlePrice
+	}
+	if itemPrice.Discount > singlePrice-floor {
+		lineLogger.WithFields(logrus.Fields{
+			"discount":       itemPrice.Discount,
+			"discount_floor": floor,
+		}).Debug("capping discount at the discount floor")
+		itemPrice.Discount = singlePrice - floor
+		itemPrice.DiscountCapped = true
+	}
+	discountedPrice := singlePrice - itemPrice.Discount
+
+	itemPrice.Taxes, itemPrice.NetTotal, itemPrice.TaxBreakdown = calculateTaxes(discountedPrice, item, params, settings)
 	itemPrice.Total = int64(itemPrice.NetTotal + itemPrice.Taxes)
 
 	return itemPrice
 }
 
+// discountFloor returns the lowest price an item multiplied by multiplier can
+// be discounted to. Items are never discounted below zero, and not below their
+// cost if the settings ask for it.
+func discountFloor(settings *Settings, item Item, multiplier uint64) uint64 {
+	if settings != nil && settings.DiscountFloor == DiscountFloorCost {
+		return item.CostInLowestUnit() * multiplier
+	}
+	return 0
+}
+
+// distributePercentageDiscounts rounds the percentage discounts of all items
+// with the largest remainder method. Every item gets the rounded down share of
+// its discount and the remaining minor units go to the items with the largest
+// fractions, so the discounts add up to the rounded total discount of the order.
+func distributePercentageDiscounts(settings *Settings, jwtClaims map[string]interface{}, params PriceParameters) []uint64 {
+	amounts := make([]uint64, len(params.Items))
+	remainders := make([]uint64, len(params.Items))
+
+	// discounts are kept in hundredths of the lowest unit to avoid floats
+	var exactTotal, allocated uint64
+	for i, item := range params.Items {
+		var percentage uint64
+		for _, discountItem := range itemDiscounts(settings, jwtClaims, params, item, item.GetQuantity()) {
+			percentage += discountItem.Percentage
+		}
+		if percentage > 100 {
+			percentage = 100
+		}
+
+		exact := item.PriceInLowestUnit() * item.GetQuantity() * percentage
+		amounts[i] = exact / 100
+		remainders[i] = exact % 100
+		exactTotal += exact
+		allocated += amounts[i]
+	}
+
+	indexes := make([]int, len(params.Items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return remainders[indexes[a]] > remainders[indexes[b]]
+	})
+
+	intended := (exactTotal + 50) / 100
+	for _, i := range indexes {
+		if allocated >= intended {
+			break
+		}
+		amounts[i]++
+		allocated++
+	}
+
+	return amounts
+}
+
 // CalculatePrice will calculate the final total price. It takes into account
 // currency, country, coupons, and discounts.
 func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params PriceParameters, log logrus.FieldLogger) Price {
@@ -240,13 +410,14 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 		}
 	}
 
-	for _, item := range params.Items {
+	percentageDiscounts := distributePercentageDiscounts(settings, jwtClaims, params)
+	for i, item := range params.Items {
 		lineLogger := priceLogger.WithFields(logrus.Fields{
 			"product_type": item.ProductType(),
 			"product_sku":  item.ProductSku(),
 		})
 
-		itemPrice := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, 1)
+		itemPrice := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, 1, nil)
 
 		lineLogger.WithFields(
 			logrus.Fields{
@@ -260,12 +431,14 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 		price.Items = append(price.Items, itemPrice)
 
 		// avoid issues with rounding when multiplying by quantity before taxation
-		itemPriceMultiple := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, item.GetQuantity())
+		itemPriceMultiple := calculateAmountsForSingleItem(settings, lineLogger, jwtClaims, params, item, item.GetQuantity(), &percentageDiscounts[i])
 		price.Subtotal += itemPriceMultiple.Subtotal
 		price.Discount += itemPriceMultiple.Discount
+		price.DiscountCapped = price.DiscountCapped || itemPriceMultiple.DiscountCapped
 		price.NetTotal += itemPriceMultiple.NetTotal
 		price.Taxes += itemPriceMultiple.Taxes
 		price.Total += itemPriceMultiple.Total
+		price.TaxBreakdown = MergeTaxItems(price.TaxBreakdown, itemPriceMultiple.TaxBreakdown)
 	}
 
 	price.Total = int64(price.NetTotal + price.Taxes)
@@ -280,6 +453,154 @@ func CalculatePrice(settings *Settings, jwtClaims map[string]interface{}, params
 	return price
 }
 
+// TipProductType is the product type a tax has to list explicitly to apply to tips.
+const TipProductType = "tip"
+
+// CalculateTipTaxes calculates the taxes on a tip. Tips are tax free unless a
+// tax for the country explicitly lists the "tip" product type, so taxes that
+// apply to all product types don't cover them. Taxes are always added on top
+// of the tip.
+func CalculateTipTaxes(settings *Settings, country string, tip uint64) (uint64, []TaxItem) {
+	if settings == nil || tip == 0 {
+		return 0, nil
+	}
+	for _, t := range settings.Taxes {
+		if t.Code == "" && len(t.ProductTypes) > 0 && t.AppliesTo(country, TipProductType) {
+			taxes := rint(float64(tip) * float64(t.Percentage) / 100)
+			return taxes, taxBreakdown(t, country, t.Percentage, taxes)
+		}
+	}
+	return 0, nil
+}
+
+// ShippingProductType is the product type a tax has to list explicitly to
+// apply to shipping at a dedicated rate.
+const ShippingProductType = "shipping"
+
+// ShippingTaxesNone, ShippingTaxesItems and ShippingTaxesDedicated are the
+// ways shipping can be taxed. Shipping isn't taxed by default.
+const (
+	ShippingTaxesNone      = "none"
+	ShippingTaxesItems     = "items"
+	ShippingTaxesDedicated = "shipping"
+)
+
+// CalculateShippingTaxes calculates the taxes on the shipping of an order
+// whose line items add up to price. Depending on the settings shipping isn't
+// taxed, taxed at the rates of the line items in proportion to their net
+// totals, or taxed at the rate of a tax for the country explicitly listing the
+// "shipping" product type. Taxes are always added on top of the shipping and
+// are listed as shipping items of the breakdown.
+func CalculateShippingTaxes(settings *Settings, country string, price Price, shipping uint64) (uint64, []TaxItem) {
+	if settings == nil || shipping == 0 {
+		return 0, nil
+	}
+
+	var breakdown []TaxItem
+	switch settings.ShippingTaxes {
+	case ShippingTaxesItems:
+		if price.NetTotal == 0 {
+			return 0, nil
+		}
+		for _, item := range price.TaxBreakdown {
+			if item.Shipping {
+				continue
+			}
+			item.Amount = rint(float64(item.Amount) * float64(shipping) / float64(price.NetTotal))
+			if item.Amount > 0 {
+				breakdown = append(breakdown, item)
+			}
+		}
+	case ShippingTaxesDedicated:
+		for _, t := range settings.Taxes {
+			if t.Code == "" && len(t.ProductTypes) > 0 && t.AppliesTo(country, ShippingProductType) {
+				taxes := rint(float64(shipping) * float64(t.Percentage) / 100)
+				breakdown = taxBreakdown(t, country, t.Percentage, taxes)
+				break
+			}
+		}
+	}
+
+	var taxes uint64
+	for i := range breakdown {
+		breakdown[i].Shipping = true
+		taxes += breakdown[i].Amount
+	}
+	return taxes, breakdown
+}
+
+// MergeTaxItems adds up the amounts of tax items with the same jurisdiction,
+// tax code and rate, keeping shipping items apart. The order of first
+// appearance is kept.
+func MergeTaxItems(items []TaxItem, more []TaxItem) []TaxItem {
+	for _, m := range more {
+		merged := false
+		for i := range items {
+			if items[i].Jurisdiction == m.Jurisdiction && items[i].Code == m.Code && items[i].Rate == m.Rate && items[i].Shipping == m.Shipping {
+				items[i].Amount += m.Amount
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			items = append(items, m)
+		}
+	}
+	return items
+}
+
+// taxBreakdown splits the amount of a tax between the jurisdictions levying
+// it. The rounded amounts of the components add up to the amount with the
+// largest remainder method. Taxes without components are attributed to their
+// jurisdiction, or the country if they don't name one.
+func taxBreakdown(t *Tax, country string, percentage, amount uint64) []TaxItem {
+	if amount == 0 {
+		return nil
+	}
+	if t == nil || len(t.Components) == 0 {
+		jurisdiction := country
+		if t != nil && t.Jurisdiction != "" {
+			jurisdiction = t.Jurisdiction
+		}
+		code := ""
+		if t != nil {
+			code = t.Code
+		}
+		return []TaxItem{{Jurisdiction: jurisdiction, Code: code, Rate: percentage, Amount: amount}}
+	}
+
+	var total uint64
+	for _, c := range t.Components {
+		total += c.Percentage
+	}
+	if total == 0 {
+		return nil
+	}
+
+	items := make([]TaxItem, len(t.Components))
+	remainders := make([]uint64, len(t.Components))
+	var assigned uint64
+	for i, c := range t.Components {
+		share := amount * c.Percentage
+		items[i] = TaxItem{Jurisdiction: c.Jurisdiction, Code: t.Code, Rate: c.Percentage, Amount: share / total}
+		remainders[i] = share % total
+		assigned += items[i].Amount
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := 0; assigned < amount; i++ {
+		items[order[i%len(order)]].Amount++
+		assigned++
+	}
+	return items
+}
+
 func calculateDiscount(amountToDiscount, percentage, fixed uint64) uint64 {
 	var discount uint64
 	if percentage > 0 {
@@ -293,7 +614,7 @@ func calculateDiscount(amountToDiscount, percentage, fixed uint64) uint64 {
 	return discount
 }
 
-func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, settings *Settings) (taxes uint64, subtotal uint64) {
+func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, settings *Settings) (taxes uint64, subtotal uint64, breakdown []TaxItem) {
 	includeTaxes := settings != nil && settings.PricesIncludeTaxes
 	originalPrice := item.PriceInLowestUnit()
 
@@ -301,25 +622,26 @@ func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, setti
 	if item.FixedVAT() != 0 {
 		taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, percentage: item.FixedVAT()})
 	} else if settings != nil && item.TaxableItems() != nil && len(item.TaxableItems()) > 0 {
+		parent := item
 		for _, item := range item.TaxableItems() {
 			// because a discount may have been applied we need to determine the real price of this sub-item
 			priceShare := float64(item.PriceInLowestUnit()) / float64(originalPrice)
 			itemPrice := rint(float64(amountToTax) * priceShare)
 			amount := taxAmount{price: itemPrice}
-			for _, t := range settings.Taxes {
-				if t.AppliesTo(params.Country, item.ProductType()) {
-					amount.percentage = t.Percentage
-					break
-				}
+			// sub-items without a code of their own are taxed like the line item
+			code := item.ProductTaxCode()
+			if code == "" {
+				code = parent.ProductTaxCode()
+			}
+			if t := findTax(settings, params.Country, item.ProductType(), code); t != nil {
+				amount.percentage = t.Percentage
+				amount.tax = t
 			}
 			taxAmounts = append(taxAmounts, amount)
 		}
 	} else if settings != nil {
-		for _, t := range settings.Taxes {
-			if t.AppliesTo(params.Country, item.ProductType()) {
-				taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, percentage: t.Percentage})
-				break
-			}
+		if t := findTax(settings, params.Country, item.ProductType(), item.ProductTaxCode()); t != nil {
+			taxAmounts = append(taxAmounts, taxAmount{price: amountToTax, percentage: t.Percentage, tax: t})
 		}
 	}
 
@@ -335,7 +657,9 @@ func calculateTaxes(amountToTax uint64, item Item, params PriceParameters, setti
 			tax.price = rint(float64(tax.price) / (100 + float64(tax.percentage)) * 100)
 		}
 		subtotal += tax.price
-		taxes += rint(float64(tax.price) * float64(tax.percentage) / 100)
+		amount := rint(float64(tax.price) * float64(tax.percentage) / 100)
+		taxes += amount
+		breakdown = MergeTaxItems(breakdown, taxBreakdown(tax.tax, params.Country, tax.percentage, amount))
 	}
 
 	return
@@ -16,8 +16,10 @@ var testLogger = logrus.NewEntry(logrus.StandardLogger())
 type TestItem struct {
 	sku      string
 	price    uint64
+	cost     uint64
 	itemType string
 	vat      uint64
+	taxCode  string
 	items    []Item
 	quantity uint64
 }
@@ -30,6 +32,10 @@ func (t *TestItem) PriceInLowestUnit() uint64 {
 	return t.price
 }
 
+func (t *TestItem) CostInLowestUnit() uint64 {
+	return t.cost
+}
+
 func (t *TestItem) ProductType() string {
 	return t.itemType
 }
@@ -38,6 +44,10 @@ func (t *TestItem) FixedVAT() uint64 {
 	return t.vat
 }
 
+func (t *TestItem) ProductTaxCode() string {
+	return t.taxCode
+}
+
 func (t *TestItem) TaxableItems() []Item {
 	return t.items
 }
@@ -346,6 +356,64 @@ func TestMixedDiscounts(t *testing.T) {
 	assert.Equal(t, int64(0), price.Total)
 }
 
+func TestDiscountFloor(t *testing.T) {
+	settings := &Settings{MemberDiscounts: []*MemberDiscount{&MemberDiscount{
+		Claims: map[string]string{"app_metadata.plan": "member"},
+		FixedAmount: []*FixedMemberDiscount{{
+			Amount:   "0.80",
+			Currency: "USD",
+		}},
+	}, &MemberDiscount{
+		Claims:     map[string]string{"app_metadata.plan": "member"},
+		Percentage: 50,
+	}}}
+	claims := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(`{"app_metadata": {"plan": "member"}}`), &claims))
+	coupon := &TestCoupon{itemType: "test", fixed: 90}
+
+	t.Run("Zero", func(t *testing.T) {
+		params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{price: 100, cost: 30, itemType: "test", quantity: 2}}}
+		price := CalculatePrice(settings, claims, params, testLogger)
+
+		validatePrice(t, price, Price{
+			Subtotal: 200,
+			Discount: 200,
+			NetTotal: 0,
+			Taxes:    0,
+			Total:    0,
+		})
+		assert.True(t, price.DiscountCapped)
+		assert.True(t, price.Items[0].DiscountCapped)
+	})
+
+	t.Run("Cost", func(t *testing.T) {
+		settings.DiscountFloor = DiscountFloorCost
+		defer func() { settings.DiscountFloor = "" }()
+		params := PriceParameters{"USA", "USD", coupon, []Item{&TestItem{price: 100, cost: 30, itemType: "test", quantity: 2}}}
+		price := CalculatePrice(settings, claims, params, testLogger)
+
+		validatePrice(t, price, Price{
+			Subtotal: 200,
+			Discount: 140,
+			NetTotal: 60,
+			Taxes:    0,
+			Total:    60,
+		})
+		assert.True(t, price.DiscountCapped)
+		assert.Equal(t, uint64(70), price.Items[0].Discount)
+	})
+
+	t.Run("NotReached", func(t *testing.T) {
+		settings.DiscountFloor = DiscountFloorCost
+		defer func() { settings.DiscountFloor = "" }()
+		params := PriceParameters{"USA", "USD", &TestCoupon{itemType: "test", percentage: 10}, []Item{&TestItem{price: 100, cost: 30, itemType: "test"}}}
+		price := CalculatePrice(settings, nil, params, testLogger)
+
+		assert.Equal(t, uint64(10), price.Discount)
+		assert.False(t, price.DiscountCapped)
+	})
+}
+
 func TestRealWorldTaxCalculations(t *testing.T) {
 	settings := &Settings{
 		PricesIncludeTaxes: true,
@@ -487,3 +555,208 @@ func TestRealWorldFixedDiscountWithTaxes(t *testing.T) {
 		Total:    2900,
 	})
 }
+
+func TestCouponDiscountDistribution(t *testing.T) {
+	coupon := &TestCoupon{itemType: "test", percentage: 33}
+	items := []Item{
+		&TestItem{price: 101, itemType: "test"},
+		&TestItem{price: 101, itemType: "test"},
+		&TestItem{price: 101, itemType: "test"},
+	}
+	params := PriceParameters{"USA", "USD", coupon, items}
+	price := CalculatePrice(nil, nil, params, testLogger)
+
+	// 33% of 303 is 99.99, rounding every item on its own would give 99
+	validatePrice(t, price, Price{
+		Subtotal: 303,
+		Discount: 100,
+		NetTotal: 203,
+		Taxes:    0,
+		Total:    203,
+	})
+}
+
+func TestCouponDiscountDistributionWithQuantity(t *testing.T) {
+	coupon := &TestCoupon{itemType: "test", percentage: 33}
+	items := []Item{
+		&TestItem{price: 150, itemType: "test", quantity: 2},
+		&TestItem{price: 150, itemType: "test"},
+		&TestItem{price: 150, itemType: "test"},
+		&TestItem{price: 150, itemType: "other"},
+	}
+	params := PriceParameters{"USA", "USD", coupon, items}
+	price := CalculatePrice(nil, nil, params, testLogger)
+
+	// 33% of 600 is 198, rounding every item on its own would give 99 + 50 + 50
+	validatePrice(t, price, Price{
+		Subtotal: 750,
+		Discount: 198,
+		NetTotal: 552,
+		Taxes:    0,
+		Total:    552,
+	})
+}
+
+func TestDistributePercentageDiscounts(t *testing.T) {
+	coupon := &TestCoupon{itemType: "test", percentage: 33}
+	items := []Item{
+		&TestItem{price: 100, itemType: "test"},
+		&TestItem{price: 102, itemType: "test"},
+		&TestItem{price: 101, itemType: "test"},
+	}
+	params := PriceParameters{"USA", "USD", coupon, items}
+
+	// exact discounts are 33, 33.66 and 33.33
+	amounts := distributePercentageDiscounts(nil, nil, params)
+	assert.Equal(t, []uint64{33, 34, 33}, amounts)
+}
+
+func TestTipTaxes(t *testing.T) {
+	settings := &Settings{
+		Taxes: []*Tax{
+			&Tax{Percentage: 21},
+			&Tax{Percentage: 10, ProductTypes: []string{"test", TipProductType}, Countries: []string{"Canada"}},
+		},
+	}
+
+	// catch-all taxes don't apply to tips
+	taxes, _ := CalculateTipTaxes(settings, "USA", 500)
+	assert.Equal(t, uint64(0), taxes)
+	taxes, breakdown := CalculateTipTaxes(settings, "Canada", 500)
+	assert.Equal(t, uint64(50), taxes)
+	assert.Equal(t, []TaxItem{{Jurisdiction: "Canada", Rate: 10, Amount: 50}}, breakdown)
+	taxes, _ = CalculateTipTaxes(nil, "Canada", 500)
+	assert.Equal(t, uint64(0), taxes)
+}
+
+func TestShippingTaxes(t *testing.T) {
+	taxes := []*Tax{
+		&Tax{Percentage: 19, ProductTypes: []string{"test"}, Countries: []string{"Germany"}},
+		&Tax{Percentage: 7, ProductTypes: []string{"book"}, Countries: []string{"Germany"}},
+		&Tax{Percentage: 16, ProductTypes: []string{ShippingProductType}, Countries: []string{"Germany"}},
+	}
+	items := []Item{
+		&TestItem{price: 1000, itemType: "test"},
+		&TestItem{price: 1000, itemType: "book"},
+		&TestItem{price: 1000, itemType: "untaxed"},
+	}
+
+	tests := []struct {
+		name      string
+		mode      string
+		country   string
+		shipping  uint64
+		taxes     uint64
+		breakdown []TaxItem
+	}{
+		{"Default", "", "Germany", 600, 0, nil},
+		{"None", ShippingTaxesNone, "Germany", 600, 0, nil},
+		{"Items", ShippingTaxesItems, "Germany", 600, 52, []TaxItem{
+			{Jurisdiction: "Germany", Rate: 19, Amount: 38, Shipping: true},
+			{Jurisdiction: "Germany", Rate: 7, Amount: 14, Shipping: true},
+		}},
+		{"ItemsUntaxed", ShippingTaxesItems, "USA", 600, 0, nil},
+		{"Dedicated", ShippingTaxesDedicated, "Germany", 600, 96, []TaxItem{
+			{Jurisdiction: "Germany", Rate: 16, Amount: 96, Shipping: true},
+		}},
+		{"DedicatedUntaxed", ShippingTaxesDedicated, "USA", 600, 0, nil},
+		{"FreeShipping", ShippingTaxesItems, "Germany", 0, 0, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := &Settings{Taxes: taxes, ShippingTaxes: tc.mode}
+			price := CalculatePrice(settings, nil, PriceParameters{tc.country, "USD", nil, items}, testLogger)
+			shippingTaxes, breakdown := CalculateShippingTaxes(settings, tc.country, price, tc.shipping)
+			assert.Equal(t, tc.taxes, shippingTaxes)
+			assert.Equal(t, tc.breakdown, breakdown)
+		})
+	}
+
+	// shipping taxes are listed apart from the taxes on the items
+	settings := &Settings{Taxes: taxes, ShippingTaxes: ShippingTaxesItems}
+	price := CalculatePrice(settings, nil, PriceParameters{"Germany", "USD", nil, items}, testLogger)
+	_, breakdown := CalculateShippingTaxes(settings, "Germany", price, 600)
+	assert.Equal(t, []TaxItem{
+		{Jurisdiction: "Germany", Rate: 19, Amount: 190},
+		{Jurisdiction: "Germany", Rate: 7, Amount: 70},
+		{Jurisdiction: "Germany", Rate: 19, Amount: 38, Shipping: true},
+		{Jurisdiction: "Germany", Rate: 7, Amount: 14, Shipping: true},
+	}, MergeTaxItems(price.TaxBreakdown, breakdown))
+
+	_, breakdown = CalculateShippingTaxes(nil, "Germany", price, 600)
+	assert.Nil(t, breakdown)
+}
+
+func TestTaxBreakdown(t *testing.T) {
+	settings := &Settings{
+		Taxes: []*Tax{&Tax{
+			Percentage: 9,
+			Countries:  []string{"USA"},
+			Components: []*TaxComponent{
+				{Jurisdiction: "California", Percentage: 5},
+				{Jurisdiction: "San Francisco", Percentage: 4},
+			},
+		}},
+	}
+	items := []Item{
+		&TestItem{price: 1011, itemType: "test"},
+		&TestItem{price: 500, itemType: "test"},
+	}
+	params := PriceParameters{"USA", "USD", nil, items}
+	price := CalculatePrice(settings, nil, params, testLogger)
+
+	// 91 is split into 51 and 40, 45 into 25 and 20
+	assert.Equal(t, []TaxItem{
+		{Jurisdiction: "California", Rate: 5, Amount: 76},
+		{Jurisdiction: "San Francisco", Rate: 4, Amount: 60},
+	}, price.TaxBreakdown)
+
+	var sum uint64
+	for _, item := range price.TaxBreakdown {
+		sum += item.Amount
+	}
+	assert.Equal(t, price.Taxes, sum)
+}
+
+func TestTaxCodes(t *testing.T) {
+	settings := &Settings{
+		Taxes: []*Tax{
+			{Code: "reduced", Percentage: 7, Countries: []string{"Germany"}},
+			{Code: "zero", Percentage: 0, Countries: []string{"Germany"}},
+			{Percentage: 19, Countries: []string{"Germany"}},
+		},
+	}
+	items := []Item{
+		&TestItem{price: 100, itemType: "book", taxCode: "reduced"},
+		&TestItem{price: 100, itemType: "book"},
+		&TestItem{price: 200, itemType: "bundle", taxCode: "reduced", items: []Item{
+			&TestItem{price: 100, itemType: "book"},
+			&TestItem{price: 100, itemType: "food", taxCode: "zero"},
+		}},
+	}
+	require.NoError(t, CheckTaxCodes(settings, items))
+
+	params := PriceParameters{"Germany", "EUR", nil, items}
+	price := CalculatePrice(settings, nil, params, testLogger)
+	require.Len(t, price.Items, 3)
+	assert.Equal(t, uint64(7), price.Items[0].Taxes)
+	assert.Equal(t, []TaxItem{{Jurisdiction: "Germany", Code: "reduced", Rate: 7, Amount: 7}}, price.Items[0].TaxBreakdown)
+	assert.Equal(t, uint64(19), price.Items[1].Taxes)
+	assert.Equal(t, uint64(7), price.Items[2].Taxes)
+	assert.Equal(t, []TaxItem{
+		{Jurisdiction: "Germany", Code: "reduced", Rate: 7, Amount: 14},
+		{Jurisdiction: "Germany", Rate: 19, Amount: 19},
+	}, price.TaxBreakdown)
+
+	// taxes with a code don't apply to tips
+	taxes, _ := CalculateTipTaxes(&Settings{Taxes: []*Tax{
+		{Code: "reduced", Percentage: 7, ProductTypes: []string{TipProductType}},
+	}}, "Germany", 100)
+	assert.Equal(t, uint64(0), taxes)
+
+	err := CheckTaxCodes(settings, []Item{&TestItem{sku: "food-1", taxCode: "unknown"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown")
+	assert.Contains(t, err.Error(), "food-1")
+	assert.Error(t, CheckTaxCodes(nil, []Item{&TestItem{taxCode: "reduced"}}))
+}
@@ -3,10 +3,12 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/netlify/gocommerce/api"
 	"github.com/netlify/gocommerce/conf"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -39,12 +41,21 @@ func multi(cmd *cobra.Command, args []string) {
 	defer bgDB.Close()
 
 	globalConfig.MultiInstanceMode = true
+	bgLog := logrus.WithField("node_id", globalConfig.NodeID)
+	api.RunAbandonedCartReminders(bgDB, globalConfig, nil, bgLog.WithField("component", "abandoned_carts"))
+	api.RunConfirmationRetries(bgDB, globalConfig, nil, bgLog.WithField("component", "confirmations"))
+	api.RunOrderArchival(bgDB, globalConfig, nil, bgLog.WithField("component", "archival"))
+	api.RunManualPaymentExpiry(bgDB, globalConfig, nil, bgLog.WithField("component", "manual_payments"))
+	api.RunPaymentCaptures(bgDB, globalConfig, nil, bgLog.WithField("component", "captures"))
 	api := api.NewAPIWithVersion(context.Background(), globalConfig, db.Debug(), Version)
 
 	l := fmt.Sprintf("%v:%v", globalConfig.API.Host, globalConfig.API.Port)
 	logrus.Infof("GoCommerce API started on: %s", l)
 
-	models.RunHooks(bgDB, logrus.WithField("component", "hooks"))
+	guard := ssrf.NewGuard(globalConfig.Outbound.AllowedHosts)
+	hookClient := models.NewHookClient(time.Duration(globalConfig.Webhooks.Timeout)*time.Second, guard, globalConfig.NodeID)
+	models.RunHooks(bgDB, hookClient, bgLog.WithField("component", "hooks"))
+	models.RunReservationCleanup(bgDB, bgLog.WithField("component", "reservations"))
 
 	api.ListenAndServe(l)
 }
@@ -1,7 +1,10 @@
 package conf
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -31,6 +34,17 @@ type SMTPConfiguration struct {
 	AdminEmail string `json:"admin_email" split_words:"true"`
 }
 
+// WebhookConfiguration holds the configuration for delivering webhooks.
+type WebhookConfiguration struct {
+	Timeout int `json:"timeout"`
+}
+
+// OutboundConfiguration holds the configuration for requests to URLs that are
+// configured by operators or users, like coupon sources and webhooks.
+type OutboundConfiguration struct {
+	AllowedHosts []string `json:"allowed_hosts" split_words:"true"`
+}
+
 // GlobalConfiguration holds all the global configuration for gocommerce
 type GlobalConfiguration struct {
 	API struct {
@@ -42,13 +56,133 @@ type GlobalConfiguration struct {
 	Logging           nconf.LoggingConfig `envconfig:"LOG"`
 	OperatorToken     string              `split_words:"true"`
 	MultiInstanceMode bool
-	SMTP              SMTPConfiguration `json:"smtp"`
+	SMTP              SMTPConfiguration     `json:"smtp"`
+	Webhooks          WebhookConfiguration  `json:"webhooks"`
+	Outbound          OutboundConfiguration `json:"outbound"`
+
+	// NodeID identifies this process in logs and webhooks when several run
+	// side by side. It defaults to the hostname.
+	NodeID string `split_words:"true"`
 }
 
 // EmailContentConfiguration holds the configuration for emails, both subjects and template URLs.
 type EmailContentConfiguration struct {
 	OrderConfirmation string `json:"order_confirmation" split_words:"true"`
 	OrderReceived     string `json:"order_received" split_words:"true"`
+	OrderClaim        string `json:"order_claim" split_words:"true"`
+	AbandonedCart     string `json:"abandoned_cart" split_words:"true"`
+
+	PaymentInstructions string `json:"payment_instructions" split_words:"true"`
+	PartialShipment     string `json:"partial_shipment" split_words:"true"`
+	OrderComplete       string `json:"order_complete" split_words:"true"`
+	CaptureFailed       string `json:"capture_failed" split_words:"true"`
+}
+
+// ReportColumn is a column of a report export. It's filled with a Field of
+// the report rows, or with the constant Value if no field is set.
+type ReportColumn struct {
+	Header string `json:"header"`
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+}
+
+// ReportFormat is the CSV layout an accounting system imports reports in.
+// Dates are written with the Go layout DateFormat, amounts in major units.
+type ReportFormat struct {
+	Delimiter  string         `json:"delimiter"`
+	DateFormat string         `json:"date_format"`
+	Columns    []ReportColumn `json:"columns"`
+}
+
+// ReportFormats are the named report export formats. In the environment
+// they're given as a JSON object.
+type ReportFormats map[string]ReportFormat
+
+// Decode reads the formats from a JSON object.
+func (f *ReportFormats) Decode(value string) error {
+	return json.Unmarshal([]byte(value), f)
+}
+
+// WebhookCategories maps categories of event types to the URL all their
+// events are sent to. In the environment they're given as a comma separated
+// list of category:URL pairs.
+type WebhookCategories map[string]string
+
+// Decode reads the categories from the environment. Only the first colon of
+// a pair separates the category, the URL contains colons itself.
+func (c *WebhookCategories) Decode(value string) error {
+	categories := WebhookCategories{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid webhook category %q, expected category:URL", pair)
+		}
+		categories[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	*c = categories
+	return nil
+}
+
+// EligibilityRule is the check that decides who may purchase the products
+// of a type.
+type EligibilityRule struct {
+	Provider   string `json:"provider"`
+	MinimumAge int    `json:"minimum_age"`
+}
+
+// EligibilityRules map product types to their eligibility check. In the
+// environment they're given as a JSON object.
+type EligibilityRules map[string]EligibilityRule
+
+// Decode reads the rules from a JSON object.
+func (e *EligibilityRules) Decode(value string) error {
+	return json.Unmarshal([]byte(value), e)
+}
+
+// WebhookEndpoint configures the delivery of webhooks to one URL.
+type WebhookEndpoint struct {
+	// MaxInFlight limits the concurrent deliveries to the URL, overriding the
+	// limit of all webhooks.
+	MaxInFlight int `json:"max_in_flight"`
+	// Compress gzip compresses the payloads sent to the URL.
+	Compress bool `json:"compress"`
+}
+
+// WebhookEndpoints configure webhook URLs by URL. In the environment they're
+// given as a JSON object.
+type WebhookEndpoints map[string]WebhookEndpoint
+
+// Decode reads the endpoints from a JSON object.
+func (e *WebhookEndpoints) Decode(value string) error {
+	return json.Unmarshal([]byte(value), e)
+}
+
+// defaultReportFormats are the formats of the accounting systems supported
+// out of the box.
+func defaultReportFormats() ReportFormats {
+	return ReportFormats{
+		"quickbooks": {
+			DateFormat: "01/02/2006",
+			Columns: []ReportColumn{
+				{Header: "Date", Field: "date"},
+				{Header: "Description", Value: "Sales"},
+				{Header: "Amount", Field: "total"},
+			},
+		},
+		"xero": {
+			DateFormat: "02/01/2006",
+			Columns: []ReportColumn{
+				{Header: "*Date", Field: "date"},
+				{Header: "*Amount", Field: "total"},
+				{Header: "Payee", Value: "Online customers"},
+				{Header: "Description", Value: "Sales"},
+				{Header: "Reference", Field: "currency"},
+			},
+		},
+	}
 }
 
 // Configuration holds all the per-tenant configuration for gocommerce
@@ -61,6 +195,9 @@ type Configuration struct {
 	Mailer struct {
 		Subjects  EmailContentConfiguration `json:"subjects"`
 		Templates EmailContentConfiguration `json:"templates"`
+
+		ConfirmationAttempts int  `json:"confirmation_attempts" split_words:"true"`
+		LineItemDetails      bool `json:"line_item_details" split_words:"true"`
 	} `json:"mailer"`
 
 	Payment struct {
@@ -75,26 +212,182 @@ type Configuration struct {
 			Secret   string `json:"secret"`
 			Env      string `json:"env"`
 		} `json:"paypal"`
+		Braintree struct {
+			Enabled    bool   `json:"enabled"`
+			MerchantID string `json:"merchant_id" split_words:"true"`
+			PublicKey  string `json:"public_key" split_words:"true"`
+			PrivateKey string `json:"private_key" split_words:"true"`
+			Env        string `json:"env"`
+			// MerchantAccounts maps currencies to the merchant account
+			// that charges in them.
+			MerchantAccounts map[string]string `json:"merchant_accounts" split_words:"true"`
+		} `json:"braintree"`
+		Manual struct {
+			Enabled      bool   `json:"enabled"`
+			Instructions string `json:"instructions"`
+			// ExpireAfter is the number of days after which unpaid
+			// manual payments are cancelled.
+			ExpireAfter int `json:"expire_after" split_words:"true"`
+		} `json:"manual"`
+		Capture struct {
+			// Delay is the number of hours card payments are only
+			// authorized for before they're captured. 0 captures them
+			// right away.
+			Delay int `json:"delay"`
+		} `json:"capture"`
+		Routing struct {
+			Currencies map[string]string `json:"currencies"`
+			Default    string            `json:"default"`
+		} `json:"routing"`
+		MaxRefundAge   int  `json:"max_refund_age" split_words:"true"`
+		SkipTotalCheck bool `json:"skip_total_check" split_words:"true"`
+		// DisableFreeOrders rejects payments of orders with a total of
+		// zero instead of marking them as paid.
+		DisableFreeOrders bool `json:"disable_free_orders" split_words:"true"`
+
+		// Currencies lists the currencies orders can be placed in, all
+		// currencies are accepted if it's empty.
+		Currencies    []string          `json:"currencies"`
+		MinimumCharge map[string]uint64 `json:"minimum_charge" split_words:"true"`
 	} `json:"payment"`
 
 	Downloads struct {
 		Provider     string `json:"provider"`
 		NetlifyToken string `json:"netlify_token" split_words:"true"`
+		Delivery     string `json:"delivery"`
 	} `json:"downloads"`
 
+	Licenses struct {
+		URL string `json:"url"`
+	} `json:"licenses"`
+
+	SerialNumbers struct {
+		Format string `json:"format"`
+	} `json:"serial_numbers" split_words:"true"`
+
 	Coupons struct {
-		URL      string `json:"url"`
-		User     string `json:"user"`
-		Password string `json:"password"`
+		URL           string `json:"url"`
+		User          string `json:"user"`
+		Password      string `json:"password"`
+		CaseSensitive bool   `json:"case_sensitive" split_words:"true"`
 	} `json:"coupons"`
 
+	Delivery struct {
+		LeadTime  int            `json:"lead_time" split_words:"true"`
+		LeadTimes map[string]int `json:"lead_times" split_words:"true"`
+		Weekend   []string       `json:"weekend"`
+		Holidays  []string       `json:"holidays"`
+	} `json:"delivery"`
+
+	Weight struct {
+		Unit string `json:"unit"`
+	} `json:"weight"`
+
+	Countries struct {
+		Allowed []string `json:"allowed"`
+		Blocked []string `json:"blocked"`
+	} `json:"countries"`
+
+	Locale struct {
+		Infer        bool              `json:"infer"`
+		GeoHeader    string            `json:"geo_header" split_words:"true"`
+		Currencies   map[string]string `json:"currencies"`
+		CountryNames map[string]string `json:"country_names" split_words:"true"`
+	} `json:"locale"`
+
+	Display struct {
+		FormattedAmounts bool   `json:"formatted_amounts" split_words:"true"`
+		Amounts          string `json:"amounts"`
+	} `json:"display"`
+
+	Review struct {
+		HoldOverAmount uint64 `json:"hold_over_amount" split_words:"true"`
+	} `json:"review"`
+
+	Inventory struct {
+		Enabled            bool `json:"enabled"`
+		ReservationTimeout int  `json:"reservation_timeout" split_words:"true"`
+		AllowBackorders    bool `json:"allow_backorders" split_words:"true"`
+	} `json:"inventory"`
+
+	Limits struct {
+		MaxQuantityPerOrder uint64 `json:"max_quantity_per_order" split_words:"true"`
+		CustomerWindow      int    `json:"customer_window" split_words:"true"`
+		MaxBodySize         int64  `json:"max_body_size" split_words:"true"`
+		MaxLineItems        int    `json:"max_line_items" split_words:"true"`
+		MaxPendingOrders    int    `json:"max_pending_orders" split_words:"true"`
+		PendingOrderWindow  int    `json:"pending_order_window" split_words:"true"`
+	} `json:"limits"`
+
+	AbandonedCart struct {
+		Enabled bool `json:"enabled"`
+		Delay   int  `json:"delay"`
+		Window  int  `json:"window"`
+	} `json:"abandoned_cart" split_words:"true"`
+
+	Archive struct {
+		After int `json:"after"`
+	} `json:"archive"`
+
+	AddressVerification struct {
+		Mode     string `json:"mode"`
+		Provider string `json:"provider"`
+		URL      string `json:"url"`
+	} `json:"address_verification" split_words:"true"`
+
+	Eligibility struct {
+		ProductTypes EligibilityRules `json:"product_types" split_words:"true"`
+	} `json:"eligibility"`
+
+	Invoices struct {
+		SellerName    string   `json:"seller_name" split_words:"true"`
+		SellerAddress []string `json:"seller_address" split_words:"true"`
+		SellerTaxID   string   `json:"seller_tax_id" split_words:"true"`
+		SellerTaxIDs  []string `json:"seller_tax_ids" split_words:"true"`
+
+		ReverseChargeNote string `json:"reverse_charge_note" split_words:"true"`
+	} `json:"invoices"`
+
+	Reports struct {
+		Formats ReportFormats `json:"formats"`
+	} `json:"reports"`
+
+	// Consent configures the consent customers have to give when ordering.
+	// Orders must accept TermsVersion if it's set, and orders of digital goods
+	// must consent to their immediate delivery if RequireDigitalDelivery is set.
+	Consent struct {
+		TermsVersion           string `json:"terms_version" split_words:"true"`
+		RequireDigitalDelivery bool   `json:"require_digital_delivery" split_words:"true"`
+	} `json:"consent"`
+
+	Claims struct {
+		Enabled         bool `json:"enabled"`
+		TokenExpiration int  `json:"token_expiration" split_words:"true"`
+	} `json:"claims"`
+
 	Webhooks struct {
 		Order   string `json:"order"`
 		Payment string `json:"payment"`
 		Update  string `json:"update"`
 		Refund  string `json:"refund"`
 
-		Secret string `json:"secret"`
+		BackorderFulfilled string `json:"backorder_fulfilled" split_words:"true"`
+
+		// Categories maps categories of event types to the URL all their
+		// events are sent to, in addition to the URLs of the types.
+		Categories WebhookCategories `json:"categories"`
+
+		Secret      string `json:"secret"`
+		MaxInFlight int    `json:"max_in_flight" split_words:"true"`
+		// Endpoints configure the delivery to single URLs.
+		Endpoints WebhookEndpoints `json:"endpoints"`
+		// Ordered delivers the webhooks of an order to each URL one after
+		// the other, in the order they happened.
+		Ordered bool `json:"ordered"`
+
+		// Disabled lists event types no webhooks are sent for, e.g. to
+		// mute a noisy event during an incident.
+		Disabled []string `json:"disabled"`
 	} `json:"webhooks"`
 }
 
@@ -129,6 +422,9 @@ func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 	if _, err := nconf.ConfigureLogging(&config.Logging); err != nil {
 		return nil, err
 	}
+	if config.NodeID == "" {
+		config.NodeID, _ = os.Hostname()
+	}
 	return config, nil
 }
 
@@ -151,4 +447,51 @@ func (config *Configuration) ApplyDefaults() {
 	if config.JWT.AdminGroupName == "" {
 		config.JWT.AdminGroupName = "admin"
 	}
+	if config.Inventory.ReservationTimeout == 0 {
+		config.Inventory.ReservationTimeout = 15 * 60
+	}
+	if config.Limits.CustomerWindow == 0 {
+		config.Limits.CustomerWindow = 30 * 24 * 60 * 60
+	}
+	if config.Limits.MaxBodySize == 0 {
+		config.Limits.MaxBodySize = 1 << 20
+	}
+	if config.Limits.MaxLineItems == 0 {
+		config.Limits.MaxLineItems = 250
+	}
+	if config.Limits.PendingOrderWindow == 0 {
+		config.Limits.PendingOrderWindow = 24 * 60 * 60
+	}
+	if config.Claims.TokenExpiration == 0 {
+		config.Claims.TokenExpiration = 7 * 24 * 60 * 60
+	}
+	if config.AbandonedCart.Delay == 0 {
+		config.AbandonedCart.Delay = 60 * 60
+	}
+	if config.AbandonedCart.Window == 0 {
+		config.AbandonedCart.Window = 3 * 24 * 60 * 60
+	}
+	if config.Payment.Manual.ExpireAfter == 0 {
+		config.Payment.Manual.ExpireAfter = 14
+	}
+	if config.Mailer.ConfirmationAttempts == 0 {
+		config.Mailer.ConfirmationAttempts = 5
+	}
+	if config.Invoices.ReverseChargeNote == "" {
+		config.Invoices.ReverseChargeNote = "Reverse charge: the recipient is liable for the VAT (Article 196 of Council Directive 2006/112/EC)."
+	}
+	if config.Reports.Formats == nil {
+		config.Reports.Formats = ReportFormats{}
+	}
+	for name, format := range defaultReportFormats() {
+		if _, ok := config.Reports.Formats[name]; !ok {
+			config.Reports.Formats[name] = format
+		}
+	}
+	if config.Weight.Unit == "" {
+		config.Weight.Unit = "kg"
+	}
+	if len(config.Delivery.Weekend) == 0 {
+		config.Delivery.Weekend = []string{"saturday", "sunday"}
+	}
 }
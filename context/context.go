@@ -7,13 +7,16 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 
+	"github.com/netlify/gocommerce/addressverifiers"
 	"github.com/netlify/gocommerce/assetstores"
 	"github.com/netlify/gocommerce/claims"
 	"github.com/netlify/gocommerce/conf"
 	"github.com/netlify/gocommerce/coupons"
+	"github.com/netlify/gocommerce/eligibility"
 	"github.com/netlify/gocommerce/mailer"
 	"github.com/netlify/gocommerce/models"
 	"github.com/netlify/gocommerce/payments"
+	"github.com/netlify/gocommerce/ssrf"
 )
 
 type contextKey string
@@ -30,6 +33,8 @@ const (
 	adminFlagKey       = contextKey("is_admin")
 	mailerKey          = contextKey("mailer")
 	assetStoreKey      = contextKey("asset_store")
+	addressVerifierKey = contextKey("address_verifier")
+	eligibilityKey     = contextKey("eligibility")
 	paymentProviderKey = contextKey("payment-provider")
 	userIDKey          = contextKey("user_id")
 	userKey            = contextKey("user")
@@ -54,8 +59,8 @@ func GetConfig(ctx context.Context) *conf.Configuration {
 }
 
 // WithCoupons adds the coupon cache to the context based on the site URL.
-func WithCoupons(ctx context.Context, config *conf.Configuration) (context.Context, error) {
-	cache, err := coupons.NewCouponCacheFromURL(config)
+func WithCoupons(ctx context.Context, config *conf.Configuration, guard *ssrf.Guard) (context.Context, error) {
+	cache, err := coupons.NewCouponCacheFromURL(config, guard)
 	if err != nil {
 		return nil, err
 	}
@@ -130,6 +135,34 @@ func GetAssetStore(ctx context.Context) assetstores.Store {
 	return obj.(assetstores.Store)
 }
 
+// WithAddressVerifier adds the address verifier to the context.
+func WithAddressVerifier(ctx context.Context, verifier addressverifiers.AddressVerifier) context.Context {
+	return context.WithValue(ctx, addressVerifierKey, verifier)
+}
+
+// GetAddressVerifier reads the address verifier from the context.
+func GetAddressVerifier(ctx context.Context) addressverifiers.AddressVerifier {
+	obj := ctx.Value(addressVerifierKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(addressverifiers.AddressVerifier)
+}
+
+// WithEligibilityChecker adds the eligibility checker to the context.
+func WithEligibilityChecker(ctx context.Context, checker eligibility.Checker) context.Context {
+	return context.WithValue(ctx, eligibilityKey, checker)
+}
+
+// GetEligibilityChecker reads the eligibility checker from the context.
+func GetEligibilityChecker(ctx context.Context) eligibility.Checker {
+	obj := ctx.Value(eligibilityKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(eligibility.Checker)
+}
+
 // WithPaymentProviders adds the payment providers to the context.
 func WithPaymentProviders(ctx context.Context, provs map[string]payments.Provider) context.Context {
 	return context.WithValue(ctx, paymentProviderKey, provs)
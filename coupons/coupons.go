@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/netlify/gocommerce/conf"
 	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/pkg/errors"
 )
 
@@ -33,17 +35,30 @@ type couponsResponse struct {
 }
 
 type couponCacheFromURL struct {
-	url       string
-	user      string
-	password  string
-	lastFetch time.Time
-	coupons   map[string]*models.Coupon
-	mutex     sync.Mutex
-	client    *http.Client
+	url           string
+	user          string
+	password      string
+	caseSensitive bool
+	lastFetch     time.Time
+	coupons       map[string]*models.Coupon
+	codes         map[string]*models.Coupon
+	mutex         sync.Mutex
+	client        *http.Client
+}
+
+// NormalizeCode trims the whitespace around a coupon code and folds it to lower
+// case, unless codes are case sensitive.
+func NormalizeCode(code string, caseSensitive bool) string {
+	code = strings.TrimSpace(code)
+	if !caseSensitive {
+		code = strings.ToLower(code)
+	}
+	return code
 }
 
 // NewCouponCacheFromURL creates a coupon cache using the provided configuration.
-func NewCouponCacheFromURL(config *conf.Configuration) (Cache, error) {
+// The guard restricts which addresses the coupons can be loaded from.
+func NewCouponCacheFromURL(config *conf.Configuration, guard *ssrf.Guard) (Cache, error) {
 	if config.Coupons.URL == "" {
 		return nil, nil
 	}
@@ -64,12 +79,14 @@ func NewCouponCacheFromURL(config *conf.Configuration) (Cache, error) {
 	}
 
 	return &couponCacheFromURL{
-		url:       url.String(),
-		user:      config.Coupons.User,
-		password:  config.Coupons.Password,
-		coupons:   map[string]*models.Coupon{},
-		client:    &http.Client{},
-		lastFetch: time.Unix(0, 0),
+		url:           url.String(),
+		user:          config.Coupons.User,
+		password:      config.Coupons.Password,
+		caseSensitive: config.Coupons.CaseSensitive,
+		coupons:       map[string]*models.Coupon{},
+		codes:         map[string]*models.Coupon{},
+		client:        guard.Client(),
+		lastFetch:     time.Unix(0, 0),
 	}, nil
 }
 
@@ -91,6 +108,7 @@ func (c *couponCacheFromURL) load() error {
 	}
 
 	couponsResponse := &couponsResponse{}
+	codes := map[string]*models.Coupon{}
 	if resp.Body != nil && resp.Body != http.NoBody {
 		defer resp.Body.Close()
 		decoder := json.NewDecoder(resp.Body)
@@ -102,17 +120,22 @@ func (c *couponCacheFromURL) load() error {
 			if coupon.Code == "" {
 				coupon.Code = key
 			}
+			codes[NormalizeCode(key, c.caseSensitive)] = coupon
 		}
 	}
 
 	c.mutex.Lock()
 	c.coupons = couponsResponse.Coupons
+	c.codes = codes
 	c.lastFetch = time.Now()
 	c.mutex.Unlock()
 
 	return nil
 }
 
+// Lookup finds a coupon by its key in the coupon file. Codes that only match
+// after normalizing them with NormalizeCode are found too, an exact match is
+// preferred if several keys normalize to the same code.
 func (c *couponCacheFromURL) Lookup(code string) (*models.Coupon, error) {
 	if time.Now().After(c.lastFetch.Add(cacheTime)) {
 		if err := c.load(); err != nil {
@@ -120,7 +143,10 @@ func (c *couponCacheFromURL) Lookup(code string) (*models.Coupon, error) {
 		}
 	}
 
-	coupon, ok := c.coupons[code]
+	if coupon, ok := c.coupons[code]; ok {
+		return coupon, nil
+	}
+	coupon, ok := c.codes[NormalizeCode(code, c.caseSensitive)]
 	if ok {
 		return coupon, nil
 	}
@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/ssrf"
 )
 
 func TestRelativeURL(t *testing.T) {
@@ -44,7 +45,7 @@ func TestRelativeURL(t *testing.T) {
 	c.Coupons.User = "kitten"
 	c.Coupons.Password = "catnip4life"
 
-	cacheFace, err := NewCouponCacheFromURL(c)
+	cacheFace, err := NewCouponCacheFromURL(c, ssrf.NewGuard([]string{"127.0.0.1"}))
 	require.NoError(t, err)
 	require.NotNil(t, cacheFace)
 
@@ -105,6 +106,73 @@ func TestExplicitLookup(t *testing.T) {
 	assert.Equal(t, 1, callCount)
 }
 
+func TestNormalizeCode(t *testing.T) {
+	cases := []struct {
+		code          string
+		caseSensitive bool
+		expected      string
+	}{
+		{"summer20", false, "summer20"},
+		{"SUMMER20", false, "summer20"},
+		{" summer20 ", false, "summer20"},
+		{"\tSummer20\n", false, "summer20"},
+		{"SUMMER 20", false, "summer 20"},
+		{"ÉTÉ20", false, "été20"},
+		{"   ", false, ""},
+		{" SUMMER20 ", true, "SUMMER20"},
+		{"Summer20", true, "Summer20"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, NormalizeCode(c.code, c.caseSensitive), "normalizing %q", c.code)
+	}
+}
+
+func TestLookupNormalization(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"coupons": {"summer20": {"percentage": 20}, "Winter10": {"percentage": 10}, "spring": {"percentage": 5}, "SPRING": {"percentage": 15}}}`))
+	}))
+	defer svr.Close()
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		c := &conf.Configuration{}
+		c.Coupons.URL = svr.URL
+		cache := newCache(t, c)
+
+		for code, key := range map[string]string{
+			"SUMMER20":   "summer20",
+			" summer20 ": "summer20",
+			"winter10":   "Winter10",
+			"Winter10":   "Winter10",
+			"spring":     "spring",
+			"SPRING":     "SPRING",
+		} {
+			coupon, err := cache.Lookup(code)
+			if assert.NoError(t, err, "looking up %q", code) {
+				assert.Equal(t, key, coupon.Code, "looking up %q", code)
+			}
+		}
+
+		_, err := cache.Lookup("summer 20")
+		assert.IsType(t, new(CouponNotFound), err)
+	})
+
+	t.Run("CaseSensitive", func(t *testing.T) {
+		c := &conf.Configuration{}
+		c.Coupons.URL = svr.URL
+		c.Coupons.CaseSensitive = true
+		cache := newCache(t, c)
+
+		coupon, err := cache.Lookup(" Winter10\t")
+		require.NoError(t, err)
+		assert.Equal(t, "Winter10", coupon.Code)
+
+		_, err = cache.Lookup("SUMMER20")
+		assert.IsType(t, new(CouponNotFound), err)
+		_, err = cache.Lookup("winter10")
+		assert.IsType(t, new(CouponNotFound), err)
+	})
+}
+
 func TestCacheExpiration(t *testing.T) {
 	var callCount int
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -161,7 +229,7 @@ func TestMalformedResponse(t *testing.T) {
 }
 
 func newCache(t *testing.T, c *conf.Configuration) *couponCacheFromURL {
-	cacheFace, err := NewCouponCacheFromURL(c)
+	cacheFace, err := NewCouponCacheFromURL(c, ssrf.NewGuard([]string{"127.0.0.1"}))
 	require.NoError(t, err)
 	require.NotNil(t, cacheFace)
 	cache, ok := cacheFace.(*couponCacheFromURL)
@@ -0,0 +1,49 @@
+package eligibility
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultMinimumAge is the age required by the age gate if none is
+// configured.
+const DefaultMinimumAge = 18
+
+// ageChecker only lets customers purchase who provided a birthdate and have
+// reached the minimum age.
+type ageChecker struct {
+	minimumAge int
+	now        func() time.Time
+}
+
+func newAgeChecker(minimumAge int) (*ageChecker, error) {
+	if minimumAge < 0 {
+		return nil, fmt.Errorf("Invalid minimum age %d", minimumAge)
+	}
+	if minimumAge == 0 {
+		minimumAge = DefaultMinimumAge
+	}
+	return &ageChecker{
+		minimumAge: minimumAge,
+		now:        time.Now,
+	}, nil
+}
+
+func (a *ageChecker) CheckEligibility(req *Request) (*Result, error) {
+	if req.Birthdate == nil {
+		return &Result{Reason: "A birthdate is required to purchase this product"}, nil
+	}
+	if age(*req.Birthdate, a.now()) < a.minimumAge {
+		return &Result{Reason: fmt.Sprintf("You must be at least %d years old to purchase this product", a.minimumAge)}, nil
+	}
+	return &Result{Eligible: true}, nil
+}
+
+// age returns the age in full years of someone born on the birthdate.
+func age(birthdate, now time.Time) int {
+	years := now.Year() - birthdate.Year()
+	if now.Month() < birthdate.Month() || (now.Month() == birthdate.Month() && now.Day() < birthdate.Day()) {
+		years--
+	}
+	return years
+}
@@ -0,0 +1,38 @@
+package eligibility
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgeChecker(t *testing.T) {
+	checker, err := newAgeChecker(0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMinimumAge, checker.minimumAge)
+	checker.now = func() time.Time { return time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC) }
+
+	result, err := checker.CheckEligibility(&Request{})
+	require.NoError(t, err)
+	assert.False(t, result.Eligible)
+	assert.Contains(t, result.Reason, "birthdate is required")
+
+	cases := []struct {
+		birthdate time.Time
+		eligible  bool
+	}{
+		{time.Date(2002, 6, 15, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2002, 6, 16, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2002, 7, 1, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, c := range cases {
+		birthdate := c.birthdate
+		result, err := checker.CheckEligibility(&Request{Birthdate: &birthdate})
+		require.NoError(t, err)
+		assert.Equal(t, c.eligible, result.Eligible, birthdate.String())
+	}
+}
@@ -0,0 +1,73 @@
+package eligibility
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+)
+
+// Request describes the purchase of a line item that needs to be checked.
+// Birthdate is only set if the customer provided one. AppMetaData is the app
+// metadata of the customer's token, e.g. to check a membership.
+type Request struct {
+	Item        *models.LineItem
+	Birthdate   *time.Time
+	AppMetaData map[string]interface{}
+}
+
+// Result is the outcome of an eligibility check. The reason explains why a
+// line item can't be purchased.
+type Result struct {
+	Eligible bool   `json:"eligible"`
+	Reason   string `json:"reason"`
+}
+
+// Checker is the interface wrapping a rule that decides who may purchase a
+// line item, e.g. an age gate.
+type Checker interface {
+	CheckEligibility(req *Request) (*Result, error)
+}
+
+// NewChecker creates an eligibility checker based on the provided
+// configuration. Every product type has its own check, products of other
+// types can be purchased by everyone.
+func NewChecker(config *conf.Configuration) (Checker, error) {
+	if len(config.Eligibility.ProductTypes) == 0 {
+		return newNoopChecker()
+	}
+	checkers := make(map[string]Checker)
+	for productType, rule := range config.Eligibility.ProductTypes {
+		checker, err := newRuleChecker(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Error configuring the eligibility of product type '%v': %v", productType, err)
+		}
+		checkers[productType] = checker
+	}
+	return &productTypeChecker{checkers: checkers}, nil
+}
+
+func newRuleChecker(rule conf.EligibilityRule) (Checker, error) {
+	switch rule.Provider {
+	case "age":
+		return newAgeChecker(rule.MinimumAge)
+	case "":
+		return newNoopChecker()
+	default:
+		return nil, fmt.Errorf("Unknown eligibility provider '%v'", rule.Provider)
+	}
+}
+
+// productTypeChecker runs the check of the line item's product type.
+type productTypeChecker struct {
+	checkers map[string]Checker
+}
+
+func (p *productTypeChecker) CheckEligibility(req *Request) (*Result, error) {
+	checker, ok := p.checkers[req.Item.Type]
+	if !ok {
+		return &Result{Eligible: true}, nil
+	}
+	return checker.CheckEligibility(req)
+}
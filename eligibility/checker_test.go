@@ -0,0 +1,31 @@
+package eligibility
+
+import (
+	"testing"
+
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProductTypeChecker(t *testing.T) {
+	config := &conf.Configuration{}
+	config.Eligibility.ProductTypes = conf.EligibilityRules{
+		"Wine":    {Provider: "age", MinimumAge: 21},
+		"Tobacco": {Provider: "age"},
+	}
+	checker, err := NewChecker(config)
+	require.NoError(t, err)
+
+	cases := map[string]bool{"Wine": false, "Tobacco": false, "Book": true}
+	for productType, eligible := range cases {
+		result, err := checker.CheckEligibility(&Request{Item: &models.LineItem{Type: productType}})
+		require.NoError(t, err)
+		assert.Equal(t, eligible, result.Eligible, productType)
+	}
+
+	config.Eligibility.ProductTypes["Book"] = conf.EligibilityRule{Provider: "membership"}
+	_, err = NewChecker(config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,11 @@
+package eligibility
+
+type noopChecker struct{}
+
+func newNoopChecker() (*noopChecker, error) {
+	return &noopChecker{}, nil
+}
+
+func (n *noopChecker) CheckEligibility(req *Request) (*Result, error) {
+	return &Result{Eligible: true}, nil
+}
@@ -5,6 +5,7 @@ require (
 	github.com/GoogleCloudPlatform/cloudsql-proxy v0.0.0-20170623214735-571947b0f240
 	github.com/PuerkitoBio/goquery v1.1.0
 	github.com/andybalholm/cascadia v0.0.0-20161224141413-349dd0209470
+	github.com/braintree-go/braintree-go v0.22.0
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgrijalva/jwt-go v3.0.0+incompatible
 	github.com/fsnotify/fsnotify v0.0.0-20170329110642-4da3e2cfbabc
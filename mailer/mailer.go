@@ -3,6 +3,7 @@ package mailer
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/netlify/gocommerce/conf"
@@ -15,6 +16,11 @@ type Mailer interface {
 	OrderConfirmationMail(transaction *models.Transaction) error
 	OrderReceivedMail(transaction *models.Transaction) error
 	OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error)
+	OrderClaimMail(order *models.Order, token string) error
+	AbandonedCartMail(order *models.Order) error
+	PaymentInstructionsMail(transaction *models.Transaction) error
+	ShipmentMail(order *models.Order, shipped []*models.LineItem) error
+	CaptureFailedMail(transaction *models.Transaction) error
 }
 
 type mailer struct {
@@ -96,15 +102,106 @@ func hasProductType(order *models.Order, productType string) bool {
 	return false
 }
 
+// mailLineItem is a line item as shown in emails, with the image URL made
+// absolute so it loads in mail clients.
+type mailLineItem struct {
+	Title       string
+	Sku         string
+	Description string
+	Image       string
+	Quantity    uint64
+	Measure     string
+	Price       uint64
+	Free        bool
+	Options     map[string]interface{}
+}
+
+func (m *mailer) lineItems(lineItems []*models.LineItem) []mailLineItem {
+	items := []mailLineItem{}
+	for _, item := range lineItems {
+		image := item.Image
+		if image != "" && !strings.HasPrefix(image, "http://") && !strings.HasPrefix(image, "https://") {
+			image = strings.TrimSuffix(m.Config.SiteURL, "/") + "/" + strings.TrimPrefix(image, "/")
+		}
+		measure := ""
+		if item.MeasureUnit != "" {
+			measure = models.FormatMeasure(item.Measure, item.MeasureUnit)
+		}
+		items = append(items, mailLineItem{
+			Title:       item.Title,
+			Sku:         item.Sku,
+			Description: item.Description,
+			Image:       image,
+			Quantity:    item.Quantity,
+			Measure:     measure,
+			Price:       item.Price,
+			Free:        item.Free,
+			Options:     item.MetaData,
+		})
+	}
+	return items
+}
+
+// confirmationData is the template data of order confirmations. LineItems
+// holds the line items with their images and options, which the default
+// template only shows if line item details are enabled.
+func (m *mailer) confirmationData(transaction *models.Transaction) map[string]interface{} {
+	return map[string]interface{}{
+		"SiteURL":         m.Config.SiteURL,
+		"Order":           transaction.Order,
+		"Transaction":     transaction,
+		"LineItems":       m.lineItems(transaction.Order.LineItems),
+		"LineItemDetails": m.Config.Mailer.LineItemDetails,
+	}
+}
+
 const defaultConfirmationTemplate = `<h2>Thank you for your order!</h2>
 
+{{ if .LineItemDetails }}
+<table>
+{{ range .LineItems }}
+<tr>
+<td>{{ if .Image }}<img src="{{ .Image }}" alt="{{ .Title }}" width="80">{{ end }}</td>
+<td>
+<strong>{{ .Title }}</strong>{{ if .Measure }} ({{ .Measure }}){{ end }}
+{{ if .Options }}
+<ul>
+{{ range $name, $value := .Options }}
+<li>{{ $name }}: {{ $value }}</li>
+{{ end }}
+</ul>
+{{ end }}
+</td>
+<td>{{ .Quantity }} x {{ if .Free }}free{{ else }}{{ price .Price $.Order.Currency }}{{ end }}</td>
+</tr>
+{{ end }}
+</table>
+{{ else }}
 <ul>
 {{ range .Order.LineItems }}
 <li>{{ .Title }} <strong>{{ .Quantity }} x {{ .Price }}</strong></li>
 {{ end }}
 </ul>
+{{ end }}
 
+{{ range .Order.TaxBreakdown }}
+<p>{{ .Jurisdiction }} tax{{ if .Shipping }} on shipping{{ end }} ({{ .Rate }}%): <strong>{{ .Amount }}</strong></p>
+{{ end }}
 <p>Total amount: <strong>{{ .Order.Total }}</strong></p>
+{{ if .Transaction.CardLast4 }}
+<p>Paid with {{ .Transaction.CardBrand }} ending in {{ .Transaction.CardLast4 }}</p>
+{{ end }}
+{{ if .Order.EstimatedDelivery }}
+<p>Estimated delivery: <strong>{{ dateFormat "Monday, January 2" .Order.EstimatedDelivery }}</strong></p>
+{{ end }}
+{{ if .Order.Licenses }}
+<h3>Your license keys</h3>
+<ul>
+{{ range .Order.Licenses }}
+<li>{{ .Title }}: <strong>{{ .Key }}</strong></li>
+{{ end }}
+</ul>
+{{ end }}
 `
 
 // OrderConfirmationMail sends an order confirmation to the user
@@ -115,11 +212,7 @@ func (m *mailer) OrderConfirmationMail(transaction *models.Transaction) error {
 		withDefault(m.Config.Mailer.Subjects.OrderConfirmation, "Order Confirmation"),
 		m.Config.Mailer.Templates.OrderConfirmation,
 		defaultConfirmationTemplate,
-		map[string]interface{}{
-			"SiteURL":     m.Config.SiteURL,
-			"Order":       transaction.Order,
-			"Transaction": transaction,
-		},
+		m.confirmationData(transaction),
 	)
 }
 
@@ -132,6 +225,9 @@ const defaultReceivedTemplate = `<h2>Order Received From {{ .Order.Email }}</h2>
 </ul>
 
 <p>Total amount: <strong>{{ .Order.Total }}</strong></p>
+{{ if .Transaction.CardLast4 }}
+<p>Paid with {{ .Transaction.CardBrand }} ending in {{ .Transaction.CardLast4 }}</p>
+{{ end }}
 `
 
 // OrderReceivedMail sends a notification to the shop admin
@@ -149,16 +245,61 @@ func (m *mailer) OrderReceivedMail(transaction *models.Transaction) error {
 	)
 }
 
+const defaultClaimTemplate = `<h2>Save your order to your account</h2>
+
+<p>Sign up or log in with {{ .Order.Email }} and follow this link to see your order and its downloads in your account:</p>
+
+<p><a href="{{ .SiteURL }}/#claim_token={{ .Token }}">Claim your order</a></p>
+`
+
+// OrderClaimMail sends a token to the email of a guest order, that can be
+// used to attach the order to an account
+func (m *mailer) OrderClaimMail(order *models.Order, token string) error {
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.OrderClaim, "Save your order to your account"),
+		m.Config.Mailer.Templates.OrderClaim,
+		defaultClaimTemplate,
+		map[string]interface{}{
+			"SiteURL": m.Config.SiteURL,
+			"Order":   order,
+			"Token":   token,
+		},
+	)
+}
+
+const defaultAbandonedCartTemplate = `<h2>You left something in your cart</h2>
+
+<ul>
+{{ range .Order.LineItems }}
+<li>{{ .Title }} <strong>{{ .Quantity }} x {{ price .Price $.Order.Currency }}</strong></li>
+{{ end }}
+</ul>
+
+<p><a href="{{ .SiteURL }}">Complete your order</a></p>
+`
+
+// AbandonedCartMail reminds the customer of a pending order to complete the
+// checkout
+func (m *mailer) AbandonedCartMail(order *models.Order) error {
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.AbandonedCart, "You left something in your cart"),
+		m.Config.Mailer.Templates.AbandonedCart,
+		defaultAbandonedCartTemplate,
+		map[string]interface{}{
+			"SiteURL": m.Config.SiteURL,
+			"Order":   order,
+		},
+	)
+}
+
 func (m *mailer) OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error) {
 	if templateURL == "" {
 		templateURL = m.Config.Mailer.Templates.OrderConfirmation
 	}
 
-	return m.TemplateMailer.MailBody(templateURL, defaultReceivedTemplate, map[string]interface{}{
-		"SiteURL":     m.Config.SiteURL,
-		"Order":       transaction.Order,
-		"Transaction": transaction,
-	})
+	return m.TemplateMailer.MailBody(templateURL, defaultReceivedTemplate, m.confirmationData(transaction))
 }
 
 func withDefault(value string, defaultValue string) string {
@@ -167,3 +308,124 @@ func withDefault(value string, defaultValue string) string {
 	}
 	return value
 }
+
+const defaultPaymentInstructionsTemplate = `<h2>Thank you for your order!</h2>
+
+<p>We'll process your order once we received your payment of <strong>{{ price .Transaction.Amount .Transaction.Currency }}</strong>.</p>
+
+<p>{{ .Transaction.Instructions }}</p>
+
+{{ if .Order.PaymentDueAt }}
+<p>Please pay by <strong>{{ dateFormat "Monday, January 2" .Order.PaymentDueAt }}</strong>, otherwise the order is cancelled.</p>
+{{ end }}
+`
+
+// PaymentInstructionsMail tells the customer of an order awaiting a manual
+// payment how to pay
+func (m *mailer) PaymentInstructionsMail(transaction *models.Transaction) error {
+	return m.TemplateMailer.Mail(
+		transaction.Order.Email,
+		withDefault(m.Config.Mailer.Subjects.PaymentInstructions, "Payment instructions for your order"),
+		m.Config.Mailer.Templates.PaymentInstructions,
+		defaultPaymentInstructionsTemplate,
+		map[string]interface{}{
+			"SiteURL":     m.Config.SiteURL,
+			"Order":       transaction.Order,
+			"Transaction": transaction,
+		},
+	)
+}
+
+const defaultCaptureFailedTemplate = `<h2>A payment couldn't be captured</h2>
+
+<p>The authorized payment of <strong>{{ price .Transaction.Amount .Transaction.Currency }}</strong> for order {{ .Order.ID }} couldn't be captured before its authorization expired, so the order is unpaid.</p>
+
+<p>{{ .Transaction.FailureDescription }}</p>
+`
+
+// CaptureFailedMail alerts the shop admin that an authorized payment couldn't
+// be captured in time
+func (m *mailer) CaptureFailedMail(transaction *models.Transaction) error {
+	return m.TemplateMailer.Mail(
+		m.TemplateMailer.From,
+		withDefault(m.Config.Mailer.Subjects.CaptureFailed, "Payment of order {{ .Order.ID }} couldn't be captured"),
+		m.Config.Mailer.Templates.CaptureFailed,
+		defaultCaptureFailedTemplate,
+		map[string]interface{}{
+			"SiteURL":     m.Config.SiteURL,
+			"Order":       transaction.Order,
+			"Transaction": transaction,
+		},
+	)
+}
+
+const defaultPartialShipmentTemplate = `<h2>Part of your order is on its way</h2>
+
+<p>We shipped these items of your order:</p>
+<ul>
+{{ range .Shipped }}
+<li>{{ .Quantity }} x {{ .Title }}</li>
+{{ end }}
+</ul>
+
+<p>These items will follow:</p>
+<ul>
+{{ range .Pending }}
+<li>{{ .Quantity }} x {{ .Title }}</li>
+{{ end }}
+</ul>
+`
+
+const defaultOrderCompleteTemplate = `<h2>Your order is complete</h2>
+
+<p>The last items of your order are on their way:</p>
+<ul>
+{{ range .Shipped }}
+<li>{{ .Quantity }} x {{ .Title }}</li>
+{{ end }}
+</ul>
+
+{{ if .Order.EstimatedDelivery }}
+<p>Estimated delivery: <strong>{{ dateFormat "Monday, January 2" .Order.EstimatedDelivery }}</strong></p>
+{{ end }}
+`
+
+// shipmentData is the template data of shipment notices. Shipped holds the
+// items of the shipment, Pending the items of the order still to come.
+func (m *mailer) shipmentData(order *models.Order, shipped []*models.LineItem) map[string]interface{} {
+	pending := []*models.LineItem{}
+	for _, item := range order.LineItems {
+		if item.Outstanding() {
+			pending = append(pending, item)
+		}
+	}
+	return map[string]interface{}{
+		"SiteURL": m.Config.SiteURL,
+		"Order":   order,
+		"Shipped": m.lineItems(shipped),
+		"Pending": m.lineItems(pending),
+	}
+}
+
+// ShipmentMail tells the customer which items of an order were shipped. While
+// items remain it's a partial shipment notice listing those too, once
+// everything shipped it announces the completed order.
+func (m *mailer) ShipmentMail(order *models.Order, shipped []*models.LineItem) error {
+	data := m.shipmentData(order, shipped)
+	if len(data["Pending"].([]mailLineItem)) > 0 {
+		return m.TemplateMailer.Mail(
+			order.Email,
+			withDefault(m.Config.Mailer.Subjects.PartialShipment, "Part of your order has shipped"),
+			m.Config.Mailer.Templates.PartialShipment,
+			defaultPartialShipmentTemplate,
+			data,
+		)
+	}
+	return m.TemplateMailer.Mail(
+		order.Email,
+		withDefault(m.Config.Mailer.Subjects.OrderComplete, "Your order is complete"),
+		m.Config.Mailer.Templates.OrderComplete,
+		defaultOrderCompleteTemplate,
+		data,
+	)
+}
@@ -2,9 +2,12 @@ package mailer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNoopMailer(t *testing.T) {
@@ -24,3 +27,81 @@ func TestTemplateMailer(t *testing.T) {
 	m := NewMailer(smtp, conf)
 	assert.IsType(t, &mailer{}, m)
 }
+
+func TestConfirmationLineItemDetails(t *testing.T) {
+	smtp := conf.SMTPConfiguration{Host: "localhost", Port: 25}
+	config := &conf.Configuration{SiteURL: "https://shop.example.com/"}
+	transaction := &models.Transaction{Order: &models.Order{
+		Currency: "USD",
+		LineItems: []*models.LineItem{
+			{Title: "Shirt", Image: "/images/shirt.png", Quantity: 2, Price: 1500, MetaData: map[string]interface{}{"size": "M"}},
+			{Title: "Sticker", Image: "https://cdn.example.com/sticker.png", Quantity: 1, Free: true},
+		},
+	}}
+
+	m := NewMailer(smtp, config).(*mailer)
+	body, err := m.TemplateMailer.MailBody("", defaultConfirmationTemplate, m.confirmationData(transaction))
+	require.NoError(t, err)
+	assert.NotContains(t, body, "<img")
+
+	config.Mailer.LineItemDetails = true
+	m = NewMailer(smtp, config).(*mailer)
+	body, err = m.TemplateMailer.MailBody("", defaultConfirmationTemplate, m.confirmationData(transaction))
+	require.NoError(t, err)
+	assert.Contains(t, body, `<img src="https://shop.example.com/images/shirt.png" alt="Shirt" width="80">`)
+	assert.Contains(t, body, `<img src="https://cdn.example.com/sticker.png"`)
+	assert.Contains(t, body, "<li>size: M</li>")
+	assert.Contains(t, body, "2 x $15.00")
+	assert.Contains(t, body, "1 x free")
+}
+
+func TestPaymentInstructions(t *testing.T) {
+	smtp := conf.SMTPConfiguration{Host: "localhost", Port: 25}
+	config := &conf.Configuration{SiteURL: "https://shop.example.com/"}
+	dueAt := time.Date(2020, 3, 16, 0, 0, 0, 0, time.UTC)
+	transaction := &models.Transaction{
+		Amount:       2500,
+		Currency:     "EUR",
+		Instructions: "Transfer to IBAN DE00 1234 with reference 42",
+		Order:        &models.Order{PaymentDueAt: &dueAt},
+	}
+
+	m := NewMailer(smtp, config).(*mailer)
+	body, err := m.TemplateMailer.MailBody("", defaultPaymentInstructionsTemplate, map[string]interface{}{
+		"Order":       transaction.Order,
+		"Transaction": transaction,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, body, "25.00€")
+	assert.Contains(t, body, "Transfer to IBAN DE00 1234 with reference 42")
+	assert.Contains(t, body, "Monday, March 16")
+}
+
+func TestShipmentMail(t *testing.T) {
+	smtp := conf.SMTPConfiguration{Host: "localhost", Port: 25}
+	config := &conf.Configuration{SiteURL: "https://shop.example.com/"}
+	shirt := &models.LineItem{Title: "Shirt", Quantity: 2, FulfillmentType: models.PhysicalFulfillment, FulfillmentState: models.ShippedState}
+	poster := &models.LineItem{Title: "Poster", Quantity: 1, FulfillmentType: models.PhysicalFulfillment, FulfillmentState: models.PendingState}
+	ebook := &models.LineItem{Title: "E-Book", Quantity: 1, FulfillmentType: models.DigitalFulfillment, FulfillmentState: models.FulfilledState}
+	order := &models.Order{Currency: "USD", LineItems: []*models.LineItem{shirt, poster, ebook}}
+
+	m := NewMailer(smtp, config).(*mailer)
+	data := m.shipmentData(order, []*models.LineItem{shirt})
+	require.Len(t, data["Shipped"], 1)
+	require.Len(t, data["Pending"], 1, "fulfilled items aren't pending")
+	body, err := m.TemplateMailer.MailBody("", defaultPartialShipmentTemplate, data)
+	require.NoError(t, err)
+	assert.Contains(t, body, "<li>2 x Shirt</li>")
+	assert.Contains(t, body, "<li>1 x Poster</li>")
+	assert.NotContains(t, body, "E-Book")
+
+	poster.FulfillmentState = models.ShippedState
+	m = NewMailer(smtp, config).(*mailer)
+	data = m.shipmentData(order, []*models.LineItem{poster})
+	assert.Empty(t, data["Pending"])
+	body, err = m.TemplateMailer.MailBody("", defaultOrderCompleteTemplate, data)
+	require.NoError(t, err)
+	assert.Contains(t, body, "Your order is complete")
+	assert.Contains(t, body, "<li>1 x Poster</li>")
+	assert.NotContains(t, body, "Shirt")
+}
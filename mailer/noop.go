@@ -15,6 +15,26 @@ func (m *noopMailer) OrderReceivedMail(transaction *models.Transaction) error {
 	return nil
 }
 
+func (m *noopMailer) OrderClaimMail(order *models.Order, token string) error {
+	return nil
+}
+
+func (m *noopMailer) AbandonedCartMail(order *models.Order) error {
+	return nil
+}
+
+func (m *noopMailer) PaymentInstructionsMail(transaction *models.Transaction) error {
+	return nil
+}
+
+func (m *noopMailer) ShipmentMail(order *models.Order, shipped []*models.LineItem) error {
+	return nil
+}
+
+func (m *noopMailer) CaptureFailedMail(transaction *models.Transaction) error {
+	return nil
+}
+
 func (m *noopMailer) OrderConfirmationMailBody(transaction *models.Transaction, templateURL string) (string, error) {
 	return "Order Confirmed", nil
 }
@@ -74,6 +74,15 @@ func AutoMigrate(db *gorm.DB) error {
 		Event{},
 		Instance{},
 		InvoiceNumber{},
+		Invoice{},
+		InventoryItem{},
+		Reservation{},
+		Backorder{},
+		GeneratedCoupon{},
+		WebhookDelivery{},
+		License{},
+		SerialNumber{},
+		RefundItem{},
 	)
 	return db.Error
 }
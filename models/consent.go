@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// MaxTermsVersionLength is the maximum length of the terms version a customer
+// accepts.
+const MaxTermsVersionLength = 64
+
+// Consent records the terms a customer accepted when placing an Order and
+// whether they consented to the immediate delivery of digital goods, waiving
+// their right of withdrawal. AcceptedAt and IP are taken from the request that
+// created the order.
+type Consent struct {
+	TermsVersion    string     `json:"terms_version,omitempty" sql:"size:64"`
+	DigitalDelivery bool       `json:"digital_delivery"`
+	AcceptedAt      *time.Time `json:"accepted_at,omitempty"`
+	IP              string     `json:"ip,omitempty" visibility:"admin"`
+}
+
+// Given reports whether any consent was recorded.
+func (c *Consent) Given() bool {
+	return c.AcceptedAt != nil
+}
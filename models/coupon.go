@@ -12,6 +12,14 @@ type FixedAmount struct {
 	Currency string `json:"currency"`
 }
 
+// FreeProduct is a product granted for free by a coupon. The product is
+// loaded from the Path like any other line item.
+type FreeProduct struct {
+	Sku      string `json:"sku"`
+	Path     string `json:"path"`
+	Quantity uint64 `json:"quantity,omitempty"`
+}
+
 // Coupon represents a discount redeemable with a code.
 type Coupon struct {
 	Code string `json:"code"`
@@ -25,6 +33,9 @@ type Coupon struct {
 	ProductTypes []string               `json:"product_types,omitempty"`
 	Products     []string               `json:"products,omitempty"`
 	Claims       map[string]interface{} `json:"claims,omitempty"`
+
+	// FreeProduct is added to orders with a product the coupon applies to.
+	FreeProduct *FreeProduct `json:"free_product,omitempty"`
 }
 
 // Valid returns whether a coupon is valid or not.
@@ -76,6 +87,21 @@ func (c *Coupon) ValidForType(productType string) bool {
 	return false
 }
 
+// QualifiesForFreeProduct checks if the coupon grants its free product to an
+// order with these line items, which requires an item the coupon applies to.
+// Free items themselves don't count.
+func (c *Coupon) QualifiesForFreeProduct(items []*LineItem) bool {
+	if c == nil || c.FreeProduct == nil {
+		return false
+	}
+	for _, item := range items {
+		if !item.Free && item.Quantity > 0 && c.ValidForType(item.Type) && c.ValidForProduct(item.Sku) {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidForPrice returns whether a coupon applies to a specific amount.
 func (c *Coupon) ValidForPrice(currency string, price uint64) bool {
 	// TODO: Support for coupons based on amount
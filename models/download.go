@@ -20,6 +20,8 @@ type Download struct {
 
 	DownloadCount uint64 `json:"downloads"`
 
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `json:"-" sql:"index"`
@@ -30,6 +32,11 @@ func (Download) TableName() string {
 	return tableName("downloads")
 }
 
+// Expired checks if access to the download has expired.
+func (d *Download) Expired() bool {
+	return d.ExpiresAt != nil && !d.ExpiresAt.After(time.Now())
+}
+
 // SignURL signs a download URL using the provided asset store.
 func (d *Download) SignURL(store assetstores.Store) error {
 	signedURL, err := store.SignURL(d.URL)
@@ -11,7 +11,8 @@ import (
 type Event struct {
 	ID uint64 `json:"id"`
 
-	IP string `json:"ip"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty" sql:"size:500"`
 
 	User   *User  `json:"user,omitempty"`
 	UserID string `json:"user_id,omitempty"`
@@ -40,15 +41,74 @@ const (
 	EventUpdated EventType = "updated"
 	// EventDeleted is the EventType when an order is deleted.
 	EventDeleted EventType = "deleted"
+	// EventTaxOverridden is the EventType when an admin overrides an order's taxes.
+	EventTaxOverridden EventType = "tax_overridden"
+	// EventHeld is the EventType when a paid order is held for review.
+	EventHeld EventType = "held"
+	// EventApproved is the EventType when an admin approves a held order.
+	EventApproved EventType = "approved"
+	// EventRejected is the EventType when an admin rejects a held order.
+	EventRejected EventType = "rejected"
+	// EventClaimed is the EventType when a guest order is claimed by a user.
+	EventClaimed EventType = "claimed"
+	// EventBackorderFulfilled is the EventType when restocked inventory is
+	// allocated to a backordered order.
+	EventBackorderFulfilled EventType = "backorder_fulfilled"
+	// EventFulfilled is the EventType when all line items of an order are fulfilled.
+	EventFulfilled EventType = "fulfilled"
+	// EventRecalculated is the EventType when the totals of an order are
+	// calculated again with the current pricing rules.
+	EventRecalculated EventType = "recalculated"
+	// EventInvoiced is the EventType when an invoice is issued for an order.
+	EventInvoiced EventType = "invoiced"
+	// EventCredited is the EventType when a credit note corrects the invoice
+	// of an order.
+	EventCredited EventType = "credited"
+	// EventConfirmationSent is the EventType when the confirmation email of
+	// a paid order has been sent.
+	EventConfirmationSent EventType = "confirmation_sent"
+	// EventConfirmationFailed is the EventType when the confirmation email of
+	// a paid order couldn't be sent in any of the attempts.
+	EventConfirmationFailed EventType = "confirmation_failed"
+	// EventArchived is the EventType when a closed order is archived.
+	EventArchived EventType = "archived"
+	// EventUnarchived is the EventType when an admin restores an archived
+	// order to the order listings.
+	EventUnarchived EventType = "unarchived"
+	// EventAwaitingPayment is the EventType when an order awaits a manual
+	// payment.
+	EventAwaitingPayment EventType = "awaiting_payment"
+	// EventPaymentConfirmed is the EventType when an admin confirms the
+	// manual payment of an order.
+	EventPaymentConfirmed EventType = "payment_confirmed"
+	// EventPaymentExpired is the EventType when an order is cancelled since
+	// its manual payment wasn't confirmed in time.
+	EventPaymentExpired EventType = "payment_expired"
+	// EventPaymentCaptured is the EventType when an authorized payment is
+	// captured after the capture delay.
+	EventPaymentCaptured EventType = "payment_captured"
+	// EventPaymentVoided is the EventType when the authorized payment of a
+	// rejected order is released instead of captured.
+	EventPaymentVoided EventType = "payment_voided"
+	// EventPaymentCaptureFailed is the EventType when an authorized payment
+	// couldn't be captured before its authorization expired.
+	EventPaymentCaptureFailed EventType = "payment_capture_failed"
 )
 
 // LogEvent logs a new event
 func LogEvent(db *gorm.DB, ip, userID, orderID string, eventType EventType, changes []string) {
+	LogClientEvent(db, ip, "", userID, orderID, eventType, changes)
+}
+
+// LogClientEvent logs a new event like LogEvent, recording the user agent of
+// the client that caused it as well.
+func LogClientEvent(db *gorm.DB, ip, userAgent, userID, orderID string, eventType EventType, changes []string) {
 	event := &Event{
-		IP:      ip,
-		UserID:  userID,
-		OrderID: orderID,
-		Type:    string(eventType),
+		IP:        ip,
+		UserAgent: userAgent,
+		UserID:    userID,
+		OrderID:   orderID,
+		Type:      string(eventType),
 	}
 	if changes != nil {
 		event.Changes = strings.Join(changes, ",")
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// couponCodeAlphabet leaves out characters that are easily confused when
+// typing a code, like 0 and O or 1 and I.
+const couponCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GeneratedCoupon is a coupon code created in bulk from a coupon template.
+// Unlike coupons loaded from the site, generated coupons can only be redeemed
+// a limited number of times.
+type GeneratedCoupon struct {
+	ID         uint64 `json:"-"`
+	InstanceID string `json:"-" gorm:"unique_index:idx_generated_coupon_instance_code"`
+	Code       string `json:"code" gorm:"unique_index:idx_generated_coupon_instance_code"`
+	BatchID    string `json:"batch_id" sql:"index"`
+
+	Template    *Coupon `json:"-" sql:"-"`
+	RawTemplate string  `json:"-" sql:"type:text"`
+
+	MaxRedemptions uint64 `json:"max_redemptions"`
+	Redemptions    uint64 `json:"redemptions"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the GeneratedCoupon model.
+func (GeneratedCoupon) TableName() string {
+	return tableName("generated_coupons")
+}
+
+// AfterFind database callback.
+func (c *GeneratedCoupon) AfterFind() error {
+	if c.RawTemplate != "" {
+		c.Template = &Coupon{}
+		return json.Unmarshal([]byte(c.RawTemplate), c.Template)
+	}
+	return nil
+}
+
+// BeforeSave database callback.
+func (c *GeneratedCoupon) BeforeSave() error {
+	if c.Template != nil {
+		data, err := json.Marshal(c.Template)
+		if err != nil {
+			return err
+		}
+		c.RawTemplate = string(data)
+	}
+	return nil
+}
+
+// Coupon returns the coupon that can be applied to an order.
+func (c *GeneratedCoupon) Coupon() *Coupon {
+	coupon := &Coupon{}
+	if c.Template != nil {
+		*coupon = *c.Template
+	}
+	coupon.Code = c.Code
+	return coupon
+}
+
+// Redeemable returns whether the coupon can still be redeemed.
+func (c *GeneratedCoupon) Redeemable() bool {
+	return c.Redemptions < c.MaxRedemptions
+}
+
+// CouponRedeemedError is returned when a generated coupon has been redeemed
+// as often as it allows.
+type CouponRedeemedError struct {
+	Code string
+}
+
+func (e *CouponRedeemedError) Error() string {
+	return fmt.Sprintf("Coupon %s has already been redeemed", e.Code)
+}
+
+// CheckCouponRedemptions returns a CouponRedeemedError if a generated coupon
+// has already been redeemed as often as it allows. Coupons that weren't
+// generated aren't limited.
+func CheckCouponRedemptions(db *gorm.DB, instanceID, code string) error {
+	count := 0
+	rsp := db.Model(&GeneratedCoupon{}).
+		Where("instance_id = ? AND code = ? AND redemptions >= max_redemptions", instanceID, code).
+		Count(&count)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying coupons")
+	}
+	if count > 0 {
+		return &CouponRedeemedError{Code: code}
+	}
+	return nil
+}
+
+// RedeemCoupon counts a redemption of a generated coupon when an order using
+// it is paid. The count is only incremented if the coupon hasn't reached its
+// limit, so simultaneous payments can't both redeem the last use. Coupons that
+// weren't generated aren't limited.
+func RedeemCoupon(tx *gorm.DB, instanceID, code string) error {
+	rsp := tx.Model(&GeneratedCoupon{}).
+		Where("instance_id = ? AND code = ? AND redemptions < max_redemptions", instanceID, code).
+		UpdateColumn("redemptions", gorm.Expr("redemptions + 1"))
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error redeeming coupon")
+	}
+
+	if rsp.RowsAffected == 0 {
+		return CheckCouponRedemptions(tx, instanceID, code)
+	}
+	return nil
+}
+
+// ReleaseCoupon gives back a redemption of a generated coupon, e.g. when the
+// payment of the order that redeemed it failed or expired.
+func ReleaseCoupon(tx *gorm.DB, instanceID, code string) error {
+	rsp := tx.Model(&GeneratedCoupon{}).
+		Where("instance_id = ? AND code = ? AND redemptions > 0", instanceID, code).
+		UpdateColumn("redemptions", gorm.Expr("redemptions - 1"))
+	return errors.Wrap(rsp.Error, "Error releasing coupon")
+}
+
+// NewCouponCode returns a random coupon code with the given prefix.
+func NewCouponCode(prefix string, length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = couponCodeAlphabet[int(b)%len(couponCodeAlphabet)]
+	}
+	return prefix + string(buf), nil
+}
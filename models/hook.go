@@ -2,7 +2,9 @@ package models
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -11,6 +13,7 @@ import (
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/ssrf"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -20,14 +23,19 @@ const maxConcurrentHooks = 5
 const maxRetries = 5
 const retryPeriod = 30 * time.Second
 const signatureExpiration = 5 * time.Minute
+const dedupeWindow = 24 * time.Hour
+const defaultHookTimeout = 10 * time.Second
+const maxHookRedirects = 10
 
 // Hook represents a webhook.
 type Hook struct {
 	ID uint64
 
-	UserID string
+	UserID  string
+	OrderID string `gorm:"index"`
 
-	Type string
+	Type    string
+	EventID string `gorm:"index"`
 
 	Done   bool
 	Failed bool
@@ -36,6 +44,15 @@ type Hook struct {
 	Payload string `sql:"type:text"`
 	Secret  string
 
+	// MaxInFlight limits the concurrent deliveries to the hook's URL. 0 means
+	// only the limit of the worker pool applies.
+	MaxInFlight int
+	// Compress sends the payload gzip compressed.
+	Compress bool
+	// Ordered hooks of the same order are delivered to their URL one after
+	// the other, in the order they were created.
+	Ordered bool
+
 	ResponseStatus  string
 	ResponseHeaders string  `sql:"type:text"`
 	ResponseBody    string  `sql:"type:text"`
@@ -55,8 +72,17 @@ func (Hook) TableName() string {
 	return tableName("hooks")
 }
 
-// NewHook creates a Hook model.
-func NewHook(hookType, siteURL, hookURL, userID, secret string, payload interface{}) (*Hook, error) {
+// HookOptions configure how a Hook is delivered to its URL.
+type HookOptions struct {
+	Secret      string
+	MaxInFlight int
+	Compress    bool
+	Ordered     bool
+}
+
+// NewHook creates a Hook model. The order ID relates deliveries of the hook to
+// an order and may be empty.
+func NewHook(hookType, siteURL, hookURL, userID, orderID string, options HookOptions, payload interface{}) (*Hook, error) {
 	fullHookURL, err := url.Parse(hookURL)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to parse Webhook URL")
@@ -74,24 +100,102 @@ func NewHook(hookType, siteURL, hookURL, userID, secret string, payload interfac
 
 	json, _ := json.Marshal(payload)
 	return &Hook{
-		Type:    hookType,
-		UserID:  userID,
-		URL:     fullHookURL.String(),
-		Secret:  secret,
-		Payload: string(json),
+		Type:        hookType,
+		EventID:     uuid.NewRandom().String(),
+		UserID:      userID,
+		OrderID:     orderID,
+		URL:         fullHookURL.String(),
+		Secret:      options.Secret,
+		MaxInFlight: options.MaxInFlight,
+		Compress:    options.Compress,
+		Ordered:     options.Ordered,
+		Payload:     string(json),
 	}, nil
 }
 
+// Enqueue stores the Hook so it gets triggered, unless a delivery of the same
+// event, with the same event ID, has already been queued for the same
+// endpoint within the dedupe window.
+func (h *Hook) Enqueue(tx *gorm.DB) error {
+	count := 0
+	rsp := tx.Model(&Hook{}).
+		Where("event_id = ? AND url = ? AND created_at > ?", h.EventID, h.URL, time.Now().Add(-dedupeWindow)).
+		Count(&count)
+	if rsp.Error != nil {
+		return rsp.Error
+	}
+	if count > 0 {
+		return nil
+	}
+	return tx.Create(h).Error
+}
+
+// NewHookClient creates an HTTP client for delivering hooks. Connections are
+// reused across deliveries, requests are aborted after the timeout and the
+// guard keeps hooks and their redirects from reaching internal addresses.
+// Hooks are sent with the ID of the node delivering them, if set.
+func NewHookClient(timeout time.Duration, guard *ssrf.Guard, nodeID string) *http.Client {
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	transport := guard.Transport()
+	transport.MaxIdleConnsPerHost = maxConcurrentHooks
+	transport.TLSHandshakeTimeout = timeout
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &nodeTransport{transport: transport, nodeID: nodeID},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxHookRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxHookRedirects)
+			}
+			return guard.ValidateURL(req.Context(), req.URL.String())
+		},
+	}
+}
+
+// nodeTransport adds the ID of the node sending a hook to its requests.
+type nodeTransport struct {
+	transport http.RoundTripper
+	nodeID    string
+}
+
+func (t *nodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.nodeID == "" {
+		return t.transport.RoundTrip(req)
+	}
+	// round trippers must not modify the request they were given
+	r2 := *req
+	r2.Header = cloneHeader(req.Header)
+	r2.Header.Set("X-Commerce-Node-ID", t.nodeID)
+	return t.transport.RoundTrip(&r2)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
 // Trigger creates and executes the HTTP request for a Hook.
-func (h *Hook) Trigger(client *http.Client, log *logrus.Entry) (*http.Response, error) {
+func (h *Hook) Trigger(client *http.Client, log logrus.FieldLogger) (*http.Response, error) {
 	log.Infof("Triggering hook %v: %v", h.ID, h.URL)
 	h.Tries++
-	body := bytes.NewBufferString(h.Payload)
+	body, err := h.body()
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("POST", h.URL, body)
-	req.Header.Set("Content-Type", "application/json")
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("X-Commerce-Event-ID", h.EventID)
+	req.Header.Set("X-Commerce-Event-Type", h.Type)
 	if h.Secret != "" {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 			"sub": h.UserID,
@@ -106,7 +210,55 @@ func (h *Hook) Trigger(client *http.Client, log *logrus.Entry) (*http.Response,
 	return client.Do(req)
 }
 
-func (h *Hook) handleError(db *gorm.DB, log *logrus.Entry, resp *http.Response, err error) {
+// body returns the payload to send, gzip compressed if the hook asks for it.
+func (h *Hook) body() (*bytes.Buffer, error) {
+	if !h.Compress {
+		return bytes.NewBufferString(h.Payload), nil
+	}
+	body := &bytes.Buffer{}
+	zw := gzip.NewWriter(body)
+	if _, err := zw.Write([]byte(h.Payload)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Deliver triggers the Hook, records the attempt as a WebhookDelivery and
+// schedules a retry if it failed. It reports whether the delivery succeeded.
+func (h *Hook) Deliver(db *gorm.DB, client *http.Client, log logrus.FieldLogger) bool {
+	start := time.Now()
+	resp, err := h.Trigger(client, log)
+	delivery := newWebhookDelivery(h, resp, err, time.Since(start))
+
+	h.LockedAt = nil
+	h.LockedBy = nil
+	tx := db.Begin()
+	ok := err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300
+	if ok {
+		h.handleSuccess(tx, log, resp)
+	} else {
+		h.handleError(tx, log, resp, err)
+	}
+	if err := tx.Create(delivery).Error; err != nil {
+		log.WithError(err).Error("Error saving webhook delivery")
+	}
+	tx.Commit()
+	return ok
+}
+
+// postpone unlocks the Hook without delivering it, so it runs again after
+// the given time. It doesn't count as a try.
+func (h *Hook) postpone(db *gorm.DB, runAfter time.Time) {
+	h.LockedAt = nil
+	h.LockedBy = nil
+	h.RunAfter = &runAfter
+	db.Save(h)
+}
+
+func (h *Hook) handleError(db *gorm.DB, log logrus.FieldLogger, resp *http.Response, err error) {
 	if err != nil {
 		errString := err.Error()
 		h.ErrorMessage = &errString
@@ -136,7 +288,7 @@ func (h *Hook) handleError(db *gorm.DB, log *logrus.Entry, resp *http.Response,
 	db.Save(h)
 }
 
-func (h *Hook) handleSuccess(db *gorm.DB, log *logrus.Entry, resp *http.Response) {
+func (h *Hook) handleSuccess(db *gorm.DB, log logrus.FieldLogger, resp *http.Response) {
 	log.Infof("Hook %v triggered. %v", h.ID, resp.Status)
 	now := time.Now()
 	h.Done = true
@@ -151,12 +303,12 @@ func (h *Hook) handleSuccess(db *gorm.DB, log *logrus.Entry, resp *http.Response
 }
 
 // RunHooks creates a goroutine that triggers stored webhooks every 5 seconds.
-func RunHooks(db *gorm.DB, log *logrus.Entry) {
+// Deliveries to endpoints that keep failing are paused by a circuit breaker.
+func RunHooks(db *gorm.DB, client *http.Client, log *logrus.Entry) {
 	go func() {
 		id := uuid.NewRandom().String()
-		sem := make(chan bool, maxConcurrentHooks)
+		breaker := newHookBreaker(log)
 		table := Hook{}.TableName()
-		client := &http.Client{}
 		for {
 			hooks := []*Hook{}
 			tx := db.Begin()
@@ -166,33 +318,129 @@ func RunHooks(db *gorm.DB, log *logrus.Entry) {
 				Where("done = ? AND (locked_at IS NULL OR locked_at < ?) AND (run_after IS NULL OR run_after < ?)", false, now.Add(-5*time.Minute), now).
 				Updates(map[string]interface{}{"locked_at": now, "locked_by": id})
 
-			tx.Where("locked_by = ?", id).Find(&hooks)
+			tx.Where("locked_by = ?", id).Order("id asc").Find(&hooks)
 			if rsp := tx.Commit(); rsp.Error != nil {
 				log.WithError(rsp.Error).Error("Error querying for hooks")
 			}
 
-			var wg sync.WaitGroup
-			for _, hook := range hooks {
-				sem <- true
-				wg.Add(1)
-				go func(hook *Hook) {
-					defer wg.Done()
-					resp, err := hook.Trigger(client, log)
-					hook.LockedAt = nil
-					hook.LockedBy = nil
-					tx := db.Begin()
-					if err != nil || !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-						hook.handleError(tx, log, resp, err)
-					} else {
-						hook.handleSuccess(tx, log, resp)
-					}
-					tx.Commit()
-					<-sem
-				}(hook)
-			}
-
-			wg.Wait()
+			hooks = holdBlockedHooks(db, hooks, id, log)
+			deliverHooks(hooks, func(hook *Hook) bool {
+				return breaker.deliver(db, hook, func() bool {
+					return hook.Deliver(db, client, log)
+				})
+			}, func(hook *Hook) {
+				hook.postpone(db, time.Now())
+			})
 			time.Sleep(5 * time.Second)
 		}
 	}()
 }
+
+// holdBlockedHooks postpones the ordered hooks of the batch locked by id that
+// would overtake an earlier hook of the same order to the same URL, e.g. one
+// waiting for a retry, and returns the others.
+func holdBlockedHooks(db *gorm.DB, hooks []*Hook, id string, log logrus.FieldLogger) []*Hook {
+	ready := make([]*Hook, 0, len(hooks))
+	for _, hook := range hooks {
+		if !hook.Ordered || hook.OrderID == "" {
+			ready = append(ready, hook)
+			continue
+		}
+		count := 0
+		rsp := db.Model(&Hook{}).
+			Where("done = ? AND url = ? AND order_id = ? AND id < ? AND (locked_by IS NULL OR locked_by <> ?)", false, hook.URL, hook.OrderID, hook.ID, id).
+			Count(&count)
+		if rsp.Error != nil {
+			log.WithError(rsp.Error).Error("Error querying for earlier hooks")
+		}
+		if rsp.Error != nil || count > 0 {
+			hook.postpone(db, time.Now())
+			continue
+		}
+		ready = append(ready, hook)
+	}
+	return ready
+}
+
+// deliverHooks delivers a batch of hooks with at most maxConcurrentHooks in
+// flight. Hooks for the same URL are queued in order and share the MaxInFlight
+// limit of the oldest one, so a slow receiver doesn't get flooded and mostly
+// sees events in the order they happened. Ordered hooks of the same order are
+// delivered one after the other. Once one of them fails, the rest are passed
+// to hold instead, so they can't overtake it.
+func deliverHooks(hooks []*Hook, deliver func(*Hook) bool, hold func(*Hook)) {
+	sem := make(chan bool, maxConcurrentHooks)
+	var wg sync.WaitGroup
+	for _, queue := range hookQueues(hooks) {
+		chains := hookChains(queue)
+		workers := queue[0].MaxInFlight
+		if workers <= 0 || workers > len(chains) {
+			workers = len(chains)
+		}
+
+		pending := make(chan []*Hook, len(chains))
+		for _, chain := range chains {
+			pending <- chain
+		}
+		close(pending)
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for chain := range pending {
+					for n, hook := range chain {
+						sem <- true
+						ok := deliver(hook)
+						<-sem
+						if !ok {
+							for _, held := range chain[n+1:] {
+								hold(held)
+							}
+							break
+						}
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// hookQueues groups hooks by URL, keeping their order.
+func hookQueues(hooks []*Hook) [][]*Hook {
+	index := make(map[string]int)
+	queues := [][]*Hook{}
+	for _, hook := range hooks {
+		i, ok := index[hook.URL]
+		if !ok {
+			i = len(queues)
+			index[hook.URL] = i
+			queues = append(queues, nil)
+		}
+		queues[i] = append(queues[i], hook)
+	}
+	return queues
+}
+
+// hookChains splits the queue of a URL into the hooks that have to be
+// delivered one after the other. Ordered hooks of the same order share a
+// chain, all other hooks are on their own.
+func hookChains(queue []*Hook) [][]*Hook {
+	index := make(map[string]int)
+	chains := [][]*Hook{}
+	for _, hook := range queue {
+		if !hook.Ordered || hook.OrderID == "" {
+			chains = append(chains, []*Hook{hook})
+			continue
+		}
+		i, ok := index[hook.OrderID]
+		if !ok {
+			i = len(chains)
+			index[hook.OrderID] = i
+			chains = append(chains, nil)
+		}
+		chains[i] = append(chains[i], hook)
+	}
+	return chains
+}
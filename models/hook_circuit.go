@@ -0,0 +1,148 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+const circuitWindow = 10
+const circuitMinAttempts = 5
+const circuitFailureRate = 0.5
+const circuitCooldown = 5 * time.Minute
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuit tracks the recent deliveries to one webhook endpoint.
+type circuit struct {
+	state    circuitState
+	results  []bool
+	openedAt time.Time
+	probing  bool
+}
+
+func (c *circuit) failureRate() float64 {
+	failures := 0
+	for _, ok := range c.results {
+		if !ok {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(c.results))
+}
+
+// hookBreaker pauses deliveries to webhook endpoints that keep failing. When
+// at least half of the last deliveries to an endpoint failed, its circuit
+// opens and its hooks stay queued without using up their retries. After the
+// cooldown a single probe is let through: if it succeeds the circuit closes,
+// otherwise it opens again.
+type hookBreaker struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	cooldown time.Duration
+	log      logrus.FieldLogger
+	now      func() time.Time
+}
+
+func newHookBreaker(log logrus.FieldLogger) *hookBreaker {
+	return &hookBreaker{
+		circuits: make(map[string]*circuit),
+		cooldown: circuitCooldown,
+		log:      log,
+		now:      time.Now,
+	}
+}
+
+// deliver runs the delivery of a hook if its endpoint's circuit allows it and
+// records the result. Otherwise the hook is postponed until the next probe.
+// It reports whether the hook was delivered.
+func (b *hookBreaker) deliver(db *gorm.DB, hook *Hook, deliver func() bool) bool {
+	if ok, retryAt := b.allow(hook.URL); !ok {
+		hook.postpone(db, retryAt)
+		return false
+	}
+	ok := deliver()
+	b.record(hook.URL, ok)
+	return ok
+}
+
+func (b *hookBreaker) circuitFor(url string) *circuit {
+	c, ok := b.circuits[url]
+	if !ok {
+		c = &circuit{}
+		b.circuits[url] = c
+	}
+	return c
+}
+
+// allow reports whether a hook may be delivered to the URL now. If not, it
+// returns when the circuit will let the next probe through.
+func (b *hookBreaker) allow(url string) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuitFor(url)
+	retryAt := c.openedAt.Add(b.cooldown)
+	switch c.state {
+	case circuitOpen:
+		if b.now().Before(retryAt) {
+			return false, retryAt
+		}
+		c.state = circuitHalfOpen
+		c.probing = true
+		return true, time.Time{}
+	case circuitHalfOpen:
+		if c.probing {
+			return false, b.now().Add(retryPeriod)
+		}
+		c.probing = true
+		return true, time.Time{}
+	}
+	return true, time.Time{}
+}
+
+// record tracks the result of a delivery to the URL and opens or closes its
+// circuit accordingly.
+func (b *hookBreaker) record(url string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuitFor(url)
+	if c.state == circuitHalfOpen {
+		c.probing = false
+		if ok {
+			b.log.WithField("url", url).Info("Webhook endpoint recovered, closing circuit")
+			c.state = circuitClosed
+			c.results = nil
+		} else {
+			b.open(url, c)
+		}
+		return
+	}
+
+	c.results = append(c.results, ok)
+	if len(c.results) > circuitWindow {
+		c.results = c.results[len(c.results)-circuitWindow:]
+	}
+	if c.state == circuitClosed && len(c.results) >= circuitMinAttempts && c.failureRate() >= circuitFailureRate {
+		b.open(url, c)
+	}
+}
+
+func (b *hookBreaker) open(url string, c *circuit) {
+	c.state = circuitOpen
+	c.openedAt = b.now()
+	b.log.WithFields(logrus.Fields{
+		"alert":    "webhook_circuit_open",
+		"url":      url,
+		"retry_at": c.openedAt.Add(b.cooldown),
+	}).Errorf("Webhook endpoint is failing, pausing deliveries to %v", url)
+}
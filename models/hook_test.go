@@ -0,0 +1,253 @@
+package models
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/conf"
+	"github.com/netlify/gocommerce/ssrf"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliverHooksMaxInFlight(t *testing.T) {
+	hooks := []*Hook{}
+	for i := 1; i <= 6; i++ {
+		hooks = append(hooks, &Hook{ID: uint64(i), URL: "https://slow.example.com", MaxInFlight: 1})
+	}
+	hooks = append(hooks, &Hook{ID: 7, URL: "https://fast.example.com"}, &Hook{ID: 8, URL: "https://fast.example.com"})
+
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	maxSeen := map[string]int{}
+	order := map[string][]uint64{}
+	deliverHooks(hooks, func(hook *Hook) bool {
+		mu.Lock()
+		inFlight[hook.URL]++
+		if inFlight[hook.URL] > maxSeen[hook.URL] {
+			maxSeen[hook.URL] = inFlight[hook.URL]
+		}
+		order[hook.URL] = append(order[hook.URL], hook.ID)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight[hook.URL]--
+		mu.Unlock()
+		return true
+	}, func(hook *Hook) {
+		t.Errorf("Hook %d was held", hook.ID)
+	})
+
+	assert.Equal(t, 1, maxSeen["https://slow.example.com"])
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, order["https://slow.example.com"])
+	assert.Equal(t, 2, maxSeen["https://fast.example.com"])
+}
+
+func TestDeliverHooksOrdered(t *testing.T) {
+	hooks := []*Hook{
+		{ID: 1, URL: "a", OrderID: "order-1", Ordered: true},
+		{ID: 2, URL: "a", OrderID: "order-2", Ordered: true},
+		{ID: 3, URL: "a", OrderID: "order-1", Ordered: true},
+		{ID: 4, URL: "a", OrderID: "order-2", Ordered: true},
+		{ID: 5, URL: "a", OrderID: "order-1", Ordered: true},
+	}
+
+	var mu sync.Mutex
+	delivered := []uint64{}
+	held := []uint64{}
+	deliverHooks(hooks, func(hook *Hook) bool {
+		// deliveries of different orders run in parallel
+		time.Sleep(time.Duration(6-hook.ID) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, hook.ID)
+		return hook.ID != 3
+	}, func(hook *Hook) {
+		mu.Lock()
+		defer mu.Unlock()
+		held = append(held, hook.ID)
+	})
+
+	position := map[uint64]int{}
+	for i, id := range delivered {
+		position[id] = i
+	}
+	assert.Len(t, delivered, 4)
+	assert.True(t, position[1] < position[3], "delivered in order %v", delivered)
+	assert.True(t, position[2] < position[4], "delivered in order %v", delivered)
+	assert.Equal(t, []uint64{5}, held, "hooks after a failed one are held")
+}
+
+func TestHookChains(t *testing.T) {
+	a1 := &Hook{ID: 1, URL: "a", OrderID: "order-1", Ordered: true}
+	b1 := &Hook{ID: 2, URL: "a", OrderID: "order-2", Ordered: true}
+	a2 := &Hook{ID: 3, URL: "a", OrderID: "order-1", Ordered: true}
+	u1 := &Hook{ID: 4, URL: "a", OrderID: "order-1"}
+	u2 := &Hook{ID: 5, URL: "a", OrderID: "order-1"}
+	assert.Equal(t, [][]*Hook{{a1, a2}, {b1}, {u1}, {u2}}, hookChains([]*Hook{a1, b1, a2, u1, u2}))
+}
+
+func TestHookQueues(t *testing.T) {
+	a1 := &Hook{ID: 1, URL: "a"}
+	b1 := &Hook{ID: 2, URL: "b"}
+	a2 := &Hook{ID: 3, URL: "a"}
+	assert.Equal(t, [][]*Hook{{a1, a2}, {b1}}, hookQueues([]*Hook{a1, b1, a2}))
+}
+
+func TestHookTriggerCompressed(t *testing.T) {
+	var encoding, eventType string
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		eventType = r.Header.Get("X-Commerce-Event-Type")
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		received, err = ioutil.ReadAll(zr)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	hook, err := NewHook("order", "", server.URL, "user", "order", HookOptions{Compress: true}, map[string]string{"id": "order"})
+	require.NoError(t, err)
+	resp, err := hook.Trigger(server.Client(), logrus.New())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "gzip", encoding)
+	assert.Equal(t, "order", eventType)
+	assert.JSONEq(t, `{"id": "order"}`, string(received))
+}
+
+func TestHookClientNodeID(t *testing.T) {
+	var nodeID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodeID = r.Header.Get("X-Commerce-Node-ID")
+	}))
+	defer server.Close()
+
+	guard := ssrf.NewGuard([]string{"127.0.0.1"})
+	hook, err := NewHook("order", "", server.URL, "user", "order", HookOptions{}, map[string]string{"id": "order"})
+	require.NoError(t, err)
+	resp, err := hook.Trigger(NewHookClient(time.Second, guard, "node-1"), logrus.New())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "node-1", nodeID)
+}
+
+func TestHookBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newHookBreaker(logrus.New())
+	breaker.now = func() time.Time { return now }
+	url := "https://failing.example.com"
+
+	// a few failures among successes keep the circuit closed
+	for _, ok := range []bool{true, true, false, true, false} {
+		allowed, _ := breaker.allow(url)
+		require.True(t, allowed)
+		breaker.record(url, ok)
+	}
+
+	// half of the recent deliveries failed
+	breaker.record(url, false)
+	allowed, retryAt := breaker.allow(url)
+	assert.False(t, allowed)
+	assert.Equal(t, now.Add(circuitCooldown), retryAt)
+	allowed, _ = breaker.allow("https://other.example.com")
+	assert.True(t, allowed)
+
+	// after the cooldown a single probe is let through
+	now = now.Add(circuitCooldown)
+	allowed, _ = breaker.allow(url)
+	assert.True(t, allowed)
+	allowed, _ = breaker.allow(url)
+	assert.False(t, allowed)
+
+	// a failed probe opens the circuit again
+	breaker.record(url, false)
+	allowed, retryAt = breaker.allow(url)
+	assert.False(t, allowed)
+	assert.Equal(t, now.Add(circuitCooldown), retryAt)
+
+	// a successful probe closes it
+	now = now.Add(circuitCooldown)
+	allowed, _ = breaker.allow(url)
+	require.True(t, allowed)
+	breaker.record(url, true)
+	for i := 0; i < 3; i++ {
+		allowed, _ = breaker.allow(url)
+		assert.True(t, allowed)
+	}
+}
+
+// hookTestDB opens a migrated SQLite database in a file the caller removes.
+func hookTestDB(t *testing.T) (*gorm.DB, string) {
+	f, err := ioutil.TempFile("", "test-db")
+	require.NoError(t, err)
+	f.Close()
+
+	config := &conf.GlobalConfiguration{}
+	config.DB.Driver = "sqlite3"
+	config.DB.URL = f.Name()
+	config.DB.Automigrate = true
+	db, err := Connect(config)
+	require.NoError(t, err)
+	return db, f.Name()
+}
+
+func TestHookEventID(t *testing.T) {
+	eventIDs := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eventIDs = append(eventIDs, r.Header.Get("X-Commerce-Event-ID"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	guard := ssrf.NewGuard([]string{"127.0.0.1"})
+	client := NewHookClient(time.Second, guard, "")
+
+	hook, err := NewHook("order", "", server.URL, "user", "order", HookOptions{}, map[string]string{"id": "order"})
+	require.NoError(t, err)
+	require.NotEmpty(t, hook.EventID)
+	for i := 0; i < 2; i++ {
+		resp, err := hook.Trigger(client, logrus.New())
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{hook.EventID, hook.EventID}, eventIDs, "retries keep the event ID")
+}
+
+func TestHookEnqueueDedupe(t *testing.T) {
+	db, path := hookTestDB(t)
+	defer os.Remove(path)
+	defer db.Close()
+
+	payload := map[string]string{"id": "order"}
+	first, err := NewHook("update", "", "https://example.com/hooks", "user", "order", HookOptions{}, payload)
+	require.NoError(t, err)
+	require.NoError(t, first.Enqueue(db))
+
+	redelivery := *first
+	redelivery.ID = 0
+	require.NoError(t, redelivery.Enqueue(db))
+
+	second, err := NewHook("update", "", "https://example.com/hooks", "user", "order", HookOptions{}, payload)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.EventID, second.EventID, "every event gets its own ID")
+	require.NoError(t, second.Enqueue(db))
+
+	hooks := []Hook{}
+	require.NoError(t, db.Order("id").Find(&hooks).Error)
+	require.Len(t, hooks, 2, "only the redelivery is dropped")
+	assert.Equal(t, first.EventID, hooks[0].EventID)
+	assert.Equal(t, second.EventID, hooks[1].EventID)
+}
@@ -121,6 +121,7 @@ func (i *Instance) BeforeDelete(tx *gorm.DB) error {
 	delModels := map[string]interface{}{
 		"transaction":    Transaction{},
 		"invoice number": InvoiceNumber{},
+		"invoice":        Invoice{},
 	}
 
 	for name, dm := range delModels {
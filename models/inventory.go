@@ -0,0 +1,422 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const reservationCleanupPeriod = 30 * time.Second
+
+// InventoryItem tracks the stock available for a product.
+type InventoryItem struct {
+	ID         uint64 `json:"-"`
+	InstanceID string `json:"-" gorm:"unique_index:idx_inventory_instance_sku"`
+	Sku        string `json:"sku" gorm:"unique_index:idx_inventory_instance_sku"`
+	Available  uint64 `json:"available"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the database table name for the InventoryItem model.
+func (InventoryItem) TableName() string {
+	return tableName("inventory_items")
+}
+
+// Reservation holds stock for a pending order until it is paid or expires.
+type Reservation struct {
+	ID         uint64 `json:"id"`
+	InstanceID string `json:"-"`
+	OrderID    string `json:"order_id" sql:"index"`
+	Sku        string `json:"sku"`
+	Quantity   uint64 `json:"quantity"`
+
+	ExpiresAt   time.Time  `json:"expires_at" sql:"index"`
+	CommittedAt *time.Time `json:"committed_at,omitempty"`
+	ReleasedAt  *time.Time `json:"released_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the Reservation model.
+func (Reservation) TableName() string {
+	return tableName("reservations")
+}
+
+// Backorder records an order for a product that was out of stock. Backorders
+// are fulfilled in the order they were placed once the product is restocked.
+type Backorder struct {
+	ID         uint64 `json:"id"`
+	InstanceID string `json:"-" sql:"index"`
+	OrderID    string `json:"order_id" sql:"index"`
+	Sku        string `json:"sku" sql:"index"`
+	Quantity   uint64 `json:"quantity"`
+
+	FulfilledAt *time.Time `json:"fulfilled_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the Backorder model.
+func (Backorder) TableName() string {
+	return tableName("backorders")
+}
+
+// OutOfStockError is returned when there's not enough stock to reserve for a product.
+type OutOfStockError struct {
+	Sku string
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("Product %s is out of stock", e.Sku)
+}
+
+// ReserveInventory holds stock for all line items of an order until expiresAt.
+// The available count is only decremented if enough stock is left, so
+// simultaneous checkouts can't reserve the same unit twice. Products without
+// an inventory item aren't limited. If backorders are allowed, line items
+// that can't be reserved are queued as backorders instead of failing.
+func ReserveInventory(tx *gorm.DB, order *Order, expiresAt time.Time, allowBackorders bool) error {
+	for _, item := range order.LineItems {
+		rsp := tx.Model(&InventoryItem{}).
+			Where("instance_id = ? AND sku = ? AND available >= ?", order.InstanceID, item.Sku, item.Quantity).
+			UpdateColumn("available", gorm.Expr("available - ?", item.Quantity))
+		if rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error reserving inventory")
+		}
+
+		if rsp.RowsAffected == 0 {
+			count := 0
+			if err := tx.Model(&InventoryItem{}).Where("instance_id = ? AND sku = ?", order.InstanceID, item.Sku).Count(&count).Error; err != nil {
+				return errors.Wrap(err, "Error querying inventory")
+			}
+			if count == 0 {
+				continue
+			}
+			if !allowBackorders {
+				return &OutOfStockError{Sku: item.Sku}
+			}
+
+			backorder := &Backorder{
+				InstanceID: order.InstanceID,
+				OrderID:    order.ID,
+				Sku:        item.Sku,
+				Quantity:   item.Quantity,
+			}
+			if err := tx.Create(backorder).Error; err != nil {
+				return errors.Wrap(err, "Error saving backorder")
+			}
+			continue
+		}
+
+		reservation := &Reservation{
+			InstanceID: order.InstanceID,
+			OrderID:    order.ID,
+			Sku:        item.Sku,
+			Quantity:   item.Quantity,
+			ExpiresAt:  expiresAt,
+		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return errors.Wrap(err, "Error saving inventory reservation")
+		}
+	}
+	return nil
+}
+
+// Restock adds stock for a product and allocates it to waiting backorders of
+// paid orders, oldest first. Allocation stops at the first backorder that
+// can't be fulfilled completely, so later orders can't jump the queue. Restock
+// must be called within a transaction, which is what prevents two restocks
+// from allocating the same units.
+func Restock(tx *gorm.DB, instanceID, sku string, quantity uint64) (*InventoryItem, []*Backorder, error) {
+	item := &InventoryItem{}
+	rsp := tx.Where("instance_id = ? AND sku = ?", instanceID, sku).
+		Attrs(InventoryItem{InstanceID: instanceID, Sku: sku}).
+		FirstOrCreate(item)
+	if rsp.Error != nil {
+		return nil, nil, errors.Wrap(rsp.Error, "Error querying inventory")
+	}
+	rsp = tx.Model(&InventoryItem{}).
+		Where("id = ?", item.ID).
+		UpdateColumn("available", gorm.Expr("available + ?", quantity))
+	if rsp.Error != nil {
+		return nil, nil, errors.Wrap(rsp.Error, "Error restocking inventory")
+	}
+
+	backordersTable := tx.NewScope(Backorder{}).QuotedTableName()
+	ordersTable := tx.NewScope(Order{}).QuotedTableName()
+	waiting := []*Backorder{}
+	rsp = tx.
+		Joins("JOIN "+ordersTable+" ON "+ordersTable+".id = "+backordersTable+".order_id").
+		Where(backordersTable+".instance_id = ? AND "+backordersTable+".sku = ? AND "+backordersTable+".fulfilled_at IS NULL", instanceID, sku).
+		Where(ordersTable+".payment_state = ?", PaidState).
+		Order(backordersTable + ".created_at asc, " + backordersTable + ".id asc").
+		Find(&waiting)
+	if rsp.Error != nil {
+		return nil, nil, errors.Wrap(rsp.Error, "Error querying backorders")
+	}
+
+	fulfilled := []*Backorder{}
+	now := time.Now()
+	for _, backorder := range waiting {
+		rsp := tx.Model(&InventoryItem{}).
+			Where("id = ? AND available >= ?", item.ID, backorder.Quantity).
+			UpdateColumn("available", gorm.Expr("available - ?", backorder.Quantity))
+		if rsp.Error != nil {
+			return nil, nil, errors.Wrap(rsp.Error, "Error allocating inventory")
+		}
+		if rsp.RowsAffected == 0 {
+			break
+		}
+
+		rsp = tx.Model(&Backorder{}).
+			Where("id = ? AND fulfilled_at IS NULL", backorder.ID).
+			UpdateColumn("fulfilled_at", now)
+		if rsp.Error != nil {
+			return nil, nil, errors.Wrap(rsp.Error, "Error fulfilling backorder")
+		}
+		if rsp.RowsAffected == 0 {
+			return nil, nil, errors.Errorf("Backorder %d was fulfilled concurrently", backorder.ID)
+		}
+		backorder.FulfilledAt = &now
+		fulfilled = append(fulfilled, backorder)
+	}
+
+	if rsp := tx.First(item, item.ID); rsp.Error != nil {
+		return nil, nil, errors.Wrap(rsp.Error, "Error querying inventory")
+	}
+	return item, fulfilled, nil
+}
+
+// ReleaseReservation returns the stock of an uncommitted reservation of a
+// line item to the inventory, e.g. when the line item is removed from a
+// pending order.
+func ReleaseReservation(tx *gorm.DB, orderID, sku string, quantity uint64) error {
+	reservation := &Reservation{}
+	rsp := tx.Where("order_id = ? AND sku = ? AND quantity = ? AND committed_at IS NULL AND released_at IS NULL", orderID, sku, quantity).First(reservation)
+	if rsp.RecordNotFound() {
+		return nil
+	}
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for reservation")
+	}
+
+	rsp = tx.Model(&Reservation{}).
+		Where("id = ? AND committed_at IS NULL AND released_at IS NULL", reservation.ID).
+		UpdateColumn("released_at", time.Now())
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error releasing reservation")
+	}
+	if rsp.RowsAffected == 0 {
+		return nil
+	}
+	rsp = tx.Model(&InventoryItem{}).
+		Where("instance_id = ? AND sku = ?", reservation.InstanceID, reservation.Sku).
+		UpdateColumn("available", gorm.Expr("available + ?", reservation.Quantity))
+	return errors.Wrap(rsp.Error, "Error restoring inventory")
+}
+
+// ResizeReservations changes the stock held for a product of a pending order
+// when the quantity of its line items changes from one amount to another.
+// Additional units are taken from the inventory, which fails with an
+// OutOfStockError if there isn't enough left, unless backorders are allowed.
+// Removed units are taken off the order's backorders first and then returned
+// to the inventory. Products whose reservations were already released are
+// reserved again in full when the order is paid.
+func ResizeReservations(tx *gorm.DB, order *Order, sku string, from, to uint64, allowBackorders bool) error {
+	if from == to {
+		return nil
+	}
+
+	backorders := []*Backorder{}
+	rsp := tx.Where("order_id = ? AND sku = ? AND fulfilled_at IS NULL", order.ID, sku).Order("id desc").Find(&backorders)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for backorders")
+	}
+	reservations := []*Reservation{}
+	rsp = tx.Where("order_id = ? AND sku = ? AND committed_at IS NULL AND released_at IS NULL", order.ID, sku).Order("id desc").Find(&reservations)
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for reservations")
+	}
+
+	if to > from {
+		more := to - from
+		if len(reservations) == 0 {
+			if len(backorders) == 0 {
+				return nil
+			}
+			rsp := tx.Model(backorders[0]).UpdateColumn("quantity", gorm.Expr("quantity + ?", more))
+			return errors.Wrap(rsp.Error, "Error updating backorder")
+		}
+
+		rsp := tx.Model(&InventoryItem{}).
+			Where("instance_id = ? AND sku = ? AND available >= ?", order.InstanceID, sku, more).
+			UpdateColumn("available", gorm.Expr("available - ?", more))
+		if rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error reserving inventory")
+		}
+		if rsp.RowsAffected == 0 {
+			if !allowBackorders {
+				return &OutOfStockError{Sku: sku}
+			}
+			backorder := &Backorder{InstanceID: order.InstanceID, OrderID: order.ID, Sku: sku, Quantity: more}
+			return errors.Wrap(tx.Create(backorder).Error, "Error saving backorder")
+		}
+		rsp = tx.Model(reservations[0]).UpdateColumn("quantity", gorm.Expr("quantity + ?", more))
+		return errors.Wrap(rsp.Error, "Error updating reservation")
+	}
+
+	fewer := from - to
+	for _, backorder := range backorders {
+		if fewer == 0 {
+			break
+		}
+		if backorder.Quantity <= fewer {
+			if err := tx.Delete(backorder).Error; err != nil {
+				return errors.Wrap(err, "Error removing backorder")
+			}
+			fewer -= backorder.Quantity
+			continue
+		}
+		if err := tx.Model(backorder).UpdateColumn("quantity", gorm.Expr("quantity - ?", fewer)).Error; err != nil {
+			return errors.Wrap(err, "Error updating backorder")
+		}
+		fewer = 0
+	}
+
+	var released uint64
+	for _, reservation := range reservations {
+		if fewer == 0 {
+			break
+		}
+		var rsp *gorm.DB
+		n := reservation.Quantity
+		if n <= fewer {
+			rsp = tx.Model(reservation).UpdateColumn("released_at", time.Now())
+		} else {
+			n = fewer
+			rsp = tx.Model(reservation).UpdateColumn("quantity", gorm.Expr("quantity - ?", n))
+		}
+		if rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error releasing reservation")
+		}
+		fewer -= n
+		released += n
+	}
+	if released == 0 {
+		return nil
+	}
+	rsp = tx.Model(&InventoryItem{}).
+		Where("instance_id = ? AND sku = ?", order.InstanceID, sku).
+		UpdateColumn("available", gorm.Expr("available + ?", released))
+	return errors.Wrap(rsp.Error, "Error restoring inventory")
+}
+
+// ExtendReservations keeps the open reservations of an order until expiresAt,
+// e.g. while it awaits a manual payment.
+func ExtendReservations(tx *gorm.DB, orderID string, expiresAt time.Time) error {
+	return tx.Model(&Reservation{}).
+		Where("order_id = ? AND committed_at IS NULL AND released_at IS NULL", orderID).
+		UpdateColumn("expires_at", expiresAt).Error
+}
+
+// HoldReservations makes sure the stock of an order is still held before it's
+// charged. Open reservations are kept until expiresAt and line items whose
+// reservations were already released are reserved again, which fails with an
+// OutOfStockError if the stock was sold in the meantime.
+func HoldReservations(tx *gorm.DB, order *Order, expiresAt time.Time, allowBackorders bool) error {
+	if err := ExtendReservations(tx, order.ID, expiresAt); err != nil {
+		return errors.Wrap(err, "Error extending reservations")
+	}
+
+	reservations := []*Reservation{}
+	if rsp := tx.Where("order_id = ?", order.ID).Find(&reservations); rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for reservations")
+	}
+	released := map[string]bool{}
+	for _, reservation := range reservations {
+		if reservation.ReleasedAt != nil {
+			released[reservation.Sku] = true
+		}
+	}
+	for _, reservation := range reservations {
+		if reservation.ReleasedAt == nil {
+			delete(released, reservation.Sku)
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+
+	renewed := &Order{ID: order.ID, InstanceID: order.InstanceID}
+	for _, item := range order.LineItems {
+		if released[item.Sku] {
+			renewed.LineItems = append(renewed.LineItems, item)
+		}
+	}
+	return ReserveInventory(tx, renewed, expiresAt, allowBackorders)
+}
+
+// CommitReservations marks the reservations of a paid order as final, so the
+// reserved stock is no longer released.
+func CommitReservations(tx *gorm.DB, orderID string) error {
+	return tx.Model(&Reservation{}).
+		Where("order_id = ? AND committed_at IS NULL AND released_at IS NULL", orderID).
+		UpdateColumn("committed_at", time.Now()).Error
+}
+
+// ReleaseExpiredReservations returns the stock of all expired, uncommitted
+// reservations to the inventory.
+func ReleaseExpiredReservations(db *gorm.DB, log logrus.FieldLogger) error {
+	reservations := []*Reservation{}
+	now := time.Now()
+	if rsp := db.Where("committed_at IS NULL AND released_at IS NULL AND expires_at < ?", now).Find(&reservations); rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error querying for expired reservations")
+	}
+
+	for _, reservation := range reservations {
+		tx := db.Begin()
+		// only one worker may release a reservation
+		rsp := tx.Model(&Reservation{}).
+			Where("id = ? AND committed_at IS NULL AND released_at IS NULL", reservation.ID).
+			UpdateColumn("released_at", now)
+		if rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error releasing reservation")
+		}
+		if rsp.RowsAffected == 0 {
+			tx.Rollback()
+			continue
+		}
+
+		rsp = tx.Model(&InventoryItem{}).
+			Where("instance_id = ? AND sku = ?", reservation.InstanceID, reservation.Sku).
+			UpdateColumn("available", gorm.Expr("available + ?", reservation.Quantity))
+		if rsp.Error != nil {
+			tx.Rollback()
+			return errors.Wrap(rsp.Error, "Error restoring inventory")
+		}
+		if rsp := tx.Commit(); rsp.Error != nil {
+			return errors.Wrap(rsp.Error, "Error committing reservation release")
+		}
+		log.Debugf("Released reservation of %d x %s for order %s", reservation.Quantity, reservation.Sku, reservation.OrderID)
+	}
+	return nil
+}
+
+// RunReservationCleanup creates a goroutine that releases expired inventory
+// reservations of unpaid orders.
+func RunReservationCleanup(db *gorm.DB, log *logrus.Entry) {
+	go func() {
+		for {
+			if err := ReleaseExpiredReservations(db, log); err != nil {
+				log.WithError(err).Error("Error releasing expired reservations")
+			}
+			time.Sleep(reservationCleanupPeriod)
+		}
+	}()
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/netlify/gocommerce/calculator"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// InvoiceDocumentType is the type of an Invoice issued for a paid order.
+const InvoiceDocumentType = "invoice"
+
+// CreditNoteDocumentType is the type of an Invoice that corrects an issued
+// invoice.
+const CreditNoteDocumentType = "credit_note"
+
+// ErrInvoiceIssued is returned when trying to change an issued invoice.
+var ErrInvoiceIssued = errors.New("Issued invoices can't be changed, issue a credit note instead")
+
+// InvoiceParty is the seller or buyer on an invoice.
+type InvoiceParty struct {
+	Name    string   `json:"name"`
+	Company string   `json:"company,omitempty"`
+	Address []string `json:"address"`
+	Email   string   `json:"email,omitempty"`
+	TaxID   string   `json:"tax_id,omitempty"`
+	// TaxIDs are further tax IDs of the party, e.g. registrations in other
+	// countries.
+	TaxIDs []string `json:"tax_ids,omitempty"`
+}
+
+// InvoiceLine is a single line on an invoice.
+type InvoiceLine struct {
+	Sku       string `json:"sku,omitempty"`
+	Title     string `json:"title"`
+	Quantity  uint64 `json:"quantity"`
+	UnitPrice uint64 `json:"unit_price"`
+	Discount  uint64 `json:"discount"`
+	Taxes     uint64 `json:"taxes"`
+	Total     uint64 `json:"total"`
+
+	// Measure is the measure of a product sold by measure, the unit price
+	// is the price of that measure.
+	Measure     float64 `json:"measure,omitempty"`
+	MeasureUnit string  `json:"measure_unit,omitempty"`
+
+	// Serials are the serial numbers assigned to the units before the
+	// invoice was issued.
+	Serials []string `json:"serials,omitempty"`
+}
+
+// Invoice is a snapshot of a paid order as a formal document. It can't be
+// changed once issued. Corrections are issued as credit notes, which are
+// invoices of type CreditNoteDocumentType pointing to the corrected invoice.
+type Invoice struct {
+	InstanceID string `json:"-" gorm:"unique_index:idx_invoice_instance_number"`
+	ID         string `json:"id"`
+	OrderID    string `json:"order_id" sql:"index"`
+
+	Type   string `json:"type"`
+	Number int64  `json:"number" gorm:"unique_index:idx_invoice_instance_number"`
+
+	// CorrectsID is the ID of the invoice a credit note corrects.
+	CorrectsID string `json:"corrects_id,omitempty" sql:"index"`
+	Reason     string `json:"reason,omitempty"`
+
+	IssuedAt time.Time `json:"issued_at"`
+
+	Seller    InvoiceParty `json:"seller" sql:"-"`
+	RawSeller string       `json:"-" sql:"type:text"`
+	Buyer     InvoiceParty `json:"buyer" sql:"-"`
+	RawBuyer  string       `json:"-" sql:"type:text"`
+
+	Lines    []InvoiceLine `json:"lines" sql:"-"`
+	RawLines string        `json:"-" sql:"type:text"`
+
+	Currency string `json:"currency"`
+	SubTotal uint64 `json:"subtotal"`
+	Discount uint64 `json:"discount"`
+	Shipping uint64 `json:"shipping"`
+	Taxes    uint64 `json:"taxes"`
+	Tip      uint64 `json:"tip"`
+	Total    uint64 `json:"total"`
+
+	TaxBreakdown    []calculator.TaxItem `json:"tax_breakdown" sql:"-"`
+	RawTaxBreakdown string               `json:"-" sql:"type:text"`
+
+	// ReverseCharge is set if the buyer accounts for the taxes of a cross
+	// border sale, Note then holds the mandated note.
+	ReverseCharge bool   `json:"reverse_charge"`
+	Note          string `json:"note,omitempty" sql:"type:text"`
+
+	// Consent is the consent the buyer gave when placing the order.
+	Consent Consent `json:"consent" gorm:"embedded;embedded_prefix:consent_"`
+
+	CreditNotes []*Invoice `json:"credit_notes,omitempty" sql:"-"`
+
+	CreatedAt time.Time `json:"-"`
+}
+
+// TableName returns the database table name for the Invoice model.
+func (Invoice) TableName() string {
+	return tableName("invoices")
+}
+
+// NewInvoice creates an invoice for a paid order. It uses the invoice number
+// the order got when it was paid.
+func NewInvoice(order *Order, seller InvoiceParty) *Invoice {
+	billing := order.BillingAddress.AddressRequest
+	billing.combineNames()
+	buyer := InvoiceParty{
+		Name:    billing.Name,
+		Company: billing.Company,
+		Address: addressLines(billing),
+		Email:   order.Email,
+		TaxID:   order.VATNumber,
+	}
+
+	lines := make([]InvoiceLine, 0, len(order.LineItems))
+	for _, item := range order.LineItems {
+		line := InvoiceLine{
+			Sku:       item.Sku,
+			Title:     item.Title,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price + item.AddonPrice,
+		}
+		if item.MeasureUnit != "" {
+			line.Measure = item.Measure
+			line.MeasureUnit = item.MeasureUnit
+		}
+		if serials := order.SerialsFor(item); len(serials) > 0 {
+			line.Serials = serials
+		}
+		// the calculation details are the amounts of a single unit
+		if item.CalculationDetail != nil {
+			line.Discount = item.Discount * item.Quantity
+			line.Taxes = item.CalculationDetail.Taxes * item.Quantity
+			line.Total = uint64(item.CalculationDetail.Total) * item.Quantity
+		} else {
+			line.Total = line.UnitPrice * line.Quantity
+		}
+		lines = append(lines, line)
+	}
+
+	return &Invoice{
+		InstanceID:   order.InstanceID,
+		ID:           uuid.NewRandom().String(),
+		OrderID:      order.ID,
+		Type:         InvoiceDocumentType,
+		Number:       order.InvoiceNumber,
+		IssuedAt:     time.Now().UTC(),
+		Seller:       seller,
+		Buyer:        buyer,
+		Lines:        lines,
+		Currency:     order.Currency,
+		SubTotal:     order.SubTotal,
+		Discount:     order.Discount,
+		Shipping:     order.Shipping,
+		Taxes:        order.Taxes,
+		Tip:          order.Tip,
+		Total:        order.Total,
+		TaxBreakdown: order.TaxBreakdown,
+		Consent:      order.Consent,
+	}
+}
+
+// NewCreditNote creates a credit note over an amount of an invoice. The taxes
+// of the credited amount are the share of the invoice's taxes.
+func NewCreditNote(invoice *Invoice, number int64, amount uint64, reason string) *Invoice {
+	taxes := uint64(0)
+	if invoice.Total > 0 {
+		taxes = (invoice.Taxes*amount + invoice.Total/2) / invoice.Total
+	}
+	return &Invoice{
+		InstanceID: invoice.InstanceID,
+		ID:         uuid.NewRandom().String(),
+		OrderID:    invoice.OrderID,
+		Type:       CreditNoteDocumentType,
+		Number:     number,
+		CorrectsID: invoice.ID,
+		Reason:     reason,
+		IssuedAt:   time.Now().UTC(),
+		Seller:     invoice.Seller,
+		Buyer:      invoice.Buyer,
+		Lines: []InvoiceLine{{
+			Title:     reason,
+			Quantity:  1,
+			UnitPrice: amount - taxes,
+			Taxes:     taxes,
+			Total:     amount,
+		}},
+		Currency:      invoice.Currency,
+		SubTotal:      amount - taxes,
+		Taxes:         taxes,
+		Total:         amount,
+		ReverseCharge: invoice.ReverseCharge,
+		Note:          invoice.Note,
+	}
+}
+
+// ApplyReverseCharge marks an invoice as reverse charged with the given note
+// if it's a cross border sale between businesses without taxes: both parties
+// have tax IDs, they're from different countries and no taxes were charged.
+func (i *Invoice) ApplyReverseCharge(note string) {
+	if i.Taxes > 0 || i.Seller.TaxID == "" || i.Buyer.TaxID == "" {
+		return
+	}
+	seller, err := ParseTaxID(i.Seller.TaxID)
+	if err != nil {
+		return
+	}
+	buyer, err := ParseTaxID(i.Buyer.TaxID)
+	if err != nil || buyer == seller {
+		return
+	}
+	i.ReverseCharge = true
+	i.Note = note
+}
+
+// Credited sums up the totals of the credit notes of the invoice.
+func (i *Invoice) Credited() uint64 {
+	total := uint64(0)
+	for _, note := range i.CreditNotes {
+		total += note.Total
+	}
+	return total
+}
+
+// AfterFind database callback.
+func (i *Invoice) AfterFind() error {
+	raw := []struct {
+		data  string
+		value interface{}
+	}{
+		{i.RawSeller, &i.Seller},
+		{i.RawBuyer, &i.Buyer},
+		{i.RawLines, &i.Lines},
+		{i.RawTaxBreakdown, &i.TaxBreakdown},
+	}
+	for _, r := range raw {
+		if r.data == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(r.data), r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeforeCreate database callback.
+func (i *Invoice) BeforeCreate() error {
+	raw := []struct {
+		data  *string
+		value interface{}
+	}{
+		{&i.RawSeller, i.Seller},
+		{&i.RawBuyer, i.Buyer},
+		{&i.RawLines, i.Lines},
+		{&i.RawTaxBreakdown, i.TaxBreakdown},
+	}
+	for _, r := range raw {
+		data, err := json.Marshal(r.value)
+		if err != nil {
+			return err
+		}
+		*r.data = string(data)
+	}
+	return nil
+}
+
+// BeforeUpdate database callback. Issued invoices are immutable.
+func (i *Invoice) BeforeUpdate() error {
+	return ErrInvoiceIssued
+}
+
+// FindInvoice loads the invoice of an order with its credit notes. It returns
+// nil if no invoice has been issued for the order yet.
+func FindInvoice(db *gorm.DB, orderID string) (*Invoice, error) {
+	invoice := &Invoice{}
+	rsp := db.Where("order_id = ? AND type = ?", orderID, InvoiceDocumentType).First(invoice)
+	if rsp.RecordNotFound() {
+		return nil, nil
+	}
+	if rsp.Error != nil {
+		return nil, rsp.Error
+	}
+	if rsp := db.Where("corrects_id = ?", invoice.ID).Order("number asc").Find(&invoice.CreditNotes); rsp.Error != nil {
+		return nil, rsp.Error
+	}
+	return invoice, nil
+}
+
+func addressLines(a AddressRequest) []string {
+	lines := []string{}
+	for _, line := range []string{
+		a.Address1,
+		a.Address2,
+		strings.TrimSpace(a.Zip + " " + a.City),
+		a.State,
+		a.Country,
+	} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInvoiceLineTotals(t *testing.T) {
+	order := &Order{
+		LineItems: []*LineItem{{
+			Sku:      "mug",
+			Title:    "Mug",
+			Price:    1000,
+			Quantity: 3,
+			CalculationDetail: &CalculationDetail{
+				Subtotal: 1000,
+				Discount: 100,
+				NetTotal: 900,
+				Taxes:    171,
+				Total:    1071,
+			},
+		}},
+	}
+	invoice := NewInvoice(order, InvoiceParty{})
+	require.Len(t, invoice.Lines, 1)
+	line := invoice.Lines[0]
+	assert.EqualValues(t, 3, line.Quantity)
+	assert.EqualValues(t, 1000, line.UnitPrice)
+	assert.EqualValues(t, 300, line.Discount)
+	assert.EqualValues(t, 513, line.Taxes)
+	assert.EqualValues(t, 3213, line.Total)
+}
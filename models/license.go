@@ -0,0 +1,113 @@
+package models
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// DefaultLicenseFormat is the format of generated license keys if the product
+// doesn't specify one.
+const DefaultLicenseFormat = "XXXXX-XXXXX-XXXXX-XXXXX"
+
+// LicenseMetadata configures the license keys issued for a product. In the
+// Format every X is replaced with a random letter or digit, all other
+// characters are kept. An ActivationLimit of 0 allows unlimited activations.
+type LicenseMetadata struct {
+	Format          string `json:"format"`
+	ActivationLimit uint64 `json:"activation_limit"`
+}
+
+// License is a license key issued for a unit of a paid line item.
+type License struct {
+	InstanceID string `json:"-" gorm:"unique_index:idx_license_instance_key"`
+	ID         string `json:"id"`
+
+	OrderID    string `json:"order_id" sql:"index"`
+	LineItemID int64  `json:"line_item_id"`
+
+	Title string `json:"title"`
+	Sku   string `json:"sku"`
+	Key   string `json:"key" gorm:"column:license_key;unique_index:idx_license_instance_key"`
+
+	ActivationLimit uint64 `json:"activation_limit"`
+	Activations     uint64 `json:"activations"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName returns the database table name for the License model.
+func (License) TableName() string {
+	return tableName("licenses")
+}
+
+// NewLicense creates a license with a key for a line item of an order.
+func NewLicense(order *Order, item *LineItem, key string) *License {
+	return &License{
+		InstanceID:      order.InstanceID,
+		ID:              uuid.NewRandom().String(),
+		OrderID:         order.ID,
+		LineItemID:      item.ID,
+		Title:           item.Title,
+		Sku:             item.Sku,
+		Key:             key,
+		ActivationLimit: item.LicenseActivationLimit,
+	}
+}
+
+// NewLicenseKey generates a random license key in the given format.
+func NewLicenseKey(format string) (string, error) {
+	key := []byte(format)
+	buf := make([]byte, len(key))
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, c := range key {
+		if c == 'X' {
+			key[i] = couponCodeAlphabet[int(buf[i])%len(couponCodeAlphabet)]
+		}
+	}
+	return string(key), nil
+}
+
+// LicenseActivationError is returned when a license has been activated as
+// often as it allows.
+type LicenseActivationError struct {
+	Limit uint64
+}
+
+func (e *LicenseActivationError) Error() string {
+	return fmt.Sprintf("License has already been activated %d times", e.Limit)
+}
+
+// ActivateLicense counts an activation of a license. Like RedeemCoupon, the
+// count is only incremented below the limit, so simultaneous activations
+// can't exceed it.
+func ActivateLicense(tx *gorm.DB, license *License) error {
+	query := tx.Model(&License{}).Where("id = ?", license.ID)
+	if license.ActivationLimit > 0 {
+		query = query.Where("activations < activation_limit")
+	}
+	rsp := query.UpdateColumn("activations", gorm.Expr("activations + 1"))
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error activating license")
+	}
+	if rsp.RowsAffected == 0 {
+		return &LicenseActivationError{Limit: license.ActivationLimit}
+	}
+	return errors.Wrap(tx.First(license, "id = ?", license.ID).Error, "Error loading license")
+}
+
+// DeactivateLicense releases an activation of a license.
+func DeactivateLicense(tx *gorm.DB, license *License) error {
+	rsp := tx.Model(&License{}).Where("id = ? AND activations > 0", license.ID).UpdateColumn("activations", gorm.Expr("activations - 1"))
+	if rsp.Error != nil {
+		return errors.Wrap(rsp.Error, "Error deactivating license")
+	}
+	return errors.Wrap(tx.First(license, "id = ?", license.ID).Error, "Error loading license")
+}
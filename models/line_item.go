@@ -13,6 +13,26 @@ import (
 	"github.com/pborman/uuid"
 )
 
+// PhysicalFulfillment, DigitalFulfillment and ServiceFulfillment are the ways
+// a LineItem can be fulfilled. Physical items are fulfilled once shipped,
+// digital items as soon as the order is paid and services by an admin.
+const (
+	PhysicalFulfillment = "physical"
+	DigitalFulfillment  = "digital"
+	ServiceFulfillment  = "service"
+)
+
+// LineItemFulfillmentStates are the possible values for the FulfillmentState
+// field of a LineItem
+var LineItemFulfillmentStates = []string{
+	PendingState,
+	ShippingState,
+	ShippedState,
+	DeliveredState,
+	FulfilledState,
+	ReturnedState,
+}
+
 // DiscountItem provides details about a discount that was applied
 type DiscountItem struct {
 	ID         int64 `json:"-"`
@@ -32,6 +52,9 @@ type CalculationDetail struct {
 
 	Discount      uint64         `json:"discount"`
 	DiscountItems []DiscountItem `json:"discount_items" gorm:"foreignkey:LineItemID"`
+	// DiscountCapped is set if the discount was limited by the discount
+	// floor of the settings.
+	DiscountCapped bool `json:"discount_capped"`
 
 	NetTotal uint64 `json:"net_total"`
 	Taxes    uint64 `json:"taxes"`
@@ -49,9 +72,18 @@ type LineItem struct {
 	Description string `json:"description" sql:"type:text"`
 
 	Path string `json:"path"`
+	// Image is the URL of the product image, relative to the site or absolute.
+	Image string `json:"image"`
 
 	Price uint64 `json:"price"`
 	VAT   uint64 `json:"vat"`
+	// Cost is what the product costs the shop, items aren't discounted
+	// below it if the settings ask for it.
+	Cost uint64 `json:"cost,omitempty" visibility:"admin"`
+
+	// TaxCode selects the rate of the item in the tax settings, items
+	// without a code are taxed by their type.
+	TaxCode string `json:"tax_code,omitempty"`
 
 	*CalculationDetail `json:"calculation" gorm:"embedded;embedded_prefix:calculation_"`
 
@@ -61,9 +93,40 @@ type LineItem struct {
 
 	Quantity uint64 `json:"quantity"`
 
+	// Measure is the amount of a product sold by measure, e.g. 1.5 kg of
+	// coffee, in MeasureUnit. The Price is the UnitPrice per unit of measure
+	// times the Measure.
+	Measure     float64 `json:"measure,omitempty"`
+	MeasureUnit string  `json:"measure_unit,omitempty"`
+	UnitPrice   uint64  `json:"unit_price,omitempty"`
+
+	// the number of decimals the measure can have, kept to validate it
+	MeasurePrecision int `json:"-"`
+
+	// Free items are granted by the coupon of the order and cost nothing.
+	Free bool `json:"free"`
+
+	// Weight is the weight of a single unit in the weight unit of the order.
+	Weight float64 `json:"weight"`
+
+	FulfillmentType  string `json:"fulfillment_type"`
+	FulfillmentState string `json:"fulfillment_state"`
+
+	// purchase caps of the product, kept to validate later quantity changes
+	MaxQuantityPerOrder uint64 `json:"-"`
+	MaxPerCustomer      uint64 `json:"-"`
+
+	// license keys are issued in this format once the order is paid, no
+	// licenses are issued if it's empty
+	LicenseFormat          string `json:"-"`
+	LicenseActivationLimit uint64 `json:"-"`
+
 	MetaData    map[string]interface{} `sql:"-" json:"meta"`
 	RawMetaData string                 `json:"-" sql:"type:text"`
 
+	// downloads are issued for this line item once it has been saved
+	downloads []Download
+
 	CreatedAt time.Time  `json:"-"`
 	DeletedAt *time.Time `json:"-"`
 }
@@ -133,6 +196,11 @@ func (i *PriceItem) PriceInLowestUnit() uint64 {
 	return i.Amount
 }
 
+// CostInLowestUnit implements part of the calculator.Item interface.
+func (i *PriceItem) CostInLowestUnit() uint64 {
+	return 0 // the cost of PriceItems is part of their LineItem
+}
+
 // ProductType implements part of the calculator.Item interface.
 func (i *PriceItem) ProductType() string {
 	return i.Type
@@ -143,6 +211,11 @@ func (i *PriceItem) FixedVAT() uint64 {
 	return i.VAT
 }
 
+// ProductTaxCode implements part of the calculator.Item interface.
+func (i *PriceItem) ProductTaxCode() string {
+	return "" // PriceItems are taxed with the code of their LineItem
+}
+
 // TaxableItems implements part of the calculator.Item interface.
 func (i *PriceItem) TaxableItems() []calculator.Item {
 	return nil
@@ -174,6 +247,7 @@ type PriceMetadata struct {
 	Amount   string            `json:"amount"`
 	Currency string            `json:"currency"`
 	VAT      string            `json:"vat"`
+	Cost     string            `json:"cost"`
 	Items    []PriceMetaItem   `json:"items"`
 	Claims   map[string]string `json:"claims"`
 
@@ -200,14 +274,42 @@ type LineItemMetadata struct {
 	Sku         string          `json:"sku"`
 	Title       string          `json:"title"`
 	Description string          `json:"description"`
+	Image       string          `json:"image"`
 	VAT         uint64          `json:"vat"`
+	TaxCode     string          `json:"tax_code"`
 	Prices      []PriceMetadata `json:"prices"`
 	Type        string          `json:"type"`
 
-	Downloads []Download      `json:"downloads"`
-	Addons    []AddonMetaItem `json:"addons"`
+	Downloads []Download       `json:"downloads"`
+	License   *LicenseMetadata `json:"license"`
+	Addons    []AddonMetaItem  `json:"addons"`
 
 	Webhook string `json:"webhook"`
+
+	Weight     float64 `json:"weight"`
+	WeightUnit string  `json:"weight_unit"`
+
+	Measure *MeasureMetadata `json:"measure"`
+
+	FulfillmentType string `json:"fulfillment_type"`
+
+	MaxQuantityPerOrder uint64 `json:"max_quantity_per_order"`
+	MaxPerCustomer      uint64 `json:"max_per_customer"`
+}
+
+// Fulfilled checks if the line item has been delivered. Physical items are
+// delivered once they're shipped.
+func (i *LineItem) Fulfilled() bool {
+	if i.FulfillmentType == PhysicalFulfillment && i.FulfillmentState == ShippedState {
+		return true
+	}
+	return i.FulfillmentState == DeliveredState || i.FulfillmentState == FulfilledState
+}
+
+// Outstanding checks if the line item still has to be fulfilled, i.e. it
+// wasn't fulfilled nor returned.
+func (i *LineItem) Outstanding() bool {
+	return !i.Fulfilled() && i.FulfillmentState != ReturnedState
 }
 
 // ProductSku returns the Sku of the line item to match the calculator.Item interface
@@ -220,6 +322,11 @@ func (i *LineItem) PriceInLowestUnit() uint64 {
 	return i.Price + i.AddonPrice
 }
 
+// CostInLowestUnit implements part of the calculator.Item interface.
+func (i *LineItem) CostInLowestUnit() uint64 {
+	return i.Cost
+}
+
 // ProductType implements part of the calculator.Item interface.
 func (i *LineItem) ProductType() string {
 	return i.Type
@@ -230,6 +337,11 @@ func (i *LineItem) FixedVAT() uint64 {
 	return i.VAT
 }
 
+// ProductTaxCode implements part of the calculator.Item interface.
+func (i *LineItem) ProductTaxCode() string {
+	return i.TaxCode
+}
+
 // TaxableItems implements part of the calculator.Item interface.
 func (i *LineItem) TaxableItems() []calculator.Item {
 	if i.PriceItems != nil {
@@ -252,8 +364,57 @@ func (i *LineItem) Process(userClaims map[string]interface{}, order *Order, meta
 	i.Sku = meta.Sku
 	i.Title = meta.Title
 	i.Description = meta.Description
+	i.Image = meta.Image
 	i.VAT = meta.VAT
+	i.TaxCode = meta.TaxCode
 	i.Type = meta.Type
+	i.MaxQuantityPerOrder = meta.MaxQuantityPerOrder
+	i.MaxPerCustomer = meta.MaxPerCustomer
+	i.LicenseFormat = ""
+	i.LicenseActivationLimit = 0
+	i.MeasureUnit = ""
+	i.MeasurePrecision = 0
+	i.UnitPrice = 0
+	if meta.Measure != nil {
+		i.MeasureUnit = meta.Measure.Unit
+		i.MeasurePrecision = meta.Measure.precision()
+	}
+	i.Weight = 0
+	if meta.Weight > 0 {
+		orderUnit := order.WeightUnit
+		if orderUnit == "" {
+			orderUnit = DefaultWeightUnit
+		}
+		unit := meta.WeightUnit
+		if unit == "" {
+			unit = orderUnit
+		}
+		weight, err := ConvertWeight(meta.Weight, unit, orderUnit)
+		if err != nil {
+			return fmt.Errorf("Invalid weight for item %v: %v", i.Sku, err)
+		}
+		i.Weight = weight
+	}
+	if meta.License != nil {
+		i.LicenseFormat = meta.License.Format
+		if i.LicenseFormat == "" {
+			i.LicenseFormat = DefaultLicenseFormat
+		}
+		i.LicenseActivationLimit = meta.License.ActivationLimit
+	}
+
+	switch meta.FulfillmentType {
+	case PhysicalFulfillment, DigitalFulfillment, ServiceFulfillment:
+		i.FulfillmentType = meta.FulfillmentType
+	case "":
+		i.FulfillmentType = PhysicalFulfillment
+		if len(meta.Downloads) > 0 || meta.License != nil {
+			i.FulfillmentType = DigitalFulfillment
+		}
+	default:
+		return fmt.Errorf("Unknown fulfillment type %v for item %v", meta.FulfillmentType, i.Sku)
+	}
+	i.FulfillmentState = PendingState
 
 	for index, addon := range i.AddonItems {
 		var metaAddon *AddonMetaItem
@@ -277,9 +438,10 @@ func (i *LineItem) Process(userClaims map[string]interface{}, order *Order, meta
 		i.AddonItems[index].Price = lowestPrice.cents
 	}
 
+	i.downloads = nil
 	for _, download := range meta.Downloads {
 		alreadyCreated := false
-		for _, d := range order.Downloads {
+		for _, d := range i.downloads {
 			if d.URL == download.URL {
 				alreadyCreated = true
 				break
@@ -292,18 +454,35 @@ func (i *LineItem) Process(userClaims map[string]interface{}, order *Order, meta
 		download.OrderID = order.ID
 		download.Title = i.Title
 		download.Sku = i.Sku
-		order.Downloads = append(order.Downloads, download)
+		i.downloads = append(i.downloads, download)
 	}
 
 	return i.calculatePrice(userClaims, meta.Prices, order.Currency)
 }
 
+// IssueDownloads adds the downloads of a saved LineItem to its order. Every
+// line item gets its own downloads, even if several lines share a SKU.
+func (i *LineItem) IssueDownloads(order *Order) {
+	for _, download := range i.downloads {
+		download.LineItemID = i.ID
+		order.Downloads = append(order.Downloads, download)
+	}
+}
+
 func (i *LineItem) calculatePrice(userClaims map[string]interface{}, prices []PriceMetadata, currency string) error {
 	lowestPrice, err := determineLowestPrice(userClaims, prices, currency)
 	if err != nil {
 		return err
 	}
 	i.Price = lowestPrice.cents
+	i.Cost = 0
+	if lowestPrice.Cost != "" {
+		cost, err := strconv.ParseFloat(lowestPrice.Cost, 64)
+		if err != nil {
+			return err
+		}
+		i.Cost = uint64(cost * 100)
+	}
 	i.PriceItems = make([]*PriceItem, len(lowestPrice.Items))
 	for index, item := range lowestPrice.Items {
 		amount, err := strconv.ParseFloat(item.Amount, 64)
@@ -312,6 +491,14 @@ func (i *LineItem) calculatePrice(userClaims map[string]interface{}, prices []Pr
 		}
 		i.PriceItems[index] = &PriceItem{Amount: uint64(amount * 100), Type: item.Type, VAT: item.VAT}
 	}
+	if i.MeasureUnit != "" && i.Measure > 0 {
+		i.UnitPrice = i.Price
+		i.Price = priceForMeasure(i.UnitPrice, i.Measure)
+		i.Cost = priceForMeasure(i.Cost, i.Measure)
+		for _, item := range i.PriceItems {
+			item.Amount = priceForMeasure(item.Amount, i.Measure)
+		}
+	}
 	for _, addon := range i.AddonItems {
 		i.AddonPrice += addon.Price
 	}
@@ -340,3 +527,29 @@ func determineLowestPrice(userClaims map[string]interface{}, prices []PriceMetad
 	}
 	return lowestPrice, nil
 }
+
+// PurchasedQuantity sums up how many units of a product the customer of an
+// order bought in their other orders since a point in time. Customers are
+// identified by their user ID, or by their email for guest orders. Failed
+// orders aren't counted.
+func PurchasedQuantity(db *gorm.DB, order *Order, sku string, since time.Time) (uint64, error) {
+	ordersTable := db.NewScope(Order{}).QuotedTableName()
+	itemsTable := db.NewScope(LineItem{}).QuotedTableName()
+
+	query := db.Model(&LineItem{}).
+		Select("coalesce(sum("+itemsTable+".quantity), 0)").
+		Joins("JOIN "+ordersTable+" ON "+ordersTable+".id = "+itemsTable+".order_id").
+		Where(itemsTable+".sku = ? AND "+ordersTable+".id <> ? AND "+ordersTable+".instance_id = ?", sku, order.ID, order.InstanceID).
+		Where(ordersTable+".payment_state <> ? AND "+ordersTable+".created_at > ?", FailedState, since)
+	if order.UserID != "" {
+		query = query.Where(ordersTable+".user_id = ?", order.UserID)
+	} else {
+		query = query.Where(ordersTable+".email = ?", order.Email)
+	}
+
+	var quantity uint64
+	if err := query.Row().Scan(&quantity); err != nil {
+		return 0, err
+	}
+	return quantity, nil
+}
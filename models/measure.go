@@ -0,0 +1,42 @@
+package models
+
+import (
+	"math"
+	"strconv"
+)
+
+// DefaultMeasurePrecision is the number of decimals a measure can have if
+// the product doesn't set a precision.
+const DefaultMeasurePrecision = 3
+
+// MeasureMetadata marks a product as sold by measure, e.g. by weight or
+// length. Its prices are per unit of measure.
+type MeasureMetadata struct {
+	Unit      string `json:"unit"`
+	Precision *int   `json:"precision"`
+}
+
+// precision returns the number of decimals a measure of the product can have.
+func (m *MeasureMetadata) precision() int {
+	if m.Precision == nil || *m.Precision < 0 {
+		return DefaultMeasurePrecision
+	}
+	return *m.Precision
+}
+
+// MeasurePrecise checks if a measure has at most the given number of decimals.
+func MeasurePrecise(measure float64, precision int) bool {
+	scaled := measure * math.Pow10(precision)
+	return math.Abs(scaled-math.Round(scaled)) < 1e-6
+}
+
+// FormatMeasure formats a measure with its unit, e.g. "1.5 kg".
+func FormatMeasure(measure float64, unit string) string {
+	return strconv.FormatFloat(measure, 'f', -1, 64) + " " + unit
+}
+
+// priceForMeasure prices a measure of a product with the given price per
+// unit, rounded to the nearest cent.
+func priceForMeasure(unitPrice uint64, measure float64) uint64 {
+	return uint64(math.Round(float64(unitPrice) * measure))
+}
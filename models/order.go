@@ -24,14 +24,46 @@ const ShippingState = "shipping"
 // ShippedState is the shipped state of an Order
 const ShippedState = "shipped"
 
+// FulfilledState is the fulfillment state of an Order or LineItem that has
+// been delivered completely
+const FulfilledState = "fulfilled"
+
+// PartiallyFulfilledState is the fulfillment state of an Order with some but
+// not all of its line items fulfilled
+const PartiallyFulfilledState = "partially_fulfilled"
+
+// DeliveredState is the fulfillment state of a LineItem that reached the
+// customer
+const DeliveredState = "delivered"
+
+// ReturnedState is the fulfillment state of a LineItem the customer sent
+// back, and of an Order with all of its line items returned
+const ReturnedState = "returned"
+
 // FailedState is the failed state of an Order
 const FailedState = "failed"
 
+// VoidedState is the payment state of an Order, and the status of its
+// Transaction, whose authorized payment was released without capturing it
+const VoidedState = "voided"
+
+// AwaitingPaymentState is the payment state of an Order with a manual payment
+// that hasn't been confirmed yet
+const AwaitingPaymentState = "awaiting_payment"
+
+// OnHoldState is the fulfillment state of a paid Order waiting for review
+const OnHoldState = "on_hold"
+
+// RejectedState is the fulfillment state of an Order rejected during review
+const RejectedState = "rejected"
+
 // PaymentState are the possible values for the PaymentState field
 var PaymentStates = []string{
 	PendingState,
+	AwaitingPaymentState,
 	PaidState,
 	FailedState,
+	VoidedState,
 }
 
 // FulfillmentStates are the possible values for the FulfillmentState field
@@ -39,8 +71,32 @@ var FulfillmentStates = []string{
 	PendingState,
 	ShippingState,
 	ShippedState,
+	PartiallyFulfilledState,
+	FulfilledState,
+	ReturnedState,
+	OnHoldState,
+	RejectedState,
 }
 
+// DeliverableAddress is the address verification of an Order with a shipping
+// address the verifier accepted
+const DeliverableAddress = "deliverable"
+
+// UndeliverableAddress is the address verification of an Order with a
+// shipping address the verifier flagged
+const UndeliverableAddress = "undeliverable"
+
+// MaxAttributionLength is the maximum length of the source, medium and
+// campaign of an Attribution
+const MaxAttributionLength = 255
+
+// MaxReferrerLength is the maximum length of the referrer of an Attribution
+const MaxReferrerLength = 1024
+
+// MaxUserAgentLength is the maximum length of the user agent stored with an
+// Order, longer ones are truncated
+const MaxUserAgentLength = 500
+
 // NumberType | StringType | BoolType are the different types supported in custom data for orders
 const (
 	NumberType = iota
@@ -54,7 +110,8 @@ type Order struct {
 	ID            string `json:"id"`
 	InvoiceNumber int64  `json:"invoice_number,omitempty"`
 
-	IP string `json:"ip"`
+	IP        string `json:"ip" visibility:"admin"`
+	UserAgent string `json:"user_agent,omitempty" sql:"size:500" visibility:"admin"`
 
 	User      *User  `json:"user,omitempty"`
 	UserID    string `json:"user_id,omitempty"`
@@ -65,6 +122,9 @@ type Order struct {
 	LineItems []*LineItem `json:"line_items"`
 
 	Downloads []Download `json:"downloads"`
+	Licenses  []License  `json:"licenses"`
+
+	SerialNumbers []SerialNumber `json:"serial_numbers"`
 
 	Currency string `json:"currency"`
 	Taxes    uint64 `json:"taxes"`
@@ -73,23 +133,74 @@ type Order struct {
 	Discount uint64 `json:"discount"`
 	NetTotal uint64 `json:"net_total"`
 
+	// DiscountCapped is set if the discount of any line item was limited by
+	// the discount floor of the settings.
+	DiscountCapped bool `json:"discount_capped"`
+
+	// Tip is a gratuity on top of the net total, kept out of the subtotal.
+	Tip uint64 `json:"tip"`
+
 	Total uint64 `json:"total"`
 
+	// TotalWeight is the weight of all line items in WeightUnit.
+	TotalWeight float64 `json:"total_weight"`
+	WeightUnit  string  `json:"weight_unit"`
+
+	TaxOverride       *uint64 `json:"tax_override,omitempty"`
+	TaxOverrideReason string  `json:"tax_override_reason,omitempty" visibility:"admin"`
+
+	// TaxBreakdown itemizes the taxes by jurisdiction. It's empty when the
+	// taxes have been overridden.
+	TaxBreakdown    []calculator.TaxItem `json:"tax_breakdown" sql:"-"`
+	RawTaxBreakdown string               `json:"-" sql:"type:text"`
+
 	PaymentState     string `json:"payment_state"`
 	FulfillmentState string `json:"fulfillment_state"`
 	State            string `json:"state"`
 
 	PaymentProcessor string `json:"payment_processor"`
 
+	// PaidAt is when the order was paid, nil for unpaid orders and orders
+	// paid before it was recorded.
+	PaidAt *time.Time `json:"paid_at,omitempty"`
+
+	// PaymentDueAt is when an order awaiting a manual payment is cancelled
+	// unless the payment was confirmed.
+	PaymentDueAt *time.Time `json:"payment_due_at,omitempty"`
+
+	// EstimatedDelivery is the day the shipped items of a paid order are
+	// expected to arrive, nil if no lead time applies.
+	EstimatedDelivery *time.Time `json:"estimated_delivery,omitempty"`
+
+	// ConfirmationSentAt is when the confirmation email of a paid order was
+	// sent. Failed sends are retried at ConfirmationRetryAt.
+	ConfirmationSentAt   *time.Time `json:"confirmation_sent_at,omitempty"`
+	ConfirmationAttempts int        `json:"-"`
+	ConfirmationRetryAt  *time.Time `json:"-"`
+
+	// Archived orders are left out of order listings unless requested.
+	Archived bool `json:"archived" sql:"index"`
+
 	Transactions []*Transaction `json:"transactions"`
-	Notes        []*OrderNote   `json:"notes"`
+	Notes        []*OrderNote   `json:"notes" visibility:"admin"`
 
 	ShippingAddress   Address `json:"shipping_address" gorm:"ForeignKey:ShippingAddressID"`
 	ShippingAddressID string  `json:"shipping_address_id"`
 
+	// AddressVerification is the result of verifying the shipping address
+	// when the order was created, empty if it wasn't verified.
+	AddressVerification        string `json:"address_verification,omitempty"`
+	AddressVerificationMessage string `json:"address_verification_message,omitempty"`
+
 	BillingAddress   Address `json:"billing_address" gorm:"ForeignKey:BillingAddressID"`
 	BillingAddressID string  `json:"billing_address_id"`
 
+	// InferredCountry and InferredCurrency record the defaults inferred from
+	// the request when the order was created without them, empty if the
+	// client set them.
+	InferredCountry  string `json:"inferred_country,omitempty"`
+	InferredCurrency string `json:"inferred_currency,omitempty"`
+
 	VATNumber string `json:"vatnumber"`
 
 	MetaData    map[string]interface{} `sql:"-" json:"meta"`
@@ -97,6 +208,10 @@ type Order struct {
 
 	CouponCode string `json:"coupon_code,omitempty"`
 
+	Attribution Attribution `json:"attribution" gorm:"embedded;embedded_prefix:attribution_"`
+
+	Consent Consent `json:"consent" gorm:"embedded;embedded_prefix:consent_"`
+
 	Coupon    *Coupon `json:"coupon,omitempty" sql:"-"`
 	RawCoupon string  `json:"-" sql:"type:text"`
 
@@ -105,6 +220,34 @@ type Order struct {
 	DeletedAt *time.Time `json:"-" sql:"index"`
 }
 
+// Attribution describes the marketing campaign an Order originated from.
+type Attribution struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Referrer string `json:"referrer,omitempty" sql:"size:1024"`
+}
+
+// Validate checks the lengths of the attribution fields.
+func (a *Attribution) Validate() error {
+	fields := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"source", a.Source, MaxAttributionLength},
+		{"medium", a.Medium, MaxAttributionLength},
+		{"campaign", a.Campaign, MaxAttributionLength},
+		{"referrer", a.Referrer, MaxReferrerLength},
+	}
+	for _, f := range fields {
+		if len(f.value) > f.max {
+			return fmt.Errorf("Attribution %s can't be longer than %d characters", f.name, f.max)
+		}
+	}
+	return nil
+}
+
 // TableName returns the database table name for the Order model.
 func (Order) TableName() string {
 	return tableName("orders")
@@ -125,6 +268,12 @@ func (o *Order) AfterFind() error {
 			return err
 		}
 	}
+	if o.RawTaxBreakdown != "" {
+		err := json.Unmarshal([]byte(o.RawTaxBreakdown), &o.TaxBreakdown)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -145,6 +294,14 @@ func (o *Order) BeforeSave() error {
 		}
 		o.RawCoupon = string(data)
 	}
+	o.RawTaxBreakdown = ""
+	if len(o.TaxBreakdown) > 0 {
+		data, err := json.Marshal(o.TaxBreakdown)
+		if err != nil {
+			return err
+		}
+		o.RawTaxBreakdown = string(data)
+	}
 
 	return nil
 }
@@ -164,29 +321,63 @@ func NewOrder(instanceID, sessionID, email, currency string) *Order {
 	return order
 }
 
-// CalculateTotal calculates the total price of an Order.
-func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]interface{}, log logrus.FieldLogger) {
+// CalculateTotal calculates the total price of an Order. It fails if a line
+// item has a tax code that isn't listed in the tax settings.
+func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]interface{}, log logrus.FieldLogger) error {
 	items := make([]calculator.Item, len(o.LineItems))
 	for i, item := range o.LineItems {
 		items[i] = item
 	}
+	if err := calculator.CheckTaxCodes(settings, items); err != nil {
+		return err
+	}
 
 	params := calculator.PriceParameters{o.ShippingAddress.Country, o.Currency, o.Coupon, items}
 	price := calculator.CalculatePrice(settings, claims, params, log)
 
+	// a manual tax override takes precedence over the calculated taxes
+	if o.TaxOverride != nil {
+		price.Taxes = *o.TaxOverride
+		price.TaxBreakdown = nil
+	} else {
+		shippingTaxes, shippingBreakdown := calculator.CalculateShippingTaxes(settings, o.ShippingAddress.Country, price, o.Shipping)
+		tipTaxes, tipBreakdown := calculator.CalculateTipTaxes(settings, o.ShippingAddress.Country, o.Tip)
+		price.Taxes += shippingTaxes + tipTaxes
+		price.TaxBreakdown = calculator.MergeTaxItems(price.TaxBreakdown, tipBreakdown)
+		price.TaxBreakdown = calculator.MergeTaxItems(price.TaxBreakdown, shippingBreakdown)
+	}
+	price.Total = int64(price.NetTotal + price.Taxes + o.Shipping + o.Tip)
+
 	o.SubTotal = price.Subtotal
 	o.Taxes = price.Taxes
+	o.TaxBreakdown = price.TaxBreakdown
 	o.Discount = price.Discount
+	o.DiscountCapped = price.DiscountCapped
 	o.NetTotal = price.NetTotal
 
+	if o.WeightUnit == "" {
+		o.WeightUnit = DefaultWeightUnit
+	}
+	o.TotalWeight = 0
+	for _, item := range o.LineItems {
+		weight := item.Weight * float64(item.Quantity)
+		// the weight of products sold by measure is per unit of measure
+		if item.MeasureUnit != "" && item.Measure > 0 {
+			weight *= item.Measure
+		}
+		o.TotalWeight += weight
+	}
+	o.TotalWeight = roundWeight(o.TotalWeight)
+
 	// apply price details to line items
 	for i, item := range price.Items {
 		o.LineItems[i].CalculationDetail = &CalculationDetail{
-			Discount: item.Discount,
-			Subtotal: item.Subtotal,
-			NetTotal: item.NetTotal,
-			Taxes:    item.Taxes,
-			Total:    item.Total,
+			Discount:       item.Discount,
+			DiscountCapped: item.DiscountCapped,
+			Subtotal:       item.Subtotal,
+			NetTotal:       item.NetTotal,
+			Taxes:          item.Taxes,
+			Total:          item.Total,
 		}
 
 		for _, discount := range item.DiscountItems {
@@ -197,9 +388,97 @@ func (o *Order) CalculateTotal(settings *calculator.Settings, claims map[string]
 		}
 	}
 
+	o.Total = 0
 	if price.Total > 0 {
 		o.Total = uint64(price.Total)
 	}
+	return nil
+}
+
+// Withheld reports whether the delivery of an order is withheld, because it's
+// held for review or was rejected.
+func (o *Order) Withheld() bool {
+	return o.FulfillmentState == OnHoldState || o.FulfillmentState == RejectedState
+}
+
+// FulfillDigitalItems marks the digital line items of a paid order as
+// fulfilled, since their downloads are available right away. It reports
+// whether the order became fulfilled.
+func (o *Order) FulfillDigitalItems() bool {
+	for _, item := range o.LineItems {
+		if item.FulfillmentType == DigitalFulfillment {
+			item.FulfillmentState = FulfilledState
+		}
+	}
+	return o.UpdateFulfillmentState()
+}
+
+// UpdateFulfillmentState derives the fulfillment state of the order from its
+// line items. The order is fulfilled once all of them are fulfilled or
+// returned, partially fulfilled while only some are and returned once all of
+// them were returned. Orders on hold or rejected keep their state. It reports
+// whether the order became fulfilled.
+func (o *Order) UpdateFulfillmentState() bool {
+	switch o.FulfillmentState {
+	case OnHoldState, RejectedState:
+		return false
+	}
+	if len(o.LineItems) == 0 {
+		return false
+	}
+
+	fulfilled, returned := 0, 0
+	for _, item := range o.LineItems {
+		switch {
+		case item.FulfillmentState == ReturnedState:
+			returned++
+		case item.Fulfilled():
+			fulfilled++
+		}
+	}
+
+	previous := o.FulfillmentState
+	switch {
+	case returned == len(o.LineItems):
+		o.FulfillmentState = ReturnedState
+	case fulfilled+returned == len(o.LineItems):
+		o.FulfillmentState = FulfilledState
+	case fulfilled+returned > 0:
+		o.FulfillmentState = PartiallyFulfilledState
+	default:
+		// the shipping states are set by admins, only derived states are reset
+		switch previous {
+		case PartiallyFulfilledState, FulfilledState, ReturnedState:
+			o.FulfillmentState = PendingState
+		}
+	}
+	return o.FulfillmentState == FulfilledState && previous != FulfilledState
+}
+
+// OverrideTaxes sets an explicit tax amount for the order, which is kept
+// when the total is recalculated.
+func (o *Order) OverrideTaxes(taxes uint64, reason string) {
+	o.TaxOverride = &taxes
+	o.TaxOverrideReason = reason
+	o.Taxes = taxes
+	o.TaxBreakdown = nil
+	o.Total = o.NetTotal + o.Shipping + o.Tip + taxes
+}
+
+// VerifyTotal checks that the total of the order is the sum of its net total,
+// taxes, shipping and tip, and that discounts only lowered the net total below
+// the subtotal. With prices that include taxes discounts are taken off the
+// gross price, so the net total can't be compared with the subtotal less
+// discounts exactly.
+func (o *Order) VerifyTotal() error {
+	expected := o.NetTotal + o.Taxes + o.Shipping + o.Tip
+	if o.Total != expected {
+		return fmt.Errorf("Order total %d doesn't match net total %d + taxes %d + shipping %d + tip %d = %d", o.Total, o.NetTotal, o.Taxes, o.Shipping, o.Tip, expected)
+	}
+	if o.Discount > o.SubTotal || o.NetTotal > o.SubTotal {
+		return fmt.Errorf("Order net total %d doesn't match subtotal %d less discount %d", o.NetTotal, o.SubTotal, o.Discount)
+	}
+	return nil
 }
 
 func (o *Order) BeforeDelete(tx *gorm.DB) error {
@@ -216,6 +495,8 @@ func (o *Order) BeforeDelete(tx *gorm.DB) error {
 		"event":       Event{},
 		"transaction": Transaction{},
 		"download":    Download{},
+		"license":     License{},
+		"serial":      SerialNumber{},
 	}
 	for name, dm := range delModels {
 		if result := tx.Delete(dm, "order_id = ?", o.ID); result.Error != nil {
@@ -224,3 +505,25 @@ func (o *Order) BeforeDelete(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// PendingOrderCount counts the open unpaid orders of the customer of an
+// order, other than the order itself: pending orders created since a point in
+// time and orders awaiting a manual payment that isn't due yet. Customers are
+// identified by their user ID, or by their email for guest orders.
+func PendingOrderCount(db *gorm.DB, order *Order, since time.Time) (int, error) {
+	query := db.Model(&Order{}).
+		Where("id <> ? AND instance_id = ?", order.ID, order.InstanceID).
+		Where("(payment_state = ? AND created_at > ?) OR (payment_state = ? AND payment_due_at > ?)",
+			PendingState, since, AwaitingPaymentState, time.Now())
+	if order.UserID != "" {
+		query = query.Where("user_id = ?", order.UserID)
+	} else {
+		query = query.Where("email = ?", order.Email)
+	}
+
+	count := 0
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
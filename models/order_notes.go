@@ -6,7 +6,8 @@ import "time"
 type OrderNote struct {
 	ID int64 `json:"-"`
 
-	UserID string `json:"user_id"`
+	OrderID string `json:"order_id" sql:"index"`
+	UserID  string `json:"user_id"`
 
 	Text string `json:"text" sql:"type:text"`
 
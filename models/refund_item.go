@@ -0,0 +1,55 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// RefundItem is the part of a refund allocated to a line item of the order,
+// so refunds can be tracked by product. The provider refunds the transaction
+// as a whole.
+type RefundItem struct {
+	ID            int64  `json:"-"`
+	TransactionID string `json:"-"`
+
+	LineItemID int64  `json:"line_item_id"`
+	Sku        string `json:"sku"`
+	Path       string `json:"path"`
+
+	// Quantity is the number of units returned, 0 if only an amount was
+	// refunded.
+	Quantity uint64 `json:"quantity,omitempty"`
+	Amount   uint64 `json:"amount"`
+}
+
+// TableName returns the database table name for the RefundItem model.
+func (RefundItem) TableName() string {
+	return tableName("refund_items")
+}
+
+// RefundedLineItems sums up what the successful refunds of an order
+// allocated to each of its line items, by line item ID.
+func RefundedLineItems(db *gorm.DB, orderID string) (map[int64]*RefundItem, error) {
+	transactionTable := db.NewScope(Transaction{}).QuotedTableName()
+	itemTable := db.NewScope(RefundItem{}).QuotedTableName()
+
+	items := []*RefundItem{}
+	rsp := db.
+		Joins("JOIN "+transactionTable+" ON "+transactionTable+".id = "+itemTable+".transaction_id").
+		Where(transactionTable+".order_id = ? AND "+transactionTable+".type = ? AND "+transactionTable+".status = ?", orderID, RefundTransactionType, PaidState).
+		Find(&items)
+	if rsp.Error != nil {
+		return nil, rsp.Error
+	}
+
+	refunded := map[int64]*RefundItem{}
+	for _, item := range items {
+		sum, ok := refunded[item.LineItemID]
+		if !ok {
+			sum = &RefundItem{LineItemID: item.LineItemID, Sku: item.Sku, Path: item.Path}
+			refunded[item.LineItemID] = sum
+		}
+		sum.Quantity += item.Quantity
+		sum.Amount += item.Amount
+	}
+	return refunded, nil
+}
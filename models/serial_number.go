@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// DefaultSerialNumberFormat is the format of generated serial numbers if the
+// instance doesn't configure one.
+const DefaultSerialNumberFormat = "SN-XXXXXXXXXX"
+
+// SerialNumber is the serial number of a unit of a line item, assigned when
+// the unit is fulfilled. Serial numbers are unique within an instance and
+// never change once assigned, so they can be used for warranty lookups.
+type SerialNumber struct {
+	InstanceID string `json:"-" gorm:"unique_index:idx_serial_number_instance_serial"`
+	ID         string `json:"id"`
+
+	OrderID    string `json:"order_id" sql:"index"`
+	LineItemID int64  `json:"line_item_id"`
+
+	Title  string `json:"title"`
+	Sku    string `json:"sku"`
+	Serial string `json:"serial" gorm:"unique_index:idx_serial_number_instance_serial"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the SerialNumber model.
+func (SerialNumber) TableName() string {
+	return tableName("serial_numbers")
+}
+
+// NewSerialNumber creates a serial number for a unit of a line item.
+func NewSerialNumber(order *Order, item *LineItem, serial string) *SerialNumber {
+	return &SerialNumber{
+		InstanceID: order.InstanceID,
+		ID:         uuid.NewRandom().String(),
+		OrderID:    order.ID,
+		LineItemID: item.ID,
+		Title:      item.Title,
+		Sku:        item.Sku,
+		Serial:     serial,
+	}
+}
+
+// SerialsFor returns the serial numbers assigned to the units of a line item.
+func (o *Order) SerialsFor(item *LineItem) []string {
+	serials := []string{}
+	for _, serial := range o.SerialNumbers {
+		if serial.LineItemID == item.ID {
+			serials = append(serials, serial.Serial)
+		}
+	}
+	return serials
+}
+
+// GenerateSerial generates a random serial number in the given format, which
+// works like the format of license keys.
+func GenerateSerial(format string) (string, error) {
+	return NewLicenseKey(format)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// taxIDFormats are the formats of VAT, GST and business numbers after the
+// country prefix, by prefix. EU VAT numbers use their VIES prefix, e.g. EL for
+// Greece.
+var taxIDFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^\d{9,10}$`),
+	"CY": regexp.MustCompile(`^\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^\d{8,10}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"EE": regexp.MustCompile(`^\d{9}$`),
+	"EL": regexp.MustCompile(`^\d{9}$`),
+	"ES": regexp.MustCompile(`^[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"HR": regexp.MustCompile(`^\d{11}$`),
+	"HU": regexp.MustCompile(`^\d{8}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LT": regexp.MustCompile(`^(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"LV": regexp.MustCompile(`^\d{11}$`),
+	"MT": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"RO": regexp.MustCompile(`^\d{2,10}$`),
+	"SE": regexp.MustCompile(`^\d{12}$`),
+	"SI": regexp.MustCompile(`^\d{8}$`),
+	"SK": regexp.MustCompile(`^\d{10}$`),
+	"XI": regexp.MustCompile(`^(\d{9}|\d{12}|GD\d{3}|HA\d{3})$`),
+
+	"AU": regexp.MustCompile(`^\d{11}$`),
+	"CA": regexp.MustCompile(`^\d{9}(RT\d{4})?$`),
+	"CH": regexp.MustCompile(`^E\d{9}(MWST|TVA|IVA)?$`),
+	"GB": regexp.MustCompile(`^(\d{9}|\d{12}|GD\d{3}|HA\d{3})$`),
+	"IN": regexp.MustCompile(`^\d{2}[A-Z]{5}\d{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`),
+	"NO": regexp.MustCompile(`^\d{9}(MVA)?$`),
+	"NZ": regexp.MustCompile(`^\d{8,9}$`),
+}
+
+// viesCountries are the prefixes of tax IDs that can be checked with the EU's
+// VIES service.
+var viesCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true,
+	"EE": true, "EL": true, "ES": true, "FI": true, "FR": true, "HR": true, "HU": true,
+	"IE": true, "IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true, "XI": true,
+}
+
+var taxIDSeparators = strings.NewReplacer(" ", "", ".", "", "-", "")
+
+// ParseTaxID checks the format of a tax ID prefixed with its country code,
+// like DE123456789 or AU12345678901. Spaces, dots and dashes are ignored. It
+// returns the country prefix.
+func ParseTaxID(taxID string) (string, error) {
+	id := strings.ToUpper(taxIDSeparators.Replace(taxID))
+	if len(id) < 3 {
+		return "", fmt.Errorf("Tax ID %v is too short", taxID)
+	}
+	country := id[:2]
+	format, ok := taxIDFormats[country]
+	if !ok {
+		return "", fmt.Errorf("Tax IDs of country %v are not supported", country)
+	}
+	if !format.MatchString(id[2:]) {
+		return "", fmt.Errorf("Tax ID %v doesn't match the format of country %v", taxID, country)
+	}
+	return country, nil
+}
+
+// VIESTaxID reports if a tax ID with the given country prefix can be checked
+// with the EU's VIES service.
+func VIESTaxID(country string) bool {
+	return viesCountries[country]
+}
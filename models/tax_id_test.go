@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTaxID(t *testing.T) {
+	valid := map[string]string{
+		"DE999999999":          "DE",
+		"de 999 999 999":       "DE",
+		"NL123456789B01":       "NL",
+		"ATU12345678":          "AT",
+		"CHE-123.456.789 MWST": "CH",
+		"AU12345678901":        "AU",
+		"IN27AAPFU0939F1ZV":    "IN",
+	}
+	for taxID, country := range valid {
+		parsed, err := ParseTaxID(taxID)
+		if assert.NoError(t, err, taxID) {
+			assert.Equal(t, country, parsed, taxID)
+		}
+	}
+
+	for _, taxID := range []string{"", "DE", "DE12345", "NL123456789", "XX123456789", "US123456789"} {
+		_, err := ParseTaxID(taxID)
+		assert.Error(t, err, taxID)
+	}
+}
+
+func TestInvoiceReverseCharge(t *testing.T) {
+	invoice := &Invoice{
+		Seller: InvoiceParty{TaxID: "GB123456789"},
+		Buyer:  InvoiceParty{TaxID: "DE999999999"},
+	}
+	invoice.ApplyReverseCharge("Reverse charge")
+	assert.True(t, invoice.ReverseCharge)
+	assert.Equal(t, "Reverse charge", invoice.Note)
+
+	for _, invoice := range []*Invoice{
+		{Seller: InvoiceParty{TaxID: "DE123456789"}, Buyer: InvoiceParty{TaxID: "DE999999999"}},
+		{Seller: InvoiceParty{TaxID: "GB123456789"}, Buyer: InvoiceParty{TaxID: "DE999999999"}, Taxes: 100},
+		{Seller: InvoiceParty{TaxID: "GB123456789"}},
+	} {
+		invoice.ApplyReverseCharge("Reverse charge")
+		assert.False(t, invoice.ReverseCharge)
+		assert.Empty(t, invoice.Note)
+	}
+}
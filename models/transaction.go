@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -13,6 +14,41 @@ const ChargeTransactionType = "charge"
 // RefundTransactionType is the refund transaction type.
 const RefundTransactionType = "refund"
 
+// RefundReason categorizes why a payment was refunded.
+type RefundReason string
+
+const (
+	// RefundReasonDefective is used when the product was defective.
+	RefundReasonDefective RefundReason = "defective"
+	// RefundReasonChangedMind is used when the customer changed their mind.
+	RefundReasonChangedMind RefundReason = "changed_mind"
+	// RefundReasonDuplicate is used when the customer was charged twice.
+	RefundReasonDuplicate RefundReason = "duplicate"
+	// RefundReasonFraudulent is used when the payment was fraudulent.
+	RefundReasonFraudulent RefundReason = "fraudulent"
+	// RefundReasonOther is used for all other refunds.
+	RefundReasonOther RefundReason = "other"
+)
+
+// RefundReasons are all valid reasons for a refund.
+var RefundReasons = []RefundReason{
+	RefundReasonDefective,
+	RefundReasonChangedMind,
+	RefundReasonDuplicate,
+	RefundReasonFraudulent,
+	RefundReasonOther,
+}
+
+// Valid returns whether the refund reason is known.
+func (r RefundReason) Valid() bool {
+	for _, reason := range RefundReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
 // Transaction is an transaction with a payment provider
 type Transaction struct {
 	InstanceID    string `json:"-"`
@@ -35,6 +71,38 @@ type Transaction struct {
 	Status string `json:"status"`
 	Type   string `json:"type"`
 
+	RefundReason RefundReason `json:"refund_reason,omitempty"`
+	// RefundItems allocate a refund to the line items of the order.
+	RefundItems []*RefundItem `json:"refund_items,omitempty" gorm:"foreignkey:TransactionID"`
+
+	// Fee is the processing fee of a refund, negative if fees of the charge
+	// were returned, and NetAmount what the refund cost the shop including
+	// the fee. Both are null if the provider didn't report its fees.
+	Fee       *int64  `json:"fee" visibility:"admin"`
+	NetAmount *uint64 `json:"net_amount" visibility:"admin"`
+
+	// ProviderMetadata holds extra details returned by the payment provider,
+	// namespaced by the provider's name.
+	ProviderMetadata    map[string]interface{} `json:"provider_metadata,omitempty" sql:"-" visibility:"admin"`
+	RawProviderMetadata string                 `json:"-" gorm:"column:provider_metadata" sql:"type:text"`
+
+	// RawResponse is the redacted JSON response of the provider to the
+	// charge. It's only shown to admins.
+	RawResponse string `json:"-" sql:"type:text"`
+
+	// The card the payment was made with, as far as the provider reports it.
+	// Only the last four digits of the card number are stored.
+	CardBrand   string `json:"card_brand,omitempty"`
+	CardLast4   string `json:"card_last4,omitempty"`
+	CardFunding string `json:"card_funding,omitempty"`
+
+	// Instructions tell the customer how to make a pending manual payment.
+	Instructions string `json:"instructions,omitempty" sql:"type:text"`
+
+	// CaptureAt is when a payment that was only authorized is captured. It's
+	// cleared once the payment was captured or voided.
+	CaptureAt *time.Time `json:"capture_at,omitempty"`
+
 	CreatedAt time.Time  `json:"created_at"`
 	DeletedAt *time.Time `json:"-"`
 }
@@ -44,6 +112,52 @@ func (Transaction) TableName() string {
 	return tableName("transactions")
 }
 
+// AfterFind database callback.
+func (t *Transaction) AfterFind() error {
+	if t.RawProviderMetadata != "" {
+		return json.Unmarshal([]byte(t.RawProviderMetadata), &t.ProviderMetadata)
+	}
+	return nil
+}
+
+// BeforeSave database callback.
+func (t *Transaction) BeforeSave() error {
+	if t.ProviderMetadata != nil {
+		data, err := json.Marshal(t.ProviderMetadata)
+		if err != nil {
+			return err
+		}
+		t.RawProviderMetadata = string(data)
+	}
+	return nil
+}
+
+// SetProviderMetadata stores the metadata a provider returned under the
+// provider's name, so fields of different providers can't collide.
+func (t *Transaction) SetProviderMetadata(provider string, metadata map[string]interface{}) {
+	if len(metadata) == 0 {
+		return
+	}
+	if t.ProviderMetadata == nil {
+		t.ProviderMetadata = map[string]interface{}{}
+	}
+	t.ProviderMetadata[provider] = metadata
+}
+
+// SetCardDetails records the card a payment was made with. Anything but the
+// last four digits of the card number is dropped.
+func (t *Transaction) SetCardDetails(brand, last4, funding string) {
+	if len(last4) > 4 {
+		last4 = last4[len(last4)-4:]
+	}
+	if funding == "unknown" {
+		funding = ""
+	}
+	t.CardBrand = brand
+	t.CardLast4 = last4
+	t.CardFunding = funding
+}
+
 // NewTransaction returns a new transaction for an order
 func NewTransaction(order *Order) *Transaction {
 	return &Transaction{
@@ -61,7 +175,7 @@ func NewTransaction(order *Order) *Transaction {
 
 func GetTransaction(db *gorm.DB, id string) (*Transaction, error) {
 	trans := &Transaction{ID: id}
-	if rsp := db.First(trans); rsp.Error != nil {
+	if rsp := db.Preload("RefundItems").First(trans); rsp.Error != nil {
 		if rsp.RecordNotFound() {
 			return nil, nil
 		}
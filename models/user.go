@@ -21,8 +21,9 @@ type User struct {
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `json:"-"`
 
-	OrderCount  int64          `json:"order_count" gorm:"-"`
-	LastOrderAt *HackyNullTime `json:"last_order_at" gorm:"-"`
+	OrderCount    int64          `json:"order_count" gorm:"-"`
+	LifetimeSpend uint64         `json:"lifetime_spend" gorm:"-"`
+	LastOrderAt   *HackyNullTime `json:"last_order_at" gorm:"-"`
 }
 
 // @todo: replace with mysql.NullTime once the tests no longer use SQLite
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// WebhookDelivery records a single attempt to deliver a webhook.
+type WebhookDelivery struct {
+	ID      uint64 `json:"id"`
+	HookID  uint64 `json:"hook_id" sql:"index"`
+	OrderID string `json:"order_id,omitempty" sql:"index"`
+
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	URL     string `json:"url"`
+	Attempt int    `json:"attempt"`
+
+	StatusCode int    `json:"status_code"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty" sql:"type:text"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName returns the database table name for the WebhookDelivery model.
+func (WebhookDelivery) TableName() string {
+	return tableName("webhook_deliveries")
+}
+
+func newWebhookDelivery(h *Hook, resp *http.Response, err error, latency time.Duration) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		HookID:    h.ID,
+		OrderID:   h.OrderID,
+		Type:      h.Type,
+		EventID:   h.EventID,
+		URL:       h.URL,
+		Attempt:   h.Tries,
+		LatencyMs: int64(latency / time.Millisecond),
+	}
+	if resp != nil {
+		delivery.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultWeightUnit is the unit of order weights if none is configured.
+const DefaultWeightUnit = "kg"
+
+// gramsPerUnit are the supported weight units in grams.
+var gramsPerUnit = map[string]float64{
+	"g":  1,
+	"kg": 1000,
+	"oz": 28.349523125,
+	"lb": 453.59237,
+}
+
+// ConvertWeight converts a weight between the units g, kg, oz and lb. The
+// result is rounded to three decimal places.
+func ConvertWeight(weight float64, from, to string) (float64, error) {
+	fromGrams, ok := gramsPerUnit[strings.ToLower(from)]
+	if !ok {
+		return 0, fmt.Errorf("Unknown weight unit %v", from)
+	}
+	toGrams, ok := gramsPerUnit[strings.ToLower(to)]
+	if !ok {
+		return 0, fmt.Errorf("Unknown weight unit %v", to)
+	}
+	return roundWeight(weight * fromGrams / toGrams), nil
+}
+
+func roundWeight(weight float64) float64 {
+	return math.Round(weight*1000) / 1000
+}
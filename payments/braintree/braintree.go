@@ -0,0 +1,254 @@
+package braintree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bt "github.com/braintree-go/braintree-go"
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/pkg/errors"
+	"golang.org/x/text/currency"
+)
+
+type braintreePaymentProvider struct {
+	client           *bt.Braintree
+	merchantAccounts map[string]string
+}
+
+type braintreeBodyParams struct {
+	Nonce string `json:"braintree_nonce"`
+}
+
+// Config contains the Braintree-specific configuration for payment providers.
+type Config struct {
+	MerchantID string `mapstructure:"merchant_id" json:"merchant_id"`
+	PublicKey  string `mapstructure:"public_key" json:"public_key"`
+	PrivateKey string `mapstructure:"private_key" json:"private_key"`
+	Env        string `mapstructure:"env" json:"env"`
+
+	// MerchantAccounts maps currencies to the ID of the merchant account
+	// charging in them. Without one the default merchant account is used.
+	MerchantAccounts map[string]string `mapstructure:"merchant_accounts" json:"merchant_accounts"`
+}
+
+// NewPaymentProvider creates a new Braintree payment provider using the provided configuration.
+func NewPaymentProvider(config Config) (payments.Provider, error) {
+	if config.MerchantID == "" || config.PublicKey == "" || config.PrivateKey == "" {
+		return nil, errors.New("missing Braintree merchant_id, public_key and/or private_key")
+	}
+
+	var env bt.Environment
+	if config.Env == "production" {
+		env = bt.Production
+	} else if config.Env == "sandbox" {
+		env = bt.Sandbox
+	} else {
+		// used for testing
+		env = bt.NewEnvironment(config.Env)
+	}
+
+	merchantAccounts := map[string]string{}
+	for currency, id := range config.MerchantAccounts {
+		merchantAccounts[strings.ToUpper(strings.TrimSpace(currency))] = id
+	}
+	return &braintreePaymentProvider{
+		client:           bt.New(env, config.MerchantID, config.PublicKey, config.PrivateKey),
+		merchantAccounts: merchantAccounts,
+	}, nil
+}
+
+func (b *braintreePaymentProvider) Name() string {
+	return payments.BraintreeProvider
+}
+
+func (b *braintreePaymentProvider) NewCharger(ctx context.Context, r *http.Request) (payments.Charger, error) {
+	var bp braintreeBodyParams
+	bod, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewDecoder(bod).Decode(&bp)
+	if err != nil {
+		return nil, err
+	}
+	if bp.Nonce == "" {
+		return nil, errors.New("Braintree requires a braintree_nonce for creating a payment")
+	}
+
+	capture := !payments.AuthorizeOnly(ctx)
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+		return b.charge(ctx, bp.Nonce, amount, currency, order, invoiceNumber, capture)
+	}, nil
+}
+
+func prepareShippingAddress(addr models.Address) *bt.Address {
+	return &bt.Address{
+		FirstName:       addr.Name,
+		Company:         addr.Company,
+		StreetAddress:   addr.Address1,
+		ExtendedAddress: addr.Address2,
+		Locality:        addr.City,
+		Region:          addr.State,
+		PostalCode:      addr.Zip,
+		CountryName:     addr.Country,
+	}
+}
+
+func (b *braintreePaymentProvider) charge(ctx context.Context, nonce string, amount uint64, currency string, order *models.Order, invoiceNumber int64, capture bool) (*payments.ChargeResult, error) {
+	req := &bt.TransactionRequest{
+		Type:                "sale",
+		Amount:              amountDecimal(amount, currency),
+		PaymentMethodNonce:  nonce,
+		OrderId:             order.ID,
+		PurchaseOrderNumber: fmt.Sprintf("%d", invoiceNumber),
+		ShippingAddress:     prepareShippingAddress(order.ShippingAddress),
+		MerchantAccountId:   b.merchantAccounts[strings.ToUpper(currency)],
+		Options: &bt.TransactionOptions{
+			SubmitForSettlement: capture,
+		},
+	}
+	// the client of the order is checked by the fraud protection
+	if order.IP != "" || order.UserAgent != "" {
+		req.RiskData = &bt.RiskDataRequest{CustomerIP: order.IP, CustomerBrowser: order.UserAgent}
+	}
+	tx, err := b.client.Transaction().Create(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.CurrencyISOCode != "" && tx.CurrencyISOCode != currency {
+		// the sale went through in the wrong currency, release it before
+		// failing the payment
+		if _, err := b.client.Transaction().Void(ctx, tx.Id); err != nil {
+			return nil, errors.Wrapf(err, "The Braintree merchant account charged in %v, but the order is in %v, and voiding transaction %s failed", tx.CurrencyISOCode, currency, tx.Id)
+		}
+		return nil, fmt.Errorf("The Braintree merchant account charged in %v, but the order is in %v", tx.CurrencyISOCode, currency)
+	}
+
+	metadata := map[string]interface{}{
+		"processor_response_code":     tx.ProcessorResponseCode.Int(),
+		"processor_response_text":     tx.ProcessorResponseText,
+		"avs_postal_code_response":    string(tx.AVSPostalCodeResponseCode),
+		"avs_street_address_response": string(tx.AVSStreetAddressResponseCode),
+		"cvv_response":                string(tx.CVVResponseCode),
+	}
+	if tx.RiskData != nil {
+		metadata["risk_id"] = tx.RiskData.ID
+		metadata["risk_decision"] = tx.RiskData.Decision
+	}
+	result := &payments.ChargeResult{ID: tx.Id, Metadata: metadata, Response: tx, Authorized: !capture}
+	if card := tx.CreditCard; card != nil {
+		result.Card = &payments.CardDetails{
+			Brand:   card.CardType,
+			Last4:   card.Last4,
+			Funding: braintreeCardFunding(card),
+		}
+	}
+	return result, nil
+}
+
+// amountDecimal converts an amount in the smallest unit of a currency to the
+// decimal Braintree expects, e.g. 1250 USD is 12.50 and 1250 JPY stays 1250.
+// Unknown currencies are assumed to have cents.
+func amountDecimal(amount uint64, cur string) *bt.Decimal {
+	unit, err := currency.ParseISO(cur)
+	if err != nil {
+		return bt.NewDecimal(int64(amount), 2)
+	}
+	scale, _ := currency.Standard.Rounding(unit)
+	return bt.NewDecimal(int64(amount), scale)
+}
+
+// AuthorizationExpiry is how long Braintree holds authorized transactions
+// for most merchant accounts.
+func (b *braintreePaymentProvider) AuthorizationExpiry() time.Duration {
+	return 7 * 24 * time.Hour
+}
+
+// Capture submits an authorized transaction for settlement.
+func (b *braintreePaymentProvider) Capture(ctx context.Context, transactionID string, amount uint64, currency string) error {
+	_, err := b.client.Transaction().SubmitForSettlement(ctx, transactionID, amountDecimal(amount, currency))
+	return err
+}
+
+// Void releases an authorized transaction.
+func (b *braintreePaymentProvider) Void(ctx context.Context, transactionID string) error {
+	_, err := b.client.Transaction().Void(ctx, transactionID)
+	return err
+}
+
+// braintreeCardFunding maps the debit and prepaid indicators of a card to its
+// funding type. Braintree reports "Unknown" if it can't tell.
+func braintreeCardFunding(card *bt.CreditCard) string {
+	switch {
+	case card.Prepaid == "Yes":
+		return "prepaid"
+	case card.Debit == "Yes":
+		return "debit"
+	case card.Debit == "No":
+		return "credit"
+	}
+	return ""
+}
+
+func (b *braintreePaymentProvider) NewRefunder(ctx context.Context, r *http.Request) (payments.Refunder, error) {
+	return func(transactionID string, amount uint64, currency string, reason models.RefundReason) (*payments.RefundResult, error) {
+		id, err := b.refund(ctx, transactionID, amount, currency)
+		if err != nil {
+			return nil, err
+		}
+		// Braintree doesn't report the fees of refunds
+		return &payments.RefundResult{ID: id}, nil
+	}, nil
+}
+
+// refund refunds a settled transaction. Braintree does not allow refunds
+// before a transaction has settled, so those are voided instead, which is
+// only possible for the full amount.
+func (b *braintreePaymentProvider) refund(ctx context.Context, transactionID string, amount uint64, currency string) (string, error) {
+	tx, err := b.client.Transaction().Find(ctx, transactionID)
+	if err != nil {
+		return "", err
+	}
+
+	switch tx.Status {
+	case bt.TransactionStatusAuthorized, bt.TransactionStatusSubmittedForSettlement:
+		if tx.Amount == nil || tx.Amount.Cmp(amountDecimal(amount, currency)) != 0 {
+			return "", errors.New("Braintree transactions can only be voided in full before they settle")
+		}
+		voided, err := b.client.Transaction().Void(ctx, transactionID)
+		if err != nil {
+			return "", err
+		}
+		return voided.Id, nil
+	}
+
+	ref, err := b.client.Transaction().Refund(ctx, transactionID, amountDecimal(amount, currency))
+	if err != nil {
+		return "", err
+	}
+	return ref.Id, nil
+}
+
+func (b *braintreePaymentProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (payments.Preauthorizer, error) {
+	return func(amount uint64, currency string, description string) (*payments.PreauthorizationResult, error) {
+		return b.preauthorize(ctx)
+	}, nil
+}
+
+// preauthorize generates a client token used by the Braintree drop-in UI
+// to tokenize a payment method into a nonce.
+func (b *braintreePaymentProvider) preauthorize(ctx context.Context) (*payments.PreauthorizationResult, error) {
+	token, err := b.client.ClientToken().Generate(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating braintree client token")
+	}
+	return &payments.PreauthorizationResult{
+		ClientToken: token,
+	}, nil
+}
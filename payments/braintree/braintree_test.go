@@ -0,0 +1,77 @@
+package braintree
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAmountDecimal(t *testing.T) {
+	cases := []struct {
+		amount   uint64
+		currency string
+		expected string
+	}{
+		{1250, "USD", "12.50"},
+		{1250, "eur", "12.50"},
+		{1250, "JPY", "1250"},
+		{1250, "BHD", "1.250"},
+		{1250, "monopoly-dollars", "12.50"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, amountDecimal(c.amount, c.currency).String(), c.currency)
+	}
+}
+
+func TestChargeCurrency(t *testing.T) {
+	var created string
+	var voided []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/xml")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/merchants/merchantid/transactions":
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			created = string(body)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `<transaction><id>tx-1</id><status>submitted_for_settlement</status><amount>10.00</amount><currency-iso-code>EUR</currency-iso-code></transaction>`)
+		case r.Method == http.MethodPut && r.URL.Path == "/merchants/merchantid/transactions/tx-1/void":
+			voided = append(voided, "tx-1")
+			fmt.Fprint(w, `<transaction><id>tx-1</id><status>voided</status><amount>10.00</amount><currency-iso-code>EUR</currency-iso-code></transaction>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			t.Errorf("unknown Braintree API call to %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewPaymentProvider(Config{
+		MerchantID:       "merchantid",
+		PublicKey:        "publickey",
+		PrivateKey:       "privatekey",
+		Env:              server.URL,
+		MerchantAccounts: map[string]string{"eur": "shop_eur", "USD": "shop_usd"},
+	})
+	require.NoError(t, err)
+	b := provider.(*braintreePaymentProvider)
+	order := &models.Order{ID: "order-1"}
+
+	result, err := b.charge(context.Background(), "nonce", 1000, "EUR", order, 1, true)
+	require.NoError(t, err)
+	assert.Equal(t, "tx-1", result.ID)
+	assert.Contains(t, created, "<merchant-account-id>shop_eur</merchant-account-id>")
+	assert.Contains(t, created, "<amount>10.00</amount>")
+	assert.Empty(t, voided)
+
+	_, err = b.charge(context.Background(), "nonce", 1000, "USD", order, 1, true)
+	require.Error(t, err)
+	assert.Contains(t, created, "<merchant-account-id>shop_usd</merchant-account-id>")
+	assert.Equal(t, []string{"tx-1"}, voided, "sales in the wrong currency are voided")
+}
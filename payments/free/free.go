@@ -0,0 +1,41 @@
+package free
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/pkg/errors"
+)
+
+type freePaymentProvider struct{}
+
+// NewPaymentProvider creates a provider for orders with a total of zero,
+// which are marked as paid without charging anything.
+func NewPaymentProvider() payments.Provider {
+	return &freePaymentProvider{}
+}
+
+func (f *freePaymentProvider) Name() string {
+	return payments.FreeProvider
+}
+
+// NewCharger returns a charger that accepts zero amounts without contacting
+// any payment provider.
+func (f *freePaymentProvider) NewCharger(ctx context.Context, r *http.Request) (payments.Charger, error) {
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+		if amount != 0 {
+			return nil, errors.Errorf("Only free orders can be paid without a payment provider, got an amount of %d", amount)
+		}
+		return &payments.ChargeResult{}, nil
+	}, nil
+}
+
+func (f *freePaymentProvider) NewRefunder(ctx context.Context, r *http.Request) (payments.Refunder, error) {
+	return nil, errors.New("Free orders have nothing to refund")
+}
+
+func (f *freePaymentProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (payments.Preauthorizer, error) {
+	return nil, errors.New("Free orders can't be preauthorized")
+}
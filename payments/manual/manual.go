@@ -0,0 +1,67 @@
+package manual
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/netlify/gocommerce/models"
+	"github.com/netlify/gocommerce/payments"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+type manualPaymentProvider struct {
+	instructions string
+}
+
+// Config contains the configuration for manual payments.
+type Config struct {
+	// Instructions tell customers how to pay, e.g. the bank account to
+	// transfer the money to. {order_id} and {invoice_number} are replaced
+	// with the values of the order.
+	Instructions string
+}
+
+// NewPaymentProvider creates a provider for payments that are made outside
+// of GoCommerce, like bank transfers, and confirmed by an admin.
+func NewPaymentProvider(config Config) (payments.Provider, error) {
+	if config.Instructions == "" {
+		return nil, errors.New("missing instructions for manual payments")
+	}
+	return &manualPaymentProvider{
+		instructions: config.Instructions,
+	}, nil
+}
+
+func (m *manualPaymentProvider) Name() string {
+	return payments.ManualProvider
+}
+
+// NewCharger returns a charger that doesn't charge anything, but leaves the
+// payment pending with the instructions for the customer.
+func (m *manualPaymentProvider) NewCharger(ctx context.Context, r *http.Request) (payments.Charger, error) {
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+		instructions := strings.NewReplacer(
+			"{order_id}", order.ID,
+			"{invoice_number}", strconv.FormatInt(invoiceNumber, 10),
+		).Replace(m.instructions)
+		return &payments.ChargeResult{
+			Pending:      true,
+			Instructions: instructions,
+		}, nil
+	}, nil
+}
+
+// NewRefunder returns a refunder that only records refunds, the money has to
+// be transferred back by hand.
+func (m *manualPaymentProvider) NewRefunder(ctx context.Context, r *http.Request) (payments.Refunder, error) {
+	return func(transactionID string, amount uint64, currency string, reason models.RefundReason) (*payments.RefundResult, error) {
+		return &payments.RefundResult{ID: uuid.NewRandom().String()}, nil
+	}, nil
+}
+
+func (m *manualPaymentProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (payments.Preauthorizer, error) {
+	return nil, errors.New("Manual payments can't be preauthorized")
+}
@@ -2,7 +2,11 @@ package payments
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/netlify/gocommerce/models"
 )
@@ -12,8 +16,20 @@ const (
 	StripeProvider = "stripe"
 	// PayPalProvider is the string identifier for the PayPal payment provider.
 	PayPalProvider = "paypal"
+	// BraintreeProvider is the string identifier for the Braintree payment provider.
+	BraintreeProvider = "braintree"
+	// ManualProvider is the string identifier for payments confirmed by an
+	// admin, like bank transfers.
+	ManualProvider = "manual"
+	// FreeProvider is the string identifier for orders with a total of
+	// zero, which are paid without a payment provider.
+	FreeProvider = "free"
 )
 
+// DefaultRetryAfter is how long to back off from a provider that rate limited
+// a request without saying for how long.
+const DefaultRetryAfter = 30 * time.Second
+
 // Provider represents a payment provider that can optionally charge, refund,
 // preauthorize payments.
 type Provider interface {
@@ -23,11 +39,84 @@ type Provider interface {
 	NewPreauthorizer(ctx context.Context, r *http.Request) (Preauthorizer, error)
 }
 
+// ChargeResult is the outcome of a successful charge.
+type ChargeResult struct {
+	ID string
+	// Metadata holds additional details the provider returned about the
+	// charge, like risk scores or network transaction IDs.
+	Metadata map[string]interface{}
+	// Card describes the card that was charged. It's nil if the provider
+	// didn't report it, e.g. for PayPal payments.
+	Card *CardDetails
+	// Response is the charge object the provider returned. It's stored with
+	// the transaction after RedactResponse removed sensitive fields.
+	Response interface{}
+	// Pending is set if the payment still has to be made, e.g. by bank
+	// transfer. The order awaits the payment until an admin confirms it.
+	Pending bool
+	// Instructions tell the customer how to make a pending payment.
+	Instructions string
+	// Authorized is set if the payment was only authorized. It's collected
+	// once it's captured with the provider's Capturer.
+	Authorized bool
+}
+
+// CardDetails holds what a provider reports about a charged card. Fields the
+// provider didn't return are empty.
+type CardDetails struct {
+	Brand   string
+	Last4   string
+	Funding string
+}
+
 // Charger wraps the Charge method which creates new payments with the provider.
-type Charger func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error)
+type Charger func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*ChargeResult, error)
+
+// RefundResult is the outcome of a successful refund.
+type RefundResult struct {
+	ID string
+	// Fees are the processing fees of the refund. It's nil if the provider
+	// didn't report them.
+	Fees *RefundFees
+}
+
+// RefundFees describe what a refund cost the shop as reported by the provider,
+// in the lowest unit of the currency.
+type RefundFees struct {
+	// Fee is the processing fee the provider charged for the refund. It's
+	// negative if fees of the charge were returned.
+	Fee int64
+	// Net is what the refund cost the shop, including the fee.
+	Net uint64
+}
 
 // Refunder wraps the Refund method which refunds payments with the provider.
-type Refunder func(transactionID string, amount uint64, currency string) (string, error)
+// Providers that support categorizing refunds pass the reason along.
+type Refunder func(transactionID string, amount uint64, currency string, reason models.RefundReason) (*RefundResult, error)
+
+// Capturer is implemented by providers that can authorize payments and
+// capture them later, e.g. after the order was reviewed.
+type Capturer interface {
+	// AuthorizationExpiry is how long the provider holds an authorized
+	// payment before it's released.
+	AuthorizationExpiry() time.Duration
+	Capture(ctx context.Context, transactionID string, amount uint64, currency string) error
+	Void(ctx context.Context, transactionID string) error
+}
+
+type authorizeOnlyKey struct{}
+
+// WithAuthorizeOnly makes the chargers of providers implementing Capturer
+// that are created with the returned context only authorize payments.
+func WithAuthorizeOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, authorizeOnlyKey{}, true)
+}
+
+// AuthorizeOnly checks if payments should only be authorized.
+func AuthorizeOnly(ctx context.Context) bool {
+	authorizeOnly, _ := ctx.Value(authorizeOnlyKey{}).(bool)
+	return authorizeOnly
+}
 
 // Preauthorizer wraps the Preauthorize method which pre-authorizes a payment
 // with the provider.
@@ -35,5 +124,91 @@ type Preauthorizer func(amount uint64, currency string, description string) (*Pr
 
 // PreauthorizationResult contains the data returned from a Preauthorization.
 type PreauthorizationResult struct {
-	ID string `json:"id"`
+	ID          string `json:"id,omitempty"`
+	ClientToken string `json:"client_token,omitempty"`
+}
+
+// RateLimitError is returned when the provider rejected a request because too
+// many requests were made. RetryAfter is how long the provider asked to wait
+// before trying again.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+// NewRateLimitError wraps an error of a rate limited request. retryAfter is
+// the provider's Retry-After header, DefaultRetryAfter is used if it's missing
+// or malformed.
+func NewRateLimitError(err error, retryAfter string) *RateLimitError {
+	e := &RateLimitError{RetryAfter: DefaultRetryAfter, Err: err}
+	retryAfter = strings.TrimSpace(retryAfter)
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		e.RetryAfter = time.Until(date)
+		if e.RetryAfter < 0 {
+			e.RetryAfter = 0
+		}
+	}
+	return e
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Err.Error()
+}
+
+// redactedFields are the fields of provider responses that must not be
+// stored, compared in lower case without underscores to match both JSON keys
+// and Go field names.
+var redactedFields = map[string]bool{
+	"number":             true,
+	"cvc":                true,
+	"cvv":                true,
+	"clientsecret":       true,
+	"fingerprint":        true,
+	"token":              true,
+	"nonce":              true,
+	"paymentmethodnonce": true,
+	"accesstoken":        true,
+	"email":              true,
+	"phone":              true,
+}
+
+// RedactedValue replaces the values of redacted fields.
+const RedactedValue = "[REDACTED]"
+
+// RedactResponse serializes a provider response to JSON with the values of
+// sensitive fields like card numbers, secrets and contact details replaced.
+func RedactResponse(response interface{}) (string, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(redact(v))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value != nil && redactedFields[strings.ToLower(strings.Replace(key, "_", "", -1))] {
+				v[key] = RedactedValue
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
 }
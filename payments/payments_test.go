@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactResponse(t *testing.T) {
+	response := map[string]interface{}{
+		"id":            "ch_123",
+		"client_secret": "pi_123_secret",
+		"billing": map[string]interface{}{
+			"Email": "bruce@wayneindustries.com",
+			"city":  "Gotham",
+		},
+		"cards": []interface{}{
+			map[string]interface{}{"last4": "4242", "CVV": "123", "fingerprint": nil},
+		},
+	}
+	raw, err := RedactResponse(response)
+	require.NoError(t, err)
+
+	redacted := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &redacted))
+	assert.Equal(t, map[string]interface{}{
+		"id":            "ch_123",
+		"client_secret": RedactedValue,
+		"billing": map[string]interface{}{
+			"Email": RedactedValue,
+			"city":  "Gotham",
+		},
+		"cards": []interface{}{
+			map[string]interface{}{"last4": "4242", "CVV": RedactedValue, "fingerprint": nil},
+		},
+	}, redacted)
+}
@@ -90,8 +90,9 @@ func (p *paypalPaymentProvider) NewCharger(ctx context.Context, r *http.Request)
 		return nil, errors.New("Payments requires a paypal_payment_id and paypal_user_id pair")
 	}
 
-	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
-		return p.charge(bp.PaypalID, bp.PaypalUserID, amount, currency, order, invoiceNumber)
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+		result, err := p.charge(bp.PaypalID, bp.PaypalUserID, amount, currency, order, invoiceNumber)
+		return result, rateLimited(err)
 	}, nil
 }
 
@@ -162,60 +163,79 @@ func (p *paypalPaymentProvider) updatePaymentWithOrder(paymentID string, order *
 	return err
 }
 
-func (p *paypalPaymentProvider) charge(paymentID string, userID string, amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
+func (p *paypalPaymentProvider) charge(paymentID string, userID string, amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
 	payment, err := p.client.GetPayment(paymentID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if len(payment.Transactions) != 1 {
-		return "", fmt.Errorf("The paypal payment must have exactly 1 transaction, had %v", len(payment.Transactions))
+		return nil, fmt.Errorf("The paypal payment must have exactly 1 transaction, had %v", len(payment.Transactions))
 	}
 
 	if payment.Transactions[0].Amount == nil {
-		return "", fmt.Errorf("No amount in this transaction %v", payment.Transactions[0])
+		return nil, fmt.Errorf("No amount in this transaction %v", payment.Transactions[0])
 	}
 
 	transactionValue := fmt.Sprintf("%.2f", float64(amount)/100)
 
 	if transactionValue != payment.Transactions[0].Amount.Total || payment.Transactions[0].Amount.Currency != currency {
-		return "", fmt.Errorf("The Amount in the transaction doesn't match the amount for the order: %v", payment.Transactions[0].Amount)
+		return nil, fmt.Errorf("The Amount in the transaction doesn't match the amount for the order: %v", payment.Transactions[0].Amount)
 	}
 
 	if err := p.updatePaymentWithOrder(paymentID, order, invoiceNumber); err != nil {
-		return "", errors.Wrap(err, "Updating the PayPal payment with order details failed")
+		return nil, errors.Wrap(err, "Updating the PayPal payment with order details failed")
 	}
 
 	executeResult, err := p.client.ExecuteApprovedPayment(paymentID, userID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return executeResult.ID, nil
+	return &payments.ChargeResult{
+		ID: executeResult.ID,
+		Metadata: map[string]interface{}{
+			"state":          executeResult.State,
+			"payment_method": executeResult.Payer.PaymentMethod,
+			"payer_status":   executeResult.Payer.Status,
+		},
+		Response: executeResult,
+	}, nil
 }
 
 func (p *paypalPaymentProvider) NewRefunder(ctx context.Context, r *http.Request) (payments.Refunder, error) {
 	return p.refund, nil
 }
 
-func (p *paypalPaymentProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
+// refund refunds a sale. PayPal doesn't report the fees of refunds.
+func (p *paypalPaymentProvider) refund(transactionID string, amount uint64, currency string, reason models.RefundReason) (*payments.RefundResult, error) {
 	amt := &paypalsdk.Amount{
 		Total:    formatAmount(amount),
 		Currency: currency,
 	}
 	ref, err := p.client.RefundSale(transactionID, amt)
 	if err != nil {
-		return "", err
+		return nil, rateLimited(err)
 	}
-	return ref.ID, nil
+	return &payments.RefundResult{ID: ref.ID}, nil
 }
 
 func (p *paypalPaymentProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (payments.Preauthorizer, error) {
 	config := gcontext.GetConfig(ctx)
 	return func(amount uint64, currency string, description string) (*payments.PreauthorizationResult, error) {
-		return p.preauthorize(config, amount, currency, description)
+		result, err := p.preauthorize(config, amount, currency, description)
+		return result, rateLimited(err)
 	}, nil
 }
 
+// rateLimited marks errors of requests PayPal rejected with a 429, with how
+// long PayPal asked to wait before trying again.
+func rateLimited(err error) error {
+	if e, ok := errors.Cause(err).(*paypalsdk.ErrorResponse); ok && e.Response != nil && e.Response.StatusCode == http.StatusTooManyRequests {
+		return payments.NewRateLimitError(err, e.Response.Header.Get("Retry-After"))
+	}
+	return err
+}
+
 func (p *paypalPaymentProvider) preauthorize(config *conf.Configuration, amount uint64, currency string, description string) (*payments.PreauthorizationResult, error) {
 	profile, err := p.getExperience()
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"encoding/json"
 
@@ -58,8 +59,9 @@ func (s *stripePaymentProvider) NewCharger(ctx context.Context, r *http.Request)
 		return nil, errors.New("Stripe requires a stripe_token for creating a payment")
 	}
 
-	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
-		return s.charge(bp.StripeToken, amount, currency, order, invoiceNumber)
+	capture := !payments.AuthorizeOnly(ctx)
+	return func(amount uint64, currency string, order *models.Order, invoiceNumber int64) (*payments.ChargeResult, error) {
+		return s.charge(bp.StripeToken, amount, currency, order, invoiceNumber, capture)
 	}, nil
 }
 
@@ -77,45 +79,128 @@ func prepareShippingAddress(addr models.Address) *stripe.ShippingDetailsParams {
 	}
 }
 
-func (s *stripePaymentProvider) charge(token string, amount uint64, currency string, order *models.Order, invoiceNumber int64) (string, error) {
+func (s *stripePaymentProvider) charge(token string, amount uint64, currency string, order *models.Order, invoiceNumber int64, capture bool) (*payments.ChargeResult, error) {
 	stripeAmount := int64(amount)
 	stripeDescription := fmt.Sprintf("Invoice No. %d", invoiceNumber)
-	ch, err := s.client.Charges.New(&stripe.ChargeParams{
+	metadata := map[string]string{
+		"order_id":       order.ID,
+		"invoice_number": fmt.Sprintf("%d", invoiceNumber),
+	}
+	// passed along for Radar rules
+	if order.IP != "" {
+		metadata["customer_ip"] = order.IP
+	}
+	if order.UserAgent != "" {
+		metadata["user_agent"] = order.UserAgent
+	}
+	params := &stripe.ChargeParams{
 		Amount:      &stripeAmount,
 		Source:      &stripe.SourceParams{Token: &token},
 		Currency:    &currency,
 		Description: &stripeDescription,
 		Shipping:    prepareShippingAddress(order.ShippingAddress),
 		Params: stripe.Params{
-			Metadata: map[string]string{
-				"order_id":       order.ID,
-				"invoice_number": fmt.Sprintf("%d", invoiceNumber),
-			},
+			Metadata: metadata,
 		},
-	})
+	}
+	if !capture {
+		params.Capture = stripe.Bool(false)
+	}
+	ch, err := s.client.Charges.New(params)
 
 	if err != nil {
-		return "", err
+		return nil, rateLimited(err)
 	}
 
-	return ch.ID, nil
+	result := &payments.ChargeResult{ID: ch.ID, Response: ch, Authorized: !capture}
+	if ch.Outcome != nil {
+		result.Metadata = map[string]interface{}{
+			"network_status": ch.Outcome.NetworkStatus,
+			"risk_level":     ch.Outcome.RiskLevel,
+			"risk_score":     ch.Outcome.RiskScore,
+			"seller_message": ch.Outcome.SellerMessage,
+			"outcome_type":   ch.Outcome.Type,
+		}
+	}
+	if ch.Source != nil && ch.Source.Card != nil {
+		card := ch.Source.Card
+		result.Card = &payments.CardDetails{
+			Brand:   string(card.Brand),
+			Last4:   card.Last4,
+			Funding: string(card.Funding),
+		}
+	}
+	return result, nil
 }
 
 func (s *stripePaymentProvider) NewRefunder(ctx context.Context, r *http.Request) (payments.Refunder, error) {
 	return s.refund, nil
 }
 
-func (s *stripePaymentProvider) refund(transactionID string, amount uint64, currency string) (string, error) {
+func (s *stripePaymentProvider) refund(transactionID string, amount uint64, currency string, reason models.RefundReason) (*payments.RefundResult, error) {
 	stripeAmount := int64(amount)
-	ref, err := s.client.Refunds.New(&stripe.RefundParams{
+	params := &stripe.RefundParams{
 		Charge: &transactionID,
 		Amount: &stripeAmount,
-	})
+		Reason: stripeRefundReason(reason),
+	}
+	params.AddExpand("balance_transaction")
+	ref, err := s.client.Refunds.New(params)
 	if err != nil {
-		return "", err
+		return nil, rateLimited(err)
 	}
 
-	return ref.ID, err
+	result := &payments.RefundResult{ID: ref.ID}
+	// the balance transaction of a refund debits the shop, so its amounts
+	// are negative
+	if txn := ref.BalanceTransaction; txn != nil && txn.ID != "" {
+		result.Fees = &payments.RefundFees{
+			Fee: txn.Fee,
+			Net: uint64(-txn.Net),
+		}
+	}
+	return result, nil
+}
+
+// AuthorizationExpiry is how long Stripe holds uncaptured charges.
+func (s *stripePaymentProvider) AuthorizationExpiry() time.Duration {
+	return 7 * 24 * time.Hour
+}
+
+// Capture captures an uncaptured charge.
+func (s *stripePaymentProvider) Capture(ctx context.Context, transactionID string, amount uint64, currency string) error {
+	_, err := s.client.Charges.Capture(transactionID, &stripe.CaptureParams{Amount: stripe.Int64(int64(amount))})
+	return rateLimited(err)
+}
+
+// Void releases an uncaptured charge by refunding it, which Stripe does
+// without any fees.
+func (s *stripePaymentProvider) Void(ctx context.Context, transactionID string) error {
+	_, err := s.client.Refunds.New(&stripe.RefundParams{Charge: &transactionID})
+	return rateLimited(err)
+}
+
+// rateLimited marks errors of requests Stripe rejected with a 429. The client
+// doesn't expose response headers, so they are retried after the default
+// back off.
+func rateLimited(err error) error {
+	if e, ok := err.(*stripe.Error); ok && e.HTTPStatusCode == http.StatusTooManyRequests {
+		return payments.NewRateLimitError(err, "")
+	}
+	return err
+}
+
+// stripeRefundReason maps a refund reason to the closest reason Stripe knows.
+func stripeRefundReason(reason models.RefundReason) *string {
+	switch reason {
+	case models.RefundReasonDuplicate:
+		return stripe.String(string(stripe.RefundReasonDuplicate))
+	case models.RefundReasonFraudulent:
+		return stripe.String(string(stripe.RefundReasonFraudulent))
+	case models.RefundReasonDefective, models.RefundReasonChangedMind:
+		return stripe.String(string(stripe.RefundReasonRequestedByCustomer))
+	}
+	return nil
 }
 
 func (s *stripePaymentProvider) NewPreauthorizer(ctx context.Context, r *http.Request) (payments.Preauthorizer, error) {
@@ -0,0 +1,220 @@
+package ssrf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 30 * time.Second
+
+// ForbiddenAddressError is returned when a request would connect to an
+// internal address that isn't allowed.
+type ForbiddenAddressError struct {
+	Host string
+	IP   net.IP
+}
+
+func (e *ForbiddenAddressError) Error() string {
+	if e.IP == nil || e.IP.String() == e.Host {
+		return fmt.Sprintf("Refusing to connect to internal address %s", e.Host)
+	}
+	return fmt.Sprintf("Refusing to connect to internal address %s (%s)", e.IP, e.Host)
+}
+
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Guard validates the destinations of outgoing requests to URLs that are
+// configured by operators or users. Loopback, private, link-local and other
+// internal addresses are rejected unless they're on the allowlist.
+type Guard struct {
+	hosts    map[string]bool
+	networks []*net.IPNet
+	resolver resolver
+	dialer   *net.Dialer
+}
+
+// NewGuard creates a Guard. The allowlist can contain host names, IP
+// addresses and CIDR ranges that may be connected to even if they're internal.
+func NewGuard(allowed []string) *Guard {
+	g := &Guard{
+		hosts:    make(map[string]bool),
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second},
+	}
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			g.networks = append(g.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			g.networks = append(g.networks, singleIPNet(ip))
+			continue
+		}
+		g.hosts[strings.ToLower(strings.TrimSuffix(entry, "."))] = true
+	}
+	return g
+}
+
+func singleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// privateNetworks are the private IPv4 ranges of RFC 1918 and the IPv6 unique
+// local addresses.
+var privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInternal reports whether an IP address belongs to a range that must not be
+// reachable from outgoing requests.
+func IsInternal(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		// 0.0.0.0/8 "this network" and 100.64.0.0/10 shared address space
+		if ip[0] == 0 || (ip[0] == 100 && ip[1]&0xc0 == 64) {
+			return true
+		}
+	}
+	return ip.IsLoopback() ||
+		isPrivate(ip) ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+func (g *Guard) allowedHost(host string) bool {
+	return g.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// AllowedIP reports whether requests may connect to an IP address.
+func (g *Guard) AllowedIP(ip net.IP) bool {
+	if !IsInternal(ip) {
+		return true
+	}
+	for _, network := range g.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve looks up the addresses of a host and fails if any of them isn't
+// allowed, so a host can't mix public and internal addresses.
+func (g *Guard) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := g.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if !g.AllowedIP(addr.IP) {
+			return nil, &ForbiddenAddressError{Host: host, IP: addr.IP}
+		}
+		ips = append(ips, addr.IP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No addresses found for %s", host)
+	}
+	return ips, nil
+}
+
+// ValidateURL checks that a URL uses HTTP or HTTPS and that its host only
+// resolves to allowed addresses.
+func (g *Guard) ValidateURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Unsupported URL scheme '%s'", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("URL %s has no host", rawURL)
+	}
+	if g.allowedHost(host) {
+		return nil
+	}
+	_, err = g.resolve(ctx, host)
+	return err
+}
+
+// DialContext connects to an address after checking the IP addresses its
+// host resolves to. The connection is made to the checked address itself, so
+// a DNS record that changes after validation can't redirect it.
+func (g *Guard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if g.allowedHost(host) {
+		return g.dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Transport creates an HTTP transport that only connects to allowed
+// addresses. Requests through proxies are not supported, since the guard
+// couldn't check their final destination.
+func (g *Guard) Transport() *http.Transport {
+	return &http.Transport{
+		DialContext:           g.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// Client creates an HTTP client that only connects to allowed addresses,
+// including when following redirects.
+func (g *Guard) Client() *http.Client {
+	return &http.Client{Transport: g.Transport()}
+}
@@ -0,0 +1,190 @@
+package ssrf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+func staticResolver(records map[string][]string) resolverFunc {
+	return func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		ips, ok := records[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		addrs := []net.IPAddr{}
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return addrs, nil
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	internal := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "172.31.255.255", "192.168.1.1", "169.254.169.254", "0.0.0.0", "100.64.0.1",
+		"::1", "::", "fe80::1", "fc00::1", "fd12:3456::1", "ff02::1", "::ffff:127.0.0.1", "::ffff:10.0.0.1",
+	}
+	for _, ip := range internal {
+		assert.True(t, IsInternal(net.ParseIP(ip)), "%s should be internal", ip)
+	}
+
+	public := []string{"8.8.8.8", "100.128.0.1", "172.32.0.1", "192.169.0.1", "fe00::1", "2001:4860:4860::8888", "::ffff:8.8.8.8"}
+	for _, ip := range public {
+		assert.False(t, IsInternal(net.ParseIP(ip)), "%s should not be internal", ip)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	guard := NewGuard([]string{"10.0.0.0/8", "fd00::1", " coupons.internal ", ""})
+	guard.resolver = staticResolver(nil)
+
+	assert.True(t, guard.AllowedIP(net.ParseIP("10.1.1.1")))
+	assert.True(t, guard.AllowedIP(net.ParseIP("::ffff:10.1.1.1")))
+	assert.False(t, guard.AllowedIP(net.ParseIP("192.168.1.1")))
+	assert.True(t, guard.AllowedIP(net.ParseIP("fd00::1")))
+	assert.False(t, guard.AllowedIP(net.ParseIP("fd00::2")))
+	assert.True(t, guard.AllowedIP(net.ParseIP("8.8.8.8")))
+
+	assert.NoError(t, guard.ValidateURL(context.Background(), "https://Coupons.Internal/coupons.json"))
+	assert.NoError(t, guard.ValidateURL(context.Background(), "http://10.0.0.5:8080/"))
+	assert.NoError(t, guard.ValidateURL(context.Background(), "http://[fd00::1]/"))
+}
+
+func TestValidateURL(t *testing.T) {
+	guard := NewGuard(nil)
+	guard.resolver = staticResolver(map[string][]string{
+		"public.example":   {"93.184.216.34", "2606:2800:220:1::248"},
+		"internal.example": {"10.0.0.1"},
+		"mixed.example":    {"93.184.216.34", "fd00::1"},
+		"metadata.example": {"169.254.169.254"},
+	})
+	ctx := context.Background()
+
+	assert.NoError(t, guard.ValidateURL(ctx, "https://public.example/coupons.json"))
+	assert.NoError(t, guard.ValidateURL(ctx, "http://[2606:2800:220:1::248]:8080/"))
+
+	forbidden := []string{
+		"http://internal.example/",
+		"http://mixed.example/",
+		"http://metadata.example/latest/meta-data/",
+		"http://127.0.0.1:8080/",
+		"http://[::1]/",
+		"http://[fe80::1]/",
+		"http://[::ffff:127.0.0.1]/",
+	}
+	for _, u := range forbidden {
+		err := guard.ValidateURL(ctx, u)
+		if assert.Error(t, err, u) {
+			_, ok := err.(*ForbiddenAddressError)
+			assert.True(t, ok, "%s: unexpected error %v", u, err)
+		}
+	}
+
+	assert.Error(t, guard.ValidateURL(ctx, "ftp://public.example/"))
+	assert.Error(t, guard.ValidateURL(ctx, "file:///etc/passwd"))
+	assert.Error(t, guard.ValidateURL(ctx, "http:///no-host"))
+	assert.Error(t, guard.ValidateURL(ctx, "http://unknown.example/"))
+}
+
+func TestClient(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	t.Run("Internal", func(t *testing.T) {
+		_, err := NewGuard(nil).Client().Get(server.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "internal address")
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		rsp, err := NewGuard([]string{"127.0.0.0/8"}).Client().Get(server.URL)
+		require.NoError(t, err)
+		rsp.Body.Close()
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+		defer redirect.Close()
+
+		// only the host name of the redirecting server is allowed
+		calls = 0
+		redirectURL := strings.Replace(redirect.URL, "127.0.0.1", "localhost", 1)
+		_, err := NewGuard([]string{"localhost"}).Client().Get(redirectURL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "internal address")
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		listener, err := net.Listen("tcp6", "[::1]:0")
+		if err != nil {
+			t.Skip("IPv6 loopback not available")
+		}
+		server6 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server6.Listener.Close()
+		server6.Listener = listener
+		server6.Start()
+		defer server6.Close()
+
+		_, err = NewGuard(nil).Client().Get(server6.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "internal address")
+
+		rsp, err := NewGuard([]string{"::1"}).Client().Get(server6.URL)
+		require.NoError(t, err)
+		rsp.Body.Close()
+	})
+}
+
+func TestDialRebinding(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	// the first lookup passes validation, later ones point to the loopback
+	// interface the server listens on
+	lookups := 0
+	guard := NewGuard(nil)
+	guard.resolver = resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if lookups == 1 {
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	})
+
+	rebindURL := "http://rebind.example:" + port + "/"
+	require.NoError(t, guard.ValidateURL(context.Background(), rebindURL))
+
+	_, err = guard.Client().Get(rebindURL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "internal address")
+	assert.Equal(t, 2, lookups)
+	assert.Equal(t, 0, calls)
+}